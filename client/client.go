@@ -0,0 +1,180 @@
+// Package client talks to a remote MapReduce master over the
+// versioned control-plane protocol, so other Go programs can submit
+// and follow jobs without in-process access to the Master.
+package client
+
+import (
+	"fmt"
+	"mapreduce"
+	"net/rpc"
+	"strings"
+)
+
+// Client is a connection to a master's control plane.
+type Client struct {
+	address string
+	token   string
+	rpc     *rpc.Client
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithToken sends token with every request, for masters that check
+// control tokens, see mapreduce.WithControlTokens. Observer tokens
+// allow Status, MasterStatus, Follow, FetchResult and Preview only.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// Connect dials the master listening on addr, a unix socket path or a
+// "tcp://host:port" address. The control plane is not served over gRPC.
+// Connections use TLS once the process is configured with
+// mapreduce.UseTLS.
+func Connect(addr string, opts ...Option) (*Client, error) {
+	if strings.HasPrefix(addr, "grpc://") {
+		return nil, fmt.Errorf("master %s serves gRPC, which has no control plane", addr)
+	}
+	c, err := mapreduce.DialRPC(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to master %s: %v", addr, err)
+	}
+	client := &Client{address: addr, rpc: c}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client, nil
+}
+
+// Close releases the connection
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// Submit queues a job and returns its ID
+func (c *Client) Submit(job mapreduce.JobRequest) (string, error) {
+	args := &mapreduce.SubmitJobArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, Job: job}
+	var reply mapreduce.SubmitJobReply
+	if err := c.rpc.Call(mapreduce.SubmitJobMethod, args, &reply); err != nil {
+		return "", fmt.Errorf("submit job %s: %v", job.Name, err)
+	}
+	return reply.JobID, nil
+}
+
+// Status returns the current state of a job
+func (c *Client) Status(jobID string) (*mapreduce.JobStatus, error) {
+	args := &mapreduce.JobArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, JobID: jobID}
+	var reply mapreduce.JobStatus
+	if err := c.rpc.Call(mapreduce.JobStatusMethod, args, &reply); err != nil {
+		return nil, fmt.Errorf("status of job %s: %v", jobID, err)
+	}
+	return &reply, nil
+}
+
+// MasterStatus returns what the master is doing: its running job and
+// the tasks of the job's current phase by state, the queued jobs and
+// the registered workers
+func (c *Client) MasterStatus() (*mapreduce.MasterStatus, error) {
+	args := &mapreduce.StatusArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token}
+	var reply mapreduce.MasterStatus
+	if err := c.rpc.Call(mapreduce.StatusMethod, args, &reply); err != nil {
+		return nil, fmt.Errorf("status of master %s: %v", c.address, err)
+	}
+	return &reply, nil
+}
+
+// Cancel stops a queued or running job
+func (c *Client) Cancel(jobID string) error {
+	args := &mapreduce.JobArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, JobID: jobID}
+	if err := c.rpc.Call(mapreduce.CancelJobMethod, args, new(struct{})); err != nil {
+		return fmt.Errorf("cancel job %s: %v", jobID, err)
+	}
+	return nil
+}
+
+// Reserve reserves n task slots of the workers carrying all of labels
+// for an upcoming job and returns the reservation's ID, to submit the
+// job with as JobRequest.Reservation
+func (c *Client) Reserve(n int, labels map[string]string) (string, error) {
+	args := &mapreduce.ReserveArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, Slots: n, Labels: labels}
+	var reply mapreduce.ReserveReply
+	if err := c.rpc.Call(mapreduce.ReserveSlotsMethod, args, &reply); err != nil {
+		return "", fmt.Errorf("reserve %d slots: %v", n, err)
+	}
+	return reply.ReservationID, nil
+}
+
+// Release returns the slots of a reservation to all jobs
+func (c *Client) Release(reservationID string) error {
+	args := &mapreduce.ReleaseArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, ReservationID: reservationID}
+	if err := c.rpc.Call(mapreduce.ReleaseSlotsMethod, args, new(struct{})); err != nil {
+		return fmt.Errorf("release %s: %v", reservationID, err)
+	}
+	return nil
+}
+
+// Follow calls fn with every event of a job, starting with the oldest
+// the master kept, as they happen and returns once the job has
+// finished. The job's outcome is the State of the last event.
+func (c *Client) Follow(jobID string, fn func(mapreduce.JobEvent)) error {
+	args := &mapreduce.WatchJobArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, JobID: jobID}
+	for {
+		var reply mapreduce.WatchJobReply
+		if err := c.rpc.Call(mapreduce.WatchJobMethod, args, &reply); err != nil {
+			return fmt.Errorf("follow job %s: %v", jobID, err)
+		}
+		for _, event := range reply.Events {
+			fn(event)
+			args.After = event.Seq
+		}
+		if reply.Done {
+			return nil
+		}
+	}
+}
+
+// FetchResult downloads all result records of a finished job, one
+// reduce partition after the other and each in as many chunks as the
+// master's message size limit requires
+func (c *Client) FetchResult(jobID string) ([]mapreduce.KeyValue, error) {
+	status, err := c.Status(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mapreduce.KeyValue
+	for part := 0; part < status.NReduce; part++ {
+		args := &mapreduce.FetchResultArgs{
+			Version: mapreduce.ControlProtocolVersion,
+			Token:   c.token,
+			JobID:   jobID,
+			Part:    part,
+		}
+		for {
+			var reply mapreduce.FetchResultReply
+			if err := c.rpc.Call(mapreduce.FetchResultMethod, args, &reply); err != nil {
+				return nil, fmt.Errorf("fetch result of job %s: %v", jobID, err)
+			}
+			records = append(records, reply.Records...)
+			if reply.Done {
+				break
+			}
+			args.Offset = reply.Next
+		}
+	}
+	return records, nil
+}
+
+// Preview returns n records from the start, the end and random
+// positions of the result of a finished job, without downloading all
+// of it. The master returns at most 1000 records of each kind.
+func (c *Client) Preview(jobID string, n int) (*mapreduce.OutputPreview, error) {
+	args := &mapreduce.PreviewResultArgs{Version: mapreduce.ControlProtocolVersion, Token: c.token, JobID: jobID, N: n}
+	var reply mapreduce.OutputPreview
+	if err := c.rpc.Call(mapreduce.PreviewResultMethod, args, &reply); err != nil {
+		return nil, fmt.Errorf("preview result of job %s: %v", jobID, err)
+	}
+	return &reply, nil
+}
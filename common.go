@@ -3,11 +3,7 @@
 package mapreduce
 
 import (
-	"fmt"
 	"hash/fnv"
-	"log"
-	"os"
-	"strconv"
 )
 
 // KeyValue represents a key-value pair emitted by Map functions
@@ -28,36 +24,6 @@ const (
 	reduceParse JobParse = "Reduce"
 )
 
-// mergeName constructs the name of an intermediate file that
-// stores the intermediate data for a specific reduce task.
-//
-// Parameters:
-//   - jobName: The name of the MapReduce job
-//   - id: The ID of the reduce task
-//
-// Returns the constructed file name.
-func mergeName(jobName JobParse, reduceTask int) string {
-	// Use paths from the Config variable
-	outDir := Config["output"]
-
-	// Ensure the output directory exists
-	if err := os.MkdirAll(outDir, 0777); err != nil {
-		log.Printf("Failed to create output directory: %v", err)
-	}
-
-	return fmt.Sprintf("%s/mrtmp.%v-%d", outDir, jobName, reduceTask)
-}
-
-func reduceName(jobName JobParse, mapTaskNumber int, reduceTask int) string {
-	return "./assets/output/mrtmp." + string(
-		jobName,
-	) + "-" + strconv.Itoa(
-		mapTaskNumber,
-	) + "-" + strconv.Itoa(
-		reduceTask,
-	)
-}
-
 func ihash(s string) int {
 	h := fnv.New32a()
 	h.Write([]byte(s))
@@ -0,0 +1,62 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// BuildInfo identifies the code a process runs, and with it the map
+// and reduce functions compiled into it: what Go recorded about the
+// build and a hash of the executable, which tells apart even builds
+// without version control information.
+type BuildInfo struct {
+	Path       string `json:"path,omitempty"`       // Import path of the main package
+	Version    string `json:"version,omitempty"`    // Version of the main module, "(devel)" for local builds
+	Revision   string `json:"revision,omitempty"`   // Version control revision built from
+	Modified   bool   `json:"modified,omitempty"`   // Whether the revision had local changes
+	GoVersion  string `json:"go_version,omitempty"` // Go release that built the executable
+	Executable string `json:"executable,omitempty"` // SHA-256 of the executable, hex encoded
+}
+
+// processBuild returns the build information of this process, which is
+// gathered once
+var processBuild = sync.OnceValue(func() BuildInfo {
+	var build BuildInfo
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build.Path = info.Path
+		build.Version = info.Main.Version
+		build.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				build.Revision = setting.Value
+			case "vcs.modified":
+				build.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if path, err := os.Executable(); err == nil {
+		build.Executable = hashFile(path)
+	}
+	return build
+})
+
+// hashFile returns the hex encoded SHA-256 of a local file, empty if
+// it cannot be read
+func hashFile(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
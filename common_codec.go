@@ -0,0 +1,148 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// RecordCodec identifies how records are encoded in intermediate files.
+type RecordCodec string
+
+const (
+	// CodecBinary writes every record as its length-prefixed key and
+	// value, the default. It is smaller and much faster to encode and
+	// decode than JSON.
+	CodecBinary RecordCodec = "binary"
+	// CodecJSON writes one JSON object per record, which is easy to
+	// inspect while debugging a job
+	CodecJSON RecordCodec = "json"
+)
+
+// recordCodecBinary marks records encoded by binaryEncoder
+const recordCodecBinary byte = 'b'
+
+// recordCodecIDs maps codecs to the byte stored in the file header
+var recordCodecIDs = map[RecordCodec]byte{
+	CodecBinary: recordCodecBinary,
+	CodecJSON:   recordCodecJSON,
+}
+
+// resolveRecordCodec returns the header byte of the codec the job
+// asks for. Layouts older than IntermediateFormatSelfDescribing cannot
+// name their codec and always hold JSON.
+func resolveRecordCodec(c RecordCodec, format int) (byte, error) {
+	selfDescribing := format == 0 || format >= IntermediateFormatSelfDescribing
+	switch {
+	case c == "" && !selfDescribing:
+		return recordCodecJSON, nil
+	case c == "":
+		return recordCodecBinary, nil
+	case c != CodecJSON && !selfDescribing:
+		return 0, fmt.Errorf("intermediate format %d only holds %s records", format, CodecJSON)
+	}
+	id, ok := recordCodecIDs[c]
+	if !ok {
+		return 0, fmt.Errorf("unknown record codec %q", c)
+	}
+	return id, nil
+}
+
+// recordEncoder writes records to an intermediate file
+type recordEncoder interface {
+	Encode(kv *KeyValue) error
+}
+
+// recordDecoder reads the records of an intermediate file. Decode
+// returns io.EOF after the last record.
+type recordDecoder interface {
+	Decode(kv *KeyValue) error
+}
+
+// newRecordEncoder returns an encoder writing records in codec to w
+func newRecordEncoder(w io.Writer, codec byte) recordEncoder {
+	if codec == recordCodecBinary {
+		return &binaryEncoder{w: w}
+	}
+	return jsonEncoder{json.NewEncoder(w)}
+}
+
+// newRecordDecoder returns a decoder reading records in codec from r
+func newRecordDecoder(r io.Reader, codec byte) recordDecoder {
+	if codec == recordCodecBinary {
+		return binaryDecoder{bufio.NewReader(r)}
+	}
+	return jsonDecoder{json.NewDecoder(r)}
+}
+
+// jsonEncoder implements recordEncoder for CodecJSON
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e jsonEncoder) Encode(kv *KeyValue) error {
+	return e.enc.Encode(kv)
+}
+
+// jsonDecoder implements recordDecoder for CodecJSON
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (d jsonDecoder) Decode(kv *KeyValue) error {
+	return d.dec.Decode(kv)
+}
+
+// binaryEncoder implements recordEncoder for CodecBinary. Every record
+// is the uvarint length of the key, the key, the uvarint length of the
+// value and the value, written with a single call to w.
+type binaryEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *binaryEncoder) Encode(kv *KeyValue) error {
+	e.buf = binary.AppendUvarint(e.buf[:0], uint64(len(kv.Key)))
+	e.buf = append(e.buf, kv.Key...)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(kv.Value)))
+	e.buf = append(e.buf, kv.Value...)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// binaryDecoder implements recordDecoder for CodecBinary
+type binaryDecoder struct {
+	r *bufio.Reader
+}
+
+func (d binaryDecoder) Decode(kv *KeyValue) error {
+	key, err := d.field()
+	if err != nil {
+		return err // io.EOF between records ends the file cleanly
+	}
+	value, err := d.field()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	kv.Key, kv.Value = key, value
+	return err
+}
+
+// field reads one length-prefixed string
+func (d binaryDecoder) field() (string, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(b), nil
+}
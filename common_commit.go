@@ -0,0 +1,71 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"io"
+	"math/rand/v2"
+	"strconv"
+)
+
+// pendingMarker is part of the names task output has until its attempt
+// commits it
+const pendingMarker = ".pending."
+
+// taskOutput is a file written by a task attempt. Nothing reads it
+// before commit moves it to its final name in one step, so an attempt
+// that crashes or fails halfway leaves no torn file behind, at most a
+// pending one.
+type taskOutput interface {
+	io.WriteCloser
+
+	// commit publishes the file under its final name once it is closed
+	commit() error
+
+	// discard removes the file if it was not committed
+	discard()
+}
+
+// pendingFile is task output written under a temporary name
+type pendingFile struct {
+	io.WriteCloser
+	name string // Final name
+	tmp  string // Name written to, name itself if its file system cannot rename
+}
+
+// createTaskOutput creates name with create under a temporary name in
+// the same directory, unless its file system cannot rename files, see
+// Renamer
+func createTaskOutput(name string, create func(string) (io.WriteCloser, error)) (*pendingFile, error) {
+	tmp := name
+	if canRename(name) {
+		tmp = name + pendingMarker + strconv.FormatUint(rand.Uint64(), 16)
+	}
+	file, err := create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{WriteCloser: file, name: name, tmp: tmp}, nil
+}
+
+func (f *pendingFile) commit() error {
+	if f.tmp == f.name {
+		return nil
+	}
+	return renameFile(f.tmp, f.name)
+}
+
+func (f *pendingFile) discard() {
+	removeFile(f.tmp)
+}
+
+// removePendingOutputs deletes the task output of the job's attempts
+// that never committed it: attempts that crashed, failed or were
+// abandoned
+func removePendingOutputs(ws *JobWorkspace) {
+	for _, dir := range []string{ws.IntermediateDir(), ws.OutputDir()} {
+		files, _ := globFiles(joinPath(dir, "mrtmp."+string(ws.JobName)+"-*"+pendingMarker+"*"))
+		for _, file := range files {
+			removeFile(file)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTaskOutputCommit writes a reduce output with a failing and a
+// succeeding attempt and checks that only the succeeding one leaves a
+// file, and that output an attempt never committed is swept.
+func TestTaskOutputCommit(t *testing.T) {
+	ws := NewJobWorkspace(t.TempDir(), "commit")
+	if err := ws.Create(); err != nil {
+		t.Fatal(err)
+	}
+	outFile := ws.ReduceOutput(0)
+	groups := func(emit func(key string, values Iterator)) error {
+		for _, key := range []string{"a", "b", "c"} {
+			emit(key, &sliceIterator{[]string{"1"}})
+		}
+		return nil
+	}
+	pending := func() []string {
+		files, _ := filepath.Glob(filepath.Join(ws.OutputDir(), "*"+pendingMarker+"*"))
+		return files
+	}
+
+	_, err := writeReduceOutput(outFile, nil, groups, func(key string, values Iterator) (string, error) {
+		if key == "b" {
+			return "", errors.New("broken")
+		}
+		return "1", nil
+	})
+	if err == nil {
+		t.Fatal("failing attempt succeeded")
+	}
+	if _, err := os.Stat(outFile); !os.IsNotExist(err) {
+		t.Errorf("failing attempt left %s behind: %v", outFile, err)
+	}
+	if files := pending(); len(files) > 0 {
+		t.Errorf("failing attempt left pending files %v", files)
+	}
+
+	if _, err := writeReduceOutput(outFile, nil, groups, func(key string, values Iterator) (string, error) {
+		return "1", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(outFile); err != nil || len(data) == 0 {
+		t.Errorf("succeeding attempt wrote %q: %v", data, err)
+	}
+
+	crashed, err := createTaskOutput(ws.ReduceOutput(1), createFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed.Write([]byte("torn"))
+	crashed.Close()
+	if _, err := os.Stat(ws.ReduceOutput(1)); !os.IsNotExist(err) {
+		t.Errorf("uncommitted output is visible: %v", err)
+	}
+	removePendingOutputs(ws)
+	if files := pending(); len(files) > 0 {
+		t.Errorf("pending files %v were not swept", files)
+	}
+	if _, err := os.Stat(outFile); err != nil {
+		t.Errorf("sweep removed committed output: %v", err)
+	}
+}
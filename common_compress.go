@@ -0,0 +1,176 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies the codec used for intermediate map output.
+type Compression string
+
+const (
+	// CompressionAuto picks a codec per map task by sampling its output
+	CompressionAuto Compression = "auto"
+	// CompressionNone writes plain JSON intermediate files
+	CompressionNone Compression = "none"
+	// CompressionSnappy favours speed over compression ratio
+	CompressionSnappy Compression = "snappy"
+	// CompressionZstd favours compression ratio over speed
+	CompressionZstd Compression = "zstd"
+	// CompressionGzip is slower than zstd at a similar ratio, for
+	// intermediate files read by tools that only know gzip
+	CompressionGzip Compression = "gzip"
+	// CompressionZstdDict compresses with zstd and a dictionary trained
+	// once per job on a sample of map output, for jobs with many small,
+	// similar records
+	CompressionZstdDict Compression = "zstd-dict"
+)
+
+const (
+	// compressionMagic prefixes compressed intermediate files of
+	// IntermediateFormatCodec. It is followed by a single byte naming
+	// the codec.
+	compressionMagic = "MRZ"
+
+	// compressionSampleSize is the size of the first spill block
+	// used to measure how compressible a task's output is
+	compressionSampleSize = 64 * 1024
+)
+
+// compressionIDs maps codecs to the byte stored in the file header
+var compressionIDs = map[Compression]byte{
+	CompressionNone:     'n',
+	CompressionSnappy:   's',
+	CompressionZstd:     'z',
+	CompressionZstdDict: 'd',
+	CompressionGzip:     'g',
+}
+
+// chooseCompression selects a codec for data resembling sample.
+// Output that barely compresses is stored as-is, zstd is only chosen
+// when it is clearly smaller than snappy, since it costs more CPU.
+func chooseCompression(sample []byte) Compression {
+	if len(sample) == 0 {
+		return CompressionNone
+	}
+
+	snappyRatio := float64(len(snappy.Encode(nil, sample))) / float64(len(sample))
+	if snappyRatio > 0.9 {
+		return CompressionNone
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		return CompressionSnappy
+	}
+	defer enc.Close()
+	zstdRatio := float64(len(enc.EncodeAll(sample, nil))) / float64(len(sample))
+
+	if zstdRatio < snappyRatio*0.75 {
+		return CompressionZstd
+	}
+	return CompressionSnappy
+}
+
+// resolveCompression turns the job setting into a concrete codec,
+// measuring sample when the job leaves the choice to the framework
+func resolveCompression(c Compression, sample []byte) (Compression, error) {
+	switch c {
+	case "", CompressionAuto:
+		return chooseCompression(sample), nil
+	case CompressionNone, CompressionSnappy, CompressionZstd, CompressionZstdDict, CompressionGzip:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown compression %q", c)
+	}
+}
+
+// nopWriteCloser lets uncompressed files share the compressor code path
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressWriter writes the header of the given intermediate
+// format, the current one if zero, to w and returns a writer compressing everything written to
+// it. The header names codec, the record codec of the file, see
+// resolveRecordCodec. Close must be called to flush the compressor; it
+// does not close w. CompressionZstdDict requires the job's dictionary.
+func newCompressWriter(w io.Writer, c Compression, format int, codec byte, dict []byte) (io.WriteCloser, error) {
+	if c == CompressionZstdDict && dict == nil {
+		return nil, fmt.Errorf("compression %s needs a dictionary", c)
+	}
+	header := intermediateHeader{
+		Format:      format,
+		Codec:       codec,
+		Compression: c,
+		Framework:   FrameworkVersion,
+	}
+	if err := writeIntermediateHeader(w, header); err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	case CompressionZstdDict:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderDict(dict))
+	default:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	}
+}
+
+// newDecompressReader inspects the header of r and returns a reader
+// yielding the decompressed records, together with their record codec.
+// Files of every format up to IntermediateFormatCurrent are accepted,
+// including header-less plain JSON files. Files compressed with
+// CompressionZstdDict are decoded with dict, the dictionary of their
+// job.
+func newDecompressReader(r io.Reader, dict []byte) (io.ReadCloser, byte, error) {
+	br := bufio.NewReader(r)
+	header, err := readIntermediateHeader(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	rc, err := decompress(br, header, dict)
+	return rc, header.Codec, err
+}
+
+// decompress returns a reader yielding the records following the
+// header of br
+func decompress(br *bufio.Reader, header intermediateHeader, dict []byte) (io.ReadCloser, error) {
+	switch header.Compression {
+	case CompressionSnappy:
+		return io.NopCloser(snappy.NewReader(br)), nil
+	case CompressionGzip:
+		return gzip.NewReader(br)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionZstdDict:
+		if dict == nil {
+			return nil, fmt.Errorf("compression %s needs the job's dictionary, which is missing", header.Compression)
+		}
+		dec, err := zstd.NewReader(br, zstd.WithDecoderDicts(dict))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
@@ -0,0 +1,101 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestCompressionRoundTrip verifies that every codec can read back
+// what it wrote, including the header-less plain format.
+func TestCompressionRoundTrip(t *testing.T) {
+	content := strings.Repeat(`{"Key":"the","Value":"1"}`+"\n", 1000)
+
+	for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd, CompressionGzip} {
+		var buf bytes.Buffer
+		w, err := newCompressWriter(&buf, c, 0, recordCodecJSON, nil)
+		if err != nil {
+			t.Fatalf("%s: create writer: %v", c, err)
+		}
+		io.WriteString(w, content)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: close writer: %v", c, err)
+		}
+
+		r, _, err := newDecompressReader(&buf, nil)
+		if err != nil {
+			t.Fatalf("%s: create reader: %v", c, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: read: %v", c, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s: content mismatch after round trip", c)
+		}
+	}
+}
+
+// TestChooseCompression checks the adaptive codec selection on
+// repetitive and incompressible samples.
+func TestChooseCompression(t *testing.T) {
+	if c := chooseCompression(nil); c != CompressionNone {
+		t.Errorf("empty sample: got %s, want %s", c, CompressionNone)
+	}
+
+	random := make([]byte, compressionSampleSize)
+	rand.Read(random)
+	if c := chooseCompression(random); c != CompressionNone {
+		t.Errorf("random sample: got %s, want %s", c, CompressionNone)
+	}
+
+	repetitive := []byte(strings.Repeat(`{"Key":"the","Value":"1"}`+"\n", 2000))
+	if c := chooseCompression(repetitive); c == CompressionNone {
+		t.Errorf("repetitive sample: got %s, want a compressing codec", c)
+	}
+}
+
+// TestZstdDictionaryRoundTrip trains a dictionary on small records,
+// checks that files compressed with it read back and that reading
+// them without the dictionary fails instead of yielding garbage.
+func TestZstdDictionaryRoundTrip(t *testing.T) {
+	var sample strings.Builder
+	for i := 0; sample.Len() < compressionSampleSize; i++ {
+		fmt.Fprintf(&sample, `{"Key":"user-%d","Value":"{\"visits\":%d,\"country\":\"nl\"}"}`+"\n", i, i%7)
+	}
+	dict, err := trainZstdDictionary([]byte(sample.String()))
+	if err != nil {
+		t.Fatalf("train dictionary: %v", err)
+	}
+
+	content := `{"Key":"user-99999","Value":"{\"visits\":3,\"country\":\"nl\"}"}` + "\n"
+	var buf bytes.Buffer
+	w, err := newCompressWriter(&buf, CompressionZstdDict, 0, recordCodecJSON, dict)
+	if err != nil {
+		t.Fatalf("create writer: %v", err)
+	}
+	io.WriteString(w, content)
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+	file := buf.Bytes()
+
+	r, _, err := newDecompressReader(bytes.NewReader(file), dict)
+	if err != nil {
+		t.Fatalf("create reader: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(got) != content {
+		t.Errorf("round trip: got %q, %v, want %q", got, err, content)
+	}
+
+	if _, _, err := newDecompressReader(bytes.NewReader(file), nil); err == nil {
+		t.Error("file compressed with a dictionary was accepted without it")
+	}
+}
@@ -0,0 +1,162 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// TaskContext describes the task a context-aware map or reduce
+// function is running in. Every attempt of a task, whether a retry or
+// a copy on another worker, sees the same context.
+type TaskContext struct {
+	JobName JobParse // Job the task belongs to
+	Phase   JobParse // Map or Reduce
+	Task    int      // Task number within the phase
+	Seed    int64    // Seed of the job, the same for all of its tasks
+	Group   string   // Input group of a map task's file, see JobConfig.Inputs
+
+	rng     *rand.Rand
+	acquire func(limit string, n int) (time.Duration, error) // Reserves rate limit tokens, nil for none
+	ctx     context.Context                                  // Cancelled with the task, nil for never
+
+	countersMu sync.Mutex
+	counters   map[string]int64 // See Count
+}
+
+// ContextMapFunc is a map function receiving its task context
+type ContextMapFunc func(ctx *TaskContext, file string, value string) []KeyValue
+
+// ContextReduceFunc is a reduce function receiving its task context
+type ContextReduceFunc func(ctx *TaskContext, key string, values []string) string
+
+// newTaskContext returns the context of a task of the job whose
+// options are given
+func newTaskContext(jobName JobParse, phase JobParse, task int, opts JobOptions) *TaskContext {
+	return &TaskContext{JobName: jobName, Phase: phase, Task: task, Seed: opts.Seed}
+}
+
+// Rand returns the random source of the task. It is seeded from the
+// job seed, the phase and the task number, so every attempt of the
+// task draws the same numbers in the same order, and their outputs
+// are identical. The source is shared by all calls of the task.
+func (c *TaskContext) Rand() *rand.Rand {
+	if c.rng == nil {
+		stream := uint64(c.Task) << 1
+		if c.Phase == reduceParse {
+			stream |= 1
+		}
+		c.rng = rand.New(rand.NewPCG(uint64(c.Seed), stream))
+	}
+	return c.rng
+}
+
+// Context returns a context that is cancelled once the task is, e.g.
+// because its job was cancelled. Functions calling slow services
+// should pass it on so that cancelled tasks stop promptly.
+func (c *TaskContext) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Acquire waits until n tokens of the job's rate limit called limit
+// are available, see WithRateLimit. The master keeps the limit for all
+// workers of the job, so calls across the cluster together stay within
+// it. Every call asks the master, so draw tokens for a batch of calls
+// to a limited service rather than for each record. A cancelled task
+// stops waiting with the error of its Context.
+func (c *TaskContext) Acquire(limit string, n int) error {
+	if c.acquire == nil {
+		return fmt.Errorf("rate limit %q: task has no master to ask", limit)
+	}
+	wait, err := c.acquire(limit, n)
+	if err != nil {
+		return err
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.Context().Done():
+		return c.Context().Err()
+	}
+}
+
+// bindMap turns a context-aware map function into a plain one for
+// the task of ctx. A nil ctxF returns mapF.
+func bindMap(ctxF ContextMapFunc, mapF func(string, string) []KeyValue, ctx *TaskContext) func(string, string) []KeyValue {
+	if ctxF == nil {
+		return mapF
+	}
+	return func(file string, value string) []KeyValue {
+		return ctxF(ctx, file, value)
+	}
+}
+
+// bindReduce turns a context-aware reduce function into a plain one
+// for the task of ctx. A nil ctxF returns reduceF.
+func bindReduce(ctxF ContextReduceFunc, reduceF func(string, []string) string, ctx *TaskContext) func(string, []string) string {
+	if ctxF == nil {
+		return reduceF
+	}
+	return func(key string, values []string) string {
+		return ctxF(ctx, key, values)
+	}
+}
+
+// Reservoir keeps a uniform random sample of at most k of the items
+// added to it, drawing from the random source of a task. Adding the
+// same items in the same order always yields the same sample, so a
+// sampling task produces the same output on every attempt. Values of
+// a reduce call arrive in a fixed order unless the job decodes its
+// intermediate files in parallel, see WithDecodeWorkers.
+type Reservoir struct {
+	k     int
+	seen  int64
+	items []string
+	rng   *rand.Rand
+}
+
+// NewReservoir returns an empty reservoir of k items drawing from the
+// random source of ctx
+func NewReservoir(ctx *TaskContext, k int) *Reservoir {
+	return &Reservoir{k: k, rng: ctx.Rand()}
+}
+
+// Add offers an item to the sample
+func (r *Reservoir) Add(item string) {
+	r.seen++
+	if len(r.items) < r.k {
+		r.items = append(r.items, item)
+		return
+	}
+	if i := r.rng.Int64N(r.seen); i < int64(r.k) {
+		r.items[i] = item
+	}
+}
+
+// Sample returns the sampled items
+func (r *Reservoir) Sample() []string {
+	return append([]string{}, r.items...)
+}
+
+// Seen returns the number of items added so far
+func (r *Reservoir) Seen() int64 {
+	return r.seen
+}
+
+// SampleValues returns a reproducible sample of k of values, e.g. in a
+// reduce function keeping a few examples per key
+func SampleValues(ctx *TaskContext, values []string, k int) []string {
+	r := NewReservoir(ctx, k)
+	for _, v := range values {
+		r.Add(v)
+	}
+	return r.Sample()
+}
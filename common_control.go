@@ -0,0 +1,191 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"time"
+)
+
+// ControlProtocolVersion is the version of the control-plane protocol
+// spoken between clients and the master. Requests carrying a different
+// version are rejected instead of being misinterpreted. Version 2
+// returns result partitions in chunks. Requests carry the client's
+// token, checked when the master has control tokens, see
+// WithControlTokens.
+const ControlProtocolVersion = 2
+
+// Control-plane RPC method names
+const (
+	// SubmitJobMethod queues a new job on the master
+	SubmitJobMethod = "Master.SubmitJob"
+	// JobStatusMethod reports the state of a submitted job
+	JobStatusMethod = "Master.JobStatus"
+	// CancelJobMethod cancels a queued or running job
+	CancelJobMethod = "Master.CancelJob"
+	// FetchResultMethod returns records of one result partition
+	FetchResultMethod = "Master.FetchResult"
+	// PreviewResultMethod returns a few records of a finished job's result
+	PreviewResultMethod = "Master.PreviewResult"
+	// StatusMethod reports what the master is doing
+	StatusMethod = "Master.Status"
+	// ReserveSlotsMethod reserves task slots for an upcoming job
+	ReserveSlotsMethod = "Master.Reserve"
+	// ReleaseSlotsMethod returns the slots of a reservation
+	ReleaseSlotsMethod = "Master.Release"
+	// WatchJobMethod waits for the events of a job
+	WatchJobMethod = "Master.WatchJob"
+)
+
+// JobState describes where a job is in its lifecycle.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// JobRequest describes a job submitted over the control plane.
+// Only settings that can be shipped over the wire are available;
+// custom mergers require the in-process Submit API. Options replace
+// the master's default job options rather than being merged with them.
+type JobRequest struct {
+	Name    JobParse     `json:"name"`
+	Files   []string     `json:"files"`
+	Inputs  []InputGroup `json:"inputs,omitempty"`
+	NReduce int          `json:"n_reduce"`
+	Options JobOptions   `json:"options"`
+
+	Reservation string `json:"reservation,omitempty"` // Slots the job runs on, see ReserveSlots
+}
+
+// JobStatus is the externally visible state of a submitted job.
+type JobStatus struct {
+	ID       string       `json:"id"`
+	Name     JobParse     `json:"name"`
+	State    JobState     `json:"state"`
+	Progress JobProgress  `json:"progress"`
+	Phases   []PhaseState `json:"phases,omitempty"` // Phases started so far, in order
+	NReduce  int          `json:"n_reduce"`         // Number of result partitions
+	Error    string       `json:"error,omitempty"`  // Failure reason, empty unless the job failed
+
+	Counters map[string]int64 `json:"counters,omitempty"` // Totals of the job's counters, see TaskContext.Count
+}
+
+// JobEventKind tells what a JobEvent reports.
+type JobEventKind string
+
+const (
+	EventJobState     JobEventKind = "job"           // The job changed state
+	EventPhaseStarted JobEventKind = "phase_started" // A phase started
+	EventPhaseEnded   JobEventKind = "phase_ended"   // A phase ended
+	EventTask         JobEventKind = "task"          // A task of a phase changed state
+)
+
+// JobEvent reports a change of a job, see Master.WatchJob. Every event
+// carries the job's state and progress after the change.
+type JobEvent struct {
+	Seq       int64        `json:"seq"` // Position among the job's events, from 1
+	Time      time.Time    `json:"time"`
+	Kind      JobEventKind `json:"kind"`
+	State     JobState     `json:"state"`
+	Phase     JobParse     `json:"phase,omitempty"`      // Phase of phase and task events
+	Task      int          `json:"task"`                 // Task number of task events
+	TaskState TaskState    `json:"task_state,omitempty"` // New state of the task of task events
+	Progress  JobProgress  `json:"progress"`
+	Error     string       `json:"error,omitempty"` // Failure reason once the job failed
+}
+
+// SubmitJobArgs is the request of the SubmitJob RPC.
+type SubmitJobArgs struct {
+	Version int
+	Token   string
+	Job     JobRequest
+}
+
+// SubmitJobReply carries the identifier of the queued job.
+type SubmitJobReply struct {
+	JobID string
+}
+
+// JobArgs identifies a job in JobStatus and CancelJob requests.
+type JobArgs struct {
+	Version int
+	Token   string
+	JobID   string
+}
+
+// FetchResultArgs selects a chunk of one result partition of a
+// finished job.
+type FetchResultArgs struct {
+	Version int
+	Token   string
+	JobID   string
+	Part    int
+	Offset  int64 // Next of the previous chunk, zero for the first
+}
+
+// FetchResultReply carries the records of a chunk of a result
+// partition, about the master's message size limit.
+type FetchResultReply struct {
+	Records []KeyValue
+	Next    int64 // Offset of the following chunk
+	Done    bool  // Whether the chunk ends the partition
+}
+
+// checkProtocolVersion rejects requests from incompatible clients
+func checkProtocolVersion(version int) error {
+	if version != ControlProtocolVersion {
+		return fmt.Errorf("unsupported control protocol version %d, master speaks %d",
+			version, ControlProtocolVersion)
+	}
+	return nil
+}
+
+// ReserveArgs asks for task slots of the workers carrying all of
+// Labels, see Master.ReserveSlots.
+type ReserveArgs struct {
+	Version int
+	Token   string
+	Slots   int
+	Labels  map[string]string
+}
+
+// ReserveReply identifies the reservation made.
+type ReserveReply struct {
+	ReservationID string
+}
+
+// ReleaseArgs identifies the reservation to release.
+type ReleaseArgs struct {
+	Version       int
+	Token         string
+	ReservationID string
+}
+
+// PreviewResultArgs asks for n records from the start, the end and
+// random positions of the result of a finished job.
+type PreviewResultArgs struct {
+	Version int
+	Token   string
+	JobID   string
+	N       int
+}
+
+// WatchJobArgs asks for the events of a job following the event with
+// sequence number After, zero for all events the master kept.
+type WatchJobArgs struct {
+	Version int
+	Token   string
+	JobID   string
+	After   int64
+}
+
+// WatchJobReply carries the events of a job in order. Done is set once
+// the job has finished and no events follow those returned.
+type WatchJobReply struct {
+	Events []JobEvent
+	Done   bool
+}
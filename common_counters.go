@@ -0,0 +1,96 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Count adds delta to the job's counter name, e.g. "records.skipped"
+// or "lines.malformed". Counters of a task travel to the master with
+// its result; the master adds up those of every completed task, so
+// failed and abandoned attempts do not count and a task run again
+// counts once. See JobHandle.Counters.
+func (c *TaskContext) Count(name string, delta int64) {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	if c.counters == nil {
+		c.counters = make(map[string]int64)
+	}
+	c.counters[name] += delta
+}
+
+// counterValues returns the counters of the task, nil if it counted
+// nothing
+func (c *TaskContext) counterValues() map[string]int64 {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	if len(c.counters) == 0 {
+		return nil
+	}
+	counters := make(map[string]int64, len(c.counters))
+	for name, n := range c.counters {
+		counters[name] = n
+	}
+	return counters
+}
+
+// jobCounters keeps the counters of every completed task of a job
+type jobCounters struct {
+	mu    sync.Mutex
+	tasks map[string]map[string]int64 // Counters by phase and task number
+}
+
+// set records the counters of a completed task, replacing those of an
+// earlier completion
+func (c *jobCounters) set(phase JobParse, task int, counters map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fmt.Sprintf("%s %d", phase, task)
+	if len(counters) == 0 {
+		delete(c.tasks, key)
+		return
+	}
+	if c.tasks == nil {
+		c.tasks = make(map[string]map[string]int64)
+	}
+	c.tasks[key] = counters
+}
+
+// reset forgets the counters of all tasks
+func (c *jobCounters) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks = nil
+}
+
+// totals adds up the counters of all tasks
+func (c *jobCounters) totals() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	totals := make(map[string]int64)
+	for _, counters := range c.tasks {
+		for name, n := range counters {
+			totals[name] += n
+		}
+	}
+	return totals
+}
+
+// Counters returns the path of the job's counter totals
+func (w *JobWorkspace) Counters() string {
+	return joinPath(w.LogsDir(), "counters.json")
+}
+
+// writeCounters stores counter totals as indented JSON
+func writeCounters(path string, totals map[string]int64) error {
+	data, err := json.MarshalIndent(totals, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode counters: %v", err)
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to write counters: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// InputCSV passes every record of CSV input files to a map call of
+// its own, see CSVMap. Quoted fields may span lines.
+const InputCSV = "csv"
+
+// CSVHeader says what to do with the first record of CSV input files
+type CSVHeader int
+
+const (
+	// CSVNoHeader treats the first record as data, the default
+	CSVNoHeader CSVHeader = iota
+	// CSVSkipHeader drops the first record of every file
+	CSVSkipHeader
+	// CSVPropagateHeader drops the first record of every file and
+	// passes it with every other record of the file as field names
+	CSVPropagateHeader
+)
+
+// CSVRecord is a parsed record of a CSV input file
+type CSVRecord struct {
+	Header []string // Field names, nil unless the job propagates headers
+	Fields []string
+}
+
+// Get returns the field named name in the header, or "" if the record
+// has no such field
+func (r CSVRecord) Get(name string) string {
+	for i, h := range r.Header {
+		if h == name && i < len(r.Fields) {
+			return r.Fields[i]
+		}
+	}
+	return ""
+}
+
+// CSVMapFunc is a map function receiving parsed CSV records
+type CSVMapFunc func(file string, record CSVRecord) []KeyValue
+
+// CSVMap turns a CSVMapFunc into a map function for jobs reading
+// their input with InputCSV. Records that do not parse are dropped
+// with a log message.
+func CSVMap(mapF CSVMapFunc) func(string, string) []KeyValue {
+	return func(file string, value string) []KeyValue {
+		record, err := ParseCSVRecord(value)
+		if err != nil {
+			processLog.warnf("CSVMap: %s: %v", file, err)
+			return nil
+		}
+		return mapF(file, record)
+	}
+}
+
+// ParseCSVRecord parses a value InputCSV passes to the map function,
+// e.g. in a streaming map function
+func ParseCSVRecord(value string) (CSVRecord, error) {
+	r := csv.NewReader(strings.NewReader(value))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return CSVRecord{}, err
+	}
+	switch len(rows) {
+	case 1:
+		return CSVRecord{Fields: rows[0]}, nil
+	case 2:
+		return CSVRecord{Header: rows[0], Fields: rows[1]}, nil
+	default:
+		return CSVRecord{}, fmt.Errorf("value holds %d CSV records", len(rows))
+	}
+}
+
+// csvFormat implements InputCSV. Quotes make it impossible to tell
+// where a record starts from the middle of a file, so the master reads
+// files larger than SplitSize to cut their splits between records.
+type csvFormat struct{}
+
+func (csvFormat) Splits(files []string, opts JobOptions) ([]SplitInfo, error) {
+	var splits []SplitInfo
+	for _, file := range files {
+		info, err := statFile(file)
+		if opts.SplitSize <= 0 || err != nil || info.Size() <= opts.SplitSize {
+			splits = append(splits, SplitInfo{Task: len(splits), File: file})
+			continue
+		}
+		fileSplits, err := splitCSV(file, opts.SplitSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, split := range fileSplits {
+			split.Task = len(splits)
+			splits = append(splits, split)
+		}
+	}
+	return splits, nil
+}
+
+// splitCSV cuts a CSV file into splits of about size bytes of whole
+// records
+func splitCSV(file string, size int64) ([]SplitInfo, error) {
+	f, err := openFile(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	var splits []SplitInfo
+	var start, pos int64
+	for {
+		record, err := readCSVRecord(in)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("split %s: %v", file, err)
+		}
+		pos += int64(len(record))
+		if err == io.EOF {
+			break
+		}
+		if pos-start >= size {
+			splits = append(splits, SplitInfo{File: file, Offset: start, Length: pos - start})
+			start = pos
+		}
+	}
+	if start < pos || len(splits) == 0 {
+		splits = append(splits, SplitInfo{File: file, Offset: start})
+	} else {
+		splits[len(splits)-1].Length = 0 // The last split reads whatever the file holds by then
+	}
+	return splits, nil
+}
+
+func (csvFormat) Reader(split SplitInfo, opts JobOptions, open func(offset int64) io.Reader) RecordReader {
+	r := &csvReader{pos: split.Offset, end: -1}
+	if split.Length > 0 {
+		r.end = split.Offset + split.Length
+	}
+	r.in = bufio.NewReader(open(split.Offset))
+
+	if opts.CSVHeader == CSVNoHeader {
+		r.columns, r.err = csvColumns(opts.Columns, nil)
+		return r
+	}
+	in := r.in
+	if split.Offset > 0 {
+		in = bufio.NewReader(open(0))
+	}
+	header, err := readCSVRecord(in)
+	if split.Offset == 0 {
+		r.pos += int64(len(header))
+		r.err = err
+	}
+	if len(header) > 0 && header[len(header)-1] != '\n' {
+		header = append(header, '\n')
+	}
+	if len(opts.Columns) > 0 {
+		names, err := ParseCSVRecord(string(header))
+		if err == nil {
+			r.columns, err = csvColumns(opts.Columns, names.Fields)
+		}
+		if err != nil {
+			r.err = fmt.Errorf("header of %s: %v", split.File, err)
+			return r
+		}
+		header = r.project(header)
+	}
+	if opts.CSVHeader == CSVPropagateHeader {
+		r.header = header
+	}
+	return r
+}
+
+// csvColumns returns the positions of the columns a job projects its
+// CSV input on, nil for all. Columns are looked up by name in header,
+// or taken as zero-based positions in files without a header.
+func csvColumns(columns []string, header []string) ([]int, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	positions := make([]int, len(columns))
+	for i, column := range columns {
+		if header == nil {
+			n, err := strconv.Atoi(column)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("column %q is not a position, and the input has no header", column)
+			}
+			positions[i] = n
+			continue
+		}
+		positions[i] = slices.Index(header, column)
+		if positions[i] < 0 {
+			return nil, fmt.Errorf("no column %q", column)
+		}
+	}
+	return positions, nil
+}
+
+// checkColumns reports columns that cannot be projected on, which is
+// known before any file is read for CSV input without a header
+func (o JobOptions) checkColumns() error {
+	if o.inputFormatName() != InputCSV || o.CSVHeader != CSVNoHeader {
+		return nil
+	}
+	_, err := csvColumns(o.Columns, nil)
+	return err
+}
+
+// project returns a CSV record holding the fields of record at the
+// reader's column positions, in that order. Fields are copied as they
+// are, quotes included, without decoding them; positions beyond the
+// end of the record give empty fields.
+func (r *csvReader) project(record []byte) []byte {
+	line := bytes.TrimRight(record, "\r\n")
+	bounds := append(r.bounds[:0], 0)
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			bounds = append(bounds, i+1)
+		}
+	}
+	bounds = append(bounds, len(line)+1)
+	r.bounds = bounds
+
+	out := make([]byte, 0, len(line)+1)
+	for i, column := range r.columns {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		if column+1 < len(bounds) {
+			out = append(out, line[bounds[column]:bounds[column+1]-1]...)
+		}
+	}
+	return append(out, '\n')
+}
+
+// csvReader returns the records of a CSV split, which starts and ends
+// between records. Blank lines are skipped.
+type csvReader struct {
+	in      *bufio.Reader
+	header  []byte // Passed in front of every record, nil for none
+	columns []int  // Positions of the fields passed on, nil for all
+	bounds  []int  // Field boundaries of the record being projected
+	pos     int64  // Offset of the next record in the file
+	end     int64  // Offset at which the split ends, negative for none
+	err     error  // Sticky error, io.EOF once the file is exhausted
+}
+
+// Next returns the next non-blank record of the split
+func (r *csvReader) Next() ([]byte, error) {
+	for r.err == nil && (r.end < 0 || r.pos < r.end) {
+		record, err := readCSVRecord(r.in)
+		r.pos += int64(len(record))
+		r.err = err
+		if len(bytes.TrimRight(record, "\r\n")) == 0 {
+			continue
+		}
+		if r.columns != nil {
+			record = r.project(record)
+		}
+		if r.header != nil {
+			record = append(append([]byte{}, r.header...), record...)
+		}
+		return record, nil
+	}
+	if r.err == nil || r.err == io.EOF {
+		return nil, io.EOF
+	}
+	return nil, r.err
+}
+
+// readCSVRecord reads up to and including the next newline outside
+// of quotes, or up to the end of the input, which is reported as
+// io.EOF
+func readCSVRecord(r *bufio.Reader) ([]byte, error) {
+	var record []byte
+	quoted := false
+	for {
+		line, err := r.ReadBytes('\n')
+		record = append(record, line...)
+		quoted = quoted != (bytes.Count(line, []byte(`"`))%2 == 1)
+		if err != nil || !quoted {
+			return record, err
+		}
+	}
+}
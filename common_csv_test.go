@@ -0,0 +1,105 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCSVRecords reads a CSV file with quoted newlines in splits of
+// every size and checks that every record is parsed once, with the
+// header handled as asked.
+func TestCSVRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.csv")
+	content := "name,note\n" +
+		"a,\"one\ntwo\"\n" +
+		"\n" +
+		"\"b,c\",\"say \"\"hi\"\"\"\r\n" +
+		"d,\"\n\""
+	if err := os.WriteFile(file, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"name", "note"}
+	data := [][]string{{"a", "one\ntwo"}, {"b,c", `say "hi"`}, {"d", "\n"}}
+
+	modes := []struct {
+		header CSVHeader
+		want   []CSVRecord
+	}{
+		{CSVNoHeader, []CSVRecord{{Fields: header}, {Fields: data[0]}, {Fields: data[1]}, {Fields: data[2]}}},
+		{CSVSkipHeader, []CSVRecord{{Fields: data[0]}, {Fields: data[1]}, {Fields: data[2]}}},
+		{CSVPropagateHeader, []CSVRecord{{header, data[0]}, {header, data[1]}, {header, data[2]}}},
+	}
+
+	for _, m := range modes {
+		for size := int64(1); size <= int64(len(content)); size++ {
+			opts := JobOptions{InputFormat: InputCSV, CSVHeader: m.header, SplitSize: size}
+			var records []CSVRecord
+			for _, value := range readFormat(t, file, opts) {
+				record, err := ParseCSVRecord(value)
+				if err != nil {
+					t.Fatalf("header mode %d, split size %d: %v", m.header, size, err)
+				}
+				records = append(records, record)
+			}
+			if !reflect.DeepEqual(records, m.want) {
+				t.Errorf("header mode %d, split size %d: got %q, want %q", m.header, size, records, m.want)
+			}
+		}
+	}
+}
+
+// TestCSVColumns projects CSV records on named columns and on column
+// positions, in splits of every size, and checks that quoted fields
+// survive the projection unchanged.
+func TestCSVColumns(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wide.csv")
+	content := "id,name,note,price\n" +
+		"1,a,\"x,\ny\",10\n" +
+		"2,\"b \"\"c\"\"\",z\r\n" +
+		"3,d,,30\n"
+	if err := os.WriteFile(file, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	modes := []struct {
+		header  CSVHeader
+		columns []string
+		want    []CSVRecord
+	}{
+		{CSVPropagateHeader, []string{"price", "note"}, []CSVRecord{
+			{[]string{"price", "note"}, []string{"10", "x,\ny"}},
+			{[]string{"price", "note"}, []string{"", "z"}},
+			{[]string{"price", "note"}, []string{"30", ""}},
+		}},
+		{CSVSkipHeader, []string{"name"}, []CSVRecord{
+			{Fields: []string{"a"}}, {Fields: []string{`b "c"`}}, {Fields: []string{"d"}},
+		}},
+		{CSVNoHeader, []string{"0", "2"}, []CSVRecord{
+			{Fields: []string{"id", "note"}}, {Fields: []string{"1", "x,\ny"}},
+			{Fields: []string{"2", "z"}}, {Fields: []string{"3", ""}},
+		}},
+	}
+	for _, m := range modes {
+		for size := int64(1); size <= int64(len(content)); size++ {
+			opts := JobOptions{InputFormat: InputCSV, CSVHeader: m.header, SplitSize: size, Columns: m.columns}
+			var records []CSVRecord
+			for _, value := range readFormat(t, file, opts) {
+				record, err := ParseCSVRecord(value)
+				if err != nil {
+					t.Fatalf("columns %q, split size %d: %v", m.columns, size, err)
+				}
+				records = append(records, record)
+			}
+			if !reflect.DeepEqual(records, m.want) {
+				t.Errorf("columns %q, split size %d: got %q, want %q", m.columns, size, records, m.want)
+			}
+		}
+	}
+
+	if err := (JobOptions{InputFormat: InputCSV, Columns: []string{"name"}}).checkColumns(); err == nil {
+		t.Errorf("named column accepted for input without a header")
+	}
+}
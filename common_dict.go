@@ -0,0 +1,92 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// zstdDictionarySize bounds the history of trained dictionaries
+	zstdDictionarySize = 32 * 1024
+
+	// zstdMinTrainingSample is the smallest map output a dictionary is
+	// trained on; smaller samples yield useless dictionaries
+	zstdMinTrainingSample = 4 * 1024
+)
+
+// trainZstdDictionary trains a zstd dictionary on sample, a run of
+// newline-separated encoded records
+func trainZstdDictionary(sample []byte) ([]byte, error) {
+	if len(sample) < zstdMinTrainingSample {
+		return nil, fmt.Errorf("sample of %d bytes is too small to train a dictionary", len(sample))
+	}
+	history := sample
+	if len(history) > zstdDictionarySize {
+		history = history[len(history)-zstdDictionarySize:]
+	}
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       crc32.ChecksumIEEE(sample) | 1, // Zero means no dictionary
+		Contents: bytes.SplitAfter(sample, []byte("\n")),
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedFastest,
+	})
+}
+
+// loadZstdDictionary reads the dictionary of a job, nil if the job has
+// none
+func loadZstdDictionary(path string) ([]byte, error) {
+	dict, err := readFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return dict, err
+}
+
+// sharedZstdDictionary returns the dictionary of a job, training it on
+// sample if no task of the job has done so yet. Tasks racing to train
+// it agree on the first one published, so every file of the job is
+// compressed with the same dictionary. Remote workspaces offer no
+// atomic publication, so their jobs cannot share a dictionary.
+func sharedZstdDictionary(path string, sample []byte) ([]byte, error) {
+	if isRemotePath(path) {
+		return nil, fmt.Errorf("shared dictionaries need a local workspace")
+	}
+	if dict, err := loadZstdDictionary(path); dict != nil || err != nil {
+		return dict, err
+	}
+
+	dict, err := trainZstdDictionary(sample)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".zdict-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dict)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Linking fails if another task published its dictionary first
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		return os.ReadFile(path)
+	}
+	return dict, nil
+}
@@ -0,0 +1,188 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultEmitQueueSize bounds the number of records buffered between
+// the map function and each partition writer
+const defaultEmitQueueSize = 1024
+
+// TaskMetrics holds measurements collected while a task executes.
+type TaskMetrics struct {
+	EmitQueuePeak int         // Deepest partition queue observed during the map phase
+	Compression   Compression // Codec chosen for the task's intermediate files
+	OutputDigest  uint64      // Digest of the task's output, see outputDigest
+
+	SchemaViolations int // Checked map output records violating the job's schema
+
+	// Records a map task wrote to every partition, used to prune
+	// reduce tasks, see WithKeyPrefixes, and to size them, see
+	// WithFairScheduling
+	PartitionRecords []int64
+
+	// Resources used by the task attempt. CPU times are those of the
+	// whole worker process while the task ran, so on workers with
+	// several task slots they include the tasks running alongside it.
+	WallTime  time.Duration
+	UserCPU   time.Duration
+	SystemCPU time.Duration
+
+	// Counters of the task, see TaskContext.Count. Nil if it counted
+	// nothing.
+	Counters map[string]int64
+}
+
+// recordDigest returns the share of one record in an output digest.
+// A digest adds up the shares of all records of an output, so it does
+// not depend on the order the records were produced in, which varies
+// legitimately, e.g. with Go's map iteration order.
+func recordDigest(kv KeyValue) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(kv.Key))
+	h.Write([]byte{0})
+	h.Write([]byte(kv.Value))
+	return h.Sum64()
+}
+
+// outputDigest returns the digest of kva
+func outputDigest(kva []KeyValue) uint64 {
+	var digest uint64
+	for _, kv := range kva {
+		digest += recordDigest(kv)
+	}
+	return digest
+}
+
+// emitter routes map output to per-partition writers through bounded
+// queues. Emit blocks once a partition's queue is full, so a fast
+// streaming mapper cannot get arbitrarily far ahead of a slow disk.
+type emitter struct {
+	nReduce int
+	order   *KeyOrder // Partitions records by group key when set
+	keep    KeyFilter // Drops records whose group key it rejects when set
+	bounds  []string  // Range partitions of the group keys, nil to hash them
+	queues  []chan KeyValue
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	err    error   // First write error reported by any partition writer
+	peak   int     // Deepest queue observed by Emit
+	counts []int64 // Records written to every partition, each counted by its writer
+}
+
+// newEmitter starts one writer goroutine per encoder. Records are
+// partitioned by their group key under order, which may be nil, and
+// dropped unless keep, which may be nil as well, accepts that key.
+// Group keys are hashed to partitions unless bounds range partitions
+// them, see partitionOf.
+func newEmitter(encoders []recordEncoder, queueSize int, order *KeyOrder, keep KeyFilter, bounds []string) *emitter {
+	e := &emitter{
+		nReduce: len(encoders),
+		order:   order,
+		keep:    keep,
+		bounds:  bounds,
+		queues:  make([]chan KeyValue, len(encoders)),
+		counts:  make([]int64, len(encoders)),
+	}
+	for i, enc := range encoders {
+		e.queues[i] = make(chan KeyValue, queueSize)
+		e.wg.Add(1)
+		go e.writePartition(i, e.queues[i], enc)
+	}
+	return e
+}
+
+// Emit hands a key-value pair to the writer of its partition, unless
+// the emitter's filter drops it
+func (e *emitter) Emit(kv KeyValue) {
+	group := e.order.group(kv.Key)
+	if e.keep != nil && !e.keep(group) {
+		return
+	}
+	partition := partitionOf(group, e.nReduce, e.bounds)
+	queue := e.queues[partition]
+	queue <- kv
+
+	if depth := len(queue); depth > 0 {
+		e.mu.Lock()
+		if depth > e.peak {
+			e.peak = depth
+		}
+		e.mu.Unlock()
+	}
+}
+
+// partitionOf returns the partition of a group key: the range it falls
+// in when bounds are set, see JobOptions.RangeBounds, and its hash
+// otherwise
+func partitionOf(group string, nReduce int, bounds []string) int {
+	if len(bounds) == 0 {
+		return ihash(group) % nReduce
+	}
+	partition := sort.Search(len(bounds), func(i int) bool { return group < bounds[i] })
+	return min(partition, nReduce-1)
+}
+
+// checkRangeBounds checks that the job's range partitions, if any, are
+// sorted and make up nReduce partitions
+func (o JobOptions) checkRangeBounds(nReduce int) error {
+	if o.RangeBounds == nil {
+		return nil
+	}
+	if len(o.RangeBounds) != nReduce-1 {
+		return fmt.Errorf("%d range bounds make %d partitions, the job has %d reduce tasks",
+			len(o.RangeBounds), len(o.RangeBounds)+1, nReduce)
+	}
+	if !sort.StringsAreSorted(o.RangeBounds) {
+		return fmt.Errorf("range bounds are not in ascending order")
+	}
+	return nil
+}
+
+// Close drains all queues, waits for the writers to finish and
+// returns the first write error, if any
+func (e *emitter) Close() error {
+	for _, queue := range e.queues {
+		close(queue)
+	}
+	e.wg.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// metrics reports the queue statistics and the records written to
+// every partition, once Close returned
+func (e *emitter) metrics() TaskMetrics {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return TaskMetrics{EmitQueuePeak: e.peak, PartitionRecords: append([]int64(nil), e.counts...)}
+}
+
+// writePartition encodes every record of a single partition queue.
+// After the first error the queue is still drained so Emit never blocks.
+func (e *emitter) writePartition(partition int, queue chan KeyValue, enc recordEncoder) {
+	defer e.wg.Done()
+	failed := false
+	for kv := range queue {
+		if failed {
+			continue
+		}
+		e.counts[partition]++
+		if err := enc.Encode(&kv); err != nil {
+			failed = true
+			e.mu.Lock()
+			if e.err == nil {
+				e.err = err
+			}
+			e.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// InputEncoding names the character encoding of a job's input files.
+// Map functions always receive UTF-8.
+type InputEncoding string
+
+const (
+	// EncodingAuto detects the encoding of every input file: a byte
+	// order mark names it, valid UTF-8 stays as it is, text with many
+	// NUL bytes is taken as UTF-16 and anything else as Latin-1
+	EncodingAuto InputEncoding = "auto"
+	// EncodingUTF8 passes input through unchanged, the default
+	EncodingUTF8 InputEncoding = "utf-8"
+	// EncodingUTF16LE reads little-endian UTF-16, honouring a byte order mark
+	EncodingUTF16LE InputEncoding = "utf-16le"
+	// EncodingUTF16BE reads big-endian UTF-16, honouring a byte order mark
+	EncodingUTF16BE InputEncoding = "utf-16be"
+	// EncodingLatin1 reads ISO 8859-1
+	EncodingLatin1 InputEncoding = "latin-1"
+)
+
+// encodingSampleSize is the prefix of an input file inspected when
+// looking for UTF-16 text
+const encodingSampleSize = 4096
+
+// transcodeInput converts content from the encoding e to UTF-8
+func transcodeInput(content []byte, e InputEncoding) ([]byte, error) {
+	if e == EncodingAuto {
+		e = detectEncoding(content)
+	}
+
+	var dec *encoding.Decoder
+	switch e {
+	case "", EncodingUTF8:
+		return bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), nil
+	case EncodingUTF16LE:
+		dec = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case EncodingUTF16BE:
+		dec = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case EncodingLatin1:
+		dec = charmap.ISO8859_1.NewDecoder()
+	default:
+		return nil, fmt.Errorf("unknown input encoding %q", e)
+	}
+	return dec.Bytes(content)
+}
+
+// detectEncoding guesses the encoding of content
+func detectEncoding(content []byte) InputEncoding {
+	switch {
+	case bytes.HasPrefix(content, []byte("\xef\xbb\xbf")):
+		return EncodingUTF8
+	case bytes.HasPrefix(content, []byte("\xff\xfe")):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(content, []byte("\xfe\xff")):
+		return EncodingUTF16BE
+	}
+
+	// UTF-16 text in Latin scripts has a NUL byte in every other
+	// position, which neither UTF-8 nor Latin-1 text has
+	sample := content
+	if len(sample) > encodingSampleSize {
+		sample = sample[:encodingSampleSize]
+	}
+	var evenNUL, oddNUL int
+	for i, c := range sample {
+		if c != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNUL++
+		} else {
+			oddNUL++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case pairs > 0 && oddNUL > pairs/4 && oddNUL > 2*evenNUL:
+		return EncodingUTF16LE
+	case pairs > 0 && evenNUL > pairs/4 && evenNUL > 2*oddNUL:
+		return EncodingUTF16BE
+	case utf8.Valid(content):
+		return EncodingUTF8
+	default:
+		return EncodingLatin1
+	}
+}
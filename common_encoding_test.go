@@ -0,0 +1,39 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"testing"
+)
+
+// TestTranscodeInput decodes the same text in every supported
+// encoding, with the encoding named and detected.
+func TestTranscodeInput(t *testing.T) {
+	const text = "Café déjà vu\nnaïve"
+	inputs := []struct {
+		encoding InputEncoding
+		content  []byte
+	}{
+		{EncodingUTF8, []byte(text)},
+		{EncodingUTF8, append([]byte("\xef\xbb\xbf"), text...)},
+		{EncodingUTF16LE, []byte("C\x00a\x00f\x00\xe9\x00 \x00d\x00\xe9\x00j\x00\xe0\x00 \x00v\x00u\x00\n\x00n\x00a\x00\xef\x00v\x00e\x00")},
+		{EncodingUTF16LE, []byte("\xff\xfeC\x00a\x00f\x00\xe9\x00 \x00d\x00\xe9\x00j\x00\xe0\x00 \x00v\x00u\x00\n\x00n\x00a\x00\xef\x00v\x00e\x00")},
+		{EncodingUTF16BE, []byte("\x00C\x00a\x00f\x00\xe9\x00 \x00d\x00\xe9\x00j\x00\xe0\x00 \x00v\x00u\x00\n\x00n\x00a\x00\xef\x00v\x00e")},
+		{EncodingLatin1, []byte("Caf\xe9 d\xe9j\xe0 vu\nna\xefve")},
+	}
+
+	for _, in := range inputs {
+		for _, e := range []InputEncoding{in.encoding, EncodingAuto} {
+			got, err := transcodeInput(in.content, e)
+			if err != nil {
+				t.Fatalf("%s as %s: %v", in.encoding, e, err)
+			}
+			if string(got) != text {
+				t.Errorf("%s as %s: got %q, want %q", in.encoding, e, got, text)
+			}
+		}
+	}
+
+	if _, err := transcodeInput([]byte(text), "ebcdic"); err == nil {
+		t.Error("unknown encoding accepted")
+	}
+}
@@ -0,0 +1,140 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FrameworkVersion identifies the release of the framework. It is
+// recorded in every intermediate file for diagnostics.
+const FrameworkVersion = "1.4.0"
+
+// Layouts of intermediate files. Readers understand every layout up
+// to IntermediateFormatCurrent, so files left by older workers remain
+// readable after an upgrade.
+const (
+	// IntermediateFormatPlain files hold JSON records without a header
+	IntermediateFormatPlain = 1
+	// IntermediateFormatCodec files start with compressionMagic and a
+	// codec byte; uncompressed files have no header
+	IntermediateFormatCodec = 2
+	// IntermediateFormatSelfDescribing files start with a header naming
+	// the layout, record codec, compression and framework version
+	IntermediateFormatSelfDescribing = 3
+
+	// IntermediateFormatCurrent is the layout written by default
+	IntermediateFormatCurrent = IntermediateFormatSelfDescribing
+)
+
+const (
+	// formatMagic prefixes self-describing intermediate files. It is
+	// followed by the layout version, the record codec, the compression
+	// id and the length-prefixed framework version.
+	formatMagic = "MRF"
+
+	// recordCodecJSON marks records encoded as a stream of JSON values
+	recordCodecJSON byte = 'j'
+)
+
+// intermediateHeader describes how an intermediate file was written
+type intermediateHeader struct {
+	Format      int         // Layout version
+	Codec       byte        // Record codec
+	Compression Compression // Compression of the records
+	Framework   string      // Framework version of the writer, empty in older layouts
+}
+
+// writeIntermediateHeader writes the header of a file in the given
+// layout, IntermediateFormatCurrent if zero. Plain files and
+// uncompressed IntermediateFormatCodec files have none.
+func writeIntermediateHeader(w io.Writer, h intermediateHeader) error {
+	if h.Format == 0 {
+		h.Format = IntermediateFormatCurrent
+	}
+	id, ok := compressionIDs[h.Compression]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown compression %q", h.Compression)
+	case h.Format < IntermediateFormatSelfDescribing && h.Codec != recordCodecJSON:
+		return fmt.Errorf("intermediate format %d cannot hold record codec %q", h.Format, h.Codec)
+	case h.Format == IntermediateFormatPlain && h.Compression != CompressionNone:
+		return fmt.Errorf("intermediate format %d cannot be compressed", h.Format)
+	case h.Format == IntermediateFormatPlain,
+		h.Format == IntermediateFormatCodec && h.Compression == CompressionNone:
+		return nil
+	case h.Format == IntermediateFormatCodec:
+		_, err := io.WriteString(w, compressionMagic+string(id))
+		return err
+	case h.Format == IntermediateFormatSelfDescribing:
+		if len(h.Framework) > 255 {
+			return fmt.Errorf("framework version %q is too long", h.Framework)
+		}
+		header := []byte(formatMagic)
+		header = append(header, byte(h.Format), h.Codec, id, byte(len(h.Framework)))
+		_, err := w.Write(append(header, h.Framework...))
+		return err
+	default:
+		return fmt.Errorf("unknown intermediate format %d", h.Format)
+	}
+}
+
+// readIntermediateHeader consumes the header at the start of br and
+// reports how the rest of the file is encoded. Files written in a
+// layout newer than this framework understands are rejected rather
+// than decoded as garbage.
+func readIntermediateHeader(br *bufio.Reader) (intermediateHeader, error) {
+	legacy := intermediateHeader{Format: IntermediateFormatPlain, Codec: recordCodecJSON, Compression: CompressionNone}
+
+	magic, _ := br.Peek(len(formatMagic))
+	switch {
+	case bytes.Equal(magic, []byte(formatMagic)):
+		// Self-describing header, parsed below
+	case bytes.Equal(magic, []byte(compressionMagic)):
+		fixed, err := br.Peek(len(compressionMagic) + 1)
+		if err != nil {
+			return legacy, fmt.Errorf("truncated intermediate header: %v", err)
+		}
+		br.Discard(len(fixed))
+		c, err := compressionByID(fixed[len(compressionMagic)])
+		return intermediateHeader{Format: IntermediateFormatCodec, Codec: recordCodecJSON, Compression: c}, err
+	default:
+		return legacy, nil
+	}
+
+	fixed, err := br.Peek(len(formatMagic) + 4)
+	if err != nil {
+		return legacy, fmt.Errorf("truncated intermediate header: %v", err)
+	}
+	h := intermediateHeader{Format: int(fixed[len(formatMagic)]), Codec: fixed[len(formatMagic)+1]}
+	if h.Format > IntermediateFormatCurrent {
+		return h, fmt.Errorf("intermediate format %d is newer than the supported format %d, upgrade this worker",
+			h.Format, IntermediateFormatCurrent)
+	}
+	if h.Codec != recordCodecJSON && h.Codec != recordCodecBinary {
+		return h, fmt.Errorf("unknown record codec %q", h.Codec)
+	}
+	if h.Compression, err = compressionByID(fixed[len(formatMagic)+2]); err != nil {
+		return h, err
+	}
+
+	framework := make([]byte, fixed[len(formatMagic)+3])
+	br.Discard(len(fixed))
+	if _, err := io.ReadFull(br, framework); err != nil {
+		return h, fmt.Errorf("truncated intermediate header: %v", err)
+	}
+	h.Framework = string(framework)
+	return h, nil
+}
+
+// compressionByID returns the codec stored as id in a file header
+func compressionByID(id byte) (Compression, error) {
+	for c, cid := range compressionIDs {
+		if cid == id {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown compression id %q", id)
+}
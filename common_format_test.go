@@ -0,0 +1,162 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// formatRecords is the content of every intermediate file fixture
+var formatRecords = []KeyValue{{"the", "1"}, {"quick", "1"}, {"fox", "1"}}
+
+// encodeRecords encodes formatRecords the way map tasks do
+func encodeRecords(t *testing.T) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, kv := range formatRecords {
+		if err := enc.Encode(kv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeRecords reads back the records of an intermediate file
+func decodeRecords(t *testing.T, name string, file []byte) []KeyValue {
+	r, codec, err := newDecompressReader(bytes.NewReader(file), nil)
+	if err != nil {
+		t.Fatalf("%s: create reader: %v", name, err)
+	}
+	defer r.Close()
+
+	var kva []KeyValue
+	dec := newRecordDecoder(r, codec)
+	for {
+		var kv KeyValue
+		if err := dec.Decode(&kv); err == io.EOF {
+			return kva
+		} else if err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		kva = append(kva, kv)
+	}
+}
+
+// TestReadOlderIntermediateFormats reads files laid out exactly as
+// older releases wrote them, independently of the current writer.
+func TestReadOlderIntermediateFormats(t *testing.T) {
+	records := encodeRecords(t)
+
+	var zstdFile bytes.Buffer
+	enc, err := zstd.NewWriter(&zstdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write(records)
+	enc.Close()
+
+	var snappyFile bytes.Buffer
+	sw := snappy.NewBufferedWriter(&snappyFile)
+	sw.Write(records)
+	sw.Close()
+
+	fixtures := map[string][]byte{
+		"plain":        records,
+		"codec-snappy": append([]byte("MRZs"), snappyFile.Bytes()...),
+		"codec-zstd":   append([]byte("MRZz"), zstdFile.Bytes()...),
+	}
+	for name, file := range fixtures {
+		if got := decodeRecords(t, name, file); !reflect.DeepEqual(got, formatRecords) {
+			t.Errorf("%s: got %v, want %v", name, got, formatRecords)
+		}
+	}
+}
+
+// TestWriteEveryIntermediateFormat checks that pinned formats round
+// trip and that the current format describes itself.
+func TestWriteEveryIntermediateFormat(t *testing.T) {
+	records := encodeRecords(t)
+	formats := []int{0, IntermediateFormatPlain, IntermediateFormatCodec, IntermediateFormatSelfDescribing}
+
+	for _, format := range formats {
+		for _, c := range []Compression{CompressionNone, CompressionSnappy, CompressionZstd, CompressionGzip} {
+			var buf bytes.Buffer
+			w, err := newCompressWriter(&buf, c, format, recordCodecJSON, nil)
+			if format == IntermediateFormatPlain && c != CompressionNone {
+				if err == nil {
+					t.Errorf("format %d: compression %s accepted", format, c)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("format %d, %s: create writer: %v", format, c, err)
+			}
+			w.Write(records)
+			w.Close()
+
+			file := buf.Bytes()
+			if format == 0 || format == IntermediateFormatSelfDescribing {
+				h, err := readIntermediateHeader(bufio.NewReader(bytes.NewReader(file)))
+				want := intermediateHeader{IntermediateFormatCurrent, recordCodecJSON, c, FrameworkVersion}
+				if err != nil || h != want {
+					t.Errorf("format %d, %s: header %+v, %v, want %+v", format, c, h, err, want)
+				}
+			}
+			if got := decodeRecords(t, string(c), file); !reflect.DeepEqual(got, formatRecords) {
+				t.Errorf("format %d, %s: got %v, want %v", format, c, got, formatRecords)
+			}
+		}
+	}
+}
+
+// TestRejectNewerIntermediateFormat makes sure files from a newer
+// release fail loudly instead of being decoded as garbage.
+func TestRejectNewerIntermediateFormat(t *testing.T) {
+	file := append([]byte(formatMagic), byte(IntermediateFormatCurrent+1), recordCodecJSON, 'n', 0)
+	file = append(file, encodeRecords(t)...)
+
+	_, _, err := newDecompressReader(bytes.NewReader(file), nil)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("got error %v, want a newer format error", err)
+	}
+}
+
+// TestBinaryRecordCodec round trips records through the binary codec
+// and checks that layouts without a codec byte refuse it.
+func TestBinaryRecordCodec(t *testing.T) {
+	records := append(formatRecords, KeyValue{"", ""}, KeyValue{"multi\nline", strings.Repeat("v", 300)})
+	for _, c := range []Compression{CompressionNone, CompressionSnappy} {
+		var buf bytes.Buffer
+		w, err := newCompressWriter(&buf, c, 0, recordCodecBinary, nil)
+		if err != nil {
+			t.Fatalf("%s: create writer: %v", c, err)
+		}
+		enc := newRecordEncoder(w, recordCodecBinary)
+		for i := range records {
+			if err := enc.Encode(&records[i]); err != nil {
+				t.Fatalf("%s: encode: %v", c, err)
+			}
+		}
+		w.Close()
+		if got := decodeRecords(t, string(c), buf.Bytes()); !reflect.DeepEqual(got, records) {
+			t.Errorf("%s: got %v, want %v", c, got, records)
+		}
+	}
+
+	for _, format := range []int{IntermediateFormatPlain, IntermediateFormatCodec} {
+		if _, err := newCompressWriter(new(bytes.Buffer), CompressionNone, format, recordCodecBinary, nil); err == nil {
+			t.Errorf("format %d: binary records accepted", format)
+		}
+		if codec, err := resolveRecordCodec("", format); err != nil || codec != recordCodecJSON {
+			t.Errorf("format %d: default codec %q, %v, want JSON", format, codec, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileSystem stores the input, intermediate and result files of jobs.
+// Paths of the form scheme://rest, e.g. s3://bucket/key, are served by
+// the file system registered for their scheme, see RegisterFileSystem;
+// all other paths are local files.
+type FileSystem interface {
+	// Open opens a file for reading from offset on
+	Open(name string, offset int64) (io.ReadCloser, error)
+
+	// Create creates or truncates a file for writing. Its contents
+	// are complete once Close returns without error.
+	Create(name string) (io.WriteCloser, error)
+
+	// Stat describes a file
+	Stat(name string) (fs.FileInfo, error)
+
+	// Remove removes a file
+	Remove(name string) error
+
+	// RemoveAll removes a directory and everything in it
+	RemoveAll(dir string) error
+
+	// MkdirAll creates a directory and its parents. File systems
+	// without directories, like object stores, do nothing.
+	MkdirAll(dir string) error
+
+	// Glob returns the files matching pattern, see path.Match
+	Glob(pattern string) ([]string, error)
+}
+
+// Renamer is implemented by file systems that can move a file to
+// another name in one step, replacing any file there. Task output is
+// written under a temporary name and moved into place once the task
+// succeeded on such file systems, see createTaskOutput; on others it is
+// written under its final name, which suits object stores that publish
+// an object as a whole once its upload completes.
+type Renamer interface {
+	Rename(oldName, newName string) error
+}
+
+// fileSystems holds the file systems by scheme
+var fileSystems = struct {
+	sync.RWMutex
+	byScheme map[string]FileSystem
+}{byScheme: make(map[string]FileSystem)}
+
+// RegisterFileSystem serves the paths of scheme, e.g. "s3", from fsys.
+// Register it in the master and in every worker before the first job
+// using such paths starts.
+func RegisterFileSystem(scheme string, fsys FileSystem) {
+	fileSystems.Lock()
+	defer fileSystems.Unlock()
+	fileSystems.byScheme[scheme] = fsys
+}
+
+// pathScheme returns the scheme of a path, empty for local paths
+func pathScheme(name string) string {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok || scheme == "" || strings.ContainsAny(scheme, `/\`) {
+		return ""
+	}
+	return scheme
+}
+
+// isRemotePath reports whether a path names a file outside the local
+// file system
+func isRemotePath(name string) bool {
+	return pathScheme(name) != ""
+}
+
+// fileSystemFor returns the file system serving a path
+func fileSystemFor(name string) (FileSystem, error) {
+	scheme := pathScheme(name)
+	if scheme == "" {
+		return localFS{}, nil
+	}
+	fileSystems.RLock()
+	defer fileSystems.RUnlock()
+	fsys, ok := fileSystems.byScheme[scheme]
+	if !ok {
+		return nil, fmt.Errorf("no file system registered for %s://", scheme)
+	}
+	return fsys, nil
+}
+
+// joinPath joins path elements like filepath.Join, keeping the scheme
+// of remote paths intact
+func joinPath(elem ...string) string {
+	if len(elem) == 0 || !isRemotePath(elem[0]) {
+		return filepath.Join(elem...)
+	}
+	scheme, rest, _ := strings.Cut(elem[0], "://")
+	return scheme + "://" + path.Join(append([]string{rest}, elem[1:]...)...)
+}
+
+// dirPath returns the directory of a path like filepath.Dir, keeping
+// the scheme of remote paths intact
+func dirPath(name string) string {
+	if !isRemotePath(name) {
+		return filepath.Dir(name)
+	}
+	scheme, rest, _ := strings.Cut(name, "://")
+	return scheme + "://" + path.Dir(rest)
+}
+
+// openFile opens a file of any file system for reading from offset on
+func openFile(name string, offset int64) (io.ReadCloser, error) {
+	fsys, err := fileSystemFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Open(name, offset)
+}
+
+// createFile creates a file of any file system
+func createFile(name string) (io.WriteCloser, error) {
+	fsys, err := fileSystemFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Create(name)
+}
+
+// statFile describes a file of any file system
+func statFile(name string) (fs.FileInfo, error) {
+	fsys, err := fileSystemFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Stat(name)
+}
+
+// removeFile removes a file of any file system
+func removeFile(name string) error {
+	fsys, err := fileSystemFor(name)
+	if err != nil {
+		return err
+	}
+	return fsys.Remove(name)
+}
+
+// renameFile moves a file of any file system to newName, see Renamer
+func renameFile(oldName, newName string) error {
+	fsys, err := fileSystemFor(oldName)
+	if err != nil {
+		return err
+	}
+	renamer, ok := fsys.(Renamer)
+	if !ok {
+		return fmt.Errorf("file system of %s cannot rename files", oldName)
+	}
+	return renamer.Rename(oldName, newName)
+}
+
+// canRename reports whether the file system of name implements Renamer
+func canRename(name string) bool {
+	fsys, err := fileSystemFor(name)
+	if err != nil {
+		return false
+	}
+	_, ok := fsys.(Renamer)
+	return ok
+}
+
+// removeAll removes a directory of any file system
+func removeAll(dir string) error {
+	fsys, err := fileSystemFor(dir)
+	if err != nil {
+		return err
+	}
+	return fsys.RemoveAll(dir)
+}
+
+// mkdirAll creates a directory of any file system
+func mkdirAll(dir string) error {
+	fsys, err := fileSystemFor(dir)
+	if err != nil {
+		return err
+	}
+	return fsys.MkdirAll(dir)
+}
+
+// globFiles returns the files of any file system matching pattern
+func globFiles(pattern string) ([]string, error) {
+	fsys, err := fileSystemFor(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Glob(pattern)
+}
+
+// readFile reads a whole file of any file system
+func readFile(name string) ([]byte, error) {
+	file, err := openFile(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// writeFile writes data to a file of any file system
+func writeFile(name string, data []byte) error {
+	file, err := createFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// localFS serves paths without a scheme from the local file system
+type localFS struct{}
+
+func (localFS) Open(name string, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (localFS) Create(name string) (io.WriteCloser, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (localFS) Remove(name string) error { return os.Remove(name) }
+
+func (localFS) RemoveAll(dir string) error { return os.RemoveAll(dir) }
+
+func (localFS) Rename(oldName, newName string) error { return os.Rename(oldName, newName) }
+
+func (localFS) MkdirAll(dir string) error { return os.MkdirAll(dir, 0777) }
+
+func (localFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
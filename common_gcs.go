@@ -0,0 +1,470 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCSConfig locates Google Cloud Storage, or an emulator of it, and
+// says how to authenticate.
+type GCSConfig struct {
+	Endpoint string `json:"endpoint"` // Base URL of the JSON API, https://storage.googleapis.com when empty
+	Token    string `json:"token"`    // OAuth2 access token, sent as is
+	Metadata bool   `json:"metadata"` // Take access tokens from the GCE metadata server instead
+}
+
+// gcsMetadataTokenURL hands out access tokens of the service account
+// of a Google Compute Engine instance
+const gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCSConfigFromEnv reads STORAGE_EMULATOR_HOST, the address of an
+// emulator, and GOOGLE_OAUTH_ACCESS_TOKEN. Without either, access
+// tokens are taken from the metadata server as on GCE and GKE.
+func GCSConfigFromEnv() *GCSConfig {
+	endpoint := os.Getenv("STORAGE_EMULATOR_HOST")
+	if endpoint != "" && !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	return &GCSConfig{
+		Endpoint: endpoint,
+		Token:    token,
+		Metadata: endpoint == "" && token == "",
+	}
+}
+
+// UseGCS serves gs://bucket/object paths from the Cloud Storage cfg
+// describes, for input files, job workspaces and the result directory
+// alike. Like UseTLS the setting is process-wide: call it in the master
+// and every worker before they start. Passing nil turns GCS off again.
+func UseGCS(cfg *GCSConfig) error {
+	if cfg == nil {
+		fileSystems.Lock()
+		delete(fileSystems.byScheme, "gs")
+		fileSystems.Unlock()
+		return nil
+	}
+	fsys, err := newGCS(*cfg)
+	if err != nil {
+		return err
+	}
+	RegisterFileSystem("gs", fsys)
+	return nil
+}
+
+// gcsChunkSize is how much of a file is buffered before it is sent as
+// one chunk of a resumable upload; GCS wants multiples of 256 KiB
+const gcsChunkSize = 8 << 20
+
+// gcsFS implements FileSystem over the Cloud Storage JSON API. Objects
+// are read as a stream and written with resumable uploads, one chunk at
+// a time, so neither side holds a whole file.
+type gcsFS struct {
+	cfg       GCSConfig
+	endpoint  string
+	client    *http.Client
+	chunkSize int
+	tokenURL  string
+
+	mu      sync.Mutex // Protects the cached metadata server token
+	token   string
+	expires time.Time
+}
+
+// newGCS validates cfg and fills in its defaults
+func newGCS(cfg GCSConfig) (*gcsFS, error) {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://storage.googleapis.com"
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid GCS endpoint %q", cfg.Endpoint)
+	}
+	return &gcsFS{
+		cfg:       cfg,
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		client:    http.DefaultClient,
+		chunkSize: gcsChunkSize,
+		tokenURL:  gcsMetadataTokenURL,
+	}, nil
+}
+
+// splitGCSPath returns the bucket and object name of a gs:// path
+func splitGCSPath(name string) (bucket, object string, err error) {
+	rest, ok := strings.CutPrefix(name, "gs://")
+	if !ok {
+		return "", "", fmt.Errorf("not a GCS path: %s", name)
+	}
+	bucket, object, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("GCS path without a bucket: %s", name)
+	}
+	return bucket, object, nil
+}
+
+// objectURL returns the JSON API URL of an object, whose name is
+// escaped as a single path element
+func (g *gcsFS) objectURL(bucket, object string) string {
+	return g.endpoint + "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object)
+}
+
+// do sends an authenticated request
+func (g *gcsFS) do(method, target string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	token, err := g.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return g.client.Do(req)
+}
+
+// accessToken returns the configured token, or one of the metadata
+// server that is valid for at least another minute
+func (g *gcsFS) accessToken() (string, error) {
+	if !g.cfg.Metadata {
+		return g.cfg.Token, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expires) > time.Minute {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, g.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GCS access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GCS access token: metadata server answered %s", resp.Status)
+	}
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"` // Seconds
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("GCS access token: %v", err)
+	}
+	g.token = reply.AccessToken
+	g.expires = time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+func (g *gcsFS) Open(name string, offset int64) (io.ReadCloser, error) {
+	bucket, object, err := splitGCSPath(name)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := g.do(http.MethodGet, g.objectURL(bucket, object)+"?alt=media", header, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Reading from the end of the object on
+		resp.Body.Close()
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	case resp.StatusCode >= 300:
+		return nil, gcsError("open", name, resp)
+	}
+	return resp.Body, nil
+}
+
+// Create starts a resumable upload, which the returned writer feeds
+// chunk by chunk
+func (g *gcsFS) Create(name string) (io.WriteCloser, error) {
+	bucket, object, err := splitGCSPath(name)
+	if err != nil {
+		return nil, err
+	}
+	target := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(bucket) +
+		"/o?uploadType=resumable&name=" + url.QueryEscape(object)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := g.do(http.MethodPost, target, header, strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, gcsError("create", name, resp)
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fmt.Errorf("no upload session")}
+	}
+	return &gcsUpload{fs: g, name: name, session: session}, nil
+}
+
+func (g *gcsFS) Stat(name string) (fs.FileInfo, error) {
+	bucket, object, err := splitGCSPath(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.do(http.MethodGet, g.objectURL(bucket, object), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, gcsError("stat", name, resp)
+	}
+	defer resp.Body.Close()
+	var meta struct {
+		Size    string    `json:"size"` // Decimal, as JSON numbers cannot hold every uint64
+		Updated time.Time `json:"updated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	size, err := strconv.ParseInt(meta.Size, 10, 64)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return s3FileInfo{name: path.Base(object), size: size, modTime: meta.Updated}, nil
+}
+
+// Remove deletes an object. Removing a missing object succeeds, as it
+// does on S3.
+func (g *gcsFS) Remove(name string) error {
+	bucket, object, err := splitGCSPath(name)
+	if err != nil {
+		return err
+	}
+	resp, err := g.do(http.MethodDelete, g.objectURL(bucket, object), nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return gcsError("remove", name, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RemoveAll removes every object below dir
+func (g *gcsFS) RemoveAll(dir string) error {
+	bucket, prefix, err := splitGCSPath(dir)
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	objects, err := g.list(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := g.Remove("gs://" + bucket + "/" + object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gcsFS) MkdirAll(string) error { return nil }
+
+// Glob lists the objects sharing the pattern's prefix up to its first
+// wildcard and matches their names against it
+func (g *gcsFS) Glob(pattern string) ([]string, error) {
+	bucket, objectPattern, err := splitGCSPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	prefix := objectPattern
+	if i := strings.IndexAny(objectPattern, `*?[\`); i >= 0 {
+		prefix = objectPattern[:i]
+	}
+	objects, err := g.list(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, object := range objects {
+		ok, err := path.Match(objectPattern, object)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, "gs://"+bucket+"/"+object)
+		}
+	}
+	return matches, nil
+}
+
+// list returns the names of all objects of bucket starting with prefix
+func (g *gcsFS) list(bucket, prefix string) ([]string, error) {
+	var objects []string
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		target := g.endpoint + "/storage/v1/b/" + url.PathEscape(bucket) + "/o?" + query.Encode()
+		resp, err := g.do(http.MethodGet, target, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			return nil, gcsError("list", "gs://"+bucket+"/"+prefix, resp)
+		}
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list gs://%s/%s: %v", bucket, prefix, err)
+		}
+		for _, item := range result.Items {
+			objects = append(objects, item.Name)
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+// gcsError turns an unsuccessful response into an error. Missing
+// objects are reported like missing local files.
+func gcsError(op, name string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) != nil || body.Error.Message == "" {
+		return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s", resp.Status)}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s: %s", resp.Status, body.Error.Message)}
+}
+
+// gcsUpload feeds a resumable upload. Written data is buffered until a
+// full chunk is ready, and the rest is sent as the last chunk on Close.
+type gcsUpload struct {
+	fs      *gcsFS
+	name    string
+	session string // URL of the upload session
+	buf     []byte // Data not sent yet
+	start   int64  // Offset of buf in the object
+	err     error  // First failure, returned by every later call
+}
+
+func (u *gcsUpload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	u.buf = append(u.buf, p...)
+	// A full chunk is only sent once more data follows it, as the last
+	// chunk must carry the size of the object
+	for len(u.buf) > u.fs.chunkSize {
+		if _, u.err = u.send(u.fs.chunkSize, false); u.err != nil {
+			return 0, u.err
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends the last chunk, which completes the object
+func (u *gcsUpload) Close() error {
+	if u.err != nil {
+		return u.err
+	}
+	for {
+		done, err := u.send(len(u.buf), true)
+		if err != nil {
+			u.err = err
+			return err
+		}
+		if done {
+			u.err = fs.ErrClosed
+			return nil
+		}
+	}
+}
+
+// send uploads the first n bytes of buf and drops what the server
+// stored from it, reporting whether the object is complete. Servers
+// may store less than a chunk; the rest is sent again.
+func (u *gcsUpload) send(n int, last bool) (bool, error) {
+	total := "*"
+	if last {
+		total = strconv.FormatInt(u.start+int64(len(u.buf)), 10)
+	}
+	header := http.Header{}
+	if n == 0 {
+		header.Set("Content-Range", "bytes */"+total)
+	} else {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", u.start, u.start+int64(n)-1, total))
+	}
+	resp, err := u.fs.do(http.MethodPut, u.session, header, bytes.NewReader(u.buf[:n]))
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		resp.Body.Close()
+		return true, nil
+	case resp.StatusCode != http.StatusPermanentRedirect:
+		return false, gcsError("create", u.name, resp)
+	}
+	resp.Body.Close()
+
+	// 308 Resume Incomplete: Range says what the server has stored
+	stored := u.start
+	if r := resp.Header.Get("Range"); r != "" {
+		_, end, _ := strings.Cut(r, "-")
+		last, err := strconv.ParseInt(end, 10, 64)
+		if err != nil {
+			return false, &fs.PathError{Op: "create", Path: u.name, Err: fmt.Errorf("invalid range %q", r)}
+		}
+		stored = last + 1
+	}
+	if stored <= u.start || stored > u.start+int64(n) {
+		return false, &fs.PathError{Op: "create", Path: u.name,
+			Err: fmt.Errorf("upload stalled at %d, server stored up to %d", u.start, stored)}
+	}
+	u.buf = append(u.buf[:0], u.buf[stored-u.start:]...)
+	u.start = stored
+	return false, nil
+}
@@ -0,0 +1,229 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestGCSFileSystem writes, reads, lists and removes objects of a fake
+// Cloud Storage server through the file system helpers, with chunks
+// small enough that uploads take several requests.
+func TestGCSFileSystem(t *testing.T) {
+	store := newFakeGCS()
+	server := httptest.NewServer(store)
+	defer server.Close()
+	store.url = server.URL
+	fsys, err := newGCS(GCSConfig{Endpoint: server.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.chunkSize = 4
+	RegisterFileSystem("gs", fsys)
+	defer UseGCS(nil)
+
+	files := map[string]string{
+		"gs://bucket/job/a b.txt":    "hello world",
+		"gs://bucket/job/sub/c.txt":  "nested",
+		"gs://bucket/other/d.txt":    "elsewhere",
+		"gs://bucket/job/empty.txt":  "",
+		"gs://bucket/job/four.txt":   "four",
+		"gs://bucket/job/ünïcode.gz": "\x00\x01",
+	}
+	for name, content := range files {
+		if err := writeFile(name, []byte(content)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	for name, content := range files {
+		data, err := readFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("read %s = %q, %v, want %q", name, data, err, content)
+		}
+		info, err := statFile(name)
+		if err != nil || info.Size() != int64(len(content)) {
+			t.Errorf("stat %s = %v, %v, want size %d", name, info, err, len(content))
+		}
+	}
+
+	r, err := openFile("gs://bucket/job/a b.txt", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "world" {
+		t.Errorf("read from offset 6 = %q, want %q", data, "world")
+	}
+
+	if _, err := statFile("gs://bucket/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat of missing object = %v, want ErrNotExist", err)
+	}
+	if _, err := openFile("gs://bucket/missing", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open of missing object = %v, want ErrNotExist", err)
+	}
+
+	matches, err := globFiles("gs://bucket/job/*.txt")
+	want := []string{"gs://bucket/job/a b.txt", "gs://bucket/job/empty.txt", "gs://bucket/job/four.txt"}
+	if err != nil || !reflect.DeepEqual(matches, want) {
+		t.Errorf("glob = %v, %v, want %v", matches, err, want)
+	}
+
+	if err := removeAll("gs://bucket/job"); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.names(); !reflect.DeepEqual(got, []string{"bucket/other/d.txt"}) {
+		t.Errorf("objects after RemoveAll = %v", got)
+	}
+}
+
+// fakeGCS serves the JSON API requests of gcsFS from memory. Listings
+// come in pages of two objects, and the server stores at most three
+// bytes of every chunk that does not end an upload, to exercise
+// pagination and resending.
+type fakeGCS struct {
+	sync.Mutex
+	url      string
+	objects  map[string][]byte
+	sessions map[string]*fakeGCSUpload
+}
+
+type fakeGCSUpload struct {
+	name string
+	data []byte
+}
+
+func newFakeGCS() *fakeGCS {
+	return &fakeGCS{objects: make(map[string][]byte), sessions: make(map[string]*fakeGCSUpload)}
+}
+
+func (f *fakeGCS) names() []string {
+	f.Lock()
+	defer f.Unlock()
+	var names []string
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, `{"error":{"message":"unauthenticated"}}`, http.StatusUnauthorized)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	p := r.URL.EscapedPath()
+
+	if id, ok := strings.CutPrefix(p, "/session/"); ok {
+		f.upload(w, r, id)
+		return
+	}
+	if bucket, ok := strings.CutPrefix(p, "/upload/storage/v1/b/"); ok {
+		bucket = strings.TrimSuffix(bucket, "/o")
+		id := strconv.Itoa(len(f.sessions))
+		f.sessions[id] = &fakeGCSUpload{name: bucket + "/" + r.URL.Query().Get("name")}
+		w.Header().Set("Location", f.url+"/session/"+id)
+		return
+	}
+
+	rest, _ := strings.CutPrefix(p, "/storage/v1/b/")
+	bucket, object, isObject := strings.Cut(rest, "/o/")
+	if !isObject {
+		f.list(w, strings.TrimSuffix(bucket, "/o"), r.URL.Query())
+		return
+	}
+	object, _ = url.PathUnescape(object)
+	name := bucket + "/" + object
+	data, exists := f.objects[name]
+	switch {
+	case !exists:
+		http.Error(w, `{"error":{"message":"No such object"}}`, http.StatusNotFound)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Query().Get("alt") != "media":
+		json.NewEncoder(w).Encode(map[string]string{"name": object, "size": strconv.Itoa(len(data))})
+	default:
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if offset >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			data = data[offset:]
+		}
+		w.Write(data)
+	}
+}
+
+// upload stores a chunk of a resumable upload
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request, id string) {
+	session := f.sessions[id]
+	body, _ := io.ReadAll(r.Body)
+	var start, end int
+	var total string
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		total = strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes */")
+		start = len(session.data)
+	}
+	if start != len(session.data) || end-start+1 != len(body) && len(body) > 0 {
+		http.Error(w, "chunk out of order", http.StatusBadRequest)
+		return
+	}
+	if total == "*" {
+		body = body[:min(len(body), 3)]
+	}
+	session.data = append(session.data, body...)
+	if total == "*" || total != strconv.Itoa(len(session.data)) {
+		if len(session.data) > 0 {
+			w.Header().Set("Range", "bytes=0-"+strconv.Itoa(len(session.data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+	f.objects[session.name] = session.data
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, bucket string, query url.Values) {
+	var names []string
+	for name := range f.objects {
+		if object, ok := strings.CutPrefix(name, bucket+"/"); ok && strings.HasPrefix(object, query.Get("prefix")) {
+			names = append(names, object)
+		}
+	}
+	sort.Strings(names)
+	start, _ := strconv.Atoi(query.Get("pageToken"))
+	end := min(start+2, len(names))
+
+	type item struct {
+		Name string `json:"name"`
+	}
+	result := struct {
+		Items         []item `json:"items"`
+		NextPageToken string `json:"nextPageToken,omitempty"`
+	}{}
+	for _, name := range names[start:end] {
+		result.Items = append(result.Items, item{name})
+	}
+	if end < len(names) {
+		result.NextPageToken = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(result)
+}
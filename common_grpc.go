@@ -0,0 +1,248 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcMasterService lists the master RPCs served over gRPC
+type grpcMasterService interface {
+	Register(args *RegisterArgs, reply *RegisterReply) error
+	Shutdown(args *ShutdownArgs, reply *struct{}) error
+	ReportCrash(args *CrashReport, reply *struct{}) error
+	Heartbeat(args *HeartbeatArgs, reply *HeartbeatReply) error
+	Unregister(args *UnregisterArgs, reply *struct{}) error
+	AcquireTokens(args *AcquireArgs, reply *AcquireReply) error
+	FetchFile(args *FetchFileArgs, reply *FetchFileReply) error
+}
+
+// grpcWorkerService lists the worker RPCs served over gRPC
+type grpcWorkerService interface {
+	DoTask(args *DoTaskArgs, reply *DoTaskReply) error
+	Shutdown(args *ShutdownArgs, reply *ShutdownReply) error
+	FlushCache(args *FlushCacheArgs, reply *FlushCacheReply) error
+	Prepare(args *PrepareArgs, reply *PrepareReply) error
+	Ping(args *PingArgs, reply *struct{}) error
+	CancelTasks(args *CancelTasksArgs, reply *struct{}) error
+}
+
+// grpcMasterDesc describes the Master service of mapreduce.proto
+var grpcMasterDesc = grpc.ServiceDesc{
+	ServiceName: "mapreduce.Master",
+	HandlerType: (*grpcMasterService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(RegisterArgs), new(RegisterReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).Register(args, reply))
+			},
+		},
+		{
+			MethodName: "Shutdown",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(ShutdownArgs), new(struct{})
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).Shutdown(args, reply))
+			},
+		},
+		{
+			MethodName: "ReportCrash",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(CrashReport), new(struct{})
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).ReportCrash(args, reply))
+			},
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(HeartbeatArgs), new(HeartbeatReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).Heartbeat(args, reply))
+			},
+		},
+		{
+			MethodName: "Unregister",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(UnregisterArgs), new(struct{})
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).Unregister(args, reply))
+			},
+		},
+		{
+			MethodName: "AcquireTokens",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(AcquireArgs), new(AcquireReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).AcquireTokens(args, reply))
+			},
+		},
+		{
+			MethodName: "FetchFile",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(FetchFileArgs), new(FetchFileReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcMasterService).FetchFile(args, reply))
+			},
+		},
+	},
+	Metadata: "mapreduce.proto",
+}
+
+// grpcWorkerDesc describes the Worker service of mapreduce.proto
+var grpcWorkerDesc = grpc.ServiceDesc{
+	ServiceName: "mapreduce.Worker",
+	HandlerType: (*grpcWorkerService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DoTask",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(DoTaskArgs), new(DoTaskReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).DoTask(args, reply))
+			},
+		},
+		{
+			MethodName: "Shutdown",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(ShutdownArgs), new(ShutdownReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).Shutdown(args, reply))
+			},
+		},
+		{
+			MethodName: "FlushCache",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(FlushCacheArgs), new(FlushCacheReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).FlushCache(args, reply))
+			},
+		},
+		{
+			MethodName: "Prepare",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(PrepareArgs), new(PrepareReply)
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).Prepare(args, reply))
+			},
+		},
+		{
+			MethodName: "Ping",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(PingArgs), new(struct{})
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).Ping(args, reply))
+			},
+		},
+		{
+			MethodName: "CancelTasks",
+			Handler: func(srv interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				args, reply := new(CancelTasksArgs), new(struct{})
+				if err := dec(args); err != nil {
+					return nil, err
+				}
+				return grpcReply(reply, srv.(grpcWorkerService).CancelTasks(args, reply))
+			},
+		},
+	},
+	Metadata: "mapreduce.proto",
+}
+
+// grpcReply returns reply unless the handler failed
+func grpcReply(reply interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// grpcTransport serves and calls RPCs over gRPC with the protobuf
+// messages of mapreduce.proto, so nodes need not be written in Go.
+type grpcTransport struct{}
+
+// Serve answers gRPC requests for a master or a worker
+func (grpcTransport) Serve(l net.Listener, rcvr interface{}) error {
+	var desc *grpc.ServiceDesc
+	switch rcvr.(type) {
+	case grpcMasterService:
+		desc = &grpcMasterDesc
+	case grpcWorkerService:
+		desc = &grpcWorkerDesc
+	default:
+		return fmt.Errorf("%T cannot be served over gRPC", rcvr)
+	}
+
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(protoCodec{})}
+	if cfg, _ := tlsConfigs(); cfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(desc, rcvr)
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			processLog.errorf("gRPC server error: %v", err)
+		}
+		// Let RPCs in flight, e.g. the Shutdown that closed the
+		// listener, send their replies
+		server.GracefulStop()
+	}()
+	return nil
+}
+
+// Call connects to addr and performs a single unary call. Method
+// names such as "Worker.DoTask" map to "/mapreduce.Worker/DoTask".
+func (grpcTransport) Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	service, name, ok := strings.Cut(method, ".")
+	if !ok {
+		return fmt.Errorf("invalid RPC method %q", method)
+	}
+
+	network, address := ParseAddress(addr)
+	creds := insecure.NewCredentials()
+	if _, cfg := tlsConfigs(); cfg != nil {
+		creds = credentials.NewTLS(clientTLS(cfg, network, address))
+	}
+	conn, err := grpc.NewClient("passthrough:///"+address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(protoCodec{})),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Invoke(ctx, "/mapreduce."+service+"/"+name, args, reply)
+}
@@ -0,0 +1,351 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HDFSConfig locates the namenode of an HDFS cluster, which is reached
+// through its WebHDFS REST API.
+type HDFSConfig struct {
+	NameNode string `json:"namenode"` // HTTP address of the namenode, e.g. http://namenode:9870
+	User     string `json:"user"`     // User name for simple authentication
+	Token    string `json:"token"`    // Delegation token, used instead of User when set
+}
+
+// UseHDFS serves hdfs:// and webhdfs:// paths from the HDFS cluster
+// cfg describes, for input files, job workspaces and the result
+// directory alike. The host of hdfs:// paths, e.g. hdfs://nn:8020/data,
+// names the namenode's RPC port and is ignored in favour of
+// cfg.NameNode; webhdfs://host:port/data paths name the HTTP address
+// themselves. Like UseTLS the setting is process-wide: call it in the
+// master and every worker before they start. Passing nil turns HDFS
+// off again.
+func UseHDFS(cfg *HDFSConfig) error {
+	if cfg == nil {
+		fileSystems.Lock()
+		delete(fileSystems.byScheme, "hdfs")
+		delete(fileSystems.byScheme, "webhdfs")
+		fileSystems.Unlock()
+		return nil
+	}
+	fsys, err := newHDFS(*cfg)
+	if err != nil {
+		return err
+	}
+	RegisterFileSystem("hdfs", fsys)
+	RegisterFileSystem("webhdfs", fsys)
+	return nil
+}
+
+// hdfsFS implements FileSystem over WebHDFS. Reads and writes are
+// redirected by the namenode to a datanode; files are streamed to the
+// datanode as they are written.
+type hdfsFS struct {
+	cfg      HDFSConfig
+	nameNode *url.URL
+	client   *http.Client
+}
+
+// newHDFS validates cfg
+func newHDFS(cfg HDFSConfig) (*hdfsFS, error) {
+	h := &hdfsFS{
+		cfg: cfg,
+		// Redirects are followed by hand: the namenode's answer to a
+		// create must not be replayed with an empty body
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+	if cfg.NameNode != "" {
+		u, err := url.Parse(cfg.NameNode)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid HDFS namenode %q", cfg.NameNode)
+		}
+		h.nameNode = u
+	}
+	return h, nil
+}
+
+// splitHDFSPath returns the scheme and authority prefix of a path,
+// e.g. "hdfs://nn:8020", and the absolute path in the cluster
+func splitHDFSPath(name string) (prefix, p string) {
+	scheme, rest, _ := strings.Cut(name, "://")
+	authority, p, _ := strings.Cut(rest, "/")
+	return scheme + "://" + authority, path.Clean("/" + p)
+}
+
+// url returns the WebHDFS URL of operation op on a path
+func (h *hdfsFS) url(name, op string, params url.Values) (string, error) {
+	prefix, p := splitHDFSPath(name)
+	base := h.nameNode
+	if authority := strings.TrimPrefix(prefix, "webhdfs://"); authority != prefix && authority != "" {
+		base = &url.URL{Scheme: "http", Host: authority}
+	}
+	if base == nil {
+		return "", fmt.Errorf("no HDFS namenode configured for %s", name)
+	}
+	u := *base
+	u.Path = strings.TrimSuffix(base.Path, "/") + "/webhdfs/v1" + p
+	query := url.Values{"op": {op}}
+	for key, values := range params {
+		query[key] = values
+	}
+	if h.cfg.Token != "" {
+		query.Set("delegation", h.cfg.Token)
+	} else if h.cfg.User != "" {
+		query.Set("user.name", h.cfg.User)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// do sends operation op on a path to the namenode. GET requests follow
+// the namenode's redirect to a datanode.
+func (h *hdfsFS) do(method, name, op string, params url.Values) (*http.Response, error) {
+	target, err := h.url(name, op, params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil || method != http.MethodGet || resp.StatusCode != http.StatusTemporaryRedirect {
+		return resp, err
+	}
+	resp.Body.Close()
+	return h.client.Get(resp.Header.Get("Location"))
+}
+
+func (h *hdfsFS) Open(name string, offset int64) (io.ReadCloser, error) {
+	params := url.Values{}
+	if offset > 0 {
+		params.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	resp, err := h.do(http.MethodGet, name, "OPEN", params)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, hdfsError("open", name, resp)
+	}
+	return resp.Body, nil
+}
+
+// Create asks the namenode where to write the file and streams the
+// written data to that datanode
+func (h *hdfsFS) Create(name string) (io.WriteCloser, error) {
+	resp, err := h.do(http.MethodPut, name, "CREATE", url.Values{"overwrite": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		return nil, hdfsError("create", name, resp)
+	}
+	resp.Body.Close()
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, resp.Header.Get("Location"), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	done := make(chan error, 1)
+	go func() {
+		resp, err := h.client.Do(req)
+		if err == nil && resp.StatusCode != http.StatusCreated {
+			err = hdfsError("create", name, resp)
+		} else if err == nil {
+			resp.Body.Close()
+		}
+		pr.CloseWithError(err) // Fails further writes once the upload failed
+		done <- err
+	}()
+	return &hdfsUpload{PipeWriter: pw, done: done}, nil
+}
+
+func (h *hdfsFS) Stat(name string) (fs.FileInfo, error) {
+	resp, err := h.do(http.MethodGet, name, "GETFILESTATUS", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, hdfsError("stat", name, resp)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		FileStatus hdfsFileStatus
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	_, p := splitHDFSPath(name)
+	reply.FileStatus.PathSuffix = path.Base(p)
+	return reply.FileStatus, nil
+}
+
+func (h *hdfsFS) Remove(name string) error {
+	return h.delete(name, false)
+}
+
+func (h *hdfsFS) RemoveAll(dir string) error {
+	return h.delete(dir, true)
+}
+
+// delete removes a path. Removing a missing path succeeds, like
+// deleting a missing object.
+func (h *hdfsFS) delete(name string, recursive bool) error {
+	resp, err := h.do(http.MethodDelete, name, "DELETE", url.Values{"recursive": {strconv.FormatBool(recursive)}})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return hdfsError("remove", name, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (h *hdfsFS) MkdirAll(dir string) error {
+	resp, err := h.do(http.MethodPut, dir, "MKDIRS", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return hdfsError("mkdir", dir, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Glob matches the pattern one path element at a time, listing the
+// directories whose entries an element with wildcards has to match
+func (h *hdfsFS) Glob(pattern string) ([]string, error) {
+	prefix, p := splitHDFSPath(pattern)
+	elems := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	paths := []string{"/"}
+	for i, elem := range elems {
+		last := i == len(elems)-1
+		var next []string
+		for _, dir := range paths {
+			if !strings.ContainsAny(elem, `*?[\`) {
+				next = append(next, path.Join(dir, elem))
+				continue
+			}
+			entries, err := h.list(prefix + dir)
+			if err != nil {
+				continue // Not a directory, or gone
+			}
+			for _, entry := range entries {
+				ok, err := path.Match(elem, entry.PathSuffix)
+				if err != nil {
+					return nil, err
+				}
+				if ok && (last || entry.IsDir()) {
+					next = append(next, path.Join(dir, entry.PathSuffix))
+				}
+			}
+		}
+		paths = next
+	}
+
+	var matches []string
+	for _, p := range paths {
+		// Elements without wildcards were taken on trust
+		if _, err := h.Stat(prefix + p); err == nil {
+			matches = append(matches, prefix+p)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// list returns the entries of a directory
+func (h *hdfsFS) list(dir string) ([]hdfsFileStatus, error) {
+	resp, err := h.do(http.MethodGet, dir, "LISTSTATUS", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, hdfsError("list", dir, resp)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		FileStatuses struct {
+			FileStatus []hdfsFileStatus
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, &fs.PathError{Op: "list", Path: dir, Err: err}
+	}
+	return reply.FileStatuses.FileStatus, nil
+}
+
+// hdfsError turns an unsuccessful response into an error. Missing
+// files are reported like missing local files.
+func hdfsError(op, name string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	var body struct {
+		RemoteException struct {
+			Exception string
+			Message   string
+		}
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) != nil || body.RemoteException.Exception == "" {
+		return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s", resp.Status)}
+	}
+	return &fs.PathError{Op: op, Path: name,
+		Err: fmt.Errorf("%s: %s", body.RemoteException.Exception, body.RemoteException.Message)}
+}
+
+// hdfsUpload streams a new file to its datanode
+type hdfsUpload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+// Close finishes the upload and waits for the datanode to store it
+func (u *hdfsUpload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
+
+// hdfsFileStatus is the WebHDFS description of a file or directory
+type hdfsFileStatus struct {
+	PathSuffix       string `json:"pathSuffix"`
+	Type             string `json:"type"`
+	Length           int64  `json:"length"`
+	ModificationTime int64  `json:"modificationTime"` // Milliseconds since the epoch
+	Permission       string `json:"permission"`       // Octal
+}
+
+func (s hdfsFileStatus) Name() string       { return s.PathSuffix }
+func (s hdfsFileStatus) Size() int64        { return s.Length }
+func (s hdfsFileStatus) ModTime() time.Time { return time.UnixMilli(s.ModificationTime) }
+func (s hdfsFileStatus) IsDir() bool        { return s.Type == "DIRECTORY" }
+func (s hdfsFileStatus) Sys() any           { return nil }
+
+func (s hdfsFileStatus) Mode() fs.FileMode {
+	perm, _ := strconv.ParseUint(s.Permission, 8, 32)
+	mode := fs.FileMode(perm) & fs.ModePerm
+	if s.IsDir() {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
@@ -0,0 +1,186 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestHDFSFileSystem writes, reads, lists and removes files of a fake
+// WebHDFS cluster through the file system helpers.
+func TestHDFSFileSystem(t *testing.T) {
+	cluster := newFakeWebHDFS()
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+	cluster.url = server.URL
+	if err := UseHDFS(&HDFSConfig{NameNode: server.URL, User: "mr"}); err != nil {
+		t.Fatal(err)
+	}
+	defer UseHDFS(nil)
+
+	files := map[string]string{
+		"hdfs://nn:8020/job/a b.txt":                              "hello world",
+		"hdfs:///job/sub/c.txt":                                   "nested",
+		"hdfs:///job/sub/d.log":                                   "log",
+		"hdfs:///other/e.txt":                                     "elsewhere",
+		"hdfs:///job/empty.txt":                                   "",
+		"webhdfs://" + server.URL[len("http://"):] + "/job/f.txt": "by address",
+	}
+	for name, content := range files {
+		if err := mkdirAll(dirPath(name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFile(name, []byte(content)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	for name, content := range files {
+		data, err := readFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("read %s = %q, %v, want %q", name, data, err, content)
+		}
+		info, err := statFile(name)
+		if err != nil || info.Size() != int64(len(content)) || info.IsDir() {
+			t.Errorf("stat %s = %v, %v, want file of size %d", name, info, err, len(content))
+		}
+	}
+
+	r, err := openFile("hdfs:///job/a b.txt", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "world" {
+		t.Errorf("read from offset 6 = %q, want %q", data, "world")
+	}
+
+	if _, err := statFile("hdfs:///missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat of missing file = %v, want ErrNotExist", err)
+	}
+
+	matches, err := globFiles("hdfs:///job/*/*.txt")
+	if want := []string{"hdfs:///job/sub/c.txt"}; err != nil || !reflect.DeepEqual(matches, want) {
+		t.Errorf("glob = %v, %v, want %v", matches, err, want)
+	}
+	matches, err = globFiles("hdfs:///job/*.txt")
+	want := []string{"hdfs:///job/a b.txt", "hdfs:///job/empty.txt", "hdfs:///job/f.txt"}
+	if err != nil || !reflect.DeepEqual(matches, want) {
+		t.Errorf("glob = %v, %v, want %v", matches, err, want)
+	}
+
+	if err := removeAll("hdfs:///job"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cluster.paths(); !reflect.DeepEqual(got, []string{"/", "/other", "/other/e.txt"}) {
+		t.Errorf("paths after RemoveAll = %v", got)
+	}
+}
+
+// fakeWebHDFS serves WebHDFS requests from memory. It plays both the
+// namenode, which redirects reads and writes, and the datanode.
+type fakeWebHDFS struct {
+	sync.Mutex
+	url   string
+	files map[string][]byte // Directories map to nil
+}
+
+func newFakeWebHDFS() *fakeWebHDFS {
+	return &fakeWebHDFS{files: make(map[string][]byte)}
+}
+
+func (f *fakeWebHDFS) paths() []string {
+	f.Lock()
+	defer f.Unlock()
+	var paths []string
+	for p := range f.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("user.name") != "mr" {
+		http.Error(w, `{"RemoteException":{"exception":"SecurityException","message":"no user"}}`, http.StatusUnauthorized)
+		return
+	}
+	p, ok := strings.CutPrefix(r.URL.Path, "/webhdfs/v1")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	data, exists := f.files[p]
+
+	switch op := query.Get("op"); {
+	case (op == "OPEN" || op == "CREATE") && query.Get("datanode") == "":
+		query.Set("datanode", "true")
+		http.Redirect(w, r, f.url+r.URL.Path+"?"+query.Encode(), http.StatusTemporaryRedirect)
+	case op == "OPEN":
+		if !exists || data == nil {
+			http.Error(w, `{"RemoteException":{"exception":"FileNotFoundException"}}`, http.StatusNotFound)
+			return
+		}
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		w.Write(data[min(offset, len(data)):])
+	case op == "CREATE":
+		if _, ok := f.files[path.Dir(p)]; !ok {
+			http.Error(w, `{"RemoteException":{"exception":"FileNotFoundException","message":"no parent"}}`, http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.files[p] = append([]byte{}, body...)
+		w.WriteHeader(http.StatusCreated)
+	case op == "MKDIRS":
+		for dir := p; dir != "/"; dir = path.Dir(dir) {
+			f.files[dir] = nil
+		}
+		f.files["/"] = nil
+		json.NewEncoder(w).Encode(map[string]bool{"boolean": true})
+	case op == "DELETE":
+		for name := range f.files {
+			if name == p || query.Get("recursive") == "true" && strings.HasPrefix(name, p+"/") {
+				delete(f.files, name)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"boolean": exists})
+	case op == "GETFILESTATUS":
+		if !exists {
+			http.Error(w, `{"RemoteException":{"exception":"FileNotFoundException"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"FileStatus": fakeStatus("", data)})
+	case op == "LISTSTATUS":
+		var entries []hdfsFileStatus
+		for name, data := range f.files {
+			if name != "/" && path.Dir(name) == p {
+				entries = append(entries, fakeStatus(path.Base(name), data))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"FileStatuses": map[string]any{"FileStatus": entries}})
+	default:
+		http.Error(w, "unsupported", http.StatusBadRequest)
+	}
+}
+
+func fakeStatus(name string, data []byte) hdfsFileStatus {
+	if data == nil {
+		return hdfsFileStatus{PathSuffix: name, Type: "DIRECTORY", Permission: "755"}
+	}
+	return hdfsFileStatus{PathSuffix: name, Type: "FILE", Length: int64(len(data)), Permission: "644"}
+}
@@ -0,0 +1,282 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// InputFormat divides the input files of a job into splits, one per
+// map task, and the input of every split into records, one per map
+// function call. The master asks for the splits when the job starts
+// and workers read the records in every map task, so a format must be
+// registered under the same name in the master and all workers.
+type InputFormat interface {
+	// Splits returns the splits of files in task order
+	Splits(files []string, opts JobOptions) ([]SplitInfo, error)
+
+	// Reader returns the records of split. open opens the split's
+	// file for reading from an offset on; the caller closes it.
+	Reader(split SplitInfo, opts JobOptions, open func(offset int64) io.Reader) RecordReader
+}
+
+// RecordReader returns the records of one split in order
+type RecordReader interface {
+	// Next returns the next record, or io.EOF after the last one
+	Next() ([]byte, error)
+}
+
+// Names of the built-in input formats
+const (
+	// InputWholeFile passes every input file to a single map call,
+	// the default
+	InputWholeFile = "whole-file"
+	// InputLineSplits splits files into about SplitSize bytes of
+	// whole lines and passes every split to a single map call
+	InputLineSplits = "line-splits"
+	// InputLines passes every line, without its newline, to a map
+	// call of its own. Files are split into about SplitSize bytes.
+	InputLines = "lines"
+	// InputDelimited passes every record ending with RecordDelimiter,
+	// without the delimiter, to a map call of its own
+	InputDelimited = "delimited"
+	// InputFixedLength passes every record of RecordSize bytes to a
+	// map call of its own
+	InputFixedLength = "fixed-length"
+)
+
+// inputFormats holds the input formats by name
+var inputFormats = struct {
+	sync.Mutex
+	byName map[string]InputFormat
+}{byName: map[string]InputFormat{
+	InputWholeFile:   wholeFileFormat{},
+	InputLineSplits:  lineSplitsFormat{},
+	InputLines:       delimitedFormat{delim: "\n"},
+	InputDelimited:   delimitedFormat{},
+	InputFixedLength: fixedLengthFormat{},
+	InputCSV:         csvFormat{},
+}}
+
+// RegisterInputFormat makes a custom input format available to jobs
+// under name, see WithInputFormat. Register it in the master and in
+// every worker before the first job using it starts.
+func RegisterInputFormat(name string, format InputFormat) {
+	inputFormats.Lock()
+	defer inputFormats.Unlock()
+	inputFormats.byName[name] = format
+}
+
+// InputFormats returns the names of the registered input formats
+func InputFormats() []string {
+	inputFormats.Lock()
+	defer inputFormats.Unlock()
+	names := make([]string, 0, len(inputFormats.byName))
+	for name := range inputFormats.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// inputFormatName returns the name of the job's input format. Jobs
+// not naming one get the built-in format their options ask for.
+func (o JobOptions) inputFormatName() string {
+	switch {
+	case o.InputFormat != "":
+		return o.InputFormat
+	case o.RecordSize > 0:
+		return InputFixedLength
+	case o.RecordDelimiter != "":
+		return InputDelimited
+	case o.SplitSize > 0:
+		return InputLineSplits
+	default:
+		return InputWholeFile
+	}
+}
+
+// inputFormat returns the job's input format
+func (o JobOptions) inputFormat() (InputFormat, error) {
+	return lookupInputFormat(o.inputFormatName())
+}
+
+// groupInputFormat returns the input format of an input group, the
+// job's unless the group has its own
+func (o JobOptions) groupInputFormat(group string) (InputFormat, error) {
+	if name := o.GroupFormats[group]; name != "" {
+		return lookupInputFormat(name)
+	}
+	return o.inputFormat()
+}
+
+// lookupInputFormat returns the input format registered under name
+func lookupInputFormat(name string) (InputFormat, error) {
+	inputFormats.Lock()
+	defer inputFormats.Unlock()
+	format, ok := inputFormats.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown input format %q", name)
+	}
+	return format, nil
+}
+
+// InputGroup is a named part of a job's input, e.g. one side of a
+// join, see JobConfig.Inputs. Map tasks of its files find the group's
+// name in TaskContext.Group.
+type InputGroup struct {
+	Name   string   `json:"name"`
+	Files  []string `json:"files"`
+	Format string   `json:"format,omitempty"` // Input format of the files, the job's if empty
+}
+
+// inputSplits returns the splits of the ungrouped input files followed
+// by those of every input group, each divided by its own input format
+func inputSplits(files []string, groups []InputGroup, opts JobOptions) ([]SplitInfo, error) {
+	var splits []SplitInfo
+	if len(files) > 0 {
+		format, err := opts.inputFormat()
+		if err != nil {
+			return nil, err
+		}
+		if splits, err = format.Splits(sortSplits(files), opts); err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range groups {
+		format, err := opts.groupInputFormat(group.Name)
+		if err != nil {
+			return nil, fmt.Errorf("input group %s: %v", group.Name, err)
+		}
+		groupSplits, err := format.Splits(sortSplits(group.Files), opts)
+		if err != nil {
+			return nil, fmt.Errorf("input group %s: %v", group.Name, err)
+		}
+		for i := range groupSplits {
+			groupSplits[i].Group = group.Name
+		}
+		splits = append(splits, groupSplits...)
+	}
+	return splits, nil
+}
+
+// wholeFileFormat implements InputWholeFile
+type wholeFileFormat struct{}
+
+func (wholeFileFormat) Splits(files []string, _ JobOptions) ([]SplitInfo, error) {
+	return splitInputs(files, 0, 0), nil
+}
+
+func (wholeFileFormat) Reader(_ SplitInfo, _ JobOptions, open func(offset int64) io.Reader) RecordReader {
+	return &wholeReader{in: open(0)}
+}
+
+// lineSplitsFormat implements InputLineSplits
+type lineSplitsFormat struct{}
+
+func (lineSplitsFormat) Splits(files []string, opts JobOptions) ([]SplitInfo, error) {
+	return splitInputs(files, opts.SplitSize, 0), nil
+}
+
+func (lineSplitsFormat) Reader(split SplitInfo, _ JobOptions, open func(offset int64) io.Reader) RecordReader {
+	return &joinedReader{records: newDelimitedReader(split, []byte("\n"), true, open)}
+}
+
+// delimitedFormat implements InputLines and InputDelimited
+type delimitedFormat struct {
+	delim string // Delimiter of the records, RecordDelimiter if empty
+}
+
+func (f delimitedFormat) Splits(files []string, opts JobOptions) ([]SplitInfo, error) {
+	if f.delim == "" && opts.RecordDelimiter == "" {
+		return nil, fmt.Errorf("input format %s needs a record delimiter", InputDelimited)
+	}
+	return splitInputs(files, opts.SplitSize, 0), nil
+}
+
+func (f delimitedFormat) Reader(split SplitInfo, opts JobOptions, open func(offset int64) io.Reader) RecordReader {
+	delim := f.delim
+	if delim == "" {
+		delim = opts.RecordDelimiter
+	}
+	return newDelimitedReader(split, []byte(delim), false, open)
+}
+
+// fixedLengthFormat implements InputFixedLength
+type fixedLengthFormat struct{}
+
+func (fixedLengthFormat) Splits(files []string, opts JobOptions) ([]SplitInfo, error) {
+	if opts.RecordSize <= 0 {
+		return nil, fmt.Errorf("input format %s needs a record size", InputFixedLength)
+	}
+	return splitInputs(files, opts.SplitSize, opts.RecordSize), nil
+}
+
+func (fixedLengthFormat) Reader(split SplitInfo, opts JobOptions, open func(offset int64) io.Reader) RecordReader {
+	return newFixedReader(split, opts.RecordSize, open)
+}
+
+// readRecords passes every record of a map task's split to emit,
+// transcoded to UTF-8 if the job asks for it. It stops early with the
+// error of ctx once ctx is done.
+func readRecords(ctx context.Context, split SplitInfo, opts JobOptions, disk *taskDisk, emit func(record string)) error {
+	format, err := opts.groupInputFormat(split.Group)
+	if err != nil {
+		return err
+	}
+
+	// Readers of the input format get an empty input once a file
+	// cannot be opened, and the error once they return
+	var closers []func()
+	var openErr error
+	defer func() {
+		for _, closeIn := range closers {
+			closeIn()
+		}
+	}()
+	open := func(offset int64) io.Reader {
+		in, closeIn, err := openMapInput(split.File, offset, opts, disk)
+		if err != nil {
+			openErr = err
+			return bytes.NewReader(nil)
+		}
+		closers = append(closers, closeIn)
+		return in
+	}
+
+	records := format.Reader(split, opts, open)
+	done := ctx.Done()
+	for {
+		if isClosed(done) {
+			return ctx.Err()
+		}
+		record, err := records.Next()
+		switch {
+		case openErr != nil:
+			return openErr
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return fmt.Errorf("read file %s: %w", split.File, err)
+		}
+		record, err = decodeMapInput(split.File, record, opts)
+		if err != nil {
+			return err
+		}
+		emit(string(record))
+	}
+}
+
+// mapInput applies mapF to every record of a map task's split and
+// returns the output of all calls, or the error of ctx once it is done
+func mapInput(ctx context.Context, split SplitInfo, mapF func(string, string) []KeyValue, opts JobOptions, disk *taskDisk) ([]KeyValue, error) {
+	var kva []KeyValue
+	err := readRecords(ctx, split, opts, disk, func(record string) {
+		kva = append(kva, mapF(split.File, record)...)
+	})
+	return kva, err
+}
@@ -0,0 +1,34 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// jsonRPCTransport serves and calls RPCs with JSON-RPC 1.0 over TCP,
+// the messages being the JSON encoding of the RPC argument and reply
+// types. The protocol is simple enough to implement workers in any
+// language, see the worker protocol in the Readme and
+// mapreducetest.TestWorker.
+type jsonRPCTransport struct{}
+
+// Serve answers JSON-RPC requests, one goroutine per connection
+func (jsonRPCTransport) Serve(l net.Listener, rcvr interface{}) error {
+	return serveRPC(l, rcvr, func(server *rpc.Server, conn net.Conn) {
+		server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	})
+}
+
+// Call dials addr and performs a single JSON-RPC call
+func (jsonRPCTransport) Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	conn, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	c := jsonrpc.NewClient(conn)
+	defer c.Close()
+	return callRPC(ctx, c, method, args, reply)
+}
@@ -0,0 +1,104 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyFilter decides whether a job keeps the map output records with a
+// given key, for jobs interested in a few keys only, e.g. the domains
+// ending in ".com". Records it drops are discarded by the map task and
+// never reach a reduce task.
+type KeyFilter func(key string) bool
+
+// keyFilters holds the key filters by name
+var keyFilters = struct {
+	sync.Mutex
+	byName map[string]KeyFilter
+}{byName: make(map[string]KeyFilter)}
+
+// RegisterKeyFilter makes a key filter available to jobs under name,
+// see WithKeyFilter. Register it in the master and in every worker
+// before the first job using it starts.
+func RegisterKeyFilter(name string, filter KeyFilter) {
+	keyFilters.Lock()
+	defer keyFilters.Unlock()
+	keyFilters.byName[name] = filter
+}
+
+// KeyFilters returns the names of the registered key filters
+func KeyFilters() []string {
+	keyFilters.Lock()
+	defer keyFilters.Unlock()
+	names := make([]string, 0, len(keyFilters.byName))
+	for name := range keyFilters.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyFilter returns the filter of the job's map output, nil for jobs
+// keeping every record. Keys are kept if they start with one of the
+// job's key prefixes, when it has any, and pass its named filter.
+func (o JobOptions) keyFilter() (KeyFilter, error) {
+	var named KeyFilter
+	if o.KeyFilter != "" {
+		keyFilters.Lock()
+		named = keyFilters.byName[o.KeyFilter]
+		keyFilters.Unlock()
+		if named == nil {
+			return nil, fmt.Errorf("unknown key filter %q", o.KeyFilter)
+		}
+	}
+	prefixes := o.KeyPrefixes
+	switch {
+	case len(prefixes) == 0:
+		return named, nil
+	case named == nil:
+		named = func(string) bool { return true }
+	}
+	return func(key string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return named(key)
+			}
+		}
+		return false
+	}, nil
+}
+
+// filtersKeys reports whether the job drops map output records by key
+func (o JobOptions) filtersKeys() bool {
+	return o.KeyFilter != "" || len(o.KeyPrefixes) > 0
+}
+
+// keptRecords returns the records of kva the job's key filter keeps,
+// and how many of them fall into each of nReduce partitions. Jobs not
+// filtering keys keep all records and get no counts.
+func keptRecords(kva []KeyValue, nReduce int, opts JobOptions) ([]KeyValue, []int64, error) {
+	keep, err := opts.keyFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+	if keep == nil {
+		return kva, nil, nil
+	}
+	order, err := opts.keyOrder()
+	if err != nil {
+		return nil, nil, err
+	}
+	kept := kva[:0:0]
+	counts := make([]int64, nReduce)
+	for _, kv := range kva {
+		group := order.group(kv.Key)
+		if keep(group) {
+			kept = append(kept, kv)
+			counts[partitionOf(group, nReduce, opts.RangeBounds)]++
+		}
+	}
+	return kept, counts, nil
+}
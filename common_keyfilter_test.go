@@ -0,0 +1,49 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestKeyFilter combines key prefixes with a registered filter and
+// checks which records are kept and how they are counted per
+// partition, by group key under a key order.
+func TestKeyFilter(t *testing.T) {
+	RegisterKeyFilter("short", func(key string) bool { return len(key) <= 6 })
+	kva := []KeyValue{
+		{"com.a", "1"}, {"com.abcdef", "2"}, {"org.a", "3"}, {"net.b", "4"}, {"com.b", "5"},
+	}
+
+	opts := JobOptions{KeyPrefixes: []string{"com.", "net."}, KeyFilter: "short"}
+	kept, counts, err := keptRecords(kva, 3, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyValue{{"com.a", "1"}, {"net.b", "4"}, {"com.b", "5"}}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	wantCounts := make([]int64, 3)
+	for _, kv := range want {
+		wantCounts[ihash(kv.Key)%3]++
+	}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("counts %v, want %v", counts, wantCounts)
+	}
+
+	// Under a key order the filter sees the group key only
+	opts = JobOptions{KeyPrefixes: []string{"u1"}, KeyOrder: KeyOrderComposite}
+	kept, _, _ = keptRecords([]KeyValue{{CompositeKey("u1", "u2"), ""}, {CompositeKey("u2", "u1"), ""}}, 2, opts)
+	if len(kept) != 1 || !strings.HasPrefix(kept[0].Key, "u1") {
+		t.Errorf("kept %q, want the records of group u1", kept)
+	}
+
+	if kept, counts, _ := keptRecords(kva, 3, JobOptions{}); len(kept) != len(kva) || counts != nil {
+		t.Errorf("job without filter kept %d of %d records, counts %v", len(kept), len(kva), counts)
+	}
+	if _, err := (JobOptions{KeyFilter: "missing"}).keyFilter(); err == nil {
+		t.Errorf("unknown key filter resolved")
+	}
+}
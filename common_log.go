@@ -0,0 +1,96 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Logger receives the log messages of masters and workers at four
+// levels, with key/value attributes such as the worker a message
+// comes from. *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// logging holds the logger of this process
+var logging = struct {
+	sync.RWMutex
+	logger Logger
+}{}
+
+// UseLogger sends the log messages of this process to logger, unless a
+// master or worker has a logger of its own, see WithLogger and
+// WithWorkerLogger. Like UseMetrics the setting is process-wide.
+// Passing nil, the default, logs through slog.Default, which writes to
+// the standard logger unless the program configured it otherwise.
+// Per-task messages are logged at the debug level.
+func UseLogger(logger Logger) {
+	logging.Lock()
+	defer logging.Unlock()
+	logging.logger = logger
+}
+
+// nodeLog writes the log messages of a master, a worker or code
+// running on behalf of neither
+type nodeLog struct {
+	logger Logger // The node's own logger, nil for the process logger
+	attrs  []any  // Attributes of every message, e.g. the worker's name
+}
+
+// log formats a message and sends it to the logger at level
+func (l nodeLog) log(level slog.Level, format string, args ...any) {
+	logger, custom := l.logger, l.logger != nil
+	if logger == nil {
+		logging.RLock()
+		logger, custom = logging.logger, logging.logger != nil
+		logging.RUnlock()
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	msg := fmt.Sprintf(format, args...)
+	if custom {
+		// The default logger reaches crash reports through the
+		// standard logger, others are recorded here
+		logTail.record(level.String() + " " + msg)
+	}
+	switch level {
+	case slog.LevelDebug:
+		logger.Debug(msg, l.attrs...)
+	case slog.LevelWarn:
+		logger.Warn(msg, l.attrs...)
+	case slog.LevelError:
+		logger.Error(msg, l.attrs...)
+	default:
+		logger.Info(msg, l.attrs...)
+	}
+}
+
+// debugf logs a message about a single task or file
+func (l nodeLog) debugf(format string, args ...any) {
+	l.log(slog.LevelDebug, format, args...)
+}
+
+// infof logs a message about the progress of a job or node
+func (l nodeLog) infof(format string, args ...any) {
+	l.log(slog.LevelInfo, format, args...)
+}
+
+// warnf logs a failure the node recovers from, e.g. a task to retry
+func (l nodeLog) warnf(format string, args ...any) {
+	l.log(slog.LevelWarn, format, args...)
+}
+
+// errorf logs a failure the node cannot recover from, e.g. a failed job
+func (l nodeLog) errorf(format string, args ...any) {
+	l.log(slog.LevelError, format, args...)
+}
+
+// processLog writes the messages of code running on behalf of no
+// particular master or worker, e.g. map and reduce tasks
+var processLog nodeLog
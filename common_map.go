@@ -2,10 +2,10 @@
 package mapreduce
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"log"
-	"os"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 )
 
 // doMap manages the map phase of a MapReduce job.
@@ -13,71 +13,416 @@ import (
 // into intermediate files for the reduce phase.
 //
 // The map phase works as follows:
-// 1. Reads the entire input file into memory
+// 1. Reads the input file, or the task's split of it, into memory
 // 2. Applies the user's map function to generate key-value pairs
 // 3. Partitions the pairs across nReduce intermediate files
-// 4. Writes each partition using JSON encoding
+// 4. Writes each partition using JSON encoding and the job's codec
+//
+// The returned metrics carry the digest of the map function's output,
+// taken before combining, and the number of its records violating the
+// job's schema.
+//
+// Unless the job forces a codec, the task's first spill block is
+// sampled to decide whether and how to compress its output.
+//
+// With a combiner, the values of every key are combined before they
+// are partitioned, so each partition holds one record per key. This
+// shrinks the intermediate data of jobs like word count, which emit
+// the same key many times.
+//
+// Records reach the partition files through bounded queues, one
+// writer per partition, and the deepest queue observed is returned
+// as part of the task metrics. The queues do not bound the task's
+// memory here, since the whole map output is held before it is
+// partitioned; only doStreamMap applies backpressure to the map
+// function.
+//
+// On workers sharing their disk between concurrent tasks, all file
+// I/O goes through disk, which interleaves it with the I/O of the
+// other tasks. Pass nil for plain file I/O.
+//
+// The task stops reading its input once ctx is done and returns the
+// error of ctx; the partition files are left incomplete then.
 //
 // Parameters:
+//   - ctx: Cancels the task
 //   - jobName: Unique identifier for the MapReduce job
 //   - mapTaskNumber: Index of this map task (0-based)
-//   - inFile: Path to the input file to process
+//   - split: Input file, or the part of it, to process
 //   - nReduce: Number of reduce tasks (determines number of partitions)
 //   - mapF: User-defined function to generate key-value pairs
+//   - combineF: Optional combiner applied to the map output, nil for none
+//   - opts: Job settings such as the intermediate compression codec
+//   - disk: Scheduled file I/O of this task, nil for plain I/O
 //
 // Error handling:
-//   - Fatally exits if the input file cannot be read
-//   - Fatally exits if intermediate files cannot be created
-//   - Fatally exits if JSON encoding fails
+//   - Fails if the input file cannot be read
+//   - Fails if intermediate files cannot be created or written
 //
 // The intermediate files use JSON encoding to ensure reliable
 // data transfer between map and reduce phases.
 func doMap(
+	ctx context.Context,
 	jobName JobParse,
 	mapTaskNumber int,
-	inFile string,
+	split SplitInfo,
 	nReduce int,
 	mapF func(string, string) []KeyValue,
-) {
-	// Read the entire input file into memory
-	// This simplifies the map function interface
-	file, err := os.Open(inFile)
+	combineF func(string, []string) string,
+	opts JobOptions,
+	disk *taskDisk,
+) (metrics TaskMetrics, err error) {
+	defer recoverTaskError(&err)
+	// Apply the user's map function to generate key-value pairs
+	// The job's input format decides whether the function processes
+	// the entire split at once or each of its records
+	kva, err := mapInput(ctx, split, mapF, opts, disk)
 	if err != nil {
-		log.Fatalf("doMap: open file %s error %v", inFile, err)
+		return TaskMetrics{}, err
+	}
+	digest := outputDigest(kva)
+	violations := checkSchema(kva, opts, mapTaskNumber)
+	if combineF != nil {
+		kva = combineMapOutput(kva, combineF)
+	}
+
+	ws := opts.workspace(jobName)
+	if err := ws.Create(); err != nil {
+		return TaskMetrics{}, err
 	}
-	defer file.Close()
 
-	content, err := ioutil.ReadAll(file)
+	names := make([]string, nReduce)
+	for i := range names {
+		names[i] = ws.Intermediate(mapTaskNumber, i)
+	}
+	metrics, err = writePartitions(ws, names, kva, opts, disk)
 	if err != nil {
-		log.Fatalf("doMap: read file %s error %v", inFile, err)
+		return TaskMetrics{}, err
 	}
+	metrics.OutputDigest = digest
+	metrics.SchemaViolations = violations
+	return metrics, nil
+}
 
-	// Apply the user's map function to generate key-value pairs
-	// The function processes the entire file content at once
-	kva := mapF(inFile, string(content))
+// Emitter receives the output of a streaming map function
+type Emitter interface {
+	Emit(kv KeyValue)
+}
 
-	// Create encoders and files for each reduce partition
-	// Each encoder will handle key-value pairs for one reducer
-	encoders := make([]*json.Encoder, nReduce)
-	files := make([]*os.File, nReduce)
+// StreamMapFunc is a map function called once per record of its
+// input: per line, unless the job sets a record format. It passes its
+// output to out as it goes instead of returning it.
+type StreamMapFunc func(file string, record string, out Emitter)
 
-	for i := 0; i < nReduce; i++ {
-		file, err := os.Create(reduceName(jobName, mapTaskNumber, i))
-		if err != nil {
-			log.Fatalf("doMap: create file error %v", err)
+// emitFunc adapts a function to the Emitter interface
+type emitFunc func(kv KeyValue)
+
+// Emit calls f
+func (f emitFunc) Emit(kv KeyValue) {
+	f(kv)
+}
+
+// doStreamMap runs a map task like doMap with a streaming map
+// function. The input is read one record at a time and the output
+// flows to the partition files through the bounded emit queues, so
+// memory use does not grow with the size of the input or the output.
+// Combiners are not applied.
+func doStreamMap(
+	ctx context.Context,
+	jobName JobParse,
+	mapTaskNumber int,
+	split SplitInfo,
+	nReduce int,
+	mapF StreamMapFunc,
+	opts JobOptions,
+	disk *taskDisk,
+) (TaskMetrics, error) {
+	ws := opts.workspace(jobName)
+	if err := ws.Create(); err != nil {
+		return TaskMetrics{}, err
+	}
+	if opts.inputFormatName() == InputWholeFile || opts.inputFormatName() == InputLineSplits {
+		// Reading whole files or splits at once defeats streaming;
+		// stream their lines instead
+		opts.InputFormat = InputLines
+	}
+
+	names := make([]string, nReduce)
+	for i := range names {
+		names[i] = ws.Intermediate(mapTaskNumber, i)
+	}
+	var digest uint64
+	schema := newSchemaChecker(opts, mapTaskNumber)
+	metrics, err := streamPartitions(ws, names, opts, disk, func(emit func(KeyValue)) error {
+		out := emitFunc(func(kv KeyValue) {
+			digest += recordDigest(kv)
+			schema.check(kv)
+			emit(kv)
+		})
+		return readRecords(ctx, split, opts, disk, func(record string) {
+			mapF(split.File, record, out)
+		})
+	})
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+	metrics.OutputDigest = digest
+	metrics.SchemaViolations = schema.count()
+	return metrics, nil
+}
+
+// combineMapOutput combines the values of each key of the map output
+// into a single record. Keys keep the order of their first occurrence.
+func combineMapOutput(kva []KeyValue, combineF func(string, []string) string) []KeyValue {
+	values := make(map[string][]string)
+	var keys []string
+	for _, kv := range kva {
+		if _, ok := values[kv.Key]; !ok {
+			keys = append(keys, kv.Key)
 		}
-		defer file.Close()
-		encoders[i] = json.NewEncoder(file)
-		files[i] = file
+		values[kv.Key] = append(values[kv.Key], kv.Value)
+	}
+
+	combined := make([]KeyValue, len(keys))
+	for i, key := range keys {
+		combined[i] = KeyValue{key, combineF(key, values[key])}
 	}
+	return combined
+}
 
-	// Partition map output by hashing each key
+// openMapInput opens an input file for reading from offset on.
+// With read-ahead enabled the next block is read while the previous
+// one is being consumed. The returned function closes the file.
+func openMapInput(inFile string, offset int64, opts JobOptions, disk *taskDisk) (io.Reader, func(), error) {
+	file, err := disk.openAt(inFile, offset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open file %s: %w", inFile, err)
+	}
+	if opts.ReadAhead <= 0 {
+		return file, func() { file.Close() }, nil
+	}
+	ra := newReadAheadReader(file, opts.ReadAhead)
+	return ra, func() {
+		ra.Close()
+		file.Close()
+	}, nil
+}
+
+// decodeMapInput transcodes input to UTF-8 when the job names the
+// encoding of its input files
+func decodeMapInput(inFile string, content []byte, opts JobOptions) ([]byte, error) {
+	if opts.InputEncoding == "" {
+		return content, nil
+	}
+	content, err := transcodeInput(content, opts.InputEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("decode file %s: %w", inFile, err)
+	}
+	return content, nil
+}
+
+// writePartitions hashes every record to one of the files in names
+// and writes it there, returning the metrics of the write.
+func writePartitions(ws *JobWorkspace, names []string, kva []KeyValue, opts JobOptions, disk *taskDisk) (TaskMetrics, error) {
+	return streamPartitions(ws, names, opts, disk, func(emit func(KeyValue)) error {
+		for _, kv := range kva {
+			emit(kv)
+		}
+		return nil
+	})
+}
+
+// streamPartitions writes every record passed to emit by produce to
+// the partition files in names. Unless the job forces a codec, records
+// are held back until a spill block's worth is available to pick the
+// codec from; everything after that streams straight to the writers.
+// An error of produce, or of creating the files, fails the write;
+// records emitted after the files could not be created are dropped.
+// The files are committed only if the write succeeded.
+func streamPartitions(ws *JobWorkspace, names []string, opts JobOptions, disk *taskDisk, produce func(emit func(KeyValue)) error) (TaskMetrics, error) {
+	codec, err := resolveRecordCodec(opts.RecordCodec, opts.IntermediateFormat)
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+
+	var out *partitionWriter
+	var openErr error
+	var held []KeyValue
+	var sample bytes.Buffer
+	enc := newRecordEncoder(&sample, codec)
+	start := func() {
+		out, openErr = openPartitions(ws, names, sample.Bytes(), codec, opts, disk)
+		if openErr != nil {
+			return
+		}
+		for _, kv := range held {
+			out.emit.Emit(kv)
+		}
+		held = nil
+	}
+
+	err = produce(func(kv KeyValue) {
+		switch {
+		case openErr != nil:
+		case out != nil:
+			out.emit.Emit(kv)
+		default:
+			held = append(held, kv)
+			enc.Encode(&kv)
+			if sample.Len() >= compressionSampleSize {
+				start()
+			}
+		}
+	})
+	if out == nil && openErr == nil {
+		start()
+	}
+	if openErr != nil {
+		return TaskMetrics{}, openErr
+	}
+	metrics, closeErr := out.close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = out.commit()
+	}
+	if err != nil {
+		out.discard()
+		return TaskMetrics{}, err
+	}
+	return metrics, nil
+}
+
+// partitionWriter encodes and compresses the records of one task's
+// partition files
+type partitionWriter struct {
+	emit        *emitter
+	files       []taskOutput
+	writers     []io.WriteCloser
+	compression Compression
+}
+
+// openPartitions creates the partition files in names holding records
+// in codec, compressed with the codec the job forces or the one sample
+// suggests
+func openPartitions(ws *JobWorkspace, names []string, sample []byte, codec byte, opts JobOptions, disk *taskDisk) (*partitionWriter, error) {
+	compression, err := resolveCompression(opts.Compression, sample)
+	if err != nil {
+		return nil, err
+	}
+	// Partition map output by hashing each key, or its group key when
+	// the job sorts values within groups
 	// This distributes the work evenly across reducers
-	for _, kv := range kva {
-		index := ihash(kv.Key) % nReduce
-		err := encoders[index].Encode(&kv)
+	order, err := opts.keyOrder()
+	if err != nil {
+		return nil, err
+	}
+	// Records of keys the job is not interested in are dropped here
+	keep, err := opts.keyFilter()
+	if err != nil {
+		return nil, err
+	}
+	replica := opts.replica(ws.JobName)
+	var dict []byte
+	if compression == CompressionZstdDict {
+		compression, dict = zstdDictionaryFor(ws, sample, opts.IntermediateFormat)
+		replicateDictionary(ws, replica, dict)
+	}
+
+	// Create encoders and files for each reduce partition
+	// Each encoder will handle key-value pairs for one reducer
+	p := &partitionWriter{
+		files:       make([]taskOutput, len(names)),
+		writers:     make([]io.WriteCloser, len(names)),
+		compression: compression,
+	}
+	encoders := make([]recordEncoder, len(names))
+	for i, name := range names {
+		if p.files[i], err = createReplicated(ws, replica, name, disk); err != nil {
+			p.abort()
+			return nil, fmt.Errorf("create file: %w", err)
+		}
+		p.writers[i], err = newCompressWriter(p.files[i], compression, opts.IntermediateFormat, codec, dict)
 		if err != nil {
-			log.Fatalf("doMap: encode error %v", err)
+			p.abort()
+			return nil, fmt.Errorf("compress file: %w", err)
+		}
+		encoders[i] = newRecordEncoder(p.writers[i], codec)
+	}
+	p.emit = newEmitter(encoders, defaultEmitQueueSize, order, keep, opts.RangeBounds)
+	return p, nil
+}
+
+// abort closes and discards the partition files created so far
+func (p *partitionWriter) abort() {
+	for i, file := range p.files {
+		if p.writers[i] != nil {
+			p.writers[i].Close()
+		}
+		if file != nil {
+			file.Close()
+			file.discard()
+		}
+	}
+}
+
+// close flushes and closes the partition files and returns the
+// metrics of the write. Every file is closed even if one fails. The
+// files stay pending until commit.
+func (p *partitionWriter) close() (TaskMetrics, error) {
+	err := p.emit.Close()
+	if err != nil {
+		err = fmt.Errorf("encode: %w", err)
+	}
+	for i, w := range p.writers {
+		if werr := w.Close(); werr != nil && err == nil {
+			err = fmt.Errorf("flush: %w", werr)
+		}
+		if ferr := p.files[i].Close(); ferr != nil && err == nil {
+			err = fmt.Errorf("close file: %w", ferr)
+		}
+	}
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+
+	metrics := p.emit.metrics()
+	metrics.Compression = p.compression
+	return metrics, nil
+}
+
+// commit publishes the closed partition files under their final names
+func (p *partitionWriter) commit() error {
+	for _, file := range p.files {
+		if err := file.commit(); err != nil {
+			return fmt.Errorf("commit file: %w", err)
+		}
+	}
+	return nil
+}
+
+// discard removes the partition files not committed
+func (p *partitionWriter) discard() {
+	for _, file := range p.files {
+		file.discard()
+	}
+}
+
+// zstdDictionaryFor returns the job's shared dictionary, training it on
+// sample if needed. Without a dictionary, e.g. because the sample is
+// too small or an older intermediate format is pinned, the output is
+// compressed with plain zstd instead.
+func zstdDictionaryFor(ws *JobWorkspace, sample []byte, format int) (Compression, []byte) {
+	if format != 0 && format != IntermediateFormatCurrent {
+		return CompressionZstd, nil
+	}
+	dict, err := sharedZstdDictionary(ws.ZstdDictionary(), sample)
+	if err != nil {
+		if len(sample) > 0 {
+			processLog.warnf("doMap: no zstd dictionary, compressing without: %v", err)
 		}
+		return CompressionZstd, nil
 	}
+	return CompressionZstdDict, dict
 }
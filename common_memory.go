@@ -0,0 +1,49 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// memoryCheckInterval is the number of records processed between two
+// heap measurements, since reading memory statistics is not free
+const memoryCheckInterval = 4096
+
+// ErrMemoryBudget is returned when an in-memory algorithm would grow
+// the heap beyond the budget configured for the job.
+var ErrMemoryBudget = errors.New("memory budget exceeded")
+
+// memoryGuard watches the heap while a task accumulates records in
+// memory. A zero budget disables the guard.
+type memoryGuard struct {
+	budget  uint64
+	records int
+}
+
+// newMemoryGuard creates a guard for the given budget in bytes
+func newMemoryGuard(budget uint64) *memoryGuard {
+	return &memoryGuard{budget: budget}
+}
+
+// Check is called once per accumulated record and measures the heap
+// every memoryCheckInterval records. It returns an error wrapping
+// ErrMemoryBudget once the heap exceeds the budget.
+func (g *memoryGuard) Check() error {
+	if g == nil || g.budget == 0 {
+		return nil
+	}
+	g.records++
+	if g.records%memoryCheckInterval != 0 {
+		return nil
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > g.budget {
+		return fmt.Errorf("%w: heap is %d bytes after %d records, budget is %d bytes",
+			ErrMemoryBudget, stats.HeapAlloc, g.records, g.budget)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rpcLatencyBuckets is the number of buckets of the latency
+// histograms. Bucket i counts observations of at most 2^i
+// milliseconds, the last bucket counts all slower ones.
+const rpcLatencyBuckets = 20
+
+// RPCStats summarises the calls this process made to one RPC method
+// of one peer
+type RPCStats struct {
+	Method  string                    // RPC method, e.g. "Worker.DoTask"
+	Peer    string                    // Address of the called master or worker
+	Calls   uint64                    // Calls made, including failed ones
+	Errors  uint64                    // Calls that failed or timed out
+	Total   time.Duration             // Time spent in all calls
+	Buckets [rpcLatencyBuckets]uint64 // Calls per latency bucket, see rpcBucketBound
+}
+
+// rpcBucketBound returns the upper latency bound of bucket i. The
+// last bucket is unbounded and reports zero.
+func rpcBucketBound(i int) time.Duration {
+	if i >= rpcLatencyBuckets-1 {
+		return 0
+	}
+	return time.Millisecond << uint(i)
+}
+
+// observeRPC records one call of method on peer
+func observeRPC(method string, peer string, elapsed time.Duration, ok bool) {
+	labels := map[string]string{"method": method, "peer": peer}
+	sink := metricsSink()
+	sink.Observe(MetricRPCDuration, elapsed, labels)
+	if !ok {
+		sink.Count(MetricRPCErrors, 1, labels)
+	}
+}
+
+// promSeries is a counter or latency histogram of one metric and set
+// of labels kept by the Prometheus sink
+type promSeries struct {
+	name    string
+	labels  string // Formatted labels, sorted by name
+	values  map[string]string
+	count   uint64 // Counter value, or observations of a histogram
+	total   time.Duration
+	buckets *[rpcLatencyBuckets]uint64 // Nil for counters
+}
+
+// promMetrics is the default sink, see PrometheusMetrics
+type promMetrics struct {
+	sync.Mutex
+	series map[string]*promSeries
+}
+
+// prometheus holds the metrics served by MetricsHandler
+var prometheus = &promMetrics{series: make(map[string]*promSeries)}
+
+// PrometheusMetrics returns the sink used unless UseMetrics selects
+// another one. It keeps the metrics in memory for MetricsHandler to
+// serve in the Prometheus text format.
+func PrometheusMetrics() MetricsSink {
+	return prometheus
+}
+
+// get returns the series of name and labels, creating it if needed
+func (p *promMetrics) get(name string, labels map[string]string, histogram bool) *promSeries {
+	formatted := formatPromLabels(labels)
+	key := name + "{" + formatted + "}"
+	s := p.series[key]
+	if s == nil {
+		s = &promSeries{name: name, labels: formatted, values: labels}
+		if histogram {
+			s.buckets = new([rpcLatencyBuckets]uint64)
+		}
+		p.series[key] = s
+	}
+	return s
+}
+
+func (p *promMetrics) Count(name string, delta int64, labels map[string]string) {
+	p.Lock()
+	defer p.Unlock()
+	p.get(name, labels, false).count += uint64(delta)
+}
+
+func (p *promMetrics) Observe(name string, d time.Duration, labels map[string]string) {
+	bucket := 0
+	for bucket < rpcLatencyBuckets-1 && d > rpcBucketBound(bucket) {
+		bucket++
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	s := p.get(name, labels, true)
+	s.count++
+	s.total += d
+	s.buckets[bucket]++
+}
+
+// snapshot returns copies of all series, ordered by name and labels
+func (p *promMetrics) snapshot() []promSeries {
+	p.Lock()
+	series := make([]promSeries, 0, len(p.series))
+	for _, s := range p.series {
+		c := *s
+		if s.buckets != nil {
+			buckets := *s.buckets
+			c.buckets = &buckets
+		}
+		series = append(series, c)
+	}
+	p.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+	return series
+}
+
+// RPCMetrics returns the latency histograms of all RPCs this process
+// has made, ordered by method and peer. They are kept by the
+// Prometheus sink only, see UseMetrics.
+func RPCMetrics() []RPCStats {
+	var stats []RPCStats
+	errors := make(map[string]uint64)
+	for _, s := range prometheus.snapshot() {
+		switch s.name {
+		case MetricRPCDuration:
+			stats = append(stats, RPCStats{
+				Method:  s.values["method"],
+				Peer:    s.values["peer"],
+				Calls:   s.count,
+				Total:   s.total,
+				Buckets: *s.buckets,
+			})
+		case MetricRPCErrors:
+			errors[s.labels] = s.count
+		}
+	}
+	for i := range stats {
+		stats[i].Errors = errors[formatPromLabels(map[string]string{"method": stats[i].Method, "peer": stats[i].Peer})]
+	}
+	return stats
+}
+
+// MetricsHandler serves the metrics of the Prometheus sink in the
+// Prometheus text format: a latency histogram and an error counter per
+// RPC method and peer, and the task and job metrics of masters.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePromMetrics(w, prometheus.snapshot())
+	})
+}
+
+// ServeMetrics exposes MetricsHandler at /metrics on addr, a TCP
+// host:port, until the process exits
+func ServeMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics endpoint: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			processLog.errorf("metrics endpoint error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// labelEscaper escapes Prometheus label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatPromLabels formats labels in the Prometheus text format,
+// sorted by name
+func formatPromLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// writePromMetrics writes series, ordered by name, in the Prometheus
+// text format. Histograms are reported in seconds.
+func writePromMetrics(w io.Writer, series []promSeries) {
+	for i, s := range series {
+		name := "mapreduce_" + s.name + "_total"
+		kind := "counter"
+		if s.buckets != nil {
+			name = "mapreduce_" + s.name + "_seconds"
+			kind = "histogram"
+		}
+		if i == 0 || series[i-1].name != s.name {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, metricHelp(s.name))
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		}
+
+		if s.buckets == nil {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, s.labels, s.count)
+			continue
+		}
+		sep := ""
+		if s.labels != "" {
+			sep = ","
+		}
+		var cumulative uint64
+		for i, n := range s.buckets {
+			cumulative += n
+			le := "+Inf"
+			if bound := rpcBucketBound(i); bound > 0 {
+				le = fmt.Sprint(bound.Seconds())
+			}
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, s.labels, sep, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, s.labels, s.total.Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, s.labels, s.count)
+	}
+}
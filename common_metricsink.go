@@ -0,0 +1,197 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics recorded by masters and workers. Every metric is a counter
+// or a duration; the labels each carries are listed with it.
+const (
+	MetricRPCDuration  = "rpc_duration"  // Latency of the RPCs made: method, peer
+	MetricRPCErrors    = "rpc_errors"    // RPCs that failed or timed out: method, peer
+	MetricTaskDuration = "task_duration" // Wall time of completed tasks: job, phase
+	MetricTaskFailures = "task_failures" // Task attempts that failed or were refused: job, phase
+	MetricJobDuration  = "job_duration"  // Run time of finished jobs: job, state
+)
+
+// metricHelp describes a metric for the Prometheus text format
+func metricHelp(name string) string {
+	switch name {
+	case MetricRPCDuration:
+		return "Latency of the RPCs made by this process."
+	case MetricRPCErrors:
+		return "RPCs made by this process that failed or timed out."
+	case MetricTaskDuration:
+		return "Wall time of the tasks completed by the jobs of this master."
+	case MetricTaskFailures:
+		return "Task attempts of the jobs of this master that failed or were refused."
+	case MetricJobDuration:
+		return "Run time of the jobs finished by this master."
+	}
+	return "Metric " + name + "."
+}
+
+// MetricsSink receives the metrics a master or worker records, see
+// UseMetrics. Labels describe what a value was measured on, e.g. the
+// method and peer of an RPC or the job of a task; jobs add their own
+// with WithMetricsLabels. Sinks must be safe for concurrent use and
+// must not keep the labels map.
+type MetricsSink interface {
+	// Count adds delta to the counter name
+	Count(name string, delta int64, labels map[string]string)
+
+	// Observe records a duration of the metric name, e.g. in a
+	// histogram or timer
+	Observe(name string, d time.Duration, labels map[string]string)
+}
+
+// metrics holds the sink of this process
+var metrics = struct {
+	sync.RWMutex
+	sink MetricsSink
+}{sink: prometheus}
+
+// UseMetrics sends the metrics this process records to sink instead
+// of keeping them for MetricsHandler, e.g. to a statsd daemon. Like
+// UseTLS the setting is process-wide. Passing nil restores the
+// Prometheus sink.
+func UseMetrics(sink MetricsSink) {
+	if sink == nil {
+		sink = prometheus
+	}
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.sink = sink
+}
+
+// metricsSink returns the sink in use
+func metricsSink() MetricsSink {
+	metrics.RLock()
+	defer metrics.RUnlock()
+	return metrics.sink
+}
+
+// noMetrics discards all metrics
+type noMetrics struct{}
+
+func (noMetrics) Count(string, int64, map[string]string)           {}
+func (noMetrics) Observe(string, time.Duration, map[string]string) {}
+
+// NoMetrics returns a sink discarding all metrics, for processes that
+// should not spend memory on them
+func NoMetrics() MetricsSink {
+	return noMetrics{}
+}
+
+// expvarMetrics publishes metrics as expvar variables
+type expvarMetrics struct {
+	vars *expvar.Map
+}
+
+// expvarRoot is the expvar map of ExpvarMetrics, published once
+var expvarRoot = sync.OnceValue(func() *expvar.Map {
+	return expvar.NewMap("mapreduce")
+})
+
+// ExpvarMetrics returns a sink publishing the metrics in the expvar
+// map "mapreduce", served as JSON at /debug/vars by servers using
+// http.DefaultServeMux. Keys are the metric name followed by its
+// labels, e.g. `task_failures{job="wc",phase="Map"}`. Counters are
+// integers; durations keep their count and the sum of their seconds
+// under keys ending in _count and _seconds.
+func ExpvarMetrics() MetricsSink {
+	return expvarMetrics{vars: expvarRoot()}
+}
+
+func (e expvarMetrics) Count(name string, delta int64, labels map[string]string) {
+	e.vars.Add(name+"{"+formatPromLabels(labels)+"}", delta)
+}
+
+func (e expvarMetrics) Observe(name string, d time.Duration, labels map[string]string) {
+	key := "{" + formatPromLabels(labels) + "}"
+	e.vars.Add(name+"_count"+key, 1)
+	e.vars.AddFloat(name+"_seconds"+key, d.Seconds())
+}
+
+// StatsdConfig describes the statsd daemon metrics are sent to, see
+// StatsdMetrics.
+type StatsdConfig struct {
+	Addr   string `json:"addr"`   // UDP host:port of the daemon, e.g. "127.0.0.1:8125"
+	Prefix string `json:"prefix"` // Prepended to every metric name, "mapreduce." by default
+	Tags   bool   `json:"tags"`   // Send labels as DogStatsD tags instead of in the name
+}
+
+// statsdMetrics sends every metric as one UDP packet
+type statsdMetrics struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+}
+
+// statsdEscaper replaces the characters that separate the parts of a
+// statsd line
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// StatsdMetrics returns a sink sending the metrics to a statsd daemon,
+// or a Datadog agent with Tags set: counters as "c" and durations as
+// "ms" metrics. Without tags, label values are appended to the name in
+// the order of the label names, e.g. mapreduce.task_duration.wc.Map.
+// Packets that cannot be sent are dropped.
+func StatsdMetrics(cfg *StatsdConfig) (MetricsSink, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "mapreduce."
+	}
+	return &statsdMetrics{conn: conn, prefix: prefix, tags: cfg.Tags}, nil
+}
+
+func (s *statsdMetrics) Count(name string, delta int64, labels map[string]string) {
+	s.send(name, strconv.FormatInt(delta, 10), "c", labels)
+}
+
+func (s *statsdMetrics) Observe(name string, d time.Duration, labels map[string]string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", labels)
+}
+
+// send writes one metric line
+func (s *statsdMetrics) send(name string, value string, kind string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if !s.tags {
+		for _, label := range names {
+			b.WriteByte('.')
+			b.WriteString(strings.ReplaceAll(statsdEscaper.Replace(labels[label]), ".", "_"))
+		}
+	}
+	b.WriteString(":" + value + "|" + kind)
+	if s.tags && len(names) > 0 {
+		for i, label := range names {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(label + ":" + statsdEscaper.Replace(labels[label]))
+		}
+	}
+	s.conn.Write([]byte(b.String()))
+}
@@ -0,0 +1,141 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyOrder sorts the values within every reduce group, for jobs whose
+// reduce function needs its values in a given order, e.g. the events
+// of a session by time. Map functions emit composite keys made of a
+// group key and a sort key. Records are partitioned and grouped by the
+// group key, which the reduce function receives as its key, and its
+// values arrive in the order of their composite keys.
+type KeyOrder struct {
+	// Group returns the group key of a composite key. Composite keys
+	// with the same group key are reduced together.
+	Group func(key string) string
+
+	// Compare orders two composite keys of the same group, returning a
+	// negative number when a comes first, a positive one when b does
+	// and zero for ties, which keep the order records were read in.
+	// Nil compares the keys as strings.
+	Compare func(a, b string) int
+
+	// Output returns the key the reduce function receives, and its
+	// output is written under, for a group key, e.g. to drop a sort
+	// key grouping alone does not need. Nil passes the group key.
+	Output func(group string) string
+}
+
+// KeyOrderComposite is the built-in key order of keys made by
+// CompositeKey: grouped by their group part and sorted by their sort
+// part as strings
+const KeyOrderComposite = "composite"
+
+// compositeKeySep separates the parts of a composite key
+const compositeKeySep = "\x00"
+
+// CompositeKey joins a group key, which must not contain a NUL byte,
+// and a sort key into a key of KeyOrderComposite. Sort keys compare as
+// strings, so numbers and timestamps must be zero-padded.
+func CompositeKey(group, sort string) string {
+	return group + compositeKeySep + sort
+}
+
+// SplitCompositeKey returns the parts of a key made by CompositeKey
+func SplitCompositeKey(key string) (group, sort string) {
+	group, sort, _ = strings.Cut(key, compositeKeySep)
+	return group, sort
+}
+
+// keyOrders holds the key orders by name
+var keyOrders = struct {
+	sync.Mutex
+	byName map[string]KeyOrder
+}{byName: map[string]KeyOrder{
+	KeyOrderComposite: {Group: func(key string) string {
+		group, _ := SplitCompositeKey(key)
+		return group
+	}},
+}}
+
+// RegisterKeyOrder makes a key order available to jobs under name, see
+// WithSecondarySort. Register it in the master and in every worker
+// before the first job using it starts.
+func RegisterKeyOrder(name string, order KeyOrder) {
+	keyOrders.Lock()
+	defer keyOrders.Unlock()
+	keyOrders.byName[name] = order
+}
+
+// KeyOrders returns the names of the registered key orders
+func KeyOrders() []string {
+	keyOrders.Lock()
+	defer keyOrders.Unlock()
+	names := make([]string, 0, len(keyOrders.byName))
+	for name := range keyOrders.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyOrder returns the job's key order, nil for jobs grouping records
+// by their whole key
+func (o JobOptions) keyOrder() (*KeyOrder, error) {
+	if o.KeyOrder == "" {
+		return nil, nil
+	}
+	keyOrders.Lock()
+	defer keyOrders.Unlock()
+	order, ok := keyOrders.byName[o.KeyOrder]
+	if !ok {
+		return nil, fmt.Errorf("unknown key order %q", o.KeyOrder)
+	}
+	if order.Group == nil {
+		return nil, fmt.Errorf("key order %q has no Group function", o.KeyOrder)
+	}
+	return &order, nil
+}
+
+// group returns the key records are partitioned and grouped by. A nil
+// order groups by the whole key.
+func (k *KeyOrder) group(key string) string {
+	if k == nil {
+		return key
+	}
+	return k.Group(key)
+}
+
+// compare orders two keys by group key first, then by Compare. A nil
+// order compares the keys as strings.
+func (k *KeyOrder) compare(a, b string) int {
+	if k == nil {
+		return strings.Compare(a, b)
+	}
+	if c := strings.Compare(k.Group(a), k.Group(b)); c != 0 {
+		return c
+	}
+	if k.Compare == nil {
+		return strings.Compare(a, b)
+	}
+	return k.Compare(a, b)
+}
+
+// outputGroups passes the groups of a reduce task on under their
+// output keys. A nil order or one without Output leaves them as they
+// are.
+func (k *KeyOrder) outputGroups(groups func(emit func(key string, values Iterator)) error) func(emit func(key string, values Iterator)) error {
+	if k == nil || k.Output == nil {
+		return groups
+	}
+	return func(emit func(key string, values Iterator)) error {
+		return groups(func(key string, values Iterator) {
+			emit(k.Output(key), values)
+		})
+	}
+}
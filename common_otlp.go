@@ -0,0 +1,220 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	otlpBatchSize     = 256             // Spans sent in one request at most
+	otlpQueueSize     = 4096            // Spans waiting to be sent before new ones are dropped
+	otlpFlushInterval = time.Second     // How long a span waits for its batch to fill up
+	otlpTimeout       = 5 * time.Second // Timeout of a request to the collector
+)
+
+// OTLPConfig describes the OpenTelemetry collector spans are sent to,
+// see OTLPExporter.
+type OTLPConfig struct {
+	Endpoint    string            `json:"endpoint"`     // Base URL of the collector, e.g. "http://localhost:4318"
+	ServiceName string            `json:"service_name"` // Service the spans belong to, "mapreduce" by default
+	Headers     map[string]string `json:"headers"`      // Sent with every request, e.g. credentials
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector, or a backend
+// such as Jaeger or Tempo that accepts OTLP over HTTP, in batches.
+// Spans that cannot be sent are dropped.
+type OTLPExporter struct {
+	url     string
+	service string
+	headers map[string]string
+	client  *http.Client
+
+	spans   chan *Span
+	flushes chan chan struct{}
+	once    sync.Once
+}
+
+// NewOTLPExporter returns an exporter posting spans as OTLP/JSON to
+// the /v1/traces path of the collector cfg describes
+func NewOTLPExporter(cfg *OTLPConfig) (*OTLPExporter, error) {
+	if !strings.HasPrefix(cfg.Endpoint, "http://") && !strings.HasPrefix(cfg.Endpoint, "https://") {
+		return nil, fmt.Errorf("otlp: endpoint %q is not an http:// or https:// URL", cfg.Endpoint)
+	}
+	service := cfg.ServiceName
+	if service == "" {
+		service = "mapreduce"
+	}
+	return &OTLPExporter{
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		service: service,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: otlpTimeout},
+		spans:   make(chan *Span, otlpQueueSize),
+		flushes: make(chan chan struct{}),
+	}, nil
+}
+
+// ExportSpan queues span to be sent with the next batch
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	e.once.Do(func() { go e.run() })
+	select {
+	case e.spans <- span:
+	default:
+		// The collector does not keep up; tracing must not slow down
+		// the job
+	}
+}
+
+// Flush sends the queued spans and returns once they were sent, e.g.
+// before the process exits
+func (e *OTLPExporter) Flush() {
+	e.once.Do(func() { go e.run() })
+	done := make(chan struct{})
+	e.flushes <- done
+	<-done
+}
+
+// run sends batches of spans until the process exits
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			if err := e.send(batch); err != nil {
+				processLog.warnf("otlp: dropped %d spans: %v", len(batch), err)
+			}
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flushes:
+			for n := len(e.spans); n > 0; n-- {
+				batch = append(batch, <-e.spans)
+				if len(batch) >= otlpBatchSize {
+					send()
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+// send posts one batch of spans to the collector
+func (e *OTLPExporter) send(batch []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.service, batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON messages, see the OpenTelemetry protocol specification.
+// IDs are hex encoded and times are decimal strings of nanoseconds.
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         SpanKind        `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 2 for errors
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlpRequest returns the request reporting spans of service
+func otlpRequest(service string, spans []*Span) otlpTraces {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "mapreduce"}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID: hex.EncodeToString(s.TraceID[:]),
+			SpanID:  hex.EncodeToString(s.SpanID[:]),
+			Name:    s.Name,
+			Kind:    s.Kind,
+			Start:   strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentID != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		keys := make([]string, 0, len(s.Attributes))
+		for key := range s.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			span.Attributes = append(span.Attributes, otlpAttribute{key, otlpValue{s.Attributes[key]}})
+		}
+		if s.Error != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.Error}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	resource := otlpResource{Attributes: []otlpAttribute{{"service.name", otlpValue{service}}}}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
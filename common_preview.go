@@ -0,0 +1,201 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// OutputPreview holds a few records of a job's output, to check it by
+// eye without reading all of it, see JobHandle.Preview
+type OutputPreview struct {
+	First  []KeyValue `json:"first"`  // Records at the start of the first output files
+	Last   []KeyValue `json:"last"`   // Records at the end of the last output files
+	Sample []KeyValue `json:"sample"` // Records at random positions, in output order
+}
+
+// previewTailBlock is how much of the end of an output file is read
+// first when looking for its last records; it doubles until enough
+// records are found
+const previewTailBlock = 4096
+
+// previewOutputs returns up to n records from the start, the end and
+// random positions of the reduce output files, which hold JSON lines.
+// Only the records returned and the blocks around them are read.
+// Random records are picked by byte offset, which pick draws below the
+// total size of the files, so records following long ones are picked
+// more often.
+func previewOutputs(files []string, n int, pick func(n int64) int64) (OutputPreview, error) {
+	var preview OutputPreview
+	if n <= 0 {
+		return preview, nil
+	}
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, file := range files {
+		info, err := statFile(file)
+		if err != nil {
+			return preview, fmt.Errorf("preview output: %v", err)
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	for _, file := range files {
+		records, err := readOutputLines(file, 0, n-len(preview.First))
+		if err != nil {
+			return preview, err
+		}
+		preview.First = append(preview.First, records...)
+		if len(preview.First) == n {
+			break
+		}
+	}
+
+	for i := len(files) - 1; i >= 0 && len(preview.Last) < n; i-- {
+		records, err := outputTail(files[i], sizes[i], n-len(preview.Last))
+		if err != nil {
+			return preview, err
+		}
+		preview.Last = append(records, preview.Last...)
+	}
+
+	// Every draw lands in a record, whose start identifies it; a few
+	// extra draws make up for records drawn twice
+	type position struct{ file, offset int64 }
+	picked := make(map[position]KeyValue)
+	for draws := 0; draws < 3*n && len(picked) < n && total > 0; draws++ {
+		offset := pick(total)
+		file := 0
+		for offset >= sizes[file] {
+			offset -= sizes[file]
+			file++
+		}
+		start, err := lineStart(files[file], offset)
+		if err != nil {
+			return preview, err
+		}
+		records, err := readOutputLines(files[file], start, 1)
+		if err != nil {
+			return preview, err
+		}
+		if len(records) == 1 {
+			picked[position{int64(file), start}] = records[0]
+		}
+	}
+	positions := make([]position, 0, len(picked))
+	for pos := range picked {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].file != positions[j].file {
+			return positions[i].file < positions[j].file
+		}
+		return positions[i].offset < positions[j].offset
+	})
+	for _, pos := range positions {
+		preview.Sample = append(preview.Sample, picked[pos])
+	}
+	return preview, nil
+}
+
+// readOutputLines decodes up to n records of an output file, starting
+// at offset, which must be the start of a line
+func readOutputLines(file string, offset int64, n int) ([]KeyValue, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	r, err := openFile(file, offset)
+	if err != nil {
+		return nil, fmt.Errorf("preview output: %v", err)
+	}
+	defer r.Close()
+
+	var records []KeyValue
+	dec := json.NewDecoder(r)
+	for len(records) < n {
+		var kv KeyValue
+		if err := dec.Decode(&kv); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("preview output %s: %v", file, err)
+		}
+		records = append(records, kv)
+	}
+	return records, nil
+}
+
+// lineStart returns the offset of the line of an output file that the
+// byte at offset belongs to. Lines are short, so it searches backwards
+// from offset in small blocks.
+func lineStart(file string, offset int64) (int64, error) {
+	end := offset
+	for end > 0 {
+		start := max(end-previewTailBlock, 0)
+		block, err := readRange(file, start, end)
+		if err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(block, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// outputTail returns the last n records of an output file of the
+// given size, reading ever larger blocks from its end until they hold
+// n complete lines or the whole file
+func outputTail(file string, size int64, n int) ([]KeyValue, error) {
+	for block := int64(previewTailBlock); ; block *= 2 {
+		start := max(size-block, 0)
+		data, err := readRange(file, start, size)
+		if err != nil {
+			return nil, err
+		}
+		if start > 0 {
+			// The block may start within a line, which is dropped;
+			// too few lines left read a larger block
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				continue
+			}
+			data = data[i+1:]
+		}
+		lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+		if len(data) == 0 {
+			lines = nil
+		}
+		if len(lines) < n && start > 0 {
+			continue
+		}
+		if len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+		records := make([]KeyValue, len(lines))
+		for i, line := range lines {
+			if err := json.Unmarshal(line, &records[i]); err != nil {
+				return nil, fmt.Errorf("preview output %s: %v", file, err)
+			}
+		}
+		return records, nil
+	}
+}
+
+// readRange reads the bytes of a file from start up to end
+func readRange(file string, start, end int64) ([]byte, error) {
+	r, err := openFile(file, start)
+	if err != nil {
+		return nil, fmt.Errorf("preview output: %v", err)
+	}
+	defer r.Close()
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("preview output %s: %v", file, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,80 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestPreviewOutputs previews reduce outputs, one of them empty and
+// with records longer than the blocks read from the end of a file, and
+// checks the first, last and sampled records.
+func TestPreviewOutputs(t *testing.T) {
+	dir := t.TempDir()
+	parts := [][]KeyValue{{}, {}, {}}
+	var all []KeyValue
+	for i := 0; i < 40; i++ {
+		value := strconv.Itoa(i)
+		if i%7 == 0 {
+			value = strings.Repeat("x", 3*previewTailBlock)
+		}
+		kv := KeyValue{"k" + strconv.Itoa(i), value}
+		parts[i%2*2] = append(parts[i%2*2], kv) // The middle part stays empty
+	}
+	files := make([]string, len(parts))
+	for i, records := range parts {
+		files[i] = filepath.Join(dir, "part-"+strconv.Itoa(i))
+		var data []byte
+		for _, kv := range records {
+			line, _ := json.Marshal(kv)
+			data = append(append(data, line...), '\n')
+		}
+		if err := os.WriteFile(files[i], data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, records...)
+	}
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	preview, err := previewOutputs(files, 5, rng.Int64N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := all[:5]; !reflect.DeepEqual(preview.First, want) {
+		t.Errorf("first %v, want %v", preview.First, want)
+	}
+	if want := all[len(all)-5:]; !reflect.DeepEqual(preview.Last, want) {
+		t.Errorf("last %v, want %v", preview.Last, want)
+	}
+	if len(preview.Sample) == 0 || len(preview.Sample) > 5 {
+		t.Errorf("sampled %d records, want 1 to 5", len(preview.Sample))
+	}
+	index := make(map[string]int)
+	for i, kv := range all {
+		index[kv.Key] = i
+	}
+	prev := -1
+	for _, kv := range preview.Sample {
+		i, ok := index[kv.Key]
+		if !ok || all[i] != kv || i <= prev {
+			t.Errorf("sample %v is not the output in order", preview.Sample)
+			break
+		}
+		prev = i
+	}
+
+	// Asking for more records than there are returns all of them
+	preview, err = previewOutputs(files, 100, rng.Int64N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(preview.First, all) || !reflect.DeepEqual(preview.Last, all) {
+		t.Errorf("preview of %d records returned %d first and %d last", len(all), len(preview.First), len(preview.Last))
+	}
+}
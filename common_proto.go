@@ -0,0 +1,991 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoMessage is implemented by the RPC arguments and replies that
+// can be sent over gRPC. The encoding follows mapreduce.proto.
+type protoMessage interface {
+	marshalProto(b []byte) []byte
+	unmarshalProto(b []byte) error
+}
+
+// protoCodec encodes RPC messages as protobuf for the gRPC transport
+type protoCodec struct{}
+
+// Name returns the content subtype of the codec
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+// Marshal encodes v, an RPC argument or reply
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case protoMessage:
+		return m.marshalProto(nil), nil
+	case *struct{}:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as protobuf", v)
+}
+
+// Unmarshal decodes data into v, an RPC argument or reply
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case protoMessage:
+		return m.unmarshalProto(data)
+	case *struct{}:
+		return nil
+	}
+	return fmt.Errorf("cannot decode protobuf into %T", v)
+}
+
+// protoField is a single decoded field of a message
+type protoField struct {
+	num    protowire.Number
+	varint uint64 // Value of varint fields
+	bytes  []byte // Value of string, bytes and message fields
+}
+
+// decodeProto calls set for every varint and length-delimited field
+// of the message in b. Fields of other wire types are skipped.
+func decodeProto(b []byte, set func(f protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := protoField{num: num}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := set(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendProtoString appends a string field, omitting empty strings
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendProtoVarint appends an integer or bool field, omitting zero
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendProtoMessage appends an embedded message field
+func appendProtoMessage(b []byte, num protowire.Number, m protoMessage) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.marshalProto(nil))
+}
+
+func (a *RegisterArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, a.Worker)
+
+	// Map entries are sorted to keep the encoding deterministic
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendProtoString(appendProtoString(nil, 1, k), 2, a.Labels[k])
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	b = appendProtoString(b, 3, a.Token)
+	b = appendProtoString(b, 4, a.Host)
+	for _, path := range a.LocalPaths {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, path)
+	}
+	return appendProtoVarint(b, 6, uint64(a.Slots))
+}
+
+func (a *RegisterArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.Worker = string(f.bytes)
+		case 2:
+			var key, value string
+			err := decodeProto(f.bytes, func(e protoField) error {
+				switch e.num {
+				case 1:
+					key = string(e.bytes)
+				case 2:
+					value = string(e.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if a.Labels == nil {
+				a.Labels = make(map[string]string)
+			}
+			a.Labels[key] = value
+		case 3:
+			a.Token = string(f.bytes)
+		case 4:
+			a.Host = string(f.bytes)
+		case 5:
+			a.LocalPaths = append(a.LocalPaths, string(f.bytes))
+		case 6:
+			a.Slots = int(int64(f.varint))
+		}
+		return nil
+	})
+}
+
+func (r *RegisterReply) marshalProto(b []byte) []byte {
+	var active uint64
+	if r.JobActive {
+		active = 1
+	}
+	b = appendProtoVarint(b, 1, active)
+	b = appendProtoString(b, 2, string(r.JobName))
+	b = appendProtoString(b, 3, string(r.Phase))
+	b = appendProtoString(b, 4, string(r.Compression))
+	b = appendProtoString(b, 5, r.Workspace)
+	b = appendProtoVarint(b, 6, uint64(r.PollAfter))
+	return appendProtoVarint(b, 7, uint64(r.HeartbeatInterval))
+}
+
+func (r *RegisterReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.JobActive = f.varint != 0
+		case 2:
+			r.JobName = JobParse(f.bytes)
+		case 3:
+			r.Phase = JobParse(f.bytes)
+		case 4:
+			r.Compression = Compression(f.bytes)
+		case 5:
+			r.Workspace = string(f.bytes)
+		case 6:
+			r.PollAfter = time.Duration(int64(f.varint))
+		case 7:
+			r.HeartbeatInterval = time.Duration(int64(f.varint))
+		}
+		return nil
+	})
+}
+
+func (o *JobOptions) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(o.Compression))
+	b = appendProtoVarint(b, 2, o.MemoryBudget)
+	b = appendProtoString(b, 3, o.Workspace)
+	b = appendProtoVarint(b, 4, uint64(o.ReadAhead))
+	b = appendProtoVarint(b, 5, uint64(o.DecodeWorkers))
+	b = appendProtoVarint(b, 6, uint64(o.GCPercent))
+	b = appendProtoVarint(b, 7, uint64(o.MemoryLimit))
+	b = appendProtoVarint(b, 8, uint64(o.MaxProcs))
+	b = appendProtoVarint(b, 9, uint64(o.IntermediateFormat))
+	b = appendProtoVarint(b, 10, uint64(o.MaxReduceValues))
+	var sorted uint64
+	if o.SortedReduce {
+		sorted = 1
+	}
+	b = appendProtoVarint(b, 11, sorted)
+	b = appendProtoString(b, 12, string(o.InputEncoding))
+	b = appendProtoString(b, 13, o.RecordDelimiter)
+	b = appendProtoVarint(b, 14, uint64(o.RecordSize))
+	b = appendProtoVarint(b, 15, uint64(o.Seed))
+	b = appendProtoString(b, 16, o.InputFormat)
+	b = appendProtoVarint(b, 17, uint64(o.SplitSize))
+	b = appendProtoVarint(b, 18, uint64(o.CSVHeader))
+	b = appendProtoString(b, 19, string(o.RecordCodec))
+	if o.Schema.declared() {
+		b = appendProtoMessage(b, 20, &o.Schema)
+	}
+	b = appendProtoVarint(b, 21, uint64(o.SortBuffer))
+	b = appendProtoString(b, 22, o.KeyOrder)
+
+	groups := make([]string, 0, len(o.GroupFormats))
+	for group := range o.GroupFormats {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		entry := appendProtoString(appendProtoString(nil, 1, group), 2, o.GroupFormats[group])
+		b = protowire.AppendTag(b, 23, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	for _, prefix := range o.KeyPrefixes {
+		b = protowire.AppendTag(b, 24, protowire.BytesType)
+		b = protowire.AppendString(b, prefix)
+	}
+	b = appendProtoString(b, 25, o.KeyFilter)
+	for _, column := range o.Columns {
+		b = protowire.AppendTag(b, 26, protowire.BytesType)
+		b = protowire.AppendString(b, column)
+	}
+	b = appendProtoString(b, 27, o.Replica)
+	for _, bound := range o.RangeBounds {
+		b = protowire.AppendTag(b, 28, protowire.BytesType)
+		b = protowire.AppendString(b, bound)
+	}
+	b = appendProtoString(b, 29, o.Builtin)
+	for _, cache := range o.DroppedCaches {
+		b = protowire.AppendTag(b, 30, protowire.BytesType)
+		b = protowire.AppendString(b, cache)
+	}
+	return b
+}
+
+func (o *JobOptions) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			o.Compression = Compression(f.bytes)
+		case 2:
+			o.MemoryBudget = f.varint
+		case 3:
+			o.Workspace = string(f.bytes)
+		case 4:
+			o.ReadAhead = int(int64(f.varint))
+		case 5:
+			o.DecodeWorkers = int(int64(f.varint))
+		case 6:
+			o.GCPercent = int(int64(f.varint))
+		case 7:
+			o.MemoryLimit = int64(f.varint)
+		case 8:
+			o.MaxProcs = int(int64(f.varint))
+		case 9:
+			o.IntermediateFormat = int(int64(f.varint))
+		case 10:
+			o.MaxReduceValues = int(int64(f.varint))
+		case 11:
+			o.SortedReduce = f.varint != 0
+		case 12:
+			o.InputEncoding = InputEncoding(f.bytes)
+		case 13:
+			o.RecordDelimiter = string(f.bytes)
+		case 14:
+			o.RecordSize = int(int64(f.varint))
+		case 15:
+			o.Seed = int64(f.varint)
+		case 16:
+			o.InputFormat = string(f.bytes)
+		case 17:
+			o.SplitSize = int64(f.varint)
+		case 18:
+			o.CSVHeader = CSVHeader(f.varint)
+		case 19:
+			o.RecordCodec = RecordCodec(f.bytes)
+		case 20:
+			return o.Schema.unmarshalProto(f.bytes)
+		case 21:
+			o.SortBuffer = int64(f.varint)
+		case 22:
+			o.KeyOrder = string(f.bytes)
+		case 23:
+			var group, format string
+			err := decodeProto(f.bytes, func(e protoField) error {
+				switch e.num {
+				case 1:
+					group = string(e.bytes)
+				case 2:
+					format = string(e.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if o.GroupFormats == nil {
+				o.GroupFormats = make(map[string]string)
+			}
+			o.GroupFormats[group] = format
+		case 24:
+			o.KeyPrefixes = append(o.KeyPrefixes, string(f.bytes))
+		case 25:
+			o.KeyFilter = string(f.bytes)
+		case 26:
+			o.Columns = append(o.Columns, string(f.bytes))
+		case 27:
+			o.Replica = string(f.bytes)
+		case 28:
+			o.RangeBounds = append(o.RangeBounds, string(f.bytes))
+		case 29:
+			o.Builtin = string(f.bytes)
+		case 30:
+			o.DroppedCaches = append(o.DroppedCaches, string(f.bytes))
+		}
+		return nil
+	})
+}
+
+func (s *Schema) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(s.Key))
+	b = appendProtoString(b, 2, string(s.Value))
+	var allowEmpty uint64
+	if s.AllowEmptyKeys {
+		allowEmpty = 1
+	}
+	b = appendProtoVarint(b, 3, allowEmpty)
+	b = appendProtoVarint(b, 4, uint64(s.Sample))
+	return appendProtoVarint(b, 5, uint64(s.MaxViolations))
+}
+
+func (s *Schema) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			s.Key = FieldType(f.bytes)
+		case 2:
+			s.Value = FieldType(f.bytes)
+		case 3:
+			s.AllowEmptyKeys = f.varint != 0
+		case 4:
+			s.Sample = int(int64(f.varint))
+		case 5:
+			s.MaxViolations = int(int64(f.varint))
+		}
+		return nil
+	})
+}
+
+func (a *DoTaskArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(a.JobName))
+	b = appendProtoString(b, 2, a.File)
+	b = appendProtoString(b, 3, string(a.Phase))
+	b = appendProtoVarint(b, 4, uint64(a.TaskNumber))
+	b = appendProtoVarint(b, 5, uint64(a.OtherTaskNumber))
+	b = appendProtoMessage(b, 6, &a.Options)
+	b = appendProtoString(b, 7, a.Token)
+	b = appendProtoVarint(b, 8, uint64(a.Offset))
+	b = appendProtoVarint(b, 9, uint64(a.Length))
+	var verify uint64
+	if a.Verify {
+		verify = 1
+	}
+	b = appendProtoVarint(b, 10, verify)
+	b = appendProtoString(b, 11, a.Group)
+	b = appendProtoString(b, 12, a.TraceParent)
+	var uncached uint64
+	if a.Uncached {
+		uncached = 1
+	}
+	return appendProtoVarint(b, 13, uncached)
+}
+
+func (a *DoTaskArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.JobName = JobParse(f.bytes)
+		case 2:
+			a.File = string(f.bytes)
+		case 3:
+			a.Phase = JobParse(f.bytes)
+		case 4:
+			a.TaskNumber = int(int64(f.varint))
+		case 5:
+			a.OtherTaskNumber = int(int64(f.varint))
+		case 6:
+			return a.Options.unmarshalProto(f.bytes)
+		case 7:
+			a.Token = string(f.bytes)
+		case 8:
+			a.Offset = int64(f.varint)
+		case 9:
+			a.Length = int64(f.varint)
+		case 10:
+			a.Verify = f.varint != 0
+		case 11:
+			a.Group = string(f.bytes)
+		case 12:
+			a.TraceParent = string(f.bytes)
+		case 13:
+			a.Uncached = f.varint != 0
+		}
+		return nil
+	})
+}
+
+func (a *HeartbeatArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, a.Worker)
+	return appendProtoString(b, 2, a.Token)
+}
+
+func (a *HeartbeatArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.Worker = string(f.bytes)
+		case 2:
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *UnregisterArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, a.Worker)
+	return appendProtoString(b, 2, a.Token)
+}
+
+func (a *UnregisterArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.Worker = string(f.bytes)
+		case 2:
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *AcquireArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, a.Worker)
+	b = appendProtoString(b, 2, a.Token)
+	b = appendProtoString(b, 3, a.Limit)
+	b = appendProtoVarint(b, 4, uint64(a.N))
+	b = appendProtoString(b, 5, string(a.Job))
+	return appendProtoString(b, 6, a.Workspace)
+}
+
+func (a *AcquireArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.Worker = string(f.bytes)
+		case 2:
+			a.Token = string(f.bytes)
+		case 3:
+			a.Limit = string(f.bytes)
+		case 4:
+			a.N = int(int64(f.varint))
+		case 5:
+			a.Job = JobParse(f.bytes)
+		case 6:
+			a.Workspace = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (r *AcquireReply) marshalProto(b []byte) []byte {
+	return appendProtoVarint(b, 1, uint64(r.Wait))
+}
+
+func (r *AcquireReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		if f.num == 1 {
+			r.Wait = time.Duration(int64(f.varint))
+		}
+		return nil
+	})
+}
+
+func (a *FetchFileArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, a.Worker)
+	b = appendProtoString(b, 2, a.Token)
+	b = appendProtoString(b, 3, a.File)
+	b = appendProtoVarint(b, 4, uint64(a.Seq))
+	b = appendProtoVarint(b, 5, uint64(a.Offset))
+	b = appendProtoString(b, 6, string(a.Job))
+	return appendProtoString(b, 7, a.Workspace)
+}
+
+func (a *FetchFileArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.Worker = string(f.bytes)
+		case 2:
+			a.Token = string(f.bytes)
+		case 3:
+			a.File = string(f.bytes)
+		case 4:
+			a.Seq = int(int64(f.varint))
+		case 5:
+			a.Offset = int64(f.varint)
+		case 6:
+			a.Job = JobParse(f.bytes)
+		case 7:
+			a.Workspace = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (r *FetchFileReply) marshalProto(b []byte) []byte {
+	var last uint64
+	if r.Last {
+		last = 1
+	}
+	b = appendProtoVarint(b, 1, uint64(r.Seq))
+	b = appendProtoVarint(b, 2, uint64(r.Offset))
+	b = appendProtoVarint(b, 3, uint64(r.Size))
+	if len(r.Data) > 0 {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.Data)
+	}
+	b = appendProtoVarint(b, 5, uint64(r.Checksum))
+	return appendProtoVarint(b, 6, last)
+}
+
+func (r *FetchFileReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Seq = int(int64(f.varint))
+		case 2:
+			r.Offset = int64(f.varint)
+		case 3:
+			r.Size = int64(f.varint)
+		case 4:
+			r.Data = append([]byte(nil), f.bytes...)
+		case 5:
+			r.Checksum = uint32(f.varint)
+		case 6:
+			r.Last = f.varint != 0
+		}
+		return nil
+	})
+}
+
+func (r *HeartbeatReply) marshalProto(b []byte) []byte {
+	var registered uint64
+	if r.Registered {
+		registered = 1
+	}
+	return appendProtoVarint(b, 1, registered)
+}
+
+func (r *HeartbeatReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		if f.num == 1 {
+			r.Registered = f.varint != 0
+		}
+		return nil
+	})
+}
+
+func (m *TaskMetrics) marshalProto(b []byte) []byte {
+	b = appendProtoVarint(b, 1, uint64(m.EmitQueuePeak))
+	b = appendProtoString(b, 2, string(m.Compression))
+	b = appendProtoVarint(b, 3, m.OutputDigest)
+	b = appendProtoVarint(b, 4, uint64(m.SchemaViolations))
+	b = appendProtoVarint(b, 5, uint64(m.WallTime))
+	b = appendProtoVarint(b, 6, uint64(m.UserCPU))
+	b = appendProtoVarint(b, 7, uint64(m.SystemCPU))
+	if m.PartitionRecords != nil {
+		var packed []byte
+		for _, n := range m.PartitionRecords {
+			packed = protowire.AppendVarint(packed, uint64(n))
+		}
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	names := make([]string, 0, len(m.Counters))
+	for name := range m.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := appendProtoVarint(appendProtoString(nil, 1, name), 2, uint64(m.Counters[name]))
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func (m *TaskMetrics) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.EmitQueuePeak = int(int64(f.varint))
+		case 2:
+			m.Compression = Compression(f.bytes)
+		case 3:
+			m.OutputDigest = f.varint
+		case 4:
+			m.SchemaViolations = int(int64(f.varint))
+		case 5:
+			m.WallTime = time.Duration(f.varint)
+		case 6:
+			m.UserCPU = time.Duration(f.varint)
+		case 7:
+			m.SystemCPU = time.Duration(f.varint)
+		case 8:
+			// Packed, so a count of zero survives the encoding
+			m.PartitionRecords = make([]int64, 0, len(f.bytes))
+			for b := f.bytes; len(b) > 0; {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				m.PartitionRecords = append(m.PartitionRecords, int64(v))
+				b = b[n:]
+			}
+		case 9:
+			var name string
+			var value int64
+			err := decodeProto(f.bytes, func(e protoField) error {
+				switch e.num {
+				case 1:
+					name = string(e.bytes)
+				case 2:
+					value = int64(e.varint)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if m.Counters == nil {
+				m.Counters = make(map[string]int64)
+			}
+			m.Counters[name] = value
+		}
+		return nil
+	})
+}
+
+func (r *DoTaskReply) marshalProto(b []byte) []byte {
+	b = appendProtoMessage(b, 1, &r.Metrics)
+	b = appendProtoString(b, 2, r.Refused)
+	if len(r.LostMapTasks) > 0 {
+		var packed []byte
+		for _, task := range r.LostMapTasks {
+			packed = protowire.AppendVarint(packed, uint64(task))
+		}
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	return appendProtoString(b, 4, r.Cache)
+}
+
+func (r *DoTaskReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			return r.Metrics.unmarshalProto(f.bytes)
+		case 2:
+			r.Refused = string(f.bytes)
+		case 3:
+			for b := f.bytes; len(b) > 0; {
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				r.LostMapTasks = append(r.LostMapTasks, int(int64(v)))
+				b = b[n:]
+			}
+		case 4:
+			r.Cache = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *FlushCacheArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(a.JobName))
+	b = appendProtoString(b, 2, a.Workspace)
+	return appendProtoString(b, 3, a.Token)
+}
+
+func (a *FlushCacheArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.JobName = JobParse(f.bytes)
+		case 2:
+			a.Workspace = string(f.bytes)
+		case 3:
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *ShutdownArgs) marshalProto(b []byte) []byte {
+	return appendProtoString(b, 1, a.Token)
+}
+
+func (a *ShutdownArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		if f.num == 1 {
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *PingArgs) marshalProto(b []byte) []byte {
+	return appendProtoString(b, 1, a.Token)
+}
+
+func (a *PingArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		if f.num == 1 {
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (r *FlushCacheReply) marshalProto(b []byte) []byte {
+	return appendProtoString(b, 1, r.Cache)
+}
+
+func (r *FlushCacheReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		if f.num == 1 {
+			r.Cache = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *PrepareArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(a.JobName))
+	b = appendProtoMessage(b, 2, &a.Options)
+	b = appendProtoString(b, 3, a.Token)
+	b = appendProtoString(b, 4, a.Framework)
+	b = appendProtoString(b, 5, a.BinaryVersion)
+	for _, file := range a.SideInputs {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, file)
+	}
+	var ship uint64
+	if a.ShipSideInputs {
+		ship = 1
+	}
+	b = appendProtoVarint(b, 7, ship)
+	for _, sum := range a.SideInputHashes {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, sum)
+	}
+	return b
+}
+
+func (a *PrepareArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.JobName = JobParse(f.bytes)
+		case 2:
+			return a.Options.unmarshalProto(f.bytes)
+		case 3:
+			a.Token = string(f.bytes)
+		case 4:
+			a.Framework = string(f.bytes)
+		case 5:
+			a.BinaryVersion = string(f.bytes)
+		case 6:
+			a.SideInputs = append(a.SideInputs, string(f.bytes))
+		case 7:
+			a.ShipSideInputs = f.varint != 0
+		case 8:
+			a.SideInputHashes = append(a.SideInputHashes, string(f.bytes))
+		}
+		return nil
+	})
+}
+
+func (r *PrepareReply) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, r.Framework)
+	b = appendProtoString(b, 2, r.BinaryVersion)
+	return appendProtoMessage(b, 3, &r.Build)
+}
+
+func (r *PrepareReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Framework = string(f.bytes)
+		case 2:
+			r.BinaryVersion = string(f.bytes)
+		case 3:
+			return r.Build.unmarshalProto(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (i *BuildInfo) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, i.Path)
+	b = appendProtoString(b, 2, i.Version)
+	b = appendProtoString(b, 3, i.Revision)
+	var modified uint64
+	if i.Modified {
+		modified = 1
+	}
+	b = appendProtoVarint(b, 4, modified)
+	b = appendProtoString(b, 5, i.GoVersion)
+	return appendProtoString(b, 6, i.Executable)
+}
+
+func (i *BuildInfo) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			i.Path = string(f.bytes)
+		case 2:
+			i.Version = string(f.bytes)
+		case 3:
+			i.Revision = string(f.bytes)
+		case 4:
+			i.Modified = f.varint != 0
+		case 5:
+			i.GoVersion = string(f.bytes)
+		case 6:
+			i.Executable = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (a *CancelTasksArgs) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, string(a.JobName))
+	return appendProtoString(b, 2, a.Token)
+}
+
+func (a *CancelTasksArgs) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			a.JobName = JobParse(f.bytes)
+		case 2:
+			a.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (r *ShutdownReply) marshalProto(b []byte) []byte {
+	b = appendProtoVarint(b, 1, uint64(r.Ntasks))
+	return appendProtoMessage(b, 2, &r.Summary)
+}
+
+func (r *ShutdownReply) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Ntasks = int(int64(f.varint))
+		case 2:
+			return r.Summary.unmarshalProto(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (s *WorkerSummary) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, s.Worker)
+	b = appendProtoVarint(b, 2, uint64(s.MapTasks))
+	b = appendProtoVarint(b, 3, uint64(s.ReduceTasks))
+	b = appendProtoVarint(b, 4, uint64(s.Failures))
+	b = appendProtoVarint(b, 5, uint64(s.InputBytes))
+	b = appendProtoVarint(b, 6, uint64(s.OutputBytes))
+	b = appendProtoVarint(b, 7, uint64(s.Uptime))
+	var responded uint64
+	if s.Responded {
+		responded = 1
+	}
+	return appendProtoVarint(b, 8, responded)
+}
+
+func (s *WorkerSummary) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			s.Worker = string(f.bytes)
+		case 2:
+			s.MapTasks = int(int64(f.varint))
+		case 3:
+			s.ReduceTasks = int(int64(f.varint))
+		case 4:
+			s.Failures = int(int64(f.varint))
+		case 5:
+			s.InputBytes = int64(f.varint)
+		case 6:
+			s.OutputBytes = int64(f.varint)
+		case 7:
+			s.Uptime = time.Duration(f.varint)
+		case 8:
+			s.Responded = f.varint != 0
+		}
+		return nil
+	})
+}
+
+func (r *CrashReport) marshalProto(b []byte) []byte {
+	b = appendProtoString(b, 1, r.Worker)
+	b = appendProtoVarint(b, 2, uint64(r.Time.UnixNano()))
+	b = appendProtoString(b, 3, r.Reason)
+	b = appendProtoString(b, 4, r.Stack)
+	for _, task := range r.Tasks {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, task)
+	}
+	for _, line := range r.LogTail {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, line)
+	}
+	return appendProtoString(b, 7, r.Token)
+}
+
+func (r *CrashReport) unmarshalProto(b []byte) error {
+	return decodeProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			r.Worker = string(f.bytes)
+		case 2:
+			r.Time = time.Unix(0, int64(f.varint))
+		case 3:
+			r.Reason = string(f.bytes)
+		case 4:
+			r.Stack = string(f.bytes)
+		case 5:
+			r.Tasks = append(r.Tasks, string(f.bytes))
+		case 6:
+			r.LogTail = append(r.LogTail, string(f.bytes))
+		case 7:
+			r.Token = string(f.bytes)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"io"
+	"sync"
+)
+
+// readAheadReader reads its source in fixed-size blocks on a
+// background goroutine. Two buffers are used: while the caller
+// consumes block N-1 the next block N is already being read, which
+// hides the read latency of slow, remote or compressed inputs.
+type readAheadReader struct {
+	blocks chan readAheadBlock // Filled blocks in read order
+	free   chan []byte         // Buffers ready to be filled again
+	done   chan struct{}       // Closed to stop the background reader
+	once   sync.Once
+
+	buf []byte // Block being consumed
+	cur []byte // Unread part of buf
+	err error  // Error that ended the source
+}
+
+// readAheadBlock is one block read from the source
+type readAheadBlock struct {
+	data []byte
+	err  error
+}
+
+// newReadAheadReader starts reading r in blocks of blockSize bytes
+func newReadAheadReader(r io.Reader, blockSize int) *readAheadReader {
+	ra := &readAheadReader{
+		blocks: make(chan readAheadBlock, 1),
+		free:   make(chan []byte, 2),
+		done:   make(chan struct{}),
+	}
+	ra.free <- make([]byte, blockSize)
+	ra.free <- make([]byte, blockSize)
+	go ra.fill(r)
+	return ra
+}
+
+// fill reads blocks from r until it fails or the reader is closed
+func (ra *readAheadReader) fill(r io.Reader) {
+	defer close(ra.blocks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-ra.free:
+		case <-ra.done:
+			return
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case ra.blocks <- readAheadBlock{data: buf[:n], err: err}:
+		case <-ra.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read copies data from the current block, waiting for the next one
+// once it is used up
+func (ra *readAheadReader) Read(p []byte) (int, error) {
+	for len(ra.cur) == 0 {
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		if ra.buf != nil {
+			ra.free <- ra.buf[:cap(ra.buf)]
+			ra.buf = nil
+		}
+
+		block, ok := <-ra.blocks
+		if !ok {
+			ra.err = io.EOF
+			continue
+		}
+		ra.buf, ra.cur, ra.err = block.data, block.data, block.err
+	}
+
+	n := copy(p, ra.cur)
+	ra.cur = ra.cur[n:]
+	return n, nil
+}
+
+// Close stops the background reader. It does not close the source.
+func (ra *readAheadReader) Close() error {
+	ra.once.Do(func() { close(ra.done) })
+	return nil
+}
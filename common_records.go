@@ -0,0 +1,188 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// wholeReader returns the content of its input as a single record
+type wholeReader struct {
+	in   io.Reader
+	done bool
+}
+
+// Next returns the whole input the first time it is called
+func (r *wholeReader) Next() ([]byte, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	return ioutil.ReadAll(r.in)
+}
+
+// delimitedReader returns the records of a split that end with a
+// delimiter. A record belongs to the split holding its first byte, so
+// the first record of a split starts after the first delimiter at or
+// past its offset, and its last record may extend beyond its end.
+type delimitedReader struct {
+	in        *bufio.Reader
+	delim     []byte
+	keepDelim bool  // Whether records keep their delimiter
+	pos       int64 // Offset of the next record in the file
+	end       int64 // Offset past which no record starts, negative for none
+	eof       bool
+}
+
+// newDelimitedReader positions a reader on the first record of split
+func newDelimitedReader(split SplitInfo, delim []byte, keepDelim bool, open func(offset int64) io.Reader) *delimitedReader {
+	// A record starts right after a delimiter, which may begin before
+	// the offset
+	start := split.Offset - int64(len(delim))
+	if start < 0 {
+		start = 0
+	}
+	r := &delimitedReader{delim: delim, keepDelim: keepDelim, pos: start, end: -1}
+	if split.Length > 0 {
+		r.end = split.Offset + split.Length
+	}
+	r.in = bufio.NewReader(open(start))
+	if split.Offset > 0 {
+		r.skip()
+	}
+	return r
+}
+
+// skip drops the remainder of the record preceding the split
+func (r *delimitedReader) skip() {
+	skipped, err := readDelimited(r.in, r.delim)
+	r.pos += int64(len(skipped))
+	r.eof = err != nil
+}
+
+// Next returns the next record of the split
+func (r *delimitedReader) Next() ([]byte, error) {
+	if r.eof || (r.end >= 0 && r.pos >= r.end) {
+		return nil, io.EOF
+	}
+	record, err := readDelimited(r.in, r.delim)
+	r.pos += int64(len(record))
+	if err == io.EOF {
+		r.eof = true
+		if len(record) == 0 {
+			return nil, io.EOF
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if !r.keepDelim {
+		record = bytes.TrimSuffix(record, r.delim)
+	}
+	return record, nil
+}
+
+// readDelimited reads up to and including the next delimiter, or up
+// to the end of the input, which is reported as io.EOF
+func readDelimited(r *bufio.Reader, delim []byte) ([]byte, error) {
+	last := delim[len(delim)-1]
+	var record []byte
+	for {
+		chunk, err := r.ReadBytes(last)
+		record = append(record, chunk...)
+		if err != nil || bytes.HasSuffix(record, delim) {
+			return record, err
+		}
+	}
+}
+
+// joinedReader returns all records of another reader as one record,
+// for splits passed to a single map call
+type joinedReader struct {
+	records RecordReader
+	done    bool
+}
+
+// Next returns the concatenated records the first time it is called
+func (r *joinedReader) Next() ([]byte, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	var content []byte
+	for {
+		record, err := r.records.Next()
+		if err == io.EOF {
+			return content, nil
+		} else if err != nil {
+			return nil, err
+		}
+		content = append(content, record...)
+	}
+}
+
+// fixedReader returns the fixed-width records starting in a split.
+// A short record at the end of the file is returned as it is.
+type fixedReader struct {
+	in   io.Reader
+	size int64
+	pos  int64 // Offset of the next record in the file
+	end  int64 // Offset past which no record starts, negative for none
+}
+
+// newFixedReader positions a reader on the first record of split
+func newFixedReader(split SplitInfo, size int, open func(offset int64) io.Reader) *fixedReader {
+	r := &fixedReader{size: int64(size), end: -1}
+	r.pos = (split.Offset + r.size - 1) / r.size * r.size
+	if split.Length > 0 {
+		r.end = split.Offset + split.Length
+	}
+	r.in = bufio.NewReader(open(r.pos))
+	return r
+}
+
+// Next returns the next record of the split
+func (r *fixedReader) Next() ([]byte, error) {
+	if r.end >= 0 && r.pos >= r.end {
+		return nil, io.EOF
+	}
+	record := make([]byte, r.size)
+	n, err := io.ReadFull(r.in, record)
+	r.pos += int64(n)
+	if n > 0 && (err == nil || err == io.ErrUnexpectedEOF) {
+		return record[:n], nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return nil, err
+}
+
+// splitInputs divides the input files into splits of about size
+// bytes, one per map task. Splits of fixed-width records are rounded
+// up to whole records; delimited records are aligned by the readers.
+// Files that cannot be inspected or are no larger than size form one
+// split each, as do all files when size is not positive.
+func splitInputs(files []string, size int64, recordSize int) []SplitInfo {
+	if size > 0 && recordSize > 0 {
+		size = (size + int64(recordSize) - 1) / int64(recordSize) * int64(recordSize)
+	}
+
+	var splits []SplitInfo
+	for _, file := range files {
+		info, err := statFile(file)
+		if size <= 0 || err != nil || info.Size() <= size {
+			splits = append(splits, SplitInfo{Task: len(splits), File: file})
+			continue
+		}
+		for offset := int64(0); offset < info.Size(); offset += size {
+			split := SplitInfo{Task: len(splits), File: file, Offset: offset, Length: size}
+			if offset+size >= info.Size() {
+				split.Length = 0 // The last split reads whatever the file holds by then
+			}
+			splits = append(splits, split)
+		}
+	}
+	return splits
+}
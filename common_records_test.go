@@ -0,0 +1,89 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSplitsAlignToRecords reads a file in splits of every size and
+// checks that each record is read by exactly one split, whole.
+func TestSplitsAlignToRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "records")
+	formats := []struct {
+		name    string
+		opts    JobOptions
+		content string
+		records []string
+	}{
+		{"lines", JobOptions{InputFormat: InputLines}, "a\nbb\n\nccc\ndddd", []string{"a", "bb", "", "ccc", "dddd"}},
+		{"line splits", JobOptions{}, "a\nbb\n\nccc\ndddd", nil},
+		{"delimiter", JobOptions{RecordDelimiter: "\x1e"}, "one\x1etwo\x1e\x1ethree\x1e", []string{"one", "two", "", "three"}},
+		{"multi-byte delimiter", JobOptions{RecordDelimiter: "\r\n"}, "a\rb\r\ncd\r\n\r\ne", []string{"a\rb", "cd", "", "e"}},
+		{"fixed width", JobOptions{RecordSize: 3}, "abcdefghij", []string{"abc", "def", "ghi", "j"}},
+	}
+
+	for _, f := range formats {
+		if err := os.WriteFile(file, []byte(f.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		for size := int64(1); size <= int64(len(f.content)); size++ {
+			opts := f.opts
+			opts.SplitSize = size
+			records := readFormat(t, file, opts)
+			if f.records == nil {
+				// Splits passed whole must add up to the file
+				if got := strings.Join(records, ""); got != f.content {
+					t.Errorf("%s, split size %d: got %q, want %q", f.name, size, got, f.content)
+				}
+			} else if !reflect.DeepEqual(records, f.records) {
+				t.Errorf("%s, split size %d: got %q, want %q", f.name, size, records, f.records)
+			}
+		}
+	}
+}
+
+// readFormat returns the records of all splits of file in the job's
+// input format
+func readFormat(t *testing.T, file string, opts JobOptions) []string {
+	format, err := opts.inputFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splits, err := format.Splits([]string{file}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []string
+	for _, split := range splits {
+		var files []*os.File
+		open := func(offset int64) io.Reader {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Seek(offset, io.SeekStart)
+			files = append(files, f)
+			return f
+		}
+		reader := format.Reader(split, opts, open)
+		for {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, string(record))
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	return records
+}
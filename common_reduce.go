@@ -2,9 +2,12 @@
 package mapreduce
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 )
 
 // doReduce manages the reduce phase of a MapReduce job.
@@ -17,66 +20,489 @@ import (
 // 4. Writes the final key-value pairs to a single output file
 //
 // Parameters:
+//   - ctx: Cancels the task
 //   - jobName: Unique identifier for the MapReduce job
 //   - reduceTaskNumber: Index of this reduce task (0-based)
 //   - outFile: Path where the final output will be written
 //   - nMap: Number of map tasks that generated intermediate files
 //   - reduceF: User-defined function to process grouped values
+//   - opts: Job settings shared with the map phase
+//   - disk: Scheduled file I/O of this task, nil for plain I/O
+//
+// The format and compression of intermediate files are detected from
+// their header, so files written by older workers remain readable. Jobs with DecodeWorkers set decode
+// several files in parallel; values then reach reduceF in no
+// particular order. Jobs with MaxReduceValues set reduce keys with many
+// values in bounded chunks, see reduceChunked. Jobs with SortedReduce
+// set group the records by sorting them instead of hashing, and write
+// their output sorted by key; with SortBuffer set as well, the records
+// are sorted externally, see externalSorter, and only the values of
+// one key at a time are held in memory. Jobs with KeyOrder set sort as
+// well, group the records by group key and pass the values of a group
+// in the order of their composite keys, see KeyOrder.
+//
+// The returned metrics carry the digest of the task's output.
+//
+// Once ctx is done the task stops reading its input or reducing, and
+// returns the error of ctx; the output written so far is removed.
 //
 // Error handling:
-//   - Logs but continues if an intermediate file cannot be opened
-//   - Fatally exits if the output file cannot be created
+//   - Fails with a fetchError if an intermediate file cannot be opened
+//     or breaks off mid-record, so that the master reruns its map task
+//   - Fails if an intermediate file is in a format it cannot read
+//   - Fails if the grouped values exceed the job's memory budget
+//   - Fails if the output file cannot be written
 //
 // The output is written in JSON format, with each line containing
 // a key-value pair produced by the reduce function.
 func doReduce(
+	ctx context.Context,
 	jobName JobParse,
 	reduceTaskNumber int,
 	outFile string,
 	nMap int,
 	reduceF func(string, []string) string,
-) {
-	// Create a map to store all values for each key
-	// This aggregates results from all map tasks
-	kvMap := make(map[string][]string)
+	opts JobOptions,
+	disk *taskDisk,
+) (TaskMetrics, error) {
+	return reduceGroups(ctx, jobName, reduceTaskNumber, outFile, nMap, func(key string, values Iterator) (string, error) {
+		return reduceChunked(reduceF, key, collectValues(values), opts.MaxReduceValues), nil
+	}, opts, disk)
+}
+
+// Iterator streams the values of one key to a StreamReduceFunc
+type Iterator interface {
+	// Next returns the next value, or false once all values are read
+	Next() (string, bool)
+}
+
+// StreamReduceFunc is a reduce function reading the values of a key
+// one at a time instead of receiving them all at once. A returned
+// error fails the task.
+type StreamReduceFunc func(key string, values Iterator) (string, error)
+
+// doStreamReduce runs a reduce task like doReduce with a streaming
+// reduce function. Values are only streamed from disk when the job
+// sorts externally, see WithExternalSort; otherwise they are grouped
+// in memory as usual and the function iterates over them. Jobs with
+// MaxReduceValues set pass all values of a key to a single call.
+// The output written so far is removed if the function fails.
+func doStreamReduce(
+	ctx context.Context,
+	jobName JobParse,
+	reduceTaskNumber int,
+	outFile string,
+	nMap int,
+	reduceF StreamReduceFunc,
+	opts JobOptions,
+	disk *taskDisk,
+) (TaskMetrics, error) {
+	return reduceGroups(ctx, jobName, reduceTaskNumber, outFile, nMap, reduceF, opts, disk)
+}
 
-	// Process intermediate files from each map task
+// reduceGroups groups the input of a reduce task by key as the job's
+// options ask, passes every group to reduceF and writes the results to
+// outFile. It stops at the first error of reduceF, or once ctx is done.
+func reduceGroups(
+	ctx context.Context,
+	jobName JobParse,
+	reduceTaskNumber int,
+	outFile string,
+	nMap int,
+	reduceF StreamReduceFunc,
+	opts JobOptions,
+	disk *taskDisk,
+) (TaskMetrics, error) {
+	// Sorting values within groups needs a sorted reduce
+	order, err := opts.keyOrder()
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+	sorted := opts.SortedReduce || order != nil
+
+	// Cancellation is checked before the output is created and before
+	// every group is reduced
+	done := ctx.Done()
+	write := func(groups func(emit func(key string, values Iterator)) error) (TaskMetrics, error) {
+		if isClosed(done) {
+			return TaskMetrics{}, ctx.Err()
+		}
+		return writeReduceOutput(outFile, disk, order.outputGroups(groups), func(key string, values Iterator) (value string, err error) {
+			if isClosed(done) {
+				return "", ctx.Err()
+			}
+			defer recoverTaskError(&err)
+			return reduceF(key, values)
+		})
+	}
+
+	// Process intermediate files from each map task, plus any partial
+	// aggregates spilled by worker combiner caches
 	// Each file contains key-value pairs assigned to this reducer
-	for i := 0; i < nMap; i++ {
-		fileName := reduceName(jobName, i, reduceTaskNumber)
-		file, err := os.Open(fileName)
+	ws := opts.workspace(jobName)
+	replica := opts.replica(jobName)
+	inputs := reduceInputs(ws, replica, nMap, reduceTaskNumber, opts.DroppedCaches)
+	dict, err := loadZstdDictionary(readableCopy(ws, replica, ws.ZstdDictionary()))
+	if err != nil {
+		return TaskMetrics{}, fmt.Errorf("read zstd dictionary: %w", err)
+	}
+	// Once the values exceed the memory budget, further records are
+	// dropped and the task fails after decoding
+	guard := newMemoryGuard(opts.MemoryBudget)
+	var memErr error
+	checkMemory := func() bool {
+		if memErr == nil {
+			memErr = guard.Check()
+		}
+		return memErr == nil
+	}
+
+	if sorted && opts.SortBuffer > 0 {
+		dir, err := ws.SortRuns(reduceTaskNumber)
+		if err != nil {
+			return TaskMetrics{}, err
+		}
+		defer os.RemoveAll(dir)
+		sorter := newExternalSorter(dir, opts.SortBuffer, order, disk)
+		if err := decodeReduceInputs(done, inputs, dict, opts.DecodeWorkers, disk, sorter.add); err != nil {
+			return TaskMetrics{}, err
+		}
+		return write(sorter.groups)
+	}
+	if sorted {
+		var records []KeyValue
+		err := decodeReduceInputs(done, inputs, dict, opts.DecodeWorkers, disk, func(batch []KeyValue) {
+			for _, kv := range batch {
+				if !checkMemory() {
+					return
+				}
+				records = append(records, kv)
+			}
+		})
+		if err == nil {
+			err = memErr
+		}
 		if err != nil {
-			log.Printf("doReduce: open file %s error %v", fileName, err)
-			continue // Skip this file but continue processing others
+			return TaskMetrics{}, err
 		}
+		return write(func(emit func(key string, values Iterator)) error {
+			reduceSorted(records, order, func(key string, values []string) {
+				emit(key, &sliceIterator{values})
+			})
+			return nil
+		})
+	}
 
-		// Use a JSON decoder to read key-value pairs
-		dec := json.NewDecoder(file)
-		for {
-			var kv KeyValue
-			err = dec.Decode(&kv)
-			if err != nil {
-				break // End of file or error
+	// Create a map to store all values for each key
+	// This aggregates results from all map tasks
+	kvMap := make(map[string][]string)
+	err = decodeReduceInputs(done, inputs, dict, opts.DecodeWorkers, disk, func(batch []KeyValue) {
+		for _, kv := range batch {
+			if !checkMemory() {
+				return
 			}
 			// Append each value to the slice for its key
 			kvMap[kv.Key] = append(kvMap[kv.Key], kv.Value)
 		}
-		file.Close()
+	})
+	if err == nil {
+		err = memErr
+	}
+	if err != nil {
+		return TaskMetrics{}, err
 	}
+	return write(func(emit func(key string, values Iterator)) error {
+		for key, values := range kvMap {
+			emit(key, &sliceIterator{values})
+		}
+		return nil
+	})
+}
 
+// writeReduceOutput applies reduceF to every group produced by groups
+// and writes the results to outFile, returning the digest of the
+// results in the task metrics. outFile appears only once all results
+// are written, see createTaskOutput. Once reduceF fails, the remaining
+// groups are skipped and nothing is written, as when groups or writing
+// the file fail.
+func writeReduceOutput(
+	outFile string,
+	disk *taskDisk,
+	groups func(emit func(key string, values Iterator)) error,
+	reduceF StreamReduceFunc,
+) (TaskMetrics, error) {
 	// Create the final output file
 	// This will contain the results of applying reduceF to each key's values
-	file, err := os.Create(outFile)
+	if err := mkdirAll(dirPath(outFile)); err != nil {
+		return TaskMetrics{}, fmt.Errorf("create output directory: %w", err)
+	}
+	file, err := createTaskOutput(outFile, disk.create)
 	if err != nil {
-		log.Fatalf("doReduce: create file %s error %v", outFile, err)
+		return TaskMetrics{}, fmt.Errorf("create file %s: %w", outFile, err)
 	}
-	defer file.Close()
-	enc := json.NewEncoder(file)
+	// Reduce outputs are JSON lines whatever the job's record codec,
+	// since other programs read them
+	enc := newRecordEncoder(file, recordCodecJSON)
 
 	// Process each key's values through the reduce function
 	// Write each result as a JSON-encoded KeyValue pair
-	for key, values := range kvMap {
-		output := reduceF(key, values)
-		enc.Encode(KeyValue{key, output})
+	var metrics TaskMetrics
+	var reduceErr error
+	err = groups(func(key string, values Iterator) {
+		if reduceErr != nil {
+			return
+		}
+		value, err := reduceF(key, values)
+		if err != nil {
+			reduceErr = fmt.Errorf("reduce key %q: %w", key, err)
+			return
+		}
+		kv := KeyValue{key, value}
+		metrics.OutputDigest += recordDigest(kv)
+		enc.Encode(&kv)
+	})
+	if reduceErr != nil {
+		err = reduceErr
+	}
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = fmt.Errorf("close file %s: %w", outFile, cerr)
+	}
+	if err == nil {
+		if err = file.commit(); err != nil {
+			err = fmt.Errorf("commit file %s: %w", outFile, err)
+		}
+	}
+	if err != nil {
+		file.discard()
+		return metrics, err
+	}
+	return metrics, nil
+}
+
+// sliceIterator iterates over values held in memory
+type sliceIterator struct {
+	values []string
+}
+
+func (it *sliceIterator) Next() (string, bool) {
+	if len(it.values) == 0 {
+		return "", false
+	}
+	value := it.values[0]
+	it.values = it.values[1:]
+	return value, true
+}
+
+// collectValues returns the values left in it
+func collectValues(it Iterator) []string {
+	if s, ok := it.(*sliceIterator); ok {
+		values := s.values
+		s.values = nil
+		return values
+	}
+	var values []string
+	for value, ok := it.Next(); ok; value, ok = it.Next() {
+		values = append(values, value)
+	}
+	return values
+}
+
+// reduceSorted sorts records by key under order, by key if nil, and
+// passes the values of each group to emit, in key order. Values of
+// records that compare equal keep the order they were decoded in.
+func reduceSorted(records []KeyValue, order *KeyOrder, emit func(key string, values []string)) {
+	sortRecords(records, order)
+	var values []string
+	for i, kv := range records {
+		values = append(values, kv.Value)
+		group := order.group(kv.Key)
+		if i == len(records)-1 || order.group(records[i+1].Key) != group {
+			emit(group, values)
+			values = nil
+		}
+	}
+}
+
+// reduceChunked applies reduceF to the values of key, passing at most
+// limit values per call. Larger value lists are reduced in chunks and
+// the chunk results reduced again until a single call remains. Limits
+// below two disable chunking.
+func reduceChunked(reduceF func(string, []string) string, key string, values []string, limit int) string {
+	if limit < 2 {
+		return reduceF(key, values)
+	}
+	for len(values) > limit {
+		partial := make([]string, 0, (len(values)+limit-1)/limit)
+		for start := 0; start < len(values); start += limit {
+			end := start + limit
+			if end > len(values) {
+				end = len(values)
+			}
+			partial = append(partial, reduceF(key, values[start:end]))
+		}
+		values = partial
+	}
+	return reduceF(key, values)
+}
+
+// decodeBatchSize is the number of records a decoder hands to the
+// grouping stage at once
+const decodeBatchSize = 256
+
+// decodeReduceInputs decodes the intermediate files in names, using
+// the job's zstd dictionary dict if any, and passes their records to
+// group in batches. With more than one worker
+// the files are decoded by a bounded pool of goroutines while group
+// runs on the calling goroutine only; otherwise they are decoded one
+// after another in order. The first file that cannot be decoded fails
+// the decoding, once all workers are done.
+func decodeReduceInputs(done <-chan struct{}, names []string, dict []byte, workers int, disk *taskDisk, group func([]KeyValue)) error {
+	if workers <= 1 || len(names) <= 1 {
+		for _, name := range names {
+			if err := decodeIntermediate(done, name, dict, disk, group); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if workers > len(names) {
+		workers = len(names)
+	}
+
+	files := make(chan string)
+	batches := make(chan []KeyValue, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range files {
+				err := decodeIntermediate(done, name, dict, disk, func(batch []KeyValue) {
+					batches <- batch
+				})
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		for _, name := range names {
+			files <- name
+		}
+		close(files)
+		wg.Wait()
+		close(batches)
+	}()
+
+	for batch := range batches {
+		group(batch)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return firstErr
+}
+
+// decodeIntermediate reads one intermediate file and passes its
+// records to emit in batches of up to decodeBatchSize. It stops early
+// once done is closed. A file that cannot be opened or breaks off
+// mid-record fails with a fetchError rather than losing its records.
+func decodeIntermediate(done <-chan struct{}, fileName string, dict []byte, disk *taskDisk, emit func([]KeyValue)) error {
+	if isClosed(done) {
+		return nil
+	}
+	file, err := disk.open(fileName)
+	if err != nil {
+		return &fetchError{file: fileName, err: err}
+	}
+	defer file.Close()
+
+	// Skipping a file in a format this worker cannot read would
+	// silently drop its records from the result
+	reader, codec, err := newDecompressReader(file, dict)
+	if err != nil {
+		return fmt.Errorf("decode file %s: %w", fileName, err)
+	}
+	defer reader.Close()
+
+	dec := newRecordDecoder(reader, codec)
+	batch := make([]KeyValue, 0, decodeBatchSize)
+	for {
+		var kv KeyValue
+		if err := dec.Decode(&kv); err == io.EOF {
+			break
+		} else if err != nil {
+			return &fetchError{file: fileName, err: err}
+		}
+		batch = append(batch, kv)
+		if len(batch) == decodeBatchSize {
+			emit(batch)
+			batch = make([]KeyValue, 0, decodeBatchSize)
+			if isClosed(done) {
+				return nil
+			}
+		}
+	}
+	if len(batch) > 0 {
+		emit(batch)
+	}
+	return nil
+}
+
+// reduceInputs lists the intermediate files a reduce task must read,
+// falling back to the copies in replica, which may be nil, of the ones
+// that are missing. Spills of the dropped combiner caches are left out.
+func reduceInputs(ws *JobWorkspace, replica *JobWorkspace, nMap int, reduceTask int, dropped []string) []string {
+	names := make([]string, 0, nMap)
+	for i := 0; i < nMap; i++ {
+		names = append(names, readableCopy(ws, replica, ws.Intermediate(i, reduceTask)))
+	}
+
+	spills, err := globFiles(ws.CacheSpillPattern(reduceTask))
+	if err != nil {
+		processLog.warnf("doReduce: list cache spills error %v", err)
+	}
+	spills = keptSpills(ws, spills, dropped)
+	if replica != nil {
+		found := make(map[string]bool, len(spills))
+		for _, spill := range spills {
+			found[replicaPath(ws, replica, spill)] = true
+		}
+		copies, err := globFiles(replica.CacheSpillPattern(reduceTask))
+		if err != nil {
+			processLog.warnf("doReduce: list replicated cache spills error %v", err)
+		}
+		copies = keptSpills(replica, copies, dropped)
+		for _, spill := range copies {
+			if !found[spill] {
+				processLog.warnf("doReduce: cache spill of %s is missing, reading its replica", spill)
+				spills = append(spills, spill)
+			}
+		}
+	}
+	return append(names, spills...)
+}
+
+// keptSpills returns the cache spills of ws not written by one of the
+// dropped combiner caches
+func keptSpills(ws *JobWorkspace, spills []string, dropped []string) []string {
+	if len(dropped) == 0 {
+		return spills
+	}
+	kept := spills[:0]
+	for _, spill := range spills {
+		owned := false
+		for _, cache := range dropped {
+			owned = owned || strings.HasPrefix(spill, ws.CacheSpillPrefix(cache))
+		}
+		if !owned {
+			kept = append(kept, spill)
+		}
 	}
+	return kept
 }
@@ -0,0 +1,115 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"io"
+	"strings"
+)
+
+// replica returns the workspace receiving a second copy of the job's
+// map output, nil unless the job replicates it, see
+// WithIntermediateReplica
+func (o JobOptions) replica(jobName JobParse) *JobWorkspace {
+	if o.Replica == "" {
+		return nil
+	}
+	return NewJobWorkspace(o.Replica, jobName)
+}
+
+// replicaPath returns the path name, a file in ws, has in replica
+func replicaPath(ws *JobWorkspace, replica *JobWorkspace, name string) string {
+	return joinPath(replica.Root, strings.TrimPrefix(name, ws.Root))
+}
+
+// replicatedFile writes everything to a file and its replica
+type replicatedFile struct {
+	io.Writer
+	primary taskOutput
+	replica taskOutput
+}
+
+// createReplicated creates the task output name and, when the job
+// replicates its map output, its copy in replica. A copy that cannot be
+// written fails the write like the file itself, so a replica is always
+// complete.
+func createReplicated(ws *JobWorkspace, replica *JobWorkspace, name string, disk *taskDisk) (taskOutput, error) {
+	file, err := createTaskOutput(name, disk.create)
+	if err != nil {
+		return nil, err
+	}
+	if replica == nil {
+		return file, nil
+	}
+	copyName := replicaPath(ws, replica, name)
+	if err := mkdirAll(dirPath(copyName)); err != nil {
+		file.Close()
+		file.discard()
+		return nil, err
+	}
+	dup, err := createTaskOutput(copyName, createFile)
+	if err != nil {
+		file.Close()
+		file.discard()
+		return nil, err
+	}
+	return &replicatedFile{Writer: io.MultiWriter(file, dup), primary: file, replica: dup}, nil
+}
+
+// Close closes the file and its replica
+func (f *replicatedFile) Close() error {
+	err := f.primary.Close()
+	if rerr := f.replica.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// commit publishes the file, then its replica
+func (f *replicatedFile) commit() error {
+	if err := f.primary.commit(); err != nil {
+		return err
+	}
+	return f.replica.commit()
+}
+
+// discard removes the file and its replica if they were not committed
+func (f *replicatedFile) discard() {
+	f.primary.discard()
+	f.replica.discard()
+}
+
+// replicateDictionary copies the job's zstd dictionary to replica, so
+// map output compressed with it stays readable from there
+func replicateDictionary(ws *JobWorkspace, replica *JobWorkspace, dict []byte) {
+	if replica == nil || dict == nil {
+		return
+	}
+	name := replica.ZstdDictionary()
+	if _, err := statFile(name); err == nil {
+		return
+	}
+	if err := mkdirAll(replica.IntermediateDir()); err != nil {
+		processLog.warnf("doMap: replicate zstd dictionary error %v", err)
+		return
+	}
+	if err := writeFile(name, dict); err != nil {
+		processLog.warnf("doMap: replicate zstd dictionary error %v", err)
+	}
+}
+
+// readableCopy returns name, a file in ws, or its copy in replica if
+// the file itself cannot be found, e.g. on the disk of a failed node
+func readableCopy(ws *JobWorkspace, replica *JobWorkspace, name string) string {
+	if replica == nil {
+		return name
+	}
+	if _, err := statFile(name); err == nil {
+		return name
+	}
+	copyName := replicaPath(ws, replica, name)
+	if _, err := statFile(copyName); err != nil {
+		return name
+	}
+	processLog.warnf("doReduce: %s is missing, reading its replica %s", name, copyName)
+	return copyName
+}
@@ -3,12 +3,15 @@
 package mapreduce
 
 import (
-	"context"
+	"crypto/subtle"
 	"fmt"
-	"net/rpc"
 	"time"
 )
 
+// registrationPollInterval is how long a worker that registered while
+// no job is running should wait before checking in again
+const registrationPollInterval = 2 * time.Second
+
 // Constants for RPC method names used throughout the system
 const (
 	// RegisterMethod is used when a worker registers with the master
@@ -17,12 +20,213 @@ const (
 	DoTaskMethod = "Worker.DoTask"
 	// ShutdownMethod is invoked to gracefully terminate a worker
 	ShutdownMethod = "Worker.Shutdown"
+	// FlushCacheMethod tells a worker the map phase of a job has ended
+	FlushCacheMethod = "Worker.FlushCache"
+	// PrepareMethod readies a worker for a job before its first task
+	PrepareMethod = "Worker.Prepare"
+	// PingMethod checks that a worker running a long task is alive
+	PingMethod = "Worker.Ping"
+	// HeartbeatMethod tells the master a worker is still alive
+	HeartbeatMethod = "Master.Heartbeat"
+	// UnregisterMethod tells the master a worker is leaving
+	UnregisterMethod = "Master.Unregister"
+	// ReportCrashMethod carries the last words of a dying worker
+	ReportCrashMethod = "Master.ReportCrash"
+	// AcquireTokensMethod draws from a rate limit kept by the master
+	AcquireTokensMethod = "Master.AcquireTokens"
+	// FetchFileMethod downloads a chunk of a file the master ships
+	FetchFileMethod = "Master.FetchFile"
+	// CancelTasksMethod aborts a cancelled job's tasks running on a worker
+	CancelTasksMethod = "Worker.CancelTasks"
 )
 
 // RegisterArgs represents the arguments for worker registration RPC.
 // Worker field contains the network address of the registering worker.
 type RegisterArgs struct {
-	Worker string
+	Worker     string
+	Labels     map[string]string // Free-form labels describing the worker
+	Token      string            // Credential presented to the master
+	Host       string            // Host name of the worker's machine
+	LocalPaths []string          // Directories on storage local to the worker
+	Slots      int               // Tasks the worker runs at once, one if zero
+}
+
+// RegisterReply acknowledges a worker registration. It tells the
+// worker whether a job is running and which settings it expects, so
+// idle workers know to wait rather than retry in a tight loop.
+type RegisterReply struct {
+	JobActive   bool          // Whether a job is currently being scheduled
+	JobName     JobParse      // Name of the active job, if any
+	Phase       JobParse      // Phase the active job is in
+	Compression Compression   // Intermediate codec requested by the job
+	Workspace   string        // Root of the active job's workspace
+	PollAfter   time.Duration // Suggested delay before checking in again when idle
+
+	// HeartbeatInterval is how often the worker must send heartbeats,
+	// zero when the master does not track worker liveness
+	HeartbeatInterval time.Duration
+}
+
+// HeartbeatArgs identifies the worker sending a heartbeat.
+type HeartbeatArgs struct {
+	Worker string // Address the worker registered with
+	Token  string // Credential presented to the master
+}
+
+// HeartbeatReply tells a worker whether the master still counts on it.
+type HeartbeatReply struct {
+	Registered bool // False once the worker was dropped and must register again
+}
+
+// AcquireArgs asks the master for tokens of a job's rate limit.
+type AcquireArgs struct {
+	Worker    string   // Address of the worker running the task
+	Token     string   // Credential presented to the master
+	Limit     string   // Name of the rate limit, see WithRateLimit
+	N         int      // Number of tokens
+	Job       JobParse // Job of the task, whose limit it is
+	Workspace string   // Root of the job's workspace; names may be shared by concurrent jobs
+}
+
+// AcquireReply grants the tokens of an AcquireArgs request.
+type AcquireReply struct {
+	Wait time.Duration // How long to wait before using the tokens
+}
+
+// FetchFileArgs asks for the chunk of a shipped file starting at
+// Offset, see WithShippedSideInputs. Seq numbers the chunks of a
+// download from zero; the master echoes it so that a late reply to an
+// abandoned request is never taken for the current chunk.
+type FetchFileArgs struct {
+	Worker    string   // Address of the worker downloading the file
+	Token     string   // Credential presented to the master
+	File      string   // Path of the file as the job names it
+	Seq       int      // Number of the chunk within the download
+	Offset    int64    // Position of the chunk in the file
+	Job       JobParse // Job shipping the file
+	Workspace string   // Root of the job's workspace, see AcquireArgs
+}
+
+// FetchFileReply carries a chunk of a shipped file. Chunks are at most
+// the master's message size limit, see WithMaxMessageSize.
+type FetchFileReply struct {
+	Seq      int    // Seq of the request
+	Offset   int64  // Offset of the request
+	Size     int64  // Size of the whole file
+	Data     []byte // Contents of the chunk
+	Checksum uint32 // CRC-32 (IEEE) of Data
+	Last     bool   // Whether the chunk ends the file
+}
+
+// UnregisterArgs identifies a worker leaving the cluster.
+type UnregisterArgs struct {
+	Worker string // Address the worker registered with
+	Token  string // Credential presented to the master
+}
+
+// JobOptions holds per-job settings that are shipped to the workers
+// together with every task of the job.
+type JobOptions struct {
+	Compression   Compression // Codec for intermediate files, chosen per task by default
+	MemoryBudget  uint64      // Heap budget in bytes for in-memory grouping, zero means unlimited
+	Workspace     string      // Root of the job workspace, DefaultWorkspace when empty
+	Replica       string      // Root of a second workspace copying the map output, none when empty
+	ReadAhead     int         // Block size in bytes for reading map input ahead, zero disables it
+	DecodeWorkers int         // Intermediate files a reduce task decodes in parallel, one by default
+
+	// IntermediateFormat is the layout of intermediate files written by
+	// the job, IntermediateFormatCurrent if zero. Pinning an older
+	// layout keeps the files readable by workers not yet upgraded.
+	IntermediateFormat int
+
+	// RecordCodec encodes the records of intermediate files, CodecBinary
+	// if empty, or CodecJSON when an older layout is pinned
+	RecordCodec RecordCodec
+
+	// MaxReduceValues bounds the values passed to a single reduce call.
+	// Keys with more values are reduced in chunks whose results are
+	// reduced again, so the reduce function must accept its own output
+	// as a value, like a combiner. Zero passes all values at once.
+	MaxReduceValues int
+
+	// SortedReduce groups the records of a reduce task by sorting them
+	// instead of in a hash map. This needs less memory per record and
+	// writes the task's output sorted by key.
+	SortedReduce bool
+
+	// SortBuffer bounds the bytes of records a sorted reduce holds in
+	// memory. Larger partitions are spilled to disk in sorted runs that
+	// are merged while reducing. Zero sorts in memory.
+	SortBuffer int64
+
+	// KeyOrder names the registered key order sorting the values of
+	// every reduce group, see RegisterKeyOrder. Empty groups records by
+	// their whole key.
+	KeyOrder string
+
+	// RangeBounds range partitions the map output instead of hashing:
+	// partition i receives the records whose group key sorts below
+	// bound i and not below bound i-1, so there is one bound fewer
+	// than reduce tasks, in ascending order, see WithRangePartitions
+	RangeBounds []string
+
+	// Builtin names the built-in job the workers run instead of their
+	// own map and reduce functions, e.g. BuiltinSortByValue, see
+	// Master.SortByValue. Empty runs the workers' functions.
+	Builtin string
+
+	// Filter of the map output: records are kept if the key they are
+	// partitioned by starts with one of KeyPrefixes, when set, and
+	// passes the key filter registered as KeyFilter, when set. Reduce
+	// tasks whose partitions end up empty are skipped.
+	KeyPrefixes []string
+	KeyFilter   string
+
+	// InputEncoding is the character encoding of the input files, which
+	// are transcoded to UTF-8 before the map function sees them. Empty
+	// passes the input through unchanged.
+	InputEncoding InputEncoding
+
+	// Record format of the input files. With either set, the map
+	// function is called once per record instead of once per split.
+	// RecordSize takes precedence over RecordDelimiter.
+	RecordDelimiter string // Byte sequence ending every record, e.g. "\x1e"
+	RecordSize      int    // Size in bytes of fixed-width records
+
+	// InputFormat names the registered input format dividing the input
+	// into splits and records, see RegisterInputFormat. Empty picks a
+	// built-in format from the settings above and SplitSize.
+	InputFormat string
+	SplitSize   int64     // Input size per map task, zero for one task per file
+	CSVHeader   CSVHeader // Header handling of InputCSV
+
+	// Columns of structured input the map function needs, all if
+	// empty. InputCSV passes only these fields, in this order, looked
+	// up by name in the header or by zero-based position in files
+	// without one. Registered input formats may honor it as well.
+	Columns []string
+
+	// GroupFormats names the input format of every input group that
+	// has its own, see JobConfig.Inputs. The master fills it in.
+	GroupFormats map[string]string
+
+	// Schema the map output is checked against, see WithSchema
+	Schema Schema
+
+	// Seed of the job's random sources, see TaskContext.Rand. The
+	// master picks one when the job starts unless WithSeed sets it.
+	Seed int64
+
+	// Runtime settings applied on workers while the job's tasks run.
+	// Zero keeps the worker's own setting.
+	GCPercent   int   // GC target percentage, negative disables the collector
+	MemoryLimit int64 // Soft memory limit in bytes
+	MaxProcs    int   // GOMAXPROCS
+
+	// DroppedCaches lists the combiner caches whose spills reduce tasks
+	// skip because the master ran the map tasks they absorbed again,
+	// see FlushCacheReply. The master fills it in.
+	DroppedCaches []string
 }
 
 // DoTaskArgs encapsulates all necessary information for task execution RPCs.
@@ -36,18 +240,127 @@ type DoTaskArgs struct {
 	// - For reduce tasks: number of map tasks that generated intermediate files
 	// - For map tasks: number of reduce tasks that will process the results
 	OtherTaskNumber int
+
+	Options JobOptions // Settings shared by all tasks of the job
+	Token   string     // Credential of the master, see WithWorkerToken
+
+	// Part of File a map task reads, see SplitInfo
+	Offset int64
+	Length int64
+	Group  string // Input group of File, see JobConfig.Inputs
+
+	// Verify asks for a second run of a completed task whose output
+	// is discarded, to check that it matches, see WithDeterminismCheck
+	Verify bool
+
+	// TraceParent is the W3C traceparent of the master's span of the
+	// task RPC, the parent of the worker's spans, see UseTracing. Empty
+	// without tracing.
+	TraceParent string
+
+	// Uncached asks for the output of a map task to be written to its
+	// intermediate files even by a worker with a combiner cache, e.g.
+	// when the master runs the task again because the cache holding its
+	// output was lost
+	Uncached bool
+}
+
+// split returns the input split of a map task
+func (a *DoTaskArgs) split() SplitInfo {
+	return SplitInfo{Task: a.TaskNumber, File: a.File, Offset: a.Offset, Length: a.Length, Group: a.Group}
+}
+
+// PrepareArgs describes a job to a worker before it receives any of
+// the job's tasks.
+type PrepareArgs struct {
+	JobName         JobParse   // Job about to be scheduled on the worker
+	Options         JobOptions // Settings shared by all tasks of the job
+	Token           string     // Credential of the master, see WithWorkerToken
+	Framework       string     // FrameworkVersion of the master
+	BinaryVersion   string     // Version of the map and reduce code the job needs, empty for any
+	SideInputs      []string   // Files the job's tasks read besides their input
+	ShipSideInputs  bool       // Whether to download SideInputs from the master with FetchFile
+	SideInputHashes []string   // Hex SHA-256 of every shipped side input, which workers cache the files by
+}
+
+// PrepareReply reports what a prepared worker runs.
+type PrepareReply struct {
+	Framework     string    // FrameworkVersion of the worker
+	BinaryVersion string    // Version of the worker's map and reduce code
+	Build         BuildInfo // Build of the worker's executable
+}
+
+// DoTaskReply carries the result of a task execution RPC back to the master.
+type DoTaskReply struct {
+	Metrics TaskMetrics // Measurements collected while running the task
+
+	// Refused says why the worker did not run the task, e.g. because
+	// its disk is nearly full; the master hands the task to another
+	// worker. Empty if the task ran.
+	Refused string
+
+	// LostMapTasks lists the map tasks whose output a reduce task could
+	// not find, in which case it did not run; the master runs them
+	// again and retries the reduce task.
+	LostMapTasks []int
+
+	// Cache identifies the worker's combiner cache that absorbed the
+	// output of a map task, see WithCombinerCache. The output reaches
+	// the intermediate files only once the master flushes the cache.
+	// Empty if the task wrote its intermediate files.
+	Cache string
+}
+
+// FlushCacheArgs names the job whose map phase has ended.
+type FlushCacheArgs struct {
+	JobName   JobParse // Job whose partial aggregates the cache spills
+	Workspace string   // Root of the job's workspace, see AcquireArgs
+	Token     string   // Credential of the master, see WithWorkerToken
+}
+
+// FlushCacheReply identifies the combiner cache that was flushed. The
+// master runs the map tasks another cache absorbed again, e.g. that of
+// an earlier instance of a worker restarted at the same address.
+type FlushCacheReply struct {
+	Cache string // Identifier of the worker's cache, empty without one
+}
+
+// ShutdownArgs asks a worker, or the RPC server of the master itself,
+// to shut down.
+type ShutdownArgs struct {
+	Token string // Credential of the master, see WithWorkerToken
+}
+
+// PingArgs probes a worker that runs a task of the master.
+type PingArgs struct {
+	Token string // Credential of the master, see WithWorkerToken
+}
+
+// CancelTasksArgs names the job whose running tasks a worker aborts.
+type CancelTasksArgs struct {
+	JobName JobParse // Job that was cancelled
+	Token   string   // Credential of the master, see WithWorkerToken
 }
 
 // ShutdownReply contains the response data for worker shutdown RPC.
 // Ntasks represents the total number of tasks completed by the worker
 // before shutdown.
 type ShutdownReply struct {
-	Ntasks int
+	Ntasks  int
+	Summary WorkerSummary // Work done by the worker, see JobResult
+}
+
+// validToken reports whether got matches the configured token want.
+// Without a configured token every caller is accepted. The comparison
+// takes constant time so the token cannot be guessed byte by byte.
+func validToken(want string, got string) bool {
+	return want == "" || subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
 }
 
-// call performs an RPC call to the specified service with timeout control.
+// call performs an RPC call to the specified service with timeout
+// control, waiting at most DefaultRPCTimeout.
 // Parameters:
-//   - srv: Network address of the RPC server (unix socket path)
+//   - srv: Network address of the RPC server, its scheme selects the transport
 //   - rpcName: Name of the RPC method to invoke
 //   - args: Arguments to pass to the RPC method
 //   - reply: Pointer to store the RPC response
@@ -55,36 +368,7 @@ type ShutdownReply struct {
 // Returns:
 //   - bool: true if the RPC call was successful, false if it failed or timed out
 func call(srv string, rpcName string, args interface{}, reply interface{}) bool {
-	if err := validateRPCArgs(srv, rpcName, args); err != nil {
-		return false
-	}
-	c, err := rpc.Dial("unix", srv)
-	if err != nil {
-		return false
-	}
-	defer c.Close()
-
-	// Set up timeout context to prevent indefinite blocking
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Create buffered channel for RPC response
-	done := make(chan error, 1)
-
-	// Execute RPC call in separate goroutine to enable timeout control
-	go func() {
-		done <- c.Call(rpcName, args, reply)
-	}()
-
-	// Wait for either RPC completion or timeout
-	select {
-	case err := <-done:
-		// Return true only if RPC completed without error
-		return err == nil
-	case <-ctx.Done():
-		// Return false if timeout occurred
-		return false
-	}
+	return callTimeout(srv, rpcName, args, reply, DefaultRPCTimeout)
 }
 
 // validateRPCArgs performs basic validation of RPC call parameters.
@@ -0,0 +1,483 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates an S3-compatible object store, e.g. AWS S3 or
+// MinIO, and holds the credentials to access it.
+type S3Config struct {
+	Endpoint     string `json:"endpoint"`      // Base URL of the store, AWS S3 in Region when empty
+	Region       string `json:"region"`        // Region requests are signed for, us-east-1 by default
+	AccessKey    string `json:"access_key"`    // Access key ID
+	SecretKey    string `json:"secret_key"`    // Secret access key
+	SessionToken string `json:"session_token"` // Token of temporary credentials, may be empty
+	PathStyle    bool   `json:"path_style"`    // Address buckets as endpoint/bucket, as MinIO expects
+}
+
+// S3ConfigFromEnv reads the standard AWS environment variables:
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+// AWS_REGION and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL. Custom
+// endpoints are addressed path-style.
+func S3ConfigFromEnv() *S3Config {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return &S3Config{
+		Endpoint:     endpoint,
+		Region:       os.Getenv("AWS_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		PathStyle:    endpoint != "",
+	}
+}
+
+// UseS3 serves s3://bucket/key paths from the object store cfg
+// describes, for input files, job workspaces and the result directory
+// alike. Like UseTLS the setting is process-wide: call it in the master
+// and every worker before they start. Passing nil turns S3 off again.
+func UseS3(cfg *S3Config) error {
+	if cfg == nil {
+		fileSystems.Lock()
+		delete(fileSystems.byScheme, "s3")
+		fileSystems.Unlock()
+		return nil
+	}
+	fsys, err := newS3FS(*cfg)
+	if err != nil {
+		return err
+	}
+	RegisterFileSystem("s3", fsys)
+	return nil
+}
+
+// s3FS implements FileSystem for s3:// paths. Objects are written to a
+// local temporary file first and uploaded in a single request on
+// Close, which limits them to the 5 GiB a single upload may hold.
+type s3FS struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// newS3FS validates cfg and fills in its defaults
+func newS3FS(cfg S3Config) (*s3FS, error) {
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("S3 requires an access key and a secret key")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	return &s3FS{cfg: cfg, endpoint: endpoint, client: http.DefaultClient, now: time.Now}, nil
+}
+
+// splitS3Path returns the bucket and key of an s3:// path
+func splitS3Path(name string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(name, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("not an S3 path: %s", name)
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("S3 path without a bucket: %s", name)
+	}
+	return bucket, key, nil
+}
+
+func (s *s3FS) Open(name string, offset int64) (io.ReadCloser, error) {
+	bucket, key, err := splitS3Path(name)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := s.do(http.MethodGet, bucket, key, nil, header, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Reading from the end of the object on
+		resp.Body.Close()
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	case resp.StatusCode >= 300:
+		return nil, s3Error("open", name, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3FS) Create(name string) (io.WriteCloser, error) {
+	bucket, key, err := splitS3Path(name)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "mrs3-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{fs: s, name: name, bucket: bucket, key: key, tmp: tmp, sum: sha256.New()}, nil
+}
+
+func (s *s3FS) Stat(name string) (fs.FileInfo, error) {
+	bucket, key, err := splitS3Path(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodHead, bucket, key, nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, s3Error("stat", name, resp)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return s3FileInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+func (s *s3FS) Remove(name string) error {
+	bucket, key, err := splitS3Path(name)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, bucket, key, nil, nil, nil, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return s3Error("remove", name, resp)
+	}
+	return nil
+}
+
+// RemoveAll removes every object below dir
+func (s *s3FS) RemoveAll(dir string) error {
+	bucket, prefix, err := splitS3Path(dir)
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	keys, err := s.list(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.Remove("s3://" + bucket + "/" + key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *s3FS) MkdirAll(string) error { return nil }
+
+// Glob lists the objects sharing the pattern's prefix up to its first
+// wildcard and matches their keys against it
+func (s *s3FS) Glob(pattern string) ([]string, error) {
+	bucket, keyPattern, err := splitS3Path(pattern)
+	if err != nil {
+		return nil, err
+	}
+	prefix := keyPattern
+	if i := strings.IndexAny(keyPattern, `*?[\`); i >= 0 {
+		prefix = keyPattern[:i]
+	}
+	keys, err := s.list(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, key := range keys {
+		ok, err := path.Match(keyPattern, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, "s3://"+bucket+"/"+key)
+		}
+	}
+	return matches, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response the file
+// system needs
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns the keys of all objects of bucket starting with prefix
+func (s *s3FS) list(bucket, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, bucket, "", query, nil, nil, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			return nil, s3Error("list", "s3://"+bucket+"/"+prefix, resp)
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list s3://%s/%s: %v", bucket, prefix, err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a signed request for an object, or for the bucket itself
+// when key is empty
+func (s *s3FS) do(method, bucket, key string, query url.Values, header http.Header, body io.Reader, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(bucket, key, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	// The client ignores a Content-Length header and would send a body
+	// of unknown length chunked, which S3 refuses for PUT
+	if size := header.Get("Content-Length"); size != "" && body != nil {
+		if req.ContentLength, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return nil, err
+		}
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	s.sign(req, payloadHash)
+	return s.client.Do(req)
+}
+
+// objectURL returns the URL of an object, addressing the bucket by
+// host name unless the store wants path-style requests
+func (s *s3FS) objectURL(bucket, key string, query url.Values) string {
+	u := *s.endpoint
+	escaped := "/" + s3Escape(key, false)
+	if s.cfg.PathStyle {
+		escaped = strings.TrimSuffix(u.Path, "/") + "/" + bucket + escaped
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path, _ = url.PathUnescape(escaped)
+	u.RawPath = escaped
+	u.RawQuery = s3CanonicalQuery(query)
+	return u.String()
+}
+
+// sign adds an AWS Signature Version 4 to req
+func (s *s3FS) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	// Sign the host and every x-amz-* and range header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3Escape encodes s as AWS expects in canonical requests: every byte
+// but unreserved characters, and slashes unless escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query with sorted, escaped parameters, which
+// serves both as the request's query and in its signature
+func s3CanonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error turns an unsuccessful response into an error. Missing
+// objects are reported like missing local files.
+func s3Error(op, name string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	var body struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) != nil || body.Code == "" {
+		body.Code = resp.Status
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fmt.Errorf("%s %s", body.Code, body.Message)}
+}
+
+// s3Upload buffers a new object in a temporary file and uploads it on
+// Close
+type s3Upload struct {
+	fs     *s3FS
+	name   string
+	bucket string
+	key    string
+	tmp    *os.File
+	sum    hash.Hash
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	n, err := u.tmp.Write(p)
+	u.sum.Write(p[:n])
+	return n, err
+}
+
+// Close uploads the object and removes the temporary file
+func (u *s3Upload) Close() error {
+	defer os.Remove(u.tmp.Name())
+	defer u.tmp.Close()
+	size, err := u.tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := u.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	resp, err := u.fs.do(http.MethodPut, u.bucket, u.key, nil, header,
+		io.NopCloser(io.LimitReader(u.tmp, size)), hex.EncodeToString(u.sum.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return s3Error("create", u.name, resp)
+	}
+	return nil
+}
+
+// s3FileInfo describes an object
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i s3FileInfo) Name() string       { return i.name }
+func (i s3FileInfo) Size() int64        { return i.size }
+func (i s3FileInfo) Mode() fs.FileMode  { return 0444 }
+func (i s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i s3FileInfo) IsDir() bool        { return false }
+func (i s3FileInfo) Sys() any           { return nil }
@@ -0,0 +1,165 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// FieldType is the declared type of the keys or values of a job's
+// records
+type FieldType string
+
+const (
+	// FieldAny accepts every string, the default
+	FieldAny FieldType = ""
+	// FieldString accepts valid UTF-8 text
+	FieldString FieldType = "string"
+	// FieldInt accepts base 10 integers
+	FieldInt FieldType = "int"
+	// FieldFloat accepts decimal floating-point numbers
+	FieldFloat FieldType = "float"
+	// FieldBool accepts what strconv.ParseBool accepts
+	FieldBool FieldType = "bool"
+	// FieldJSON accepts a single JSON document
+	FieldJSON FieldType = "json"
+)
+
+// Schema declares what the records emitted by a job's map function
+// look like. Workers check the map output against it and count the
+// records violating it, so mapper bugs such as emitting empty keys
+// show up after the map phase instead of in the job's results.
+type Schema struct {
+	Key   FieldType // Type of every key
+	Value FieldType // Type of every value
+
+	// AllowEmptyKeys accepts empty keys, which are violations
+	// otherwise whatever the key type
+	AllowEmptyKeys bool
+
+	// Sample checks one in every Sample records of a task, e.g. for
+	// jobs where checking every record costs too much. Every record
+	// is checked if it is one or less.
+	Sample int
+
+	// MaxViolations is the number of checked records that may
+	// violate the schema before the job fails once its map phase has
+	// finished. Negative only counts violations.
+	MaxViolations int
+}
+
+// declared reports whether the job has a schema at all
+func (s Schema) declared() bool {
+	return s != Schema{}
+}
+
+// validate rejects schemas with unknown types
+func (s Schema) validate() error {
+	for _, t := range []FieldType{s.Key, s.Value} {
+		switch t {
+		case FieldAny, FieldString, FieldInt, FieldFloat, FieldBool, FieldJSON:
+		default:
+			return fmt.Errorf("unknown schema field type %q", t)
+		}
+	}
+	return nil
+}
+
+// Check returns why kv violates the schema, or nil if it does not
+func (s Schema) Check(kv KeyValue) error {
+	if kv.Key == "" && !s.AllowEmptyKeys {
+		return errors.New("empty key")
+	}
+	if err := checkField(s.Key, kv.Key); err != nil {
+		return fmt.Errorf("key %q: %v", kv.Key, err)
+	}
+	if err := checkField(s.Value, kv.Value); err != nil {
+		return fmt.Errorf("value %q of key %q: %v", kv.Value, kv.Key, err)
+	}
+	return nil
+}
+
+// checkField returns why s is not of type t
+func checkField(t FieldType, s string) error {
+	var err error
+	switch t {
+	case FieldString:
+		if !utf8.ValidString(s) {
+			err = errors.New("not valid UTF-8")
+		}
+	case FieldInt:
+		_, err = strconv.ParseInt(s, 10, 64)
+	case FieldFloat:
+		_, err = strconv.ParseFloat(s, 64)
+	case FieldBool:
+		_, err = strconv.ParseBool(s)
+	case FieldJSON:
+		if !json.Valid([]byte(s)) {
+			err = errors.New("not a JSON document")
+		}
+	}
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = fmt.Errorf("not %s: %v", t, numErr.Err)
+	}
+	return err
+}
+
+// schemaChecker checks the output of one map task against the job's
+// schema. A nil checker checks nothing.
+type schemaChecker struct {
+	schema     Schema
+	task       int
+	seen       int64
+	violations int
+}
+
+// newSchemaChecker returns the checker of a map task, nil if the job
+// declares no schema
+func newSchemaChecker(opts JobOptions, task int) *schemaChecker {
+	if !opts.Schema.declared() {
+		return nil
+	}
+	return &schemaChecker{schema: opts.Schema, task: task}
+}
+
+// check counts kv if it is sampled and violates the schema. The first
+// violation of the task is logged as an example.
+func (c *schemaChecker) check(kv KeyValue) {
+	if c == nil {
+		return
+	}
+	c.seen++
+	if c.schema.Sample > 1 && (c.seen-1)%int64(c.schema.Sample) != 0 {
+		return
+	}
+	if err := c.schema.Check(kv); err != nil {
+		if c.violations == 0 {
+			processLog.warnf("doMap: task %d: output violates the job schema: %v", c.task, err)
+		}
+		c.violations++
+	}
+}
+
+// count returns the violations found so far
+func (c *schemaChecker) count() int {
+	if c == nil {
+		return 0
+	}
+	return c.violations
+}
+
+// checkSchema returns the number of records of a map task's output
+// violating the job's schema
+func checkSchema(kva []KeyValue, opts JobOptions, task int) int {
+	c := newSchemaChecker(opts, task)
+	if c == nil {
+		return 0
+	}
+	for _, kv := range kva {
+		c.check(kv)
+	}
+	return c.count()
+}
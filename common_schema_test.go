@@ -0,0 +1,46 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "testing"
+
+// TestSchemaCheck checks records against schemas of every field type
+// and counts the violations of sampled map output.
+func TestSchemaCheck(t *testing.T) {
+	cases := []struct {
+		schema Schema
+		kv     KeyValue
+		ok     bool
+	}{
+		{Schema{}, KeyValue{"", "x"}, false},
+		{Schema{AllowEmptyKeys: true}, KeyValue{"", "x"}, true},
+		{Schema{Key: FieldString}, KeyValue{"a\xff", ""}, false},
+		{Schema{Key: FieldInt}, KeyValue{"-12", "x"}, true},
+		{Schema{Key: FieldInt}, KeyValue{"1.5", "x"}, false},
+		{Schema{Value: FieldFloat}, KeyValue{"k", "1.5e3"}, true},
+		{Schema{Value: FieldFloat}, KeyValue{"k", "one"}, false},
+		{Schema{Value: FieldBool}, KeyValue{"k", "true"}, true},
+		{Schema{Value: FieldBool}, KeyValue{"k", "yes"}, false},
+		{Schema{Value: FieldJSON}, KeyValue{"k", `{"a":[1,2]}`}, true},
+		{Schema{Value: FieldJSON}, KeyValue{"k", `{"a":`}, false},
+	}
+	for _, c := range cases {
+		if err := c.schema.Check(c.kv); (err == nil) != c.ok {
+			t.Errorf("schema %+v, record %q: got error %v, want ok %v", c.schema, c.kv, err, c.ok)
+		}
+	}
+
+	if err := (Schema{Key: "date"}).validate(); err == nil {
+		t.Errorf("schema with an unknown type validated")
+	}
+
+	kva := []KeyValue{{"1", "a"}, {"", "b"}, {"x", "c"}, {"", "d"}}
+	for _, c := range []struct{ sample, want int }{{0, 3}, {1, 3}, {2, 1}} {
+		opts := JobOptions{Schema: Schema{Key: FieldInt, Sample: c.sample}}
+		if got := checkSchema(kva, opts, 0); got != c.want {
+			t.Errorf("sample %d: got %d violations, want %d", c.sample, got, c.want)
+		}
+	}
+	if got := checkSchema(kva, JobOptions{}, 0); got != 0 {
+		t.Errorf("no schema: got %d violations, want 0", got)
+	}
+}
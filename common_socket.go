@@ -0,0 +1,49 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isAbstractSocket reports whether a unix socket address names a Linux
+// abstract socket, e.g. "@mapreduce-master"
+func isAbstractSocket(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+// socketPath returns the path a node with the unix socket address
+// listens on and is dialed at. Paths too long for the kernel, e.g.
+// built from a long directory, job name and PID, are replaced by a
+// short name derived from a hash of the address: every process maps
+// the address to the same name, so nodes still find each other.
+func socketPath(address string) string {
+	if isAbstractSocket(address) || len(address) <= maxSocketPath {
+		return address
+	}
+	sum := sha256.Sum256([]byte(address))
+	name := "mr-" + hex.EncodeToString(sum[:10]) + ".sock"
+	if path := filepath.Join(os.TempDir(), name); len(path) <= maxSocketPath {
+		return path
+	}
+	return filepath.Join("/tmp", name)
+}
+
+// checkSocketAddress reports unix socket addresses no node can listen
+// on: abstract names off Linux or longer than the kernel allows
+func checkSocketAddress(address string) error {
+	if !isAbstractSocket(address) {
+		return nil
+	}
+	if !abstractSockets {
+		return fmt.Errorf("abstract socket %s: abstract sockets are only supported on Linux", address)
+	}
+	if len(address) > maxSocketPath {
+		return fmt.Errorf("abstract socket %s: name is %d bytes long, at most %d are allowed", address, len(address), maxSocketPath)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build linux
+
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// maxSocketPath is the longest unix socket path the kernel accepts,
+// sun_path without its terminating NUL
+const maxSocketPath = 107
+
+// abstractSockets reports whether socket names starting with "@" live
+// in the abstract namespace instead of the file system
+const abstractSockets = true
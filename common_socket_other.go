@@ -0,0 +1,12 @@
+//go:build !linux
+
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// maxSocketPath is the longest unix socket path accepted everywhere
+// else, sun_path of the BSDs and macOS without its terminating NUL
+const maxSocketPath = 103
+
+// abstractSockets reports whether socket names starting with "@" live
+// in the abstract namespace, which only Linux has
+const abstractSockets = false
@@ -0,0 +1,74 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSocketPath checks that long socket paths are shortened the same
+// way every time and that nodes listening on them can be reached
+func TestSocketPath(t *testing.T) {
+	short := "/tmp/824-socket/master.sock"
+	if _, path := ParseAddress(short); path != short {
+		t.Errorf("ParseAddress(%q) = %q, want it unchanged", short, path)
+	}
+	if _, path := ParseAddress("@mapreduce-master"); path != "@mapreduce-master" {
+		t.Errorf("abstract socket name changed to %q", path)
+	}
+
+	long := filepath.Join(t.TempDir(), strings.Repeat("deep/", 20), fmt.Sprintf("job-%d-worker-0.sock", os.Getpid()))
+	_, path := ParseAddress(long)
+	if len(path) > maxSocketPath {
+		t.Fatalf("socket path %q of %d bytes is still too long", path, len(path))
+	}
+	if _, again := ParseAddress(long); again != path {
+		t.Errorf("socket path %q maps to %q and %q", long, path, again)
+	}
+	if _, other := ParseAddress(long + "x"); other == path {
+		t.Errorf("socket paths %q and %q map to the same name", long, long+"x")
+	}
+
+	l, err := listen(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go l.Accept()
+	client, err := DialRPC(long)
+	if err != nil {
+		t.Fatalf("dial %s: %v", long, err)
+	}
+	client.Close()
+}
+
+// TestAbstractSocket listens on a Linux abstract socket, which needs
+// no file
+func TestAbstractSocket(t *testing.T) {
+	addr := fmt.Sprintf("@mapreduce-test-%d", os.Getpid())
+	l, err := listen(addr)
+	if !abstractSockets {
+		if err == nil {
+			l.Close()
+			t.Fatalf("listening on %s succeeded without abstract sockets", addr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go l.Accept()
+	client, err := DialRPC(addr)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	client.Close()
+
+	if err := checkSocketAddress("@" + strings.Repeat("x", maxSocketPath)); err == nil {
+		t.Error("overlong abstract socket name accepted")
+	}
+}
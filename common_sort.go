@@ -0,0 +1,320 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// sortRecordOverhead approximates the memory a buffered record takes
+// besides its key and value
+const sortRecordOverhead = 32
+
+// sortMergeFanIn bounds the sorted runs merged at once, and so the
+// files a reduce task keeps open
+const sortMergeFanIn = 64
+
+// externalSorter groups the records of a reduce task by key with
+// bounded memory. Records are buffered until the buffer holds limit
+// bytes, then sorted and spilled to dir as a sorted run; the runs are
+// merged while the groups are read. Records are sorted and grouped
+// under order, by key if nil; records that compare equal keep the
+// order they were added in.
+type externalSorter struct {
+	dir   string
+	limit int64
+	order *KeyOrder
+	disk  *taskDisk
+
+	buf   []KeyValue
+	bytes int64
+	runs  []string // Spilled runs, in the order their records were added
+	seq   int      // Number of run files created
+	err   error    // First error spilling or merging runs, see fail
+}
+
+// newExternalSorter returns a sorter spilling runs of limit bytes to
+// dir
+func newExternalSorter(dir string, limit int64, order *KeyOrder, disk *taskDisk) *externalSorter {
+	return &externalSorter{dir: dir, limit: limit, order: order, disk: disk}
+}
+
+// add buffers records, spilling the buffer once it is full. Records
+// are dropped once the sorter has failed.
+func (s *externalSorter) add(records []KeyValue) {
+	if s.err != nil {
+		return
+	}
+	for _, kv := range records {
+		s.buf = append(s.buf, kv)
+		s.bytes += int64(len(kv.Key)+len(kv.Value)) + sortRecordOverhead
+		if s.bytes >= s.limit {
+			s.spill()
+		}
+	}
+}
+
+// fail records the first error of the sorter, after which it drops
+// records and yields no further groups
+func (s *externalSorter) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// spill writes the buffer to a new sorted run
+func (s *externalSorter) spill() {
+	sortRecords(s.buf, s.order)
+	name, err := s.writeRun(func(emit func(KeyValue)) error {
+		for _, kv := range s.buf {
+			emit(kv)
+		}
+		return nil
+	})
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	s.runs = append(s.runs, name)
+	s.buf = nil
+	s.bytes = 0
+}
+
+// writeRun creates a run file holding the records produced by records
+func (s *externalSorter) writeRun(records func(emit func(KeyValue)) error) (string, error) {
+	name := filepath.Join(s.dir, "run-"+strconv.Itoa(s.seq))
+	s.seq++
+	file, err := s.disk.create(name)
+	if err != nil {
+		return "", fmt.Errorf("create sorted run: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	enc := newRecordEncoder(w, recordCodecBinary)
+	var encErr error
+	err = records(func(kv KeyValue) {
+		if encErr == nil {
+			encErr = enc.Encode(&kv)
+		}
+	})
+	if err == nil {
+		err = encErr
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("write sorted run %s: %w", name, err)
+	}
+	return name, nil
+}
+
+// groups passes each group key with an iterator over its values to
+// emit, in key order. Without any spilled run, the buffer is grouped in memory;
+// otherwise values are read from the runs as emit iterates over them.
+// emit must be done with the iterator when it returns; values it did
+// not read are skipped. The error of a failed sorter is returned,
+// possibly after some groups were emitted.
+func (s *externalSorter) groups(emit func(key string, values Iterator)) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.runs) == 0 {
+		reduceSorted(s.buf, s.order, func(key string, values []string) {
+			emit(key, &sliceIterator{values})
+		})
+		return nil
+	}
+	if len(s.buf) > 0 {
+		s.spill()
+	}
+
+	// Merge the oldest runs first and put their result in front, so
+	// ties between runs still resolve in the order records were added
+	for len(s.runs) > sortMergeFanIn && s.err == nil {
+		merged, err := s.writeRun(func(emit func(KeyValue)) error {
+			m, err := s.openRuns(s.runs[:sortMergeFanIn])
+			if err != nil {
+				return err
+			}
+			defer m.close()
+			for kv, ok := m.peek(); ok; kv, ok = m.peek() {
+				emit(*kv)
+				m.advance()
+			}
+			return m.err
+		})
+		if err != nil {
+			s.fail(err)
+			break
+		}
+		s.runs = append([]string{merged}, s.runs[sortMergeFanIn:]...)
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	m, err := s.openRuns(s.runs)
+	if err != nil {
+		s.fail(err)
+		return err
+	}
+	defer m.close()
+	for kv, ok := m.peek(); ok; kv, ok = m.peek() {
+		it := &groupIterator{merge: m, group: s.order.group(kv.Key)}
+		emit(it.group, it)
+		// Skip the values emit did not read
+		for _, more := it.Next(); more; _, more = it.Next() {
+		}
+	}
+	s.runs = nil
+	if m.err != nil {
+		s.fail(m.err)
+	}
+	return s.err
+}
+
+// runMerge reads the records of sorted runs in key order
+type runMerge struct {
+	names   []string
+	files   []io.Closer
+	cursors runCursors
+	err     error // First error reading a run, which ends the merge
+}
+
+// openRuns starts merging the sorted runs in names
+func (s *externalSorter) openRuns(names []string) (*runMerge, error) {
+	m := &runMerge{names: names, cursors: runCursors{order: s.order}}
+	for i, name := range names {
+		file, err := s.disk.open(name)
+		if err != nil {
+			m.close()
+			return nil, fmt.Errorf("open sorted run: %w", err)
+		}
+		m.files = append(m.files, file)
+		c := &runCursor{run: i, dec: newRecordDecoder(file, recordCodecBinary)}
+		more, err := c.next(name)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		if more {
+			m.cursors.list = append(m.cursors.list, c)
+		}
+	}
+	heap.Init(&m.cursors)
+	return m, nil
+}
+
+// peek returns the next record without consuming it, or false once
+// all runs are exhausted or one of them cannot be read
+func (m *runMerge) peek() (*KeyValue, bool) {
+	if len(m.cursors.list) == 0 || m.err != nil {
+		return nil, false
+	}
+	return &m.cursors.list[0].kv, true
+}
+
+// advance consumes the record returned by peek
+func (m *runMerge) advance() {
+	c := m.cursors.list[0]
+	more, err := c.next(m.names[c.run])
+	switch {
+	case err != nil:
+		m.err = err
+	case more:
+		heap.Fix(&m.cursors, 0)
+	default:
+		heap.Pop(&m.cursors)
+	}
+}
+
+// close closes and removes the runs
+func (m *runMerge) close() {
+	for i, file := range m.files {
+		file.Close()
+		os.Remove(m.names[i])
+	}
+}
+
+// groupIterator streams the values of one group from a merge
+type groupIterator struct {
+	merge *runMerge
+	group string
+	done  bool
+}
+
+func (it *groupIterator) Next() (string, bool) {
+	if it.done {
+		return "", false
+	}
+	kv, ok := it.merge.peek()
+	if !ok || it.merge.cursors.order.group(kv.Key) != it.group {
+		it.done = true
+		return "", false
+	}
+	value := kv.Value
+	it.merge.advance()
+	return value, true
+}
+
+// runCursor is the next record of a sorted run being merged
+type runCursor struct {
+	run int // Position of the run in the merge
+	kv  KeyValue
+	dec recordDecoder
+}
+
+// next reads the following record of the run, reporting whether there
+// is one
+func (c *runCursor) next(name string) (bool, error) {
+	err := c.dec.Decode(&c.kv)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read sorted run %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// runCursors is a heap of cursors ordered by key under order, then by
+// run
+type runCursors struct {
+	order *KeyOrder
+	list  []*runCursor
+}
+
+func (h *runCursors) Len() int { return len(h.list) }
+
+func (h *runCursors) Less(i, j int) bool {
+	if c := h.order.compare(h.list[i].kv.Key, h.list[j].kv.Key); c != 0 {
+		return c < 0
+	}
+	return h.list[i].run < h.list[j].run
+}
+
+func (h *runCursors) Swap(i, j int) { h.list[i], h.list[j] = h.list[j], h.list[i] }
+
+func (h *runCursors) Push(x any) { h.list = append(h.list, x.(*runCursor)) }
+
+func (h *runCursors) Pop() any {
+	c := h.list[len(h.list)-1]
+	h.list = h.list[:len(h.list)-1]
+	return c
+}
+
+// sortRecords sorts records by key under order, keeping the order of
+// records that compare equal
+func sortRecords(records []KeyValue, order *KeyOrder) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return order.compare(records[i].Key, records[j].Key) < 0
+	})
+}
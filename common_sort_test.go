@@ -0,0 +1,103 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestExternalSort groups records through enough sorted runs to need
+// several merge passes and checks that keys come out in order with
+// their values in the order they were added, as in an in-memory sort.
+func TestExternalSort(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	records := make([]KeyValue, 2000)
+	for i := range records {
+		records[i] = KeyValue{fmt.Sprintf("k%03d", rng.IntN(200)), fmt.Sprint(i)}
+	}
+
+	type group struct {
+		Key    string
+		Values []string
+	}
+	var want []group
+	reduceSorted(append([]KeyValue{}, records...), nil, func(key string, values []string) {
+		want = append(want, group{key, values})
+	})
+
+	for _, limit := range []int64{1 << 20, 2000, 1} {
+		dir := t.TempDir()
+		s := newExternalSorter(dir, limit, nil, nil)
+		for start := 0; start < len(records); start += 256 {
+			s.add(records[start:min(start+256, len(records))])
+		}
+		var got []group
+		s.groups(func(key string, values Iterator) {
+			got = append(got, group{key, collectValues(values)})
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("buffer of %d bytes: groups differ from an in-memory sort", limit)
+		}
+		if left, _ := os.ReadDir(dir); len(left) > 0 {
+			t.Errorf("buffer of %d bytes: %d sorted runs left behind", limit, len(left))
+		}
+	}
+
+	// Values a reduce function leaves unread are skipped
+	s := newExternalSorter(t.TempDir(), 2000, nil, nil)
+	s.add(records)
+	i := 0
+	s.groups(func(key string, values Iterator) {
+		first, _ := values.Next()
+		if key != want[i].Key || first != want[i].Values[0] {
+			t.Errorf("group %d: got key %s and first value %s, want %s and %s", i, key, first, want[i].Key, want[i].Values[0])
+		}
+		i++
+	})
+	if i != len(want) {
+		t.Errorf("got %d groups reading one value each, want %d", i, len(want))
+	}
+}
+
+// TestSecondarySort groups composite keys by their group part and
+// checks that values come out ordered by their sort part, whether the
+// records are sorted in memory or through spilled runs.
+func TestSecondarySort(t *testing.T) {
+	order, err := JobOptions{KeyOrder: KeyOrderComposite}.keyOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewPCG(3, 4))
+	var records []KeyValue
+	for _, i := range rng.Perm(1000) {
+		ts := fmt.Sprintf("%04d", i)
+		records = append(records, KeyValue{CompositeKey(fmt.Sprintf("user%d", i%7), ts), ts})
+	}
+
+	for _, limit := range []int64{1 << 20, 1000} {
+		s := newExternalSorter(t.TempDir(), limit, order, nil)
+		s.add(records)
+		var keys []string
+		s.groups(func(key string, values Iterator) {
+			keys = append(keys, key)
+			vals := collectValues(values)
+			if len(vals) != 1000/7 && len(vals) != 1000/7+1 {
+				t.Errorf("buffer of %d bytes: group %s has %d values", limit, key, len(vals))
+			}
+			if !sort.StringsAreSorted(vals) {
+				t.Errorf("buffer of %d bytes: values of group %s are not sorted", limit, key)
+			}
+		})
+		if len(keys) != 7 || !sort.StringsAreSorted(keys) {
+			t.Errorf("buffer of %d bytes: got groups %v, want user0 to user6 in order", limit, keys)
+		}
+	}
+
+	if _, err := (JobOptions{KeyOrder: "missing"}).keyOrder(); err == nil {
+		t.Errorf("unknown key order resolved")
+	}
+}
@@ -0,0 +1,93 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// BuiltinSortByValue is the built-in job of Master.SortByValue: its
+// map function reads result records and emits them under their value
+// sort key, its reduce function passes every record through
+const BuiltinSortByValue = "sort-by-value"
+
+// KeyOrderByValue is the key order of BuiltinSortByValue: keys made by
+// valueSortKey, every one a group of its own, written out under their
+// original key
+const KeyOrderByValue = "by-value"
+
+// SortSkippedCounter counts the records BuiltinSortByValue skipped
+// because they were not result records
+const SortSkippedCounter = "sort-by-value-skipped"
+
+// builtinJob holds the map and reduce functions of a built-in job
+type builtinJob struct {
+	mapF    ContextMapFunc
+	reduceF ContextReduceFunc
+}
+
+// builtinJobs holds the built-in jobs by name
+var builtinJobs = map[string]builtinJob{
+	BuiltinSortByValue: {mapF: sortByValueMap, reduceF: sortByValueReduce},
+}
+
+func init() {
+	RegisterKeyOrder(KeyOrderByValue, KeyOrder{
+		Group: func(key string) string { return key },
+		Output: func(group string) string {
+			_, key := SplitCompositeKey(group)
+			return key
+		},
+	})
+}
+
+// builtin returns the job's built-in job, nil for jobs running the
+// workers' own functions
+func (o JobOptions) builtin() (*builtinJob, error) {
+	if o.Builtin == "" {
+		return nil, nil
+	}
+	job, ok := builtinJobs[o.Builtin]
+	if !ok {
+		return nil, fmt.Errorf("unknown built-in job %q", o.Builtin)
+	}
+	return &job, nil
+}
+
+// sortByValueMap emits a result record under its value sort key, the
+// record's key following as the sort part so that ties come out in key
+// order
+func sortByValueMap(ctx *TaskContext, _ string, line string) []KeyValue {
+	kv, err := ParseResultRecord(line)
+	if err != nil {
+		ctx.Count(SortSkippedCounter, 1)
+		return nil
+	}
+	return []KeyValue{{Key: CompositeKey(valueSortKey(kv.Value), kv.Key), Value: kv.Value}}
+}
+
+// sortByValueReduce passes the value of a record through; keys are
+// unique, so groups hold a single value unless the input repeats a key
+func sortByValueReduce(_ *TaskContext, _ string, values []string) string {
+	return values[len(values)-1]
+}
+
+// valueSortKey returns a key that sorts numeric values as strings in
+// descending order, values that are no numbers, NaN included, last
+func valueSortKey(value string) string {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		return "~"
+	}
+	// Flip the sign bit of positive numbers and every bit of negative
+	// ones so that the bits sort like the numbers, then invert them to
+	// sort descending
+	bits := math.Float64bits(f)
+	if bits>>63 == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return fmt.Sprintf("%016x", ^bits)
+}
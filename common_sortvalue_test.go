@@ -0,0 +1,28 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"sort"
+	"testing"
+)
+
+// TestValueSortKey sorts values by their sort keys and checks that
+// numbers come out in descending order, whatever their sign, and that
+// NaN and values that are no numbers come last.
+func TestValueSortKey(t *testing.T) {
+	values := []string{"-1", "NaN", "0", "+Inf", "abc", "2.5", "-Inf", "-0.5", "100", "", "-100"}
+	sort.SliceStable(values, func(i, j int) bool {
+		return valueSortKey(values[i]) < valueSortKey(values[j])
+	})
+	want := []string{"+Inf", "100", "2.5", "0", "-0.5", "-1", "-100", "-Inf"}
+	for i, v := range want {
+		if values[i] != v {
+			t.Fatalf("values sort as %q, want numbers in the order %q", values, want)
+		}
+	}
+	for _, v := range values[len(want):] {
+		if valueSortKey(v) != valueSortKey("abc") {
+			t.Errorf("%q does not sort with the values that are no numbers", v)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "time"
+
+// TaskState describes where a task is in its lifecycle.
+type TaskState string
+
+const (
+	TaskIdle      TaskState = "idle"      // Waiting for a worker, also after a failed attempt
+	TaskRunning   TaskState = "running"   // Assigned to a worker
+	TaskCompleted TaskState = "completed" // Finished successfully
+)
+
+// PhaseState is the externally visible state of one phase of a job.
+type PhaseState struct {
+	Phase     JobParse    `json:"phase"`
+	Tasks     []TaskState `json:"tasks"`     // State of every task, by task number
+	Completed int         `json:"completed"` // Tasks in TaskCompleted
+	Failures  int         `json:"failures"`  // Attempts that failed or were refused, each retried
+	Started   time.Time   `json:"started"`   // When the first task was handed out
+	Ended     time.Time   `json:"ended"`     // When the last task finished, zero while the phase runs
+
+	// Tasks whose output differed between two runs, see WithDeterminismCheck
+	Nondeterministic []int `json:"nondeterministic,omitempty"`
+
+	// Output records of the phase's tasks violating the job's schema, see WithSchema
+	SchemaViolations int `json:"schema_violations,omitempty"`
+
+	// Resources used by the completed tasks of the phase, see TaskMetrics
+	WallTime  time.Duration `json:"wall_time_ns,omitempty"`
+	UserCPU   time.Duration `json:"user_cpu_ns,omitempty"`
+	SystemCPU time.Duration `json:"system_cpu_ns,omitempty"`
+}
+
+// CPUShare returns the CPU time the phase's tasks used per second of
+// their wall time. Phases close to one per task slot are CPU-bound;
+// phases well below it spend their time waiting for I/O.
+func (p PhaseState) CPUShare() float64 {
+	if p.WallTime <= 0 {
+		return 0
+	}
+	return float64(p.UserCPU+p.SystemCPU) / float64(p.WallTime)
+}
+
+// clone returns a copy of the phase that shares no memory with it
+func (p PhaseState) clone() PhaseState {
+	p.Tasks = append([]TaskState(nil), p.Tasks...)
+	p.Nondeterministic = append([]int(nil), p.Nondeterministic...)
+	return p
+}
+
+// WorkerInfo is the externally visible state of a registered worker.
+type WorkerInfo struct {
+	Address    string            `json:"address"`               // Address the master calls back
+	Host       string            `json:"host,omitempty"`        // Host name of the worker's machine
+	Labels     map[string]string `json:"labels,omitempty"`      // Labels reported at registration
+	LocalPaths []string          `json:"local_paths,omitempty"` // Directories on storage local to the worker
+	Slots      int               `json:"slots"`                 // Tasks the worker runs at once
+	Reserved   int               `json:"reserved,omitempty"`    // Slots held by reservations, see ReserveSlots
+	LastSeen   time.Time         `json:"last_seen"`             // Last heartbeat, zero without heartbeats
+}
@@ -0,0 +1,148 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultRPCTimeout bounds RPCs without a configured timeout
+	DefaultRPCTimeout = 10 * time.Second
+
+	// NoTimeout lets an RPC run for as long as it takes
+	NoTimeout time.Duration = -1
+
+	// defaultPingInterval is how often workers running a task without
+	// timeout are probed
+	defaultPingInterval = 5 * time.Second
+)
+
+// RPCTimeouts bounds how long nodes wait for each kind of RPC. Zero
+// fields use DefaultRPCTimeout and NoTimeout waits indefinitely.
+//
+// Map tasks on big files can legitimately run for much longer than any
+// fixed timeout. With DoTask set to NoTimeout the master instead pings
+// the worker every PingInterval while the task runs, and gives up on
+// the task as soon as a ping fails.
+type RPCTimeouts struct {
+	Register     time.Duration // Worker registration with the master
+	DoTask       time.Duration // A whole map or reduce task
+	Shutdown     time.Duration // Shutting down workers and the master
+	Other        time.Duration // Cache flushes, pings and other short RPCs
+	PingInterval time.Duration // Probe interval for tasks without timeout, 5s if zero
+}
+
+// forMethod returns the timeout of an RPC method
+func (t RPCTimeouts) forMethod(method string) time.Duration {
+	var timeout time.Duration
+	switch method {
+	case RegisterMethod:
+		timeout = t.Register
+	case DoTaskMethod:
+		timeout = t.DoTask
+	case ShutdownMethod, "Master.Shutdown":
+		timeout = t.Shutdown
+	default:
+		timeout = t.Other
+	}
+	if timeout == 0 {
+		return DefaultRPCTimeout
+	}
+	return timeout
+}
+
+// pingInterval returns how often workers running a task are probed
+func (t RPCTimeouts) pingInterval() time.Duration {
+	if t.PingInterval <= 0 {
+		return defaultPingInterval
+	}
+	return t.PingInterval
+}
+
+// callTimeout behaves like call but waits at most timeout for the
+// reply, or indefinitely when timeout is negative. The latency of the
+// call is recorded in the RPC metrics.
+func callTimeout(srv string, rpcName string, args interface{}, reply interface{}, timeout time.Duration) bool {
+	if err := validateRPCArgs(srv, rpcName, args); err != nil {
+		return false
+	}
+
+	ctx := context.Background()
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return timedCall(ctx, srv, rpcName, args, reply)
+}
+
+// timedCall performs an RPC and records its latency and outcome
+func timedCall(ctx context.Context, srv string, rpcName string, args interface{}, reply interface{}) bool {
+	start := time.Now()
+	err := transportFor(srv).Call(ctx, srv, rpcName, args, reply)
+	observeRPC(rpcName, srv, time.Since(start), err == nil)
+	return err == nil
+}
+
+// callTask assigns a task to a worker with the DoTask timeout of t.
+// Without a timeout the worker is pinged while the task runs and the
+// call is abandoned once the worker stops answering. The call is also
+// abandoned when lost is closed, i.e. the worker missed its heartbeats,
+// or when job is cancelled.
+func callTask(job context.Context, srv string, args *DoTaskArgs, reply *DoTaskReply, t RPCTimeouts, lost <-chan struct{}) (ok bool) {
+	job, span := startSpan(job, SpanClient, DoTaskMethod, "worker", srv)
+	defer func() {
+		if !ok {
+			span.end(fmt.Errorf("%s task %d failed on %s", args.Phase, args.TaskNumber, srv))
+			return
+		}
+		span.end(nil)
+	}()
+	args.TraceParent = traceParent(job)
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	timeout := t.forMethod(DoTaskMethod)
+	if timeout >= 0 {
+		ctx, cancel = context.WithTimeout(job, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(job)
+	}
+	defer cancel()
+
+	if lost != nil {
+		go func() {
+			select {
+			case <-lost:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if timeout < 0 {
+		go pingDuringTask(ctx, cancel, srv, args, t)
+	}
+	return timedCall(ctx, srv, DoTaskMethod, args, reply)
+}
+
+// pingDuringTask pings srv until ctx is done and cancels the task
+// once a ping fails
+func pingDuringTask(ctx context.Context, cancel context.CancelFunc, srv string, args *DoTaskArgs, t RPCTimeouts) {
+	ticker := time.NewTicker(t.pingInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !callTimeout(srv, PingMethod, &PingArgs{Token: args.Token}, new(struct{}), t.forMethod(PingMethod)) {
+			processLog.warnf("Master: worker %s stopped answering pings during %s task %d",
+				srv, args.Phase, args.TaskNumber)
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// TLSConfig holds the certificates a node uses to secure its RPCs.
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"`   // PEM certificate presented to peers
+	KeyFile    string `json:"key_file"`    // PEM private key of the certificate
+	CAFile     string `json:"ca_file"`     // PEM bundle of CAs trusted to sign peer certificates
+	Mutual     bool   `json:"mutual"`      // Require client certificates signed by the CA (mTLS)
+	ServerName string `json:"server_name"` // Name expected in server certificates, the dialed host by default
+}
+
+// rpcTLS holds the TLS settings of this process, nil while RPCs are
+// not secured
+var rpcTLS struct {
+	sync.RWMutex
+	server *tls.Config
+	client *tls.Config
+}
+
+// UseTLS secures every RPC this process serves or makes, over all
+// transports. Certificates describe a node rather than a connection,
+// so the setting is process-wide and applies to masters, workers and
+// control-plane clients alike. With Mutual set, servers only accept
+// peers presenting a certificate signed by the CA, so untrusted
+// workers cannot register. Passing nil turns TLS off again.
+func UseTLS(cfg *TLSConfig) error {
+	var server, client *tls.Config
+	if cfg != nil {
+		var err error
+		if server, client, err = cfg.build(); err != nil {
+			return err
+		}
+	}
+
+	rpcTLS.Lock()
+	defer rpcTLS.Unlock()
+	rpcTLS.server, rpcTLS.client = server, client
+	return nil
+}
+
+// tlsConfigs returns the server and client settings in use, nil
+// without TLS
+func tlsConfigs() (server *tls.Config, client *tls.Config) {
+	rpcTLS.RLock()
+	defer rpcTLS.RUnlock()
+	return rpcTLS.server, rpcTLS.client
+}
+
+// build loads the certificates and derives the server and client
+// sides of the configuration
+func (c *TLSConfig) build() (*tls.Config, *tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, nil, fmt.Errorf("TLS requires a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+	}
+	if c.Mutual && pool == nil {
+		return nil, nil, fmt.Errorf("mutual TLS requires a CA file")
+	}
+
+	server := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.Mutual {
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+		server.ClientCAs = pool
+	}
+
+	client := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   c.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return server, client, nil
+}
+
+// clientTLS returns the client settings for dialing address, filling
+// in the server name from the host when none is configured. Unix
+// socket paths have no host, so "localhost" is expected there.
+func clientTLS(client *tls.Config, network string, address string) *tls.Config {
+	cfg := client.Clone()
+	if cfg.ServerName != "" {
+		return cfg
+	}
+	cfg.ServerName = "localhost"
+	if network == "tcp" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			cfg.ServerName = host
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,180 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of a test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of cert
+}
+
+// newTestCA creates a self-signed CA and writes its certificate to dir
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue signs a certificate for localhost, usable by servers and
+// clients, and returns the files of the certificate and its key
+func (ca *testCA) issue(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file string, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves a worker's RPCs at a socket secured with cfg and
+// returns its address. TLS is turned off again when the test ends.
+func serveTLS(t *testing.T, cfg *TLSConfig) string {
+	t.Helper()
+	t.Cleanup(func() { UseTLS(nil) })
+	if err := UseTLS(cfg); err != nil {
+		t.Fatal(err)
+	}
+	addr := filepath.Join(t.TempDir(), "worker.sock")
+	l, err := listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := (netRPCTransport{}).Serve(l, &Worker{done: make(chan struct{})}); err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// pingTLS pings the worker at addr with the client side of cfg
+func pingTLS(t *testing.T, addr string, cfg *TLSConfig) error {
+	t.Helper()
+	if err := UseTLS(cfg); err != nil {
+		t.Fatal(err)
+	}
+	c, err := DialRPC(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Call(PingMethod, &PingArgs{}, new(struct{}))
+}
+
+// pingRawTLS pings the worker at addr with a client configured by cfg
+// instead of UseTLS
+func pingRawTLS(addr string, cfg *tls.Config) error {
+	network, address := ParseAddress(addr)
+	conn, err := tls.Dial(network, address, cfg)
+	if err != nil {
+		return err
+	}
+	c := rpc.NewClient(conn)
+	defer c.Close()
+	return c.Call(PingMethod, &PingArgs{}, new(struct{}))
+}
+
+// TestTLSHandshake pings a worker serving mutual TLS with certificates
+// of its CA, of another CA and without a client certificate. Only the
+// first is answered.
+func TestTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+	strangerCert, strangerKey := other.issue(t, dir, "stranger")
+
+	addr := serveTLS(t, &TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file, Mutual: true})
+
+	if err := pingTLS(t, addr, &TLSConfig{CertFile: clientCert, KeyFile: clientKey, CAFile: ca.file}); err != nil {
+		t.Errorf("ping with a certificate of the CA failed: %v", err)
+	}
+	if err := pingTLS(t, addr, &TLSConfig{CertFile: clientCert, KeyFile: clientKey, CAFile: other.file}); err == nil {
+		t.Error("a server certificate of an untrusted CA was accepted")
+	}
+
+	// Clients only offer certificates of the CAs the server asks for,
+	// so the stranger's is presented regardless to reach the server
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	stranger, err := tls.LoadX509KeyPair(strangerCert, strangerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &tls.Config{RootCAs: pool, ServerName: "localhost", MinVersion: tls.VersionTLS12}
+	if err := pingRawTLS(addr, client); err == nil {
+		t.Error("ping without a client certificate was answered")
+	}
+	client.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &stranger, nil
+	}
+	if err := pingRawTLS(addr, client); err == nil {
+		t.Error("ping with a certificate of another CA was answered")
+	}
+}
@@ -0,0 +1,184 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation of a traced job: the job itself, one of
+// its phases, a task, an RPC or the execution of a task on a worker.
+// Spans of one job share a trace ID and point to their parent, so a
+// tracing backend shows a whole run as one tree, see UseTracing.
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte // Zero for the root span of a trace
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string // Why the operation failed, empty if it succeeded
+}
+
+// SpanKind tells a tracing backend which side of an RPC a span
+// describes. Values follow OpenTelemetry.
+type SpanKind int
+
+const (
+	SpanInternal SpanKind = 1 // Operation within a process
+	SpanServer   SpanKind = 2 // Handling of an RPC, e.g. a worker running a task
+	SpanClient   SpanKind = 3 // RPC made to another process
+)
+
+// SpanExporter receives the spans a master or worker finishes, see
+// UseTracing. Exporters must be safe for concurrent use and should not
+// block, e.g. by batching spans and sending them in the background.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// tracing holds the exporter of this process
+var tracing = struct {
+	sync.RWMutex
+	exporter SpanExporter
+}{}
+
+// UseTracing sends a span for every job, phase, task attempt and task
+// RPC this process runs to exporter, e.g. an OTLPExporter. Masters pass
+// the trace context to workers with every task, so the spans of both
+// end up in one trace. Like UseMetrics the setting is process-wide.
+// Passing nil, the default, disables tracing.
+func UseTracing(exporter SpanExporter) {
+	tracing.Lock()
+	defer tracing.Unlock()
+	tracing.exporter = exporter
+}
+
+// spanExporter returns the exporter in use, nil without tracing
+func spanExporter() SpanExporter {
+	tracing.RLock()
+	defer tracing.RUnlock()
+	return tracing.exporter
+}
+
+// spanKey is the context key of the span an operation belongs to
+type spanKey struct{}
+
+// remoteSpanKey is the context key of a span of another process, the
+// parent of spans started from the context
+type remoteSpanKey struct{}
+
+// spanParent identifies a span that is the parent of new spans
+type spanParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// traceSpan is a span being recorded. Its methods do nothing on nil
+// spans, which startSpan returns without tracing.
+type traceSpan struct {
+	span     Span
+	exporter SpanExporter
+}
+
+// startSpan starts a span of the given kind and name as a child of the
+// span of ctx, or as the root of a new trace, and returns a context
+// carrying it. Without tracing ctx is returned with a nil span.
+func startSpan(ctx context.Context, kind SpanKind, name string, attrs ...string) (context.Context, *traceSpan) {
+	exporter := spanExporter()
+	if exporter == nil {
+		return ctx, nil
+	}
+	s := &traceSpan{exporter: exporter, span: Span{Name: name, Kind: kind, Start: time.Now()}}
+	if parent, ok := parentSpan(ctx); ok {
+		s.span.TraceID = parent.traceID
+		s.span.ParentID = parent.spanID
+	} else {
+		rand.Read(s.span.TraceID[:])
+	}
+	rand.Read(s.span.SpanID[:])
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.setAttribute(attrs[i], attrs[i+1])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// parentSpan returns the span new spans of ctx are children of
+func parentSpan(ctx context.Context) (spanParent, bool) {
+	if s, ok := ctx.Value(spanKey{}).(*traceSpan); ok {
+		return spanParent{traceID: s.span.TraceID, spanID: s.span.SpanID}, true
+	}
+	parent, ok := ctx.Value(remoteSpanKey{}).(spanParent)
+	return parent, ok
+}
+
+// setAttribute records a property of the operation, e.g. its task
+// number
+func (s *traceSpan) setAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	if s.span.Attributes == nil {
+		s.span.Attributes = make(map[string]string)
+	}
+	s.span.Attributes[key] = value
+}
+
+// end finishes the span, failed if err is not nil, and exports it
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+	s.exporter.ExportSpan(&s.span)
+}
+
+// traceParent returns the span of ctx as a W3C traceparent header, the
+// form it travels in with a task, empty without a span
+func traceParent(ctx context.Context) string {
+	parent, ok := parentSpan(ctx)
+	if !ok {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(parent.traceID[:]) + "-" + hex.EncodeToString(parent.spanID[:]) + "-01"
+}
+
+// withTraceParent returns a context whose new spans are children of
+// the span of another process given as a W3C traceparent header.
+// Malformed headers are ignored.
+func withTraceParent(ctx context.Context, header string) context.Context {
+	parent, err := parseTraceParent(header)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteSpanKey{}, parent)
+}
+
+// parseTraceParent decodes a W3C traceparent header
+func parseTraceParent(header string) (spanParent, error) {
+	var parent spanParent
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return parent, fmt.Errorf("malformed traceparent %q", header)
+	}
+	if _, err := hex.Decode(parent.traceID[:], []byte(parts[1])); err != nil {
+		return parent, fmt.Errorf("malformed traceparent %q: %v", header, err)
+	}
+	if _, err := hex.Decode(parent.spanID[:], []byte(parts[2])); err != nil {
+		return parent, fmt.Errorf("malformed traceparent %q: %v", header, err)
+	}
+	if parent.traceID == ([16]byte{}) || parent.spanID == ([8]byte{}) {
+		return parent, fmt.Errorf("traceparent %q has a zero ID", header)
+	}
+	return parent, nil
+}
@@ -0,0 +1,70 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestOTLPExport traces an RPC whose server side continues the trace
+// from its traceparent, and checks the spans the collector receives.
+func TestOTLPExport(t *testing.T) {
+	var mu sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var traces otlpTraces
+		if req.URL.Path != "/v1/traces" || json.NewDecoder(req.Body).Decode(&traces) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range traces.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(&OTLPConfig{Endpoint: collector.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseTracing(exporter)
+	defer UseTracing(nil)
+
+	ctx, client := startSpan(context.Background(), SpanClient, DoTaskMethod, "worker", "w1")
+	header := traceParent(ctx)
+	_, server := startSpan(withTraceParent(context.Background(), header), SpanServer, "execute Map task")
+	server.end(errors.New("boom"))
+	client.end(nil)
+	exporter.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+	got, parent := spans[0], spans[1]
+	if got.TraceID != parent.TraceID || got.ParentSpanID != parent.SpanID || parent.ParentSpanID != "" {
+		t.Errorf("server span %+v is not a child of client span %+v", got, parent)
+	}
+	if got.Status == nil || got.Status.Message != "boom" || parent.Status != nil {
+		t.Errorf("statuses %+v and %+v, want an error on the server span only", got.Status, parent.Status)
+	}
+	if len(parent.Attributes) != 1 || parent.Attributes[0].Key != "worker" || parent.Attributes[0].Value.StringValue != "w1" {
+		t.Errorf("client span attributes %+v, want worker=w1", parent.Attributes)
+	}
+
+	for _, bad := range []string{"", "00-abc-def-01", "00-" + got.TraceID + "-0000000000000000-01"} {
+		if _, err := parseTraceParent(bad); err == nil {
+			t.Errorf("parsed malformed traceparent %q", bad)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Address schemes. Addresses without a scheme are unix domain socket
+// paths served with net/rpc.
+const (
+	// tcpScheme prefixes net/rpc addresses served over TCP,
+	// e.g. "tcp://10.0.0.1:7777"
+	tcpScheme = "tcp://"
+	// grpcScheme prefixes gRPC addresses served over TCP,
+	// e.g. "grpc://10.0.0.1:7777"
+	grpcScheme = "grpc://"
+	// jsonrpcScheme prefixes addresses served with JSON-RPC over TCP,
+	// e.g. "jsonrpc://10.0.0.1:7777", see the worker protocol in the
+	// Readme
+	jsonrpcScheme = "jsonrpc://"
+	// httpScheme prefixes net/rpc addresses of masters mounted on the
+	// HTTP server of an embedding service, e.g.
+	// "http://10.0.0.1:8080/mapreduce", see Master.Mount; httpsScheme
+	// addresses are dialed over TLS
+	httpScheme  = "http://"
+	httpsScheme = "https://"
+)
+
+// Transport carries the RPCs between masters and workers. The
+// transport of a node is chosen by the scheme of its address: net/rpc
+// by default, gRPC with protobuf messages for "grpc://" addresses and
+// JSON-RPC for "jsonrpc://" addresses.
+type Transport interface {
+	// Serve registers rcvr, a *Master or *Worker, and answers its RPCs
+	// on l in the background until l is closed
+	Serve(l net.Listener, rcvr interface{}) error
+	// Call invokes method, e.g. RegisterMethod, on the node at addr,
+	// giving up once ctx is done
+	Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error
+}
+
+// transportFor returns the transport serving addr
+func transportFor(addr string) Transport {
+	if strings.HasPrefix(addr, grpcScheme) {
+		return grpcTransport{}
+	}
+	if strings.HasPrefix(addr, jsonrpcScheme) {
+		return jsonRPCTransport{}
+	}
+	return netRPCTransport{}
+}
+
+// netRPCTransport is the default transport based on net/rpc and gob
+type netRPCTransport struct{}
+
+// Serve answers net/rpc requests, one goroutine per connection
+func (netRPCTransport) Serve(l net.Listener, rcvr interface{}) error {
+	return serveRPC(l, rcvr, func(server *rpc.Server, conn net.Conn) {
+		server.ServeConn(conn)
+	})
+}
+
+// serveRPC registers rcvr with a net/rpc server and hands every
+// connection accepted on l to serveConn in a goroutine of its own
+func serveRPC(l net.Listener, rcvr interface{}, serveConn func(server *rpc.Server, conn net.Conn)) error {
+	server := rpc.NewServer()
+	if err := server.Register(rcvr); err != nil {
+		return fmt.Errorf("failed to register RPC receiver: %v", err)
+	}
+	if cfg, _ := tlsConfigs(); cfg != nil {
+		l = tls.NewListener(l, cfg)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					processLog.warnf("RPC server accept error: %v", err)
+				}
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serveConn(server, conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+// Call dials addr and performs a single net/rpc call
+func (netRPCTransport) Call(ctx context.Context, addr string, method string, args interface{}, reply interface{}) error {
+	c, err := DialRPC(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return callRPC(ctx, c, method, args, reply)
+}
+
+// callRPC performs a call on c, giving up once ctx is done
+func callRPC(ctx context.Context, c *rpc.Client, method string, args interface{}, reply interface{}) error {
+	// Run the call in the background; closing the client on timeout
+	// makes it return
+	call := c.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DialRPC connects a net/rpc client to the node at addr, over TLS
+// when the process is configured with UseTLS
+func DialRPC(addr string) (*rpc.Client, error) {
+	if isHTTPAddress(addr) {
+		return dialHTTPRPC(addr)
+	}
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// dial connects to the node at addr, over TLS when the process is
+// configured with UseTLS
+func dial(addr string) (net.Conn, error) {
+	network, address := ParseAddress(addr)
+	_, cfg := tlsConfigs()
+	if cfg == nil {
+		return net.Dial(network, address)
+	}
+	return tls.Dial(network, address, clientTLS(cfg, network, address))
+}
+
+// ParseAddress splits a master or worker address into the network
+// and address arguments of net.Dial and net.Listen.
+//
+//	"/tmp/824-socket/master.sock" -> "unix", "/tmp/824-socket/master.sock"
+//	"tcp://0.0.0.0:7777"          -> "tcp", "0.0.0.0:7777"
+//	"grpc://0.0.0.0:7777"         -> "tcp", "0.0.0.0:7777"
+//	"jsonrpc://0.0.0.0:7777"      -> "tcp", "0.0.0.0:7777"
+//	"http://10.0.0.1:8080/mr"     -> "tcp", "10.0.0.1:8080"
+//	"@mapreduce-master"           -> "unix", "@mapreduce-master"
+//
+// Names starting with "@" are Linux abstract sockets, which leave no
+// file behind. Socket paths longer than the kernel accepts are
+// replaced by a short hashed name in the temporary directory.
+func ParseAddress(addr string) (network string, address string) {
+	for _, scheme := range []string{tcpScheme, grpcScheme, jsonrpcScheme} {
+		if strings.HasPrefix(addr, scheme) {
+			return "tcp", strings.TrimPrefix(addr, scheme)
+		}
+	}
+	if isHTTPAddress(addr) {
+		if u, err := url.Parse(addr); err == nil {
+			return "tcp", httpHostPort(u)
+		}
+	}
+	return "unix", socketPath(addr)
+}
+
+// isHTTPAddress reports whether addr names a master mounted on an
+// HTTP server
+func isHTTPAddress(addr string) bool {
+	return strings.HasPrefix(addr, httpScheme) || strings.HasPrefix(addr, httpsScheme)
+}
+
+// httpHostPort returns the host and port of an HTTP URL, with the
+// default port of its scheme if it has none
+func httpHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// dialHTTPRPC connects a net/rpc client to a master mounted on an HTTP
+// server. The CONNECT request to the master's RPC endpoint turns the
+// connection into a plain net/rpc one.
+func dialHTTPRPC(addr string) (*rpc.Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	hostport := httpHostPort(u)
+	var conn net.Conn
+	if u.Scheme == "https" {
+		_, cfg := tlsConfigs()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		conn, err = tls.Dial("tcp", hostport, clientTLS(cfg, "tcp", hostport))
+	} else {
+		conn, err = net.Dial("tcp", hostport)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rpcPath := strings.TrimSuffix(u.Path, "/") + httpRPCPath
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.0\n\n", rpcPath)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("RPC endpoint %s%s: %v", hostport, rpcPath, err)
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// TCPAddress returns the address under which a node listening on
+// hostport is reached over TCP
+func TCPAddress(hostport string) string {
+	return tcpScheme + hostport
+}
+
+// JSONRPCAddress returns the address under which a node listening on
+// hostport is reached with JSON-RPC over TCP
+func JSONRPCAddress(hostport string) string {
+	return jsonrpcScheme + hostport
+}
+
+// listen opens a listener for addr. Stale unix socket files are
+// removed and missing socket directories created first.
+func listen(addr string) (net.Listener, error) {
+	network, address := ParseAddress(addr)
+	switch {
+	case network == "unix" && isAbstractSocket(address):
+		if err := checkSocketAddress(address); err != nil {
+			return nil, err
+		}
+	case network == "unix":
+		if address != addr {
+			processLog.infof("Socket path %s is too long, listening on %s", addr, address)
+		}
+		os.Remove(address)
+		if dir := filepath.Dir(address); dir != "" {
+			if err := os.MkdirAll(dir, 0777); err != nil {
+				return nil, fmt.Errorf("failed to create directory %s: %v", dir, err)
+			}
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %v", err)
+	}
+	return l, nil
+}
@@ -0,0 +1,163 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Pair is a key/value pair emitted by a typed map function
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Map is a map function emitting typed keys and values. Func turns it
+// into a map function for workers and Sequential:
+//
+//	count := mapreduce.Map[string, int](func(file, value string) []mapreduce.Pair[string, int] {
+//		...
+//	})
+//	mapreduce.RunWorker(master, me, count.Func(), sum.Func(), -1)
+type Map[K comparable, V any] func(file string, value string) []Pair[K, V]
+
+// Reduce is a reduce function of typed keys and values returning a
+// typed result, see Map
+type Reduce[K comparable, V any, R any] func(key K, values []V) R
+
+// Func returns a map function encoding the emitted keys and values
+// with EncodeValue
+func (f Map[K, V]) Func() func(string, string) []KeyValue {
+	return func(file string, value string) []KeyValue {
+		pairs := f(file, value)
+		kva := make([]KeyValue, len(pairs))
+		for i, p := range pairs {
+			kva[i] = KeyValue{Key: mustEncodeValue("Map", p.Key), Value: mustEncodeValue("Map", p.Value)}
+		}
+		return kva
+	}
+}
+
+// Func returns a reduce function decoding keys and values with
+// DecodeValue and encoding the result with EncodeValue. A key or value
+// that does not decode fails the task, as it means the job's map and
+// reduce functions disagree on the types.
+func (f Reduce[K, V, R]) Func() func(string, []string) string {
+	return func(key string, values []string) string {
+		k, err := DecodeValue[K](key)
+		if err != nil {
+			failTask("Reduce: key %q: %v", key, err)
+		}
+		vs := make([]V, len(values))
+		for i, value := range values {
+			if vs[i], err = DecodeValue[V](value); err != nil {
+				failTask("Reduce: value %q of key %q: %v", value, key, err)
+			}
+		}
+		return mustEncodeValue("Reduce", f(k, vs))
+	}
+}
+
+// EncodeValue turns a typed key or value into the string the untyped
+// API passes around. Strings are kept as they are, booleans and
+// numbers are formatted with strconv, and everything else is encoded
+// as JSON. Named types are encoded like their underlying type.
+func EncodeValue[T any](v T) (string, error) {
+	rv := reflect.ValueOf(&v).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DecodeValue parses a string written by EncodeValue, e.g. a line of a
+// typed job's result
+func DecodeValue[T any](s string) (T, error) {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, err
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return v, err
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return v, err
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			return v, err
+		}
+		rv.SetFloat(f)
+	default:
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// mustEncodeValue encodes v for a typed function, failing the task if
+// its type cannot be encoded
+func mustEncodeValue[T any](api string, v T) string {
+	s, err := EncodeValue(v)
+	if err != nil {
+		failTask("%s: encode %T: %v", api, v, err)
+	}
+	return s
+}
+
+// taskError carries the failure of a task out of a map or reduce
+// function that cannot return errors, see failTask
+type taskError struct {
+	err error
+}
+
+// failTask fails the running task from within its map or reduce
+// function. The task returns the error as if it failed itself.
+func failTask(format string, v ...interface{}) {
+	panic(taskError{fmt.Errorf(format, v...)})
+}
+
+// recoverTaskError turns the failure raised by failTask into the
+// error of a task, err being its named result. Other panics are raised
+// again.
+func recoverTaskError(err *error) {
+	if r := recover(); r != nil {
+		failure, ok := r.(taskError)
+		if !ok {
+			panic(r)
+		}
+		*err = failure.err
+	}
+}
@@ -0,0 +1,82 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestTypedFuncs runs a typed word count through the untyped map and
+// reduce functions Func returns, decoding keys and values on the way.
+func TestTypedFuncs(t *testing.T) {
+	type count struct {
+		Words int
+		Files []string
+	}
+	mapF := Map[string, count](func(file, value string) []Pair[string, count] {
+		var pairs []Pair[string, count]
+		for _, word := range strings.Fields(value) {
+			pairs = append(pairs, Pair[string, count]{word, count{1, []string{file}}})
+		}
+		return pairs
+	}).Func()
+	reduceF := Reduce[string, count, int](func(word string, counts []count) int {
+		total := 0
+		for _, c := range counts {
+			total += c.Words
+		}
+		return total
+	}).Func()
+
+	kva := mapF("a.txt", "to be or not to be")
+	if kva[0].Key != "to" || kva[0].Value != `{"Words":1,"Files":["a.txt"]}` {
+		t.Fatalf("map output %v", kva[0])
+	}
+	var values []string
+	for _, kv := range kva {
+		if kv.Key == "be" {
+			values = append(values, kv.Value)
+		}
+	}
+	if got := reduceF("be", values); got != "2" {
+		t.Errorf("reduce = %q, want 2", got)
+	}
+}
+
+// TestValueEncoding checks that EncodeValue and DecodeValue round-trip
+// basic, named and composite types, and keep strings and numbers
+// readable.
+func TestValueEncoding(t *testing.T) {
+	type userID string
+	type point struct{ X, Y float64 }
+
+	roundTrip(t, "plain text", "plain text")
+	roundTrip(t, userID("u-42"), "u-42")
+	roundTrip(t, -17, "-17")
+	roundTrip(t, int8(127), "127")
+	roundTrip(t, uint64(1<<63), "9223372036854775808")
+	roundTrip(t, 0.1, "0.1")
+	roundTrip(t, true, "true")
+	roundTrip(t, point{1.5, -2}, `{"X":1.5,"Y":-2}`)
+	roundTrip(t, []int{3, 1, 2}, "[3,1,2]")
+
+	if _, err := DecodeValue[int8]("300"); err == nil {
+		t.Error("DecodeValue[int8](300) succeeded")
+	}
+	if _, err := DecodeValue[int]("twelve"); err == nil {
+		t.Error("DecodeValue[int](twelve) succeeded")
+	}
+}
+
+func roundTrip[T any](t *testing.T, v T, want string) {
+	t.Helper()
+	got, err := EncodeValue(v)
+	if err != nil || got != want {
+		t.Errorf("EncodeValue(%#v) = %q, %v, want %q", v, got, err, want)
+	}
+	back, err := DecodeValue[T](want)
+	if err != nil || !reflect.DeepEqual(back, v) {
+		t.Errorf("DecodeValue(%q) = %#v, %v, want %#v", want, back, err, v)
+	}
+}
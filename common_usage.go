@@ -0,0 +1,26 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "time"
+
+// taskUsage measures the resources a task attempt uses
+type taskUsage struct {
+	start        time.Time
+	user, system time.Duration
+}
+
+// startUsage starts measuring a task attempt
+func startUsage() taskUsage {
+	u := taskUsage{start: time.Now()}
+	u.user, u.system = processCPU()
+	return u
+}
+
+// stop records the wall time and the CPU time used since startUsage
+// in metrics
+func (u taskUsage) stop(metrics *TaskMetrics) {
+	user, system := processCPU()
+	metrics.WallTime = time.Since(u.start)
+	metrics.UserCPU = user - u.user
+	metrics.SystemCPU = system - u.system
+}
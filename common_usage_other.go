@@ -0,0 +1,11 @@
+//go:build !unix
+
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "time"
+
+// processCPU reports no CPU time where getrusage is not available
+func processCPU() (user, system time.Duration) {
+	return 0, 0
+}
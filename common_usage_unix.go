@@ -0,0 +1,19 @@
+//go:build unix
+
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPU returns the user and system CPU time the process has used
+// so far, as reported by getrusage
+func processCPU() (user, system time.Duration) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+	return time.Duration(usage.Utime.Nano()), time.Duration(usage.Stime.Nano())
+}
@@ -0,0 +1,224 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// JobWorkspace lays out all files of a single job under one root:
+//
+//	<root>/input         staged input files
+//	<root>/intermediate  map output and combiner cache spills
+//	<root>/output        reduce task outputs
+//	<root>/logs          manifests and other job logs
+//
+// Every path used by the framework is built by one of its methods, so
+// jobs never write outside their own directory.
+type JobWorkspace struct {
+	JobName JobParse
+	Root    string
+}
+
+// NewJobWorkspace creates a workspace for the job rooted at root
+func NewJobWorkspace(root string, jobName JobParse) *JobWorkspace {
+	return &JobWorkspace{JobName: jobName, Root: root}
+}
+
+// DefaultWorkspace returns the workspace a job uses when no root is
+// configured: a directory named after the job inside the configured
+// output path.
+func DefaultWorkspace(jobName JobParse) *JobWorkspace {
+	return NewJobWorkspace(joinPath(Config["output"], string(jobName)), jobName)
+}
+
+// InputDir returns the directory for staged input files
+func (w *JobWorkspace) InputDir() string {
+	return joinPath(w.Root, "input")
+}
+
+// IntermediateDir returns the directory holding map output
+func (w *JobWorkspace) IntermediateDir() string {
+	return joinPath(w.Root, "intermediate")
+}
+
+// OutputDir returns the directory holding reduce output
+func (w *JobWorkspace) OutputDir() string {
+	return joinPath(w.Root, "output")
+}
+
+// LogsDir returns the directory for manifests and job logs
+func (w *JobWorkspace) LogsDir() string {
+	return joinPath(w.Root, "logs")
+}
+
+// Intermediate returns the file map task mapTask writes for reduceTask
+func (w *JobWorkspace) Intermediate(mapTask int, reduceTask int) string {
+	return joinPath(w.IntermediateDir(),
+		"mrtmp."+string(w.JobName)+"-"+strconv.Itoa(mapTask)+"-"+strconv.Itoa(reduceTask))
+}
+
+// CacheSpill returns a file holding partial aggregates flushed from a
+// worker's combiner cache, identified by cache
+func (w *JobWorkspace) CacheSpill(cache string, seq int, reduceTask int) string {
+	return joinPath(w.IntermediateDir(), w.cacheSpillBase(cache)+strconv.Itoa(seq)+"-"+strconv.Itoa(reduceTask))
+}
+
+// CacheSpillPrefix returns the start of the names of every spill file
+// of a combiner cache
+func (w *JobWorkspace) CacheSpillPrefix(cache string) string {
+	return joinPath(w.IntermediateDir(), w.cacheSpillBase(cache))
+}
+
+// cacheSpillBase returns the start of the base names of the spill
+// files of a combiner cache
+func (w *JobWorkspace) cacheSpillBase(cache string) string {
+	return "mrtmp." + string(w.JobName) + "-cache-" + cache + "."
+}
+
+// CacheSpillPattern matches every cache spill file of a reduce task
+func (w *JobWorkspace) CacheSpillPattern(reduceTask int) string {
+	return joinPath(w.IntermediateDir(),
+		"mrtmp."+string(w.JobName)+"-cache-*-"+strconv.Itoa(reduceTask))
+}
+
+// SortRuns creates a directory of its own for the sorted runs spilled
+// by one attempt of a reduce task, see WithExternalSort. Runs of remote
+// workspaces are spilled to the local temporary directory.
+func (w *JobWorkspace) SortRuns(reduceTask int) (string, error) {
+	dir := w.IntermediateDir()
+	if isRemotePath(dir) {
+		dir = ""
+	}
+	return os.MkdirTemp(dir, "mrtmp."+string(w.JobName)+"-sort-"+strconv.Itoa(reduceTask)+"-*")
+}
+
+// ZstdDictionary returns the compression dictionary shared by the map
+// output of the job, see CompressionZstdDict
+func (w *JobWorkspace) ZstdDictionary() string {
+	return joinPath(w.IntermediateDir(), "mrtmp."+string(w.JobName)+".zdict")
+}
+
+// ReduceOutput returns the output file of a reduce task
+func (w *JobWorkspace) ReduceOutput(reduceTask int) string {
+	return joinPath(w.OutputDir(), "mrtmp."+string(w.JobName)+"-"+strconv.Itoa(reduceTask))
+}
+
+// ReduceOutputs returns the output files of all reduce tasks
+func (w *JobWorkspace) ReduceOutputs(nReduce int) []string {
+	parts := make([]string, nReduce)
+	for i := range parts {
+		parts[i] = w.ReduceOutput(i)
+	}
+	return parts
+}
+
+// Manifest returns the path of the job manifest
+func (w *JobWorkspace) Manifest() string {
+	return joinPath(w.LogsDir(), "manifest.json")
+}
+
+// Log returns the path of a named file in the logs directory
+func (w *JobWorkspace) Log(name string) string {
+	return joinPath(w.LogsDir(), name)
+}
+
+// Create makes sure all workspace directories exist
+func (w *JobWorkspace) Create() error {
+	for _, dir := range []string{w.InputDir(), w.IntermediateDir(), w.OutputDir(), w.LogsDir()} {
+		if err := mkdirAll(dir); err != nil {
+			return fmt.Errorf("failed to create workspace directory %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// Purge removes the workspace and everything in it
+func (w *JobWorkspace) Purge() error {
+	if err := removeAll(w.Root); err != nil {
+		return fmt.Errorf("failed to purge workspace %s: %v", w.Root, err)
+	}
+	return nil
+}
+
+// Archive writes the contents of the workspace to dest as a gzipped
+// tarball. Paths inside the archive are relative to the workspace root.
+// When dirs is not empty only those workspace directories are included.
+// Remote workspaces cannot be archived.
+func (w *JobWorkspace) Archive(dest string, dirs ...string) error {
+	if isRemotePath(w.Root) {
+		return fmt.Errorf("cannot archive remote workspace %s", w.Root)
+	}
+	if len(dirs) == 0 {
+		dirs = []string{w.Root}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	file, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range dirs {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(w.Root, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			return addToArchive(tw, path, filepath.ToSlash(rel), info)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive workspace %s: %v", w.Root, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+	return gz.Close()
+}
+
+// addToArchive writes a single file or directory entry to tw
+func addToArchive(tw *tar.Writer, path string, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// workspace returns the workspace of the job the options belong to
+func (o JobOptions) workspace(jobName JobParse) *JobWorkspace {
+	if o.Workspace != "" {
+		return NewJobWorkspace(o.Workspace, jobName)
+	}
+	return DefaultWorkspace(jobName)
+}
@@ -1,23 +1,38 @@
 package mapreduce
 
 import (
-	"log"
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v2"
 )
 
+// Config holds the paths of the configuration file, read from
+// config.yaml in the working directory when the program starts
 var Config map[string]string
 
+// configErr is why no configuration could be loaded, returned when a
+// master or worker starts
+var configErr error
+
 func init() {
+	configErr = LoadConfig("config.yaml")
+}
+
+// LoadConfig reads the paths of the configuration file at path into
+// Config, e.g. for programs not started from the directory holding
+// config.yaml. Masters and workers fail to start while no
+// configuration could be loaded.
+func LoadConfig(path string) error {
 	var config map[string]map[string]string
-	data, err := os.ReadFile("config.yaml")
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		return fmt.Errorf("read config file: %w", err)
 	}
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		log.Fatalf("Failed to parse config file: %v", err)
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
 	}
 	Config = config["paths"]
+	configErr = nil
+	return nil
 }
@@ -9,7 +9,6 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
-	"time"
 )
 
 // JobParse is the type alias for mapreduce.JobParse
@@ -73,7 +72,74 @@ func setupMasterSocket() error {
 	return nil
 }
 
+// handoffMaster hands the listening socket to a new instance of this
+// binary and waits until the jobs started here have finished
+func handoffMaster(master *mapreduce.Master) {
+	binary, err := os.Executable()
+	if err != nil {
+		log.Printf("Upgrade failed: %v", err)
+		return
+	}
+	process, err := master.Handoff(binary, os.Args[1:])
+	if err != nil {
+		log.Printf("Upgrade failed: %v", err)
+		return
+	}
+	log.Printf("Master handed off to process %d", process.Pid)
+	os.Exit(0)
+}
+
+// runSuccessor serves the control plane taken over from an upgraded
+// master. The predecessor finishes its own job, so none is submitted.
+func runSuccessor(masterSocket string) {
+	master, err := mapreduce.StartMaster(masterSocket)
+	if err != nil {
+		log.Fatalf("Failed to take over master: %v", err)
+	}
+
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+	go func() {
+		<-upgrade
+		handoffMaster(master)
+	}()
+	go handleStopSignals(master)
+	master.Wait()
+}
+
+// handleStopSignals stops the master on Ctrl-C and SIGTERM. The first
+// Ctrl-C lets the running job finish; a second Ctrl-C or SIGTERM
+// cancels it.
+func handleStopSignals(master *mapreduce.Master) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	mode := mapreduce.StopSoft
+	for sig := range signals {
+		if sig == syscall.SIGTERM {
+			mode = mapreduce.StopHard
+		}
+		if mode == mapreduce.StopSoft {
+			log.Printf("Received %v, waiting for the running job. Press Ctrl-C again to cancel it", sig)
+		} else {
+			log.Printf("Received %v, cancelling the running job", sig)
+		}
+		go func(mode mapreduce.StopMode) {
+			if err := master.Stop(mode); err != nil {
+				log.Printf("Stop failed: %v", err)
+			}
+		}(mode)
+		mode = mapreduce.StopHard
+	}
+}
+
 func main() {
+	if os.Getenv(mapreduce.HandoffEnv) != "" {
+		log.Println("Taking over from previous master...")
+		runSuccessor(mapreduce.Config["master_socket"])
+		return
+	}
+
 	// Setup input files for word counting
 	inputFile1, inputFile2, err := setupInputFiles()
 	if err != nil {
@@ -89,7 +155,7 @@ func main() {
 
 	// Configure MapReduce task
 	inputFiles := []string{inputFile1, inputFile2}
-	nReduce := len(inputFiles)                        // Number of reduce tasks
+	nReduce := mapreduce.AutoReduce                   // Sized from the input
 	masterSocket := mapreduce.Config["master_socket"] // Master socket path
 
 	// Setup socket directory and cleanup
@@ -99,7 +165,6 @@ func main() {
 
 	// Print configuration information
 	log.Printf("Master socket: %s", masterSocket)
-	log.Printf("Number of reduce tasks: %d", nReduce)
 	log.Printf("Input files: %v", inputFiles)
 
 	// Create and start master
@@ -112,26 +177,24 @@ func main() {
 	log.Println("Waiting for workers to connect...")
 
 	// Setup signal handling for graceful shutdown
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go handleStopSignals(master)
 
-	// Wait for task completion in background
-	done := make(chan struct{})
+	// SIGUSR2 upgrades the master in place
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
 	go func() {
-		master.Wait()
-		close(done)
+		<-upgrade
+		handoffMaster(master)
 	}()
 
-	// Wait for completion or interrupt
-	select {
-	case <-done:
-		log.Println("All tasks completed successfully")
-	case <-interrupt:
-		log.Println("Received interrupt signal. Shutting down...")
-		// Give master time to cleanup
-		time.Sleep(time.Second)
-	}
+	// Wait until the job has finished or the master was stopped
+	master.Wait()
 
-	log.Println("Master node completed")
+	result := master.Result()
+	log.Printf("Master node completed, %d tasks on %d workers", result.Tasks(), len(result.Workers))
+	if preview, err := result.Preview(5); err == nil {
+		log.Printf("First records: %v", preview.First)
+		log.Printf("Random records: %v", preview.Sample)
+	}
 	log.Println("Results can be found in: ./assets/result/mrt.result.txt")
 }
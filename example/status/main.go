@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"mapreduce"
+	"mapreduce/client"
+	"os"
+)
+
+// printEvent prints a job event on one line
+func printEvent(event mapreduce.JobEvent) {
+	switch event.Kind {
+	case mapreduce.EventTask:
+		fmt.Printf("%s %s task %d %s (%d/%d)\n", event.Time.Format("15:04:05"), event.Phase,
+			event.Task, event.TaskState, event.Progress.Completed, event.Progress.Total)
+	case mapreduce.EventPhaseStarted, mapreduce.EventPhaseEnded:
+		fmt.Printf("%s %s %s\n", event.Time.Format("15:04:05"), event.Phase, event.Kind)
+	default:
+		fmt.Printf("%s job %s %s\n", event.Time.Format("15:04:05"), event.State, event.Error)
+	}
+}
+
+func main() {
+	token := flag.String("token", "", "control token, see mapreduce.WithControlTokens")
+	follow := flag.Bool("follow", false, "print the job's events until it has finished")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-token token] [-follow] <master-address> [job-id]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 || (*follow && flag.NArg() != 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client.Connect(flag.Arg(0), client.WithToken(*token))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	var status interface{}
+	switch {
+	case *follow:
+		var last mapreduce.JobEvent
+		if err := c.Follow(flag.Arg(1), func(event mapreduce.JobEvent) {
+			printEvent(event)
+			last = event
+		}); err != nil {
+			log.Fatal(err)
+		}
+		if last.State != mapreduce.JobSucceeded {
+			os.Exit(1)
+		}
+		return
+	case flag.NArg() == 2:
+		status, err = c.Status(flag.Arg(1))
+	default:
+		status, err = c.MasterStatus()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Println(string(out))
+}
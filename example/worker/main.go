@@ -34,51 +34,79 @@ func ReduceFunc(key string, values []string) string {
 	return strconv.Itoa(len(values))
 }
 
-// runWorkerWithRetry starts the worker process with retry mechanism
+// runWorkerWithRetry starts the worker process, retrying while the
+// master is unreachable, and waits until the master shuts it down
 func runWorkerWithRetry(masterSocket, workerSocket string, done chan struct{}) {
 	const (
-		maxRetries     = 5
-		retryInterval  = time.Second * 2
-		taskWaitPeriod = time.Second
+		maxRetries    = 5
+		retryInterval = time.Second * 2
 	)
+	defer close(done)
 
-	for {
-		for i := 0; i < maxRetries; i++ {
-			if i > 0 {
-				log.Printf("Retry attempt %d/%d...", i+1, maxRetries)
-				time.Sleep(retryInterval)
-			}
-
-			err := mapreduce.RunWorker(masterSocket, workerSocket, MapFunc, ReduceFunc, -1)
-			if err != nil {
-				log.Printf("Worker error: %v", err)
-				// Continue retrying for connection-related errors
-				if strings.Contains(err.Error(), "connection") ||
-					strings.Contains(err.Error(), "RPC") {
-					continue
-				}
-				// For other errors, assume the task is complete
-				log.Printf("Worker completed or encountered non-connection error")
-				close(done)
-				return
-			}
-
-			// If RunWorker returns normally, reset retry counter and wait for new tasks
-			i = -1
-			time.Sleep(taskWaitPeriod)
+	for i := 0; i < maxRetries; i++ {
+		if i > 0 {
+			log.Printf("Retry attempt %d/%d...", i+1, maxRetries)
+			time.Sleep(retryInterval)
 		}
 
-		// Exit if max retries reached
-		log.Printf("Worker failed after %d retries", maxRetries)
-		close(done)
+		worker, err := mapreduce.StartWorker(masterSocket, workerSocket, MapFunc, ReduceFunc, -1)
+		if err != nil {
+			log.Printf("Worker error: %v", err)
+			continue
+		}
+
+		reg := worker.Registration()
+		if reg.JobActive {
+			log.Printf("Registered for job %s (%s phase)", reg.JobName, reg.Phase)
+		} else {
+			log.Printf("Registered, waiting for the next job")
+		}
+
+		// Tasks are served in the background until the master shuts us down
+		worker.Wait()
 		return
 	}
+
+	// Exit if max retries reached
+	log.Printf("Worker failed after %d retries", maxRetries)
+}
+
+// runWorkerFromSpec starts a worker described by a JSON spec read
+// from path, or from stdin when path is "-"
+func runWorkerFromSpec(path string) {
+	in := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open worker spec: %v", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	spec, err := mapreduce.ReadWorkerSpec(in)
+	if err != nil {
+		log.Fatalf("Invalid worker spec: %v", err)
+	}
+
+	log.Printf("Starting worker %s from spec...", spec.Address)
+	worker, err := mapreduce.RunWorkerFromSpec(spec, MapFunc, ReduceFunc)
+	if err != nil {
+		log.Fatalf("Worker error: %v", err)
+	}
+	worker.Wait()
+	log.Printf("Worker %s exiting", spec.Address)
 }
 
 func main() {
 	// Validate command line arguments
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <worker-number>\n", os.Args[0])
+	if len(os.Args) == 3 && os.Args[1] == "--spec" {
+		runWorkerFromSpec(os.Args[2])
+		return
+	}
+	if len(os.Args) != 2 && len(os.Args) != 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <worker-number> [tcp://host:port]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s --spec <file|->\n", os.Args[0])
 		os.Exit(1)
 	}
 
@@ -96,6 +124,12 @@ func main() {
 		workerNum,
 	)
 
+	// Listen on TCP instead when an address is given, so the master
+	// can reach the worker from another host
+	if len(os.Args) == 3 {
+		workerSocket = os.Args[2]
+	}
+
 	// Ensure socket directory exists
 	socketDir := mapreduce.Config["socket_base"]
 	if err := os.MkdirAll(socketDir, 0777); err != nil {
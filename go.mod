@@ -2,4 +2,17 @@ module mapreduce
 
 go 1.23.4
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/text v0.17.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+)
@@ -0,0 +1,176 @@
+package mapreducetest
+
+import (
+	"fmt"
+	"net"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"mapreduce"
+)
+
+// ConformanceJob is the name of the job TestWorker runs: a word count
+// whose map function emits the pair (word, "1") for every word of its
+// input split, words being separated by white space, and whose reduce
+// function returns the number of values of a key in decimal.
+const ConformanceJob mapreduce.JobParse = "conformance-wc"
+
+// conformanceWait bounds how long TestWorker waits for the worker to
+// register and for the job to finish
+const conformanceWait = 30 * time.Second
+
+// conformanceInputs are the input files of the conformance job
+var conformanceInputs = []string{
+	"the quick brown fox jumps over the lazy dog\n",
+	"the dog barks\nthe fox runs\n\nover and over\n",
+	"",
+}
+
+// TestWorker checks that a worker speaks the worker protocol described
+// in the Readme, so that workers written in other languages can be
+// tested against a real master. It starts a master on a "jsonrpc://"
+// address on the loopback interface and calls start with that address;
+// start launches the worker under test, which must register with the
+// master, and returns a function stopping it. TestWorker then checks
+// that the worker answers pings and rejects unknown methods, runs
+// ConformanceJob on it with three reduce tasks, compares the result
+// with the expected word counts and shuts the worker down. The job
+// pins the plain intermediate layout, IntermediateFormatPlain, without
+// compression. TestWorker returns the first deviation it finds. Like
+// any master, it needs a configuration, see mapreduce.LoadConfig.
+func TestWorker(start func(master string) (stop func(), err error)) error {
+	dir, err := os.MkdirTemp("", "mapreducetest")
+	if err != nil {
+		return fmt.Errorf("failed to create job directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := make([]string, len(conformanceInputs))
+	want := make(map[string]string)
+	counts := make(map[string]int)
+	for i, contents := range conformanceInputs {
+		files[i] = filepath.Join(dir, fmt.Sprintf("input-%d.txt", i))
+		if err := os.WriteFile(files[i], []byte(contents), 0666); err != nil {
+			return fmt.Errorf("failed to write input: %v", err)
+		}
+		for _, word := range strings.Fields(contents) {
+			counts[word]++
+		}
+	}
+	for word, n := range counts {
+		want[word] = strconv.Itoa(n)
+	}
+
+	hostport, err := freePort()
+	if err != nil {
+		return err
+	}
+	master := mapreduce.JSONRPCAddress(hostport)
+	mr, err := mapreduce.StartMaster(master,
+		mapreduce.WithWorkspace(filepath.Join(dir, "workspace")),
+		mapreduce.WithResultDir(filepath.Join(dir, "result")),
+		mapreduce.WithIntermediateFormat(mapreduce.IntermediateFormatPlain),
+		mapreduce.WithCompression(mapreduce.CompressionNone),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start master: %v", err)
+	}
+	defer mr.Stop(mapreduce.StopHard)
+
+	stop, err := start(master)
+	if err != nil {
+		return fmt.Errorf("failed to start worker: %v", err)
+	}
+	defer stop()
+
+	worker, err := awaitWorker(mr)
+	if err != nil {
+		return err
+	}
+	if err := checkMethods(worker); err != nil {
+		return err
+	}
+
+	handle, err := mr.Submit(mapreduce.JobConfig{Name: ConformanceJob, Files: files, NReduce: 3})
+	if err != nil {
+		return fmt.Errorf("failed to submit %s: %v", ConformanceJob, err)
+	}
+	select {
+	case <-handle.Done():
+	case <-time.After(conformanceWait):
+		handle.Cancel()
+		return fmt.Errorf("%s did not finish within %v", ConformanceJob, conformanceWait)
+	}
+	if err := handle.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v", ConformanceJob, err)
+	}
+	result, err := handle.Result()
+	if err != nil {
+		return err
+	}
+	got, err := result.Map()
+	if err != nil {
+		return fmt.Errorf("failed to read the result of %s: %v", ConformanceJob, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%s counted %v, want %v", ConformanceJob, got, want)
+	}
+
+	if err := mr.Stop(mapreduce.StopSoft); err != nil {
+		return err
+	}
+	for _, summary := range mr.Result().Workers {
+		if !summary.Responded {
+			return fmt.Errorf("worker %s did not answer %s", summary.Worker, mapreduce.ShutdownMethod)
+		}
+	}
+	return nil
+}
+
+// freePort returns a loopback host and port nothing listens on
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to pick a port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// awaitWorker waits for a worker to register with mr and returns its
+// address
+func awaitWorker(mr *mapreduce.Master) (string, error) {
+	deadline := time.Now().Add(conformanceWait)
+	for time.Now().Before(deadline) {
+		if workers := mr.Workers(); len(workers) > 0 {
+			return workers[0].Address, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "", fmt.Errorf("no worker registered within %v", conformanceWait)
+}
+
+// checkMethods calls the worker directly: pings must succeed and
+// unknown methods must be answered with an error
+func checkMethods(worker string) error {
+	network, address := mapreduce.ParseAddress(worker)
+	if !strings.HasPrefix(worker, mapreduce.JSONRPCAddress("")) {
+		return fmt.Errorf("worker registered as %s, want a %s address", worker, mapreduce.JSONRPCAddress(""))
+	}
+	c, err := jsonrpc.Dial(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to dial worker %s: %v", worker, err)
+	}
+	defer c.Close()
+	if err := c.Call(mapreduce.PingMethod, mapreduce.PingArgs{}, &struct{}{}); err != nil {
+		return fmt.Errorf("%s failed: %v", mapreduce.PingMethod, err)
+	}
+	if err := c.Call("Worker.Conform", struct{}{}, &struct{}{}); err == nil {
+		return fmt.Errorf("worker accepted the unknown method Worker.Conform")
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package mapreducetest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mapreduce"
+)
+
+// TestWorkerConformance runs the conformance suite against the Go
+// worker, against a worker written from the protocol description
+// alone, and against the command in MAPREDUCE_CONFORMANCE_WORKER, if
+// set, which is given the master's address as its last argument.
+func TestWorkerConformance(t *testing.T) {
+	if err := mapreduce.LoadConfig("../config.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("go", func(t *testing.T) {
+		err := TestWorker(func(master string) (func(), error) {
+			hostport, err := freePort()
+			if err != nil {
+				return nil, err
+			}
+			wk, err := mapreduce.StartWorker(master, mapreduce.JSONRPCAddress(hostport), wordCount, countValues, -1)
+			if err != nil {
+				return nil, err
+			}
+			return func() { wk.Leave() }, nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		err := TestWorker(func(master string) (func(), error) {
+			w, err := startPlainWorker(master)
+			if err != nil {
+				return nil, err
+			}
+			return func() { w.listener.Close() }, nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	command := strings.Fields(os.Getenv("MAPREDUCE_CONFORMANCE_WORKER"))
+	if len(command) == 0 {
+		return
+	}
+	t.Run("command", func(t *testing.T) {
+		err := TestWorker(func(master string) (func(), error) {
+			cmd := exec.Command(command[0], append(command[1:], master)...)
+			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			return func() {
+				cmd.Process.Kill()
+				cmd.Wait()
+			}, nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// wordCount is the map function of ConformanceJob
+func wordCount(_ string, contents string) []mapreduce.KeyValue {
+	var kvs []mapreduce.KeyValue
+	for _, word := range strings.Fields(contents) {
+		kvs = append(kvs, mapreduce.KeyValue{Key: word, Value: "1"})
+	}
+	return kvs
+}
+
+// countValues is the reduce function of ConformanceJob
+func countValues(_ string, values []string) string {
+	return strconv.Itoa(len(values))
+}
+
+// plainWorker implements the worker protocol with nothing but JSON
+// over TCP, as a worker written in another language would
+type plainWorker struct {
+	address  string
+	listener net.Listener
+}
+
+// plainRequest and plainResponse are JSON-RPC 1.0 messages
+type plainRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     json.RawMessage   `json:"id"`
+}
+
+type plainResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// plainTask holds the fields of a DoTask request the worker needs
+type plainTask struct {
+	JobName         string
+	Phase           string
+	File            string
+	TaskNumber      int
+	OtherTaskNumber int
+	Offset          int64
+	Length          int64
+	Options         struct{ Workspace string }
+}
+
+// startPlainWorker listens on a free port and registers with master
+func startPlainWorker(master string) (*plainWorker, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	w := &plainWorker{address: "jsonrpc://" + l.Addr().String(), listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go w.serve(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(master, "jsonrpc://"))
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, `{"method":"Master.Register","params":[{"Worker":%q,"Slots":1}],"id":0}`+"\n", w.address)
+	var reply plainResponse
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil || reply.Error != nil {
+		l.Close()
+		return nil, fmt.Errorf("register: %v %v", err, reply.Error)
+	}
+	return w, nil
+}
+
+// serve answers the requests of a connection
+func (w *plainWorker) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req plainRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := plainResponse{ID: req.ID, Result: struct{}{}}
+		switch req.Method {
+		case "Worker.Prepare":
+			resp.Result = map[string]string{"Framework": mapreduce.FrameworkVersion}
+		case "Worker.Ping", "Worker.FlushCache", "Worker.CancelTasks":
+		case "Worker.Shutdown":
+			resp.Result = map[string]int{"Ntasks": 0}
+			defer w.listener.Close()
+		case "Worker.DoTask":
+			var task plainTask
+			err := json.Unmarshal(req.Params[0], &task)
+			if err == nil {
+				err = task.run()
+			}
+			if err != nil {
+				resp.Result, resp.Error = nil, err.Error()
+			}
+		default:
+			resp.Result, resp.Error = nil, "unknown method "+req.Method
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// run runs a task of ConformanceJob
+func (t *plainTask) run() error {
+	root := t.Options.Workspace
+	switch t.Phase {
+	case "Map":
+		file, err := os.Open(t.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		file.Seek(t.Offset, io.SeekStart)
+		var r io.Reader = file
+		if t.Length > 0 {
+			r = io.LimitReader(file, t.Length)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		parts := make([][]mapreduce.KeyValue, t.OtherTaskNumber)
+		for _, word := range strings.Fields(string(data)) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			p := int(h.Sum32()&0x7ffffff) % t.OtherTaskNumber
+			parts[p] = append(parts[p], mapreduce.KeyValue{Key: word, Value: "1"})
+		}
+		for r, kvs := range parts {
+			name := fmt.Sprintf("mrtmp.%s-%d-%d", t.JobName, t.TaskNumber, r)
+			if err := writeRecords(filepath.Join(root, "intermediate", name), kvs); err != nil {
+				return err
+			}
+		}
+	case "Reduce":
+		counts := make(map[string]int)
+		for m := 0; m < t.OtherTaskNumber; m++ {
+			name := fmt.Sprintf("mrtmp.%s-%d-%d", t.JobName, m, t.TaskNumber)
+			file, err := os.Open(filepath.Join(root, "intermediate", name))
+			if err != nil {
+				return err
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				var kv mapreduce.KeyValue
+				if err := json.Unmarshal(scanner.Bytes(), &kv); err != nil {
+					file.Close()
+					return err
+				}
+				counts[kv.Key]++
+			}
+			file.Close()
+		}
+		var kvs []mapreduce.KeyValue
+		for key, n := range counts {
+			kvs = append(kvs, mapreduce.KeyValue{Key: key, Value: strconv.Itoa(n)})
+		}
+		name := fmt.Sprintf("mrtmp.%s-%d", t.JobName, t.TaskNumber)
+		return writeRecords(filepath.Join(root, "output", name), kvs)
+	default:
+		return fmt.Errorf("unknown phase %q", t.Phase)
+	}
+	return nil
+}
+
+// writeRecords writes one JSON record per line to a temporary file
+// renamed to name once complete
+func writeRecords(name string, kvs []mapreduce.KeyValue) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	for _, kv := range kvs {
+		if err := enc.Encode(kv); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
@@ -0,0 +1,193 @@
+package mapreducetest
+
+import (
+	"fmt"
+	"time"
+
+	"mapreduce"
+)
+
+// controlPlane answers the control-plane RPCs of a FakeMaster under the
+// method names of a real master
+type controlPlane struct {
+	f *FakeMaster
+}
+
+// checkVersion rejects requests from incompatible clients
+func checkVersion(version int) error {
+	if version != mapreduce.ControlProtocolVersion {
+		return fmt.Errorf("unsupported control protocol version %d, master speaks %d",
+			version, mapreduce.ControlProtocolVersion)
+	}
+	return nil
+}
+
+// SubmitJob queues a job, which plays its scripted outcome
+func (c *controlPlane) SubmitJob(args *mapreduce.SubmitJobArgs, reply *mapreduce.SubmitJobReply) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	id, err := c.f.submit(args.Job)
+	if err != nil {
+		return err
+	}
+	reply.JobID = id
+	return nil
+}
+
+// JobStatus reports the state of a submitted job
+func (c *controlPlane) JobStatus(args *mapreduce.JobArgs, reply *mapreduce.JobStatus) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	job, err := c.f.lookup(args.JobID)
+	if err != nil {
+		return err
+	}
+	*reply = job.snapshot()
+	return nil
+}
+
+// CancelJob cancels a job that has not finished yet
+func (c *controlPlane) CancelJob(args *mapreduce.JobArgs, _ *struct{}) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	job, err := c.f.lookup(args.JobID)
+	if err != nil {
+		return err
+	}
+	if !finished(job.status.State) {
+		c.f.finish(job, mapreduce.JobCancelled, "")
+	}
+	return nil
+}
+
+// FetchResult returns the scripted result of a succeeded job, all of it
+// in partition 0
+func (c *controlPlane) FetchResult(args *mapreduce.FetchResultArgs, reply *mapreduce.FetchResultReply) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	job, err := c.f.lookup(args.JobID)
+	if err != nil {
+		return err
+	}
+	if job.status.State != mapreduce.JobSucceeded {
+		return fmt.Errorf("job %s has no result, it is %s", args.JobID, job.status.State)
+	}
+	if args.Part < 0 || args.Part >= job.status.NReduce {
+		return fmt.Errorf("job %s has no result part %d", args.JobID, args.Part)
+	}
+	if args.Part == 0 {
+		reply.Records = job.outcome.Result
+	}
+	reply.Done = true
+	return nil
+}
+
+// PreviewResult returns records of the scripted result of a succeeded
+// job
+func (c *controlPlane) PreviewResult(args *mapreduce.PreviewResultArgs, reply *mapreduce.OutputPreview) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	job, err := c.f.lookup(args.JobID)
+	if err != nil {
+		return err
+	}
+	if job.status.State != mapreduce.JobSucceeded {
+		return fmt.Errorf("job %s has no result, it is %s", args.JobID, job.status.State)
+	}
+	*reply = preview(job.outcome.Result, args.N)
+	return nil
+}
+
+// Status reports the jobs held running and none queued; the fake has
+// no workers
+func (c *controlPlane) Status(args *mapreduce.StatusArgs, reply *mapreduce.MasterStatus) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	for id, job := range c.f.jobs {
+		if job.status.State == mapreduce.JobRunning {
+			reply.JobID = id
+			reply.Job = job.status.Name
+		}
+	}
+	return nil
+}
+
+// Reserve hands out a reservation of any number of slots
+func (c *controlPlane) Reserve(args *mapreduce.ReserveArgs, reply *mapreduce.ReserveReply) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	if args.Slots < 1 {
+		return fmt.Errorf("invalid number of slots: %d", args.Slots)
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.reservedSeq++
+	reply.ReservationID = fmt.Sprintf("reservation-%d", c.f.reservedSeq)
+	c.f.reservations[reply.ReservationID] = args.Slots
+	return nil
+}
+
+// Release forgets a reservation
+func (c *controlPlane) Release(args *mapreduce.ReleaseArgs, _ *struct{}) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.f.reservations[args.ReservationID] == 0 {
+		return fmt.Errorf("unknown reservation %s", args.ReservationID)
+	}
+	delete(c.f.reservations, args.ReservationID)
+	return nil
+}
+
+// WatchJob returns the events of a job following args.After, waiting a
+// while for new ones if there are none yet
+func (c *controlPlane) WatchJob(args *mapreduce.WatchJobArgs, reply *mapreduce.WatchJobReply) error {
+	if err := checkVersion(args.Version); err != nil {
+		return err
+	}
+	timer := time.NewTimer(watchWait)
+	defer timer.Stop()
+	for {
+		c.f.mu.Lock()
+		job, err := c.f.lookup(args.JobID)
+		if err != nil {
+			c.f.mu.Unlock()
+			return err
+		}
+		if args.After < int64(len(job.events)) {
+			reply.Events = append(reply.Events, job.events[max(args.After, 0):]...)
+		}
+		reply.Done = finished(job.status.State)
+		changed := c.f.changed
+		c.f.mu.Unlock()
+		if len(reply.Events) > 0 || reply.Done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
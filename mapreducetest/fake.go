@@ -0,0 +1,344 @@
+// Package mapreducetest provides a fake master for the tests of
+// programs that submit and follow jobs over the control plane. The
+// fake speaks the master's control-plane protocol, so the code under
+// test connects to it with client.Connect as it would to a real
+// master, but it runs no tasks: every job plays the Outcome scripted
+// for its name. TestWorker checks workers, e.g. ones written in other
+// languages, against the worker protocol.
+package mapreducetest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"mapreduce"
+)
+
+// Phases of the jobs a FakeMaster runs
+const (
+	mapPhase    mapreduce.JobParse = "Map"
+	reducePhase mapreduce.JobParse = "Reduce"
+)
+
+// watchWait is how long a WatchJob request waits for new events
+const watchWait = 10 * time.Second
+
+// Outcome scripts how the jobs of a name run on a FakeMaster.
+type Outcome struct {
+	Reject error // Refuses the submission with this error
+	Hold   bool  // Keeps the job running until FakeMaster.Finish
+
+	MapTasks    []TaskOutcome // One per map task, one succeeding task per input file if nil
+	ReduceTasks []TaskOutcome // One per reduce task, NReduce succeeding tasks if nil
+
+	Error    string               // Fails the job with this reason once its tasks ran
+	Result   []mapreduce.KeyValue // Records of a succeeded job, all in result partition 0
+	Counters map[string]int64     // Counter totals the job reports
+}
+
+// TaskOutcome scripts the attempts of one task.
+type TaskOutcome struct {
+	Failures int  // Attempts that fail before one succeeds
+	Fail     bool // Every attempt fails, which fails the job
+}
+
+// FakeMaster answers control-plane requests with scripted jobs. Jobs run
+// as soon as they are submitted, in the request, unless their outcome
+// holds them; they do not wait for each other. It accepts any control
+// token.
+type FakeMaster struct {
+	address  string
+	dir      string
+	listener net.Listener
+
+	mu           sync.Mutex
+	outcomes     map[mapreduce.JobParse]Outcome
+	jobs         map[string]*fakeJob
+	submitted    []mapreduce.JobRequest
+	seq          int
+	reservations map[string]int // Slots of every reservation
+	reservedSeq  int
+	changed      chan struct{} // Closed when a job records an event
+}
+
+// fakeJob is a job submitted to a FakeMaster
+type fakeJob struct {
+	request mapreduce.JobRequest
+	outcome Outcome
+	status  mapreduce.JobStatus
+	events  []mapreduce.JobEvent
+}
+
+// NewFakeMaster starts a fake master on a unix socket in a temporary
+// directory, see Address. Jobs succeed unless Script says otherwise.
+// The fake does not speak TLS.
+func NewFakeMaster() (*FakeMaster, error) {
+	dir, err := os.MkdirTemp("", "mapreducetest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	f := &FakeMaster{
+		address:      filepath.Join(dir, "master.sock"),
+		dir:          dir,
+		outcomes:     make(map[mapreduce.JobParse]Outcome),
+		jobs:         make(map[string]*fakeJob),
+		reservations: make(map[string]int),
+		changed:      make(chan struct{}),
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Master", &controlPlane{f}); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to register fake master: %v", err)
+	}
+	if f.listener, err = net.Listen("unix", f.address); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen on %s: %v", f.address, err)
+	}
+	go server.Accept(f.listener)
+	return f, nil
+}
+
+// Address returns the address to connect clients to
+func (f *FakeMaster) Address() string {
+	return f.address
+}
+
+// Close stops the fake master and removes its socket
+func (f *FakeMaster) Close() error {
+	err := f.listener.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
+
+// Script sets the outcome of the jobs named name submitted from now on
+func (f *FakeMaster) Script(name mapreduce.JobParse, outcome Outcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outcomes[name] = outcome
+}
+
+// Submitted returns the requests of the jobs submitted so far, in
+// order, rejected ones included
+func (f *FakeMaster) Submitted() []mapreduce.JobRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]mapreduce.JobRequest(nil), f.submitted...)
+}
+
+// Job returns the status of a submitted job
+func (f *FakeMaster) Job(id string) (mapreduce.JobStatus, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return mapreduce.JobStatus{}, false
+	}
+	return job.snapshot(), true
+}
+
+// Finish runs a job held by its outcome to its end
+func (f *FakeMaster) Finish(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	job, ok := f.jobs[id]
+	if !ok {
+		return fmt.Errorf("unknown job %s", id)
+	}
+	if job.status.State != mapreduce.JobRunning {
+		return fmt.Errorf("job %s is %s", id, job.status.State)
+	}
+	f.play(job)
+	return nil
+}
+
+// Reservations returns the slots of every reservation made and not
+// released
+func (f *FakeMaster) Reservations() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reservations := make(map[string]int, len(f.reservations))
+	for id, n := range f.reservations {
+		reservations[id] = n
+	}
+	return reservations
+}
+
+// submit queues a job and starts it. The caller must hold the lock.
+func (f *FakeMaster) submit(request mapreduce.JobRequest) (string, error) {
+	f.submitted = append(f.submitted, request)
+	outcome := f.outcomes[request.Name]
+	if outcome.Reject != nil {
+		return "", outcome.Reject
+	}
+	if request.Name == "" {
+		return "", errors.New("job name cannot be empty")
+	}
+	if request.Reservation != "" && f.reservations[request.Reservation] == 0 {
+		return "", fmt.Errorf("unknown reservation %s", request.Reservation)
+	}
+
+	f.seq++
+	id := fmt.Sprintf("%s-%d", request.Name, f.seq)
+	job := &fakeJob{
+		request: request,
+		outcome: outcome,
+		status: mapreduce.JobStatus{
+			ID:      id,
+			Name:    request.Name,
+			State:   mapreduce.JobQueued,
+			NReduce: request.NReduce,
+		},
+	}
+	f.jobs[id] = job
+	f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventJobState})
+	f.setState(job, mapreduce.JobRunning)
+	if !outcome.Hold {
+		f.play(job)
+	}
+	return id, nil
+}
+
+// play runs the scripted phases of a running job and finishes it. The
+// caller must hold the lock.
+func (f *FakeMaster) play(job *fakeJob) {
+	mapTasks := job.outcome.MapTasks
+	if mapTasks == nil {
+		files := len(job.request.Files)
+		for _, group := range job.request.Inputs {
+			files += len(group.Files)
+		}
+		mapTasks = make([]TaskOutcome, files)
+	}
+	reduceTasks := job.outcome.ReduceTasks
+	if reduceTasks == nil {
+		reduceTasks = make([]TaskOutcome, job.status.NReduce)
+	}
+	for _, phase := range []struct {
+		name  mapreduce.JobParse
+		tasks []TaskOutcome
+	}{{mapPhase, mapTasks}, {reducePhase, reduceTasks}} {
+		if failed := f.playPhase(job, phase.name, phase.tasks); failed >= 0 {
+			f.finish(job, mapreduce.JobFailed, fmt.Sprintf("%s task %d failed", phase.name, failed))
+			return
+		}
+	}
+	if job.outcome.Error != "" {
+		f.finish(job, mapreduce.JobFailed, job.outcome.Error)
+		return
+	}
+	f.finish(job, mapreduce.JobSucceeded, "")
+}
+
+// playPhase runs the tasks of a phase one after the other and returns
+// the first task that failed for good, -1 if all completed
+func (f *FakeMaster) playPhase(job *fakeJob, name mapreduce.JobParse, tasks []TaskOutcome) int {
+	phase := mapreduce.PhaseState{Phase: name, Tasks: make([]mapreduce.TaskState, len(tasks)), Started: time.Now()}
+	for i := range phase.Tasks {
+		phase.Tasks[i] = mapreduce.TaskIdle
+	}
+	job.status.Phases = append(job.status.Phases, phase)
+	current := &job.status.Phases[len(job.status.Phases)-1]
+	job.status.Progress = mapreduce.JobProgress{Phase: name, Total: len(tasks)}
+	f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventPhaseStarted, Phase: name})
+
+	setTask := func(task int, state mapreduce.TaskState) {
+		current.Tasks[task] = state
+		if state == mapreduce.TaskCompleted {
+			current.Completed++
+			job.status.Progress.Completed = current.Completed
+		}
+		f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventTask, Phase: name, Task: task, TaskState: state})
+	}
+	for task, outcome := range tasks {
+		for attempt := 0; attempt < outcome.Failures || outcome.Fail; attempt++ {
+			setTask(task, mapreduce.TaskRunning)
+			current.Failures++
+			setTask(task, mapreduce.TaskIdle)
+			if outcome.Fail {
+				current.Ended = time.Now()
+				return task
+			}
+		}
+		setTask(task, mapreduce.TaskRunning)
+		setTask(task, mapreduce.TaskCompleted)
+	}
+	current.Ended = time.Now()
+	f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventPhaseEnded, Phase: name})
+	return -1
+}
+
+// setState records a lifecycle transition of a job. The caller must
+// hold the lock.
+func (f *FakeMaster) setState(job *fakeJob, state mapreduce.JobState) {
+	job.status.State = state
+	f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventJobState})
+}
+
+// finish records the outcome of a job. The caller must hold the lock.
+func (f *FakeMaster) finish(job *fakeJob, state mapreduce.JobState, reason string) {
+	job.status.Error = reason
+	if len(job.outcome.Counters) > 0 {
+		job.status.Counters = job.outcome.Counters
+	}
+	job.status.State = state
+	f.record(job, mapreduce.JobEvent{Kind: mapreduce.EventJobState, Error: reason})
+}
+
+// record appends an event to a job's events and wakes watchers. The
+// caller must hold the lock.
+func (f *FakeMaster) record(job *fakeJob, event mapreduce.JobEvent) {
+	event.Seq = int64(len(job.events) + 1)
+	event.Time = time.Now()
+	event.State = job.status.State
+	event.Progress = job.status.Progress
+	job.events = append(job.events, event)
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// snapshot returns the status of the job, sharing no memory with it
+func (job *fakeJob) snapshot() mapreduce.JobStatus {
+	status := job.status
+	status.Phases = nil
+	for _, phase := range job.status.Phases {
+		phase.Tasks = append([]mapreduce.TaskState(nil), phase.Tasks...)
+		status.Phases = append(status.Phases, phase)
+	}
+	return status
+}
+
+// lookup finds a submitted job. The caller must hold the lock.
+func (f *FakeMaster) lookup(id string) (*fakeJob, error) {
+	job, ok := f.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %s", id)
+	}
+	return job, nil
+}
+
+// finished reports whether a job has ended
+func finished(state mapreduce.JobState) bool {
+	return state == mapreduce.JobSucceeded || state == mapreduce.JobFailed || state == mapreduce.JobCancelled
+}
+
+// preview picks n records from the start, the end and random positions
+// of records
+func preview(records []mapreduce.KeyValue, n int) mapreduce.OutputPreview {
+	var p mapreduce.OutputPreview
+	p.First = append(p.First, records[:min(n, len(records))]...)
+	p.Last = append(p.Last, records[max(len(records)-n, 0):]...)
+	sample := rand.Perm(len(records))[:min(n, len(records))]
+	sort.Ints(sample)
+	for _, i := range sample {
+		p.Sample = append(p.Sample, records[i])
+	}
+	return p
+}
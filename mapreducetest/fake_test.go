@@ -0,0 +1,85 @@
+package mapreducetest
+
+import (
+	"errors"
+	"testing"
+
+	"mapreduce"
+	"mapreduce/client"
+)
+
+// TestFakeMaster drives scripted jobs through the real client
+func TestFakeMaster(t *testing.T) {
+	f, err := NewFakeMaster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Script("wordcount", Outcome{
+		MapTasks: []TaskOutcome{{}, {Failures: 2}},
+		Result:   []mapreduce.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}},
+		Counters: map[string]int64{"lines": 7},
+	})
+	f.Script("broken", Outcome{ReduceTasks: []TaskOutcome{{Fail: true}}})
+	f.Script("held", Outcome{Hold: true})
+	f.Script("refused", Outcome{Reject: errors.New("queue full")})
+
+	c, err := client.Connect(f.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	id, err := c.Submit(mapreduce.JobRequest{Name: "wordcount", Files: []string{"a", "b"}, NReduce: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != mapreduce.JobSucceeded || status.Phases[0].Failures != 2 || status.Counters["lines"] != 7 {
+		t.Errorf("status %+v, want a succeeded job with 2 map failures and its counters", status)
+	}
+	records, err := c.FetchResult(id)
+	if err != nil || len(records) != 2 {
+		t.Errorf("result %v, %v, want the 2 scripted records", records, err)
+	}
+
+	id, err = c.Submit(mapreduce.JobRequest{Name: "broken", Files: []string{"a"}, NReduce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := c.Status(id); status.State != mapreduce.JobFailed || status.Error != "Reduce task 0 failed" {
+		t.Errorf("status %+v, want a failed reduce task", status)
+	}
+
+	if _, err := c.Submit(mapreduce.JobRequest{Name: "refused", Files: []string{"a"}, NReduce: 1}); err == nil {
+		t.Error("submitted a job scripted to be rejected")
+	}
+
+	id, err = c.Submit(mapreduce.JobRequest{Name: "held", Files: []string{"a"}, NReduce: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan mapreduce.JobState)
+	go func() {
+		var last mapreduce.JobEvent
+		if err := c.Follow(id, func(event mapreduce.JobEvent) { last = event }); err != nil {
+			t.Error(err)
+		}
+		done <- last.State
+	}()
+	if status, _ := f.Job(id); status.State != mapreduce.JobRunning {
+		t.Errorf("held job is %s, want running", status.State)
+	}
+	if err := f.Finish(id); err != nil {
+		t.Fatal(err)
+	}
+	if state := <-done; state != mapreduce.JobSucceeded {
+		t.Errorf("followed held job to %s, want succeeded", state)
+	}
+	if n := len(f.Submitted()); n != 4 {
+		t.Errorf("%d jobs submitted, want 4", n)
+	}
+}
@@ -3,10 +3,14 @@
 package mapreduce
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math/rand/v2"
 	"net"
+	"net/rpc"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Master represents the master node of the MapReduce framework
@@ -18,23 +22,147 @@ type Master struct {
 	address string   // Network address of the master node
 	files   []string // List of input files to be processed
 
-	// Synchronization
+	// Workers, job queue and lock, shared with the masters of the
+	// running jobs, see newJobMaster
+	*cluster
+
+	// Runtime state
+	phase     JobParse     // Phase being scheduled, empty while no job runs
+	listener  net.Listener // Network listener for RPC server, nil for http:// masters
+	rpcServer *rpc.Server  // RPC server of http:// masters, see Mount
+	rpcClosed atomic.Bool  // Whether the RPC server of an http:// master shut down
+
+	dashboardAddr string       // TCP address of the dashboard, none if empty
+	dashboard     net.Listener // Listener of the dashboard, see WithDashboard
+
+	authToken      string          // Credential workers must present, empty to accept any worker
+	controlTokens  map[string]Role // Role each control-plane token grants, nil to grant every client all roles
+	auditPath      string          // Audit log, see WithAuditLog, empty for none
+	checkpointPath string          // Checkpoint a standby takes over from, see WithCheckpoint, empty for none
+	timeouts       RPCTimeouts     // Timeouts of the RPCs the master makes
+	maxMessage     int             // Most file or result bytes per reply, see WithMaxMessageSize
+	logger         nodeLog         // Destination of log messages, see WithLogger
+
+	// Worker liveness, disabled unless heartbeat is set
+	heartbeat       time.Duration // Interval of worker heartbeats
+	heartbeatMisses int           // Heartbeats a worker may miss before it is dropped
+
+	// Job archive
+	archiveDir     string // Directory receiving the job archive, empty to disable
+	archiveOutputs bool   // Whether reduce outputs are included in the archive
+
+	// Pluggable components
+	merger       Merger       // Strategy used to combine reduce outputs
+	options      JobOptions   // Settings shipped to workers with every task
+	filePriority FilePriority // Scheduling priority of map tasks, nil to schedule in file order
+	fairBuckets  int          // Size buckets tasks are interleaved from, see WithFairScheduling
+	outputFormat OutputFormat // Format of the result file, nil for TextOutput
+	resultDir    string       // Directory of the result file, the configured result path if empty
+
+	// Sequential jobs only
+	combineF   func(string, []string) string // Combiner of map tasks, nil for none
+	mapCtxF    ContextMapFunc                // Replaces the map function when set
+	reduceCtxF ContextReduceFunc             // Replaces the reduce function when set
+	streamMapF StreamMapFunc                 // Replaces the map function when set
+
+	streamReduceF StreamReduceFunc // Replaces the reduce function when set
+
+	bytesPerReducer int64                      // Input size per reduce task of AutoReduce jobs
+	verifyShare     float64                    // Share of tasks run twice to check determinism
+	maxInFlight     int                        // Tasks of the job running at once, zero for no limit
+	retryPolicy     RetryPolicy                // Where failed tasks rejoin the task queue
+	retryDelay      time.Duration              // How long RetryAfterDelay holds failed tasks back
+	phaseTimeouts   map[JobParse]time.Duration // Longest a phase may run, see WithPhaseTimeouts
+	watchdogStall   time.Duration              // How long a phase may go without a completed task, zero for no watchdog
+	watchdogAction  WatchdogAction             // What the watchdog does about stalled phases
+	rateLimits      map[string]*tokenBucket    // Rate limits tasks draw from, see TaskContext.Acquire
+
+	schemaViolations int         // Map output records of the current job violating its schema
+	counters         jobCounters // Counters of the current job's tasks, see TaskContext.Count
+
+	// Journal of the current job, see WithJournal
+	journaled bool          // Whether the job is journaled
+	journal   *jobJournal   // Journal being written, nil if none
+	resumed   *journalState // Tasks completed by an earlier run of the job, nil unless resumed
+
+	// Reduce task pruning of jobs filtering keys, see WithKeyPrefixes
+	partitionRecords map[int][]int64 // Records every map task wrote to every partition
+	pruned           []bool          // Reduce tasks skipped as their partitions are empty
+
+	producers map[int]string // Worker that wrote the output of every map task, see master_lost.go
+
+	metricsLabels map[string]string // Labels added to the job's metrics, see WithMetricsLabels
+
+	// Progress reporting, see WithProgress
+	progress  []JobProgress // Tasks completed in every phase of the current job
+	progressF ProgressFunc  // Called on every completed task, may be nil
+
+	// Result publication, see WithPublish
+	registry      Registry          // Registry receiving the output, nil to publish nothing
+	dataset       string            // Dataset the output is published as
+	datasetSchema map[string]string // Description of the published records
+	splits        []SplitInfo       // Input of every map task of the current job
+	inputGroups   []InputGroup      // Named input of the current job besides its files
+
+	// Worker preparation, see Worker.Prepare
+	binaryVersion string         // Binary version workers must run, empty for any
+	sideInputs    []string       // Files workers must be able to read
+	shipInputs    bool           // Whether workers download the side inputs from the master
+	shipHashes    []string       // Content hash of every shipped side input of the current job
+	prepared      map[string]int // Worker generation prepared for the current job
+
+	workerBuilds map[string]WorkerBuild // Code of the workers prepared for the current job, see JobEnvironment
+
+	// Job queue
+	defaults       []Option              // Options every submitted job starts from
+	concurrentJobs int                   // Jobs run at once, see WithConcurrentJobs
+	forwarded      map[string]forwarding // Slots the phase being scheduled holds, nil between phases
+	reservation    string                // Reservation of the current job, empty for none
+}
+
+// cluster is the state of a master shared by all jobs it runs: the
+// registered workers, the job queue and the lock guarding both
+type cluster struct {
 	sync.Mutex            // Mutex for protecting shared resources
 	newCond    *sync.Cond // Condition variable for worker registration notifications
 
-	// Runtime state
-	workers  []string      // List of registered worker addresses
-	listener net.Listener  // Network listener for RPC server
-	shutdown chan struct{} // Channel to signal shutdown to all goroutines
-	stats    []int
+	workers    []string                   // List of registered worker addresses
+	workerGen  map[string]int             // Times each worker was added to workers
+	workerRegs map[string]RegisterArgs    // Registration of each worker, without its token
+	liveness   map[string]*workerLiveness // Heartbeat state per worker
+	shutdown   chan struct{}              // Channel to signal shutdown to all goroutines
+	result     JobResult                  // Worker summaries gathered at shutdown
+	err        error                      // Why the master's job failed, see Wait
+	outputs    []string                   // Reduce output files of the last successful job
+
+	cleanupOnce   sync.Once                // Guards closing shutdown
+	stopOnce      sync.Once                // Guards shutting down workers and the RPC server
+	handedOff     bool                     // Whether a successor took over the listener
+	stopping      bool                     // Whether Stop was called, rejecting new jobs
+	auditMu       sync.Mutex               // Serialises appends to the audit log
+	lateSummaries map[string]WorkerSummary // Summaries of workers whose shutdown reply came late, by worker
+	crashes       []CrashReport            // Last words of workers that died
+	hashCache     map[string]shippedHash   // Content hashes of the shipped files of every job, see hashSideInputs
+
+	jobs    chan *JobHandle       // Submitted jobs waiting to run
+	pending int                   // Number of jobs in the queue
+	jobSeq  int                   // Sequence number of the last submitted job
+	handles map[string]*JobHandle // Submitted jobs by ID
+	running []runningJob          // Jobs being run, in the order they started
+	last    *Master               // Master of the job started last, nil before the first
+
+	// Slot reservations, see ReserveSlots
+	reservations   map[string]*reservation // Reservations by ID
+	reservationSeq int                     // Sequence number of the last reservation
 }
 
 // newMaster creates and initializes a new Master instance
 func newMaster(master string) *Master {
-	mr := &Master{}
-	mr.newCond = sync.NewCond(mr)
+	mr := &Master{cluster: &cluster{}}
+	mr.newCond = sync.NewCond(mr.cluster)
 	mr.address = master
 	mr.shutdown = make(chan struct{})
+	mr.logger.attrs = []any{"master", master}
 	return mr
 }
 
@@ -42,77 +170,302 @@ func newMaster(master string) *Master {
 // Parameters:
 //   - jobName: Name of the job to distinguish between different MapReduce tasks
 //   - files: List of input files, each serving as input for the Map phase
-//   - nReduce: Number of reduce tasks, determining the parallelism level in Reduce phase
+//   - nReduce: Number of reduce tasks, determining the parallelism level in Reduce phase, or AutoReduce
 //   - mapF: User-defined Map function to process input files and generate intermediate key-value pairs
 //   - reduceF: User-defined Reduce function to process intermediate key-value pairs and generate final results
+//   - opts: Optional master configuration such as a custom merge strategy
 func Sequential(
 	jobName JobParse,
 	files []string,
 	nReduce int,
 	mapF func(string, string) []KeyValue,
 	reduceF func(string, []string) string,
+	opts ...Option,
+) error {
+	return SequentialContext(context.Background(), jobName, files, nReduce, mapF, reduceF, opts...)
+}
+
+// SequentialContext runs a job like Sequential until ctx is done. The
+// task running then stops at its next record or reduce group, the
+// remaining tasks and the merge are skipped, and ErrJobCancelled is
+// returned.
+func SequentialContext(
+	ctx context.Context,
+	jobName JobParse,
+	files []string,
+	nReduce int,
+	mapF func(string, string) []KeyValue,
+	reduceF func(string, []string) string,
+	opts ...Option,
 ) error {
 	if len(files) == 0 {
 		return fmt.Errorf("no input files provided")
 	}
-	if nReduce <= 0 {
+	if nReduce <= 0 && nReduce != AutoReduce {
 		return fmt.Errorf("invalid number of reduce tasks: %d", nReduce)
 	}
 	if mapF == nil || reduceF == nil {
 		return fmt.Errorf("map and reduce functions cannot be nil")
 	}
+	if configErr != nil {
+		return configErr
+	}
 
 	master := newMaster("master")
-	master.run(jobName, files, nReduce, func(phase JobParse) {
+	defer master.cleanup()
+	master.applyOptions(opts)
+	if nReduce == AutoReduce {
+		nReduce = autoReduceCount(files, master.bytesPerReducer)
+	}
+	// A failing streaming reduce function stops the job before the
+	// merge, as does ctx
+	run, stop := context.WithCancel(ctx)
+	defer stop()
+	var taskErr error
+	err := master.run(jobName, files, nReduce, func(phase JobParse) {
 		switch phase {
 		case mapParse:
-			master.runMapTasks(mapF)
+			taskErr = master.runMapTasks(run, mapF)
 		case reduceParse:
-			master.runReduceTasks(reduceF)
+			taskErr = master.runReduceTasks(run, reduceF)
 		}
-	}, nil)
-	return nil
+		if taskErr != nil {
+			stop()
+		}
+	}, run.Done())
+	if taskErr != nil && ctx.Err() == nil {
+		return taskErr
+	}
+	return err
 }
 
-// runMapTasks executes all Map tasks
-func (mr *Master) runMapTasks(mapF func(string, string) []KeyValue) {
-	for i, file := range mr.files {
-		doMap(mr.jobName, i, file, mr.nReduce, mapF)
+// runMapTasks executes all Map tasks until ctx is done
+func (mr *Master) runMapTasks(run context.Context, mapF func(string, string) []KeyValue) error {
+	for i, split := range mr.splits {
+		var metrics TaskMetrics
+		var err error
+		if mr.streamMapF != nil {
+			metrics, err = doStreamMap(run, mr.jobName, i, split, mr.nReduce, mr.streamMapF, mr.options, nil)
+		} else {
+			ctx := newTaskContext(mr.jobName, mapParse, i, mr.options)
+			ctx.Group = split.Group
+			ctx.acquire = mr.acquireTokens
+			ctx.ctx = run
+			metrics, err = doMap(run, mr.jobName, i, split, mr.nReduce, bindMap(mr.mapCtxF, mapF, ctx), mr.combineF, mr.options, nil)
+			mr.counters.set(mapParse, i, ctx.counterValues())
+		}
+		if err != nil {
+			return fmt.Errorf("map task %d: %w", i, err)
+		}
+		mr.addSchemaViolations(metrics.SchemaViolations)
+		mr.addPartitionRecords(i, metrics.PartitionRecords)
+		mr.setProgress(mapParse, i+1, len(mr.splits))
 	}
+	return nil
 }
 
-// runReduceTasks executes all Reduce tasks
-func (mr *Master) runReduceTasks(reduceF func(string, []string) string) {
+// runReduceTasks executes all Reduce tasks until ctx is done and
+// returns the first error of a streaming reduce function
+func (mr *Master) runReduceTasks(run context.Context, reduceF func(string, []string) string) error {
 	nFiles := len(mr.files)
 	for i := 0; i < mr.nReduce; i++ {
-		doReduce(mr.jobName, i, mergeName(mr.jobName, i), nFiles, reduceF)
+		if mr.isPruned(i) {
+			mr.setProgress(reduceParse, i+1, mr.nReduce)
+			continue
+		}
+		var err error
+		if mr.streamReduceF != nil {
+			_, err = doStreamReduce(run, mr.jobName, i, mr.workspace().ReduceOutput(i), nFiles, mr.streamReduceF, mr.options, nil)
+		} else {
+			ctx := newTaskContext(mr.jobName, reduceParse, i, mr.options)
+			ctx.acquire = mr.acquireTokens
+			ctx.ctx = run
+			_, err = doReduce(run, mr.jobName, i, mr.workspace().ReduceOutput(i), nFiles, bindReduce(mr.reduceCtxF, reduceF, ctx), mr.options, nil)
+			mr.counters.set(reduceParse, i, ctx.counterValues())
+		}
+		if err != nil {
+			return fmt.Errorf("reduce task %d: %w", i, err)
+		}
+		mr.setProgress(reduceParse, i+1, mr.nReduce)
 	}
+	return nil
 }
 
-// run schedules Map and Reduce tasks in sequence
+// run schedules Map and Reduce tasks in sequence.
+// Input files are sorted before tasks are numbered, so map task i
+// always processes the i-th file in lexical order, or the i-th split
+// when files are split; the resulting mapping is recorded in the job
+// manifest.
+// Closing cancel stops the job after the phase being scheduled.
 func (mr *Master) run(
 	jobName JobParse,
 	files []string,
 	nReduce int,
 	schedule func(phase JobParse),
-	finish func(),
-) {
-	defer mr.cleanup()
-
-	mr.files = files
+	cancel <-chan struct{},
+) (err error) {
+	if _, err := mr.options.keyOrder(); err != nil {
+		return err
+	}
+	if _, err := mr.options.keyFilter(); err != nil {
+		return err
+	}
+	if err := mr.options.checkColumns(); err != nil {
+		return err
+	}
+	if err := mr.options.checkRangeBounds(nReduce); err != nil {
+		return err
+	}
+	if _, err := mr.options.builtin(); err != nil {
+		return err
+	}
+	if err := mr.hashSideInputs(); err != nil {
+		return err
+	}
+	mr.options.GroupFormats = nil
+	for _, group := range mr.inputGroups {
+		if group.Format == "" {
+			continue
+		}
+		if mr.options.GroupFormats == nil {
+			mr.options.GroupFormats = make(map[string]string)
+		}
+		mr.options.GroupFormats[group.Name] = group.Format
+	}
+	splits, err := inputSplits(files, mr.inputGroups, mr.options)
+	if err != nil {
+		return err
+	}
+	mr.splits = splits
+	for i := range mr.splits {
+		mr.splits[i].Task = i
+	}
+	mr.files = make([]string, len(mr.splits))
+	for i, split := range mr.splits {
+		mr.files[i] = split.File
+	}
 	mr.nReduce = nReduce
 	mr.jobName = jobName
+	mr.schemaViolations = 0
+	mr.counters.reset()
+	mr.partitionRecords = make(map[int][]int64)
+	mr.producers = make(map[int]string)
+	mr.pruned = nil
+	mr.progress = nil
+	ws := mr.workspace()
+	var resumed *journalState
+	if mr.journaled && isRemotePath(ws.Root) {
+		mr.logger.warnf("Journal: remote workspace %s is not journaled", ws.Root)
+	} else if mr.journaled {
+		resumed = mr.resumeJournal(ws)
+	}
+	for mr.options.Seed == 0 {
+		mr.options.Seed = rand.Int64()
+	}
+	if err := ws.Create(); err != nil {
+		mr.logger.warnf("Workspace: %v", err)
+	}
+	removeCacheSpills(ws, nReduce)
+	removePendingOutputs(ws)
+	if resumed == nil {
+		removeFile(ws.ZstdDictionary()) // Trained on a previous run's output
+	}
+	if replica := mr.options.replica(jobName); replica != nil {
+		removeCacheSpills(replica, nReduce)
+		removePendingOutputs(replica)
+		removeFile(replica.ZstdDictionary())
+	}
+
+	manifest := newJobManifest(jobName, mr.splits, nReduce)
+	manifest.Seed = mr.options.Seed
+	if err := manifest.Write(ws.Manifest()); err != nil {
+		mr.logger.warnf("Manifest: %v", err)
+	}
+	env := mr.newJobEnvironment()
+	if err := env.Write(ws.Environment()); err != nil {
+		mr.logger.warnf("Environment: %v", err)
+	}
+	if mr.journaled && !isRemotePath(ws.Root) {
+		journal, journalErr := mr.openJournal(ws, resumed)
+		if journalErr != nil {
+			mr.logger.warnf("Journal: %v", journalErr)
+		}
+		mr.Lock()
+		mr.journal, mr.resumed = journal, resumed
+		mr.Unlock()
+		defer func() {
+			journal.close(err == nil)
+			mr.Lock()
+			mr.journal, mr.resumed = nil, nil
+			mr.Unlock()
+		}()
+	}
 
-	schedule(mapParse)
-	schedule(reduceParse)
-	if finish != nil {
-		finish()
+	for _, phase := range []JobParse{mapParse, reduceParse} {
+		mr.setPhase(phase)
+		if phase == mapParse {
+			mr.startProgress(phase, len(mr.splits))
+		} else {
+			mr.startProgress(phase, mr.nReduce)
+		}
+		schedule(phase)
+		mr.sweepPendingOutputs()
+		if isClosed(cancel) {
+			mr.setPhase("")
+			return ErrJobCancelled
+		}
+		if phase == mapParse {
+			if err := mr.checkSchemaViolations(); err != nil {
+				mr.setPhase("")
+				return err
+			}
+			mr.pruneReduceTasks()
+		}
+		mr.journal.completePhase(phase)
 	}
+
+	mr.setPhase("")
 	mr.merge()
+	mr.setOutputs(ws.ReduceOutputs(nReduce))
+	mr.Lock()
+	env.finish(mr.workerBuilds)
+	mr.Unlock()
+	if err := env.Write(ws.Environment()); err != nil {
+		mr.logger.warnf("Environment: %v", err)
+	}
+	if err := writeCounters(ws.Counters(), mr.counters.totals()); err != nil {
+		mr.logger.warnf("Counters: %v", err)
+	}
+	mr.archiveWorkspace()
+	return mr.publishResult()
 }
 
-// Register handles worker registration RPC requests
-func (mr *Master) Register(args *RegisterArgs, _ *struct{}) error {
+// workspace returns the directory layout of the current job
+func (mr *Master) workspace() *JobWorkspace {
+	return mr.options.workspace(mr.jobName)
+}
+
+// sweepPendingOutputs deletes the output that attempts of the phase
+// just scheduled never committed, in the workspace and its replica
+func (mr *Master) sweepPendingOutputs() {
+	removePendingOutputs(mr.workspace())
+	if replica := mr.options.replica(mr.jobName); replica != nil {
+		removePendingOutputs(replica)
+	}
+}
+
+// setPhase records the phase currently being scheduled
+func (mr *Master) setPhase(phase JobParse) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.phase = phase
+}
+
+// Register handles worker registration RPC requests.
+// The reply tells the worker whether a job is active and which
+// settings the job started last uses. Workers registering while no job
+// runs are kept and receive tasks once the next job starts.
+func (mr *Master) Register(args *RegisterArgs, reply *RegisterReply) error {
 	if args == nil || args.Worker == "" {
 		return fmt.Errorf("invalid worker registration arguments")
 	}
@@ -120,96 +473,293 @@ func (mr *Master) Register(args *RegisterArgs, _ *struct{}) error {
 	mr.Lock()
 	defer mr.Unlock()
 
-	mr.workers = append(mr.workers, args.Worker)
-	mr.newCond.Broadcast()
+	if !validToken(mr.authToken, args.Token) {
+		mr.logger.warnf("Register: rejected worker %s with an invalid token", args.Worker)
+		return fmt.Errorf("invalid worker token")
+	}
+
+	mr.trackWorker(args.Worker)
+	mr.addWorker(args.Worker)
+	if mr.workerRegs == nil {
+		mr.workerRegs = make(map[string]RegisterArgs)
+	}
+	reg := *args
+	reg.Token = ""
+	mr.workerRegs[args.Worker] = reg
+
+	active := mr.pending > 0
+	for _, r := range mr.running {
+		active = active || r.master.phase != ""
+	}
+	job := mr.lastJob()
+	*reply = RegisterReply{
+		JobActive:   active,
+		JobName:     job.jobName,
+		Phase:       job.phase,
+		Compression: job.options.Compression,
+		Workspace:   job.workspace().Root,
+		PollAfter:   registrationPollInterval,
+
+		HeartbeatInterval: mr.heartbeat,
+	}
 	return nil
 }
 
-// forwardRegistration forwards registered worker information to the scheduler
-func (mr *Master) forwardRegistration(ch chan string) {
-	i := 0
+// forwardRegistration forwards registered worker information to the
+// scheduler of one phase until stop is closed or the master shuts down.
+// Every worker is prepared for the job and then forwarded once per
+// task slot it registered with, so the scheduler runs that many of its
+// tasks at once; slots reserved for other jobs are left out until they
+// are released, as are slots other running jobs hold. The slots handed
+// out are recorded in forwarded, see startForwarding. A worker that left
+// or was dropped is forwarded again once it has registered again.
+func (mr *Master) forwardRegistration(ch chan string, stop chan struct{}, forwarded map[string]forwarding) {
 	for {
+		mr.Lock()
+		w, slots := mr.nextUnforwarded(forwarded)
+		for w == "" && !isClosed(stop) && !isClosed(mr.shutdown) {
+			mr.newCond.Wait()
+			w, slots = mr.nextUnforwarded(forwarded)
+		}
+		if isClosed(stop) || isClosed(mr.shutdown) {
+			mr.Unlock()
+			return
+		}
+		mr.Unlock()
+
+		// Workers are prepared concurrently, so a slow one does not
+		// hold back the others
+		go mr.forwardWorker(ch, stop, w, slots)
+	}
+}
+
+// forwardWorker prepares a worker for the job and forwards it to the
+// scheduler once per task slot
+func (mr *Master) forwardWorker(ch chan string, stop chan struct{}, w string, slots int) {
+	if !mr.prepareWorker(w) {
+		return
+	}
+	for i := 0; i < slots; i++ {
 		select {
+		case ch <- w:
+		case <-stop:
+			return
 		case <-mr.shutdown:
-			close(ch)
 			return
-		default:
-			mr.Lock()
-			if len(mr.workers) > i {
-				w := mr.workers[i]
-				i++
-				select {
-				case ch <- w:
-				case <-mr.shutdown:
-					mr.Unlock()
-					close(ch)
-					return
-				}
-			} else {
-				mr.newCond.Wait()
-			}
-			mr.Unlock()
 		}
 	}
 }
 
+// forwarding is what forwardRegistration handed the scheduler of a
+// worker
+type forwarding struct {
+	gen   int // Generation of the worker
+	slots int // Slots forwarded of the generation
+}
+
+// nextUnforwarded returns the first registered worker with slots the
+// job may use that were not forwarded yet, and their number, and
+// records them in forwarded. It returns "" if there is none. Slots of
+// earlier generations of a worker do not count, and the phase holds no
+// more than its share of all slots while other jobs run. The caller
+// must hold the lock.
+func (mr *Master) nextUnforwarded(forwarded map[string]forwarding) (string, int) {
+	share := mr.slotShare() - mr.heldSlots(forwarded)
+	for _, w := range mr.workers {
+		if share <= 0 {
+			break
+		}
+		f := forwarded[w]
+		if f.gen != mr.workerGen[w] {
+			f = forwarding{gen: mr.workerGen[w]}
+		}
+		if n := min(mr.jobSlots(w)-mr.leasedSlots(w)-f.slots, share); n > 0 {
+			f.slots += n
+			forwarded[w] = f
+			return w, n
+		}
+	}
+	return "", 0
+}
+
+// startForwarding returns the record of the slots the phase about to
+// be scheduled holds, which other running jobs leave alone
+func (mr *Master) startForwarding() map[string]forwarding {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.forwarded = make(map[string]forwarding)
+	return mr.forwarded
+}
+
+// stopForwarding ends the forwarding goroutine of a finished phase and
+// returns its slots to the other running jobs
+func (mr *Master) stopForwarding(stop chan struct{}) {
+	close(stop)
+	mr.Lock()
+	mr.forwarded = nil
+	mr.newCond.Broadcast()
+	mr.Unlock()
+}
+
 // Distributed executes MapReduce tasks in distributed mode
 // Parameters:
 //   - jobName: Name of the job
 //   - files: List of input files
-//   - nReduce: Number of reduce tasks
+//   - nReduce: Number of reduce tasks, or AutoReduce to size them from the input
 //   - master: Master node identifier
-func Distributed(jobName JobParse, files []string, nReduce int, master string) (mr *Master) {
-	mr = &Master{
-		jobName:  jobName,
-		files:    files,
-		nReduce:  nReduce,
-		address:  master,
-		shutdown: make(chan struct{}),
-	}
-	mr.newCond = sync.NewCond(mr)
-
-	mr.startRPCServer() // Start RPC server
-
-	// Execute job scheduling
-	go mr.run(mr.jobName, mr.files, mr.nReduce, func(phase JobParse) {
-		ch := make(chan string)
-		go mr.forwardRegistration(ch)
-		schedule(mr.jobName, mr.files, mr.nReduce, phase, ch)
-	}, func() {
-		mr.stats = mr.killWorkers()
-		mr.stopRPCServer()
-	})
+//   - opts: Optional master configuration such as a custom merge strategy
+//
+// Distributed runs a single job on a master of its own: once the job
+// has finished, the workers and the master are shut down. Use
+// StartMaster and Submit to run several jobs on one master.
+func Distributed(jobName JobParse, files []string, nReduce int, master string, opts ...Option) (mr *Master) {
+	return DistributedContext(context.Background(), jobName, files, nReduce, master, opts...)
+}
+
+// DistributedContext runs a job like Distributed until ctx is done.
+// The job is cancelled then: no more tasks are handed out, the workers
+// abort the tasks they are running, and the cluster shuts down without
+// merging the output.
+// A master that cannot start, or a job that cannot be submitted, ends
+// at once; Wait returns why.
+func DistributedContext(ctx context.Context, jobName JobParse, files []string, nReduce int, master string, opts ...Option) (mr *Master) {
+	mr, err := StartMaster(master, opts...)
+	if err != nil {
+		mr = newMaster(master)
+		mr.applyOptions(opts)
+		mr.fail(fmt.Errorf("start master: %w", err))
+		mr.cleanup()
+		return mr
+	}
 
-	log.Printf("Starting master at %s", master)
+	handle, err := mr.SubmitContext(ctx, JobConfig{Name: jobName, Files: files, NReduce: nReduce})
+	if err != nil {
+		mr.fail(fmt.Errorf("submit job %s: %w", jobName, err))
+		mr.shutdownCluster()
+		return mr
+	}
+
+	go func() {
+		if err := handle.Wait(); err != nil {
+			mr.logger.errorf("Job %s failed: %v", jobName, err)
+			mr.fail(err)
+		}
+		if mr.isHandedOff() {
+			// Workers and the socket now belong to the successor
+			return
+		}
+		mr.shutdownCluster()
+	}()
 	return mr
 }
 
 // Add cleanup method
 func (mr *Master) cleanup() {
-	if mr.listener != nil {
-		mr.listener.Close()
-	}
-	close(mr.shutdown)
+	mr.cleanupOnce.Do(func() {
+		if mr.listener != nil {
+			mr.listener.Close()
+		}
+		close(mr.shutdown)
+		if mr.dashboard != nil {
+			mr.dashboard.Close()
+		}
+
+		// Wake up goroutines waiting for worker registrations
+		mr.Lock()
+		mr.newCond.Broadcast()
+		mr.Unlock()
+	})
 }
 
-func (mr *Master) killWorkers() []int {
+// isHandedOff reports whether a successor took over the master
+func (mr *Master) isHandedOff() bool {
 	mr.Lock()
 	defer mr.Unlock()
-	ntask := make([]int, 0, len(mr.workers))
-	for _, w := range mr.workers {
-		fmt.Printf("Master:Shutdown worker %s\n", w)
-		var reply ShutdownReply
-		ok := call(w, ShutdownMethod, new(struct{}), &reply)
-		if !ok {
-			log.Fatalf("Master:RPC %s Shutdown failed", w)
+	return mr.handedOff
+}
+
+// killWorkers shuts down every registered worker and gathers their
+// summaries, in registration order. Workers are asked in parallel, so
+// a worker that never answers delays the shutdown by one RPC timeout
+// at most; it is listed as not having responded. Replies arriving
+// within lateShutdownWait after that are kept for Result.
+func (mr *Master) killWorkers() JobResult {
+	mr.Lock()
+	workers := append([]string{}, mr.workers...)
+	timeout := mr.timeouts.forMethod(ShutdownMethod)
+	mr.Unlock()
+
+	var mu sync.Mutex
+	collected := false
+	summaries := make([]WorkerSummary, len(workers))
+	for i, w := range workers {
+		summaries[i] = WorkerSummary{Worker: w}
+	}
+	replyTimeout := timeout
+	if timeout >= 0 {
+		replyTimeout += lateShutdownWait
+	}
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mr.logger.infof("Master:Shutdown worker %s", w)
+			var reply ShutdownReply
+			if !callTimeout(w, ShutdownMethod, &ShutdownArgs{Token: mr.authToken}, &reply, replyTimeout) {
+				// The worker may already be gone, e.g. after a Ctrl-C
+				// delivered to the whole process group
+				mr.logger.warnf("Master:RPC %s Shutdown failed", w)
+				return
+			}
+			summary := reply.Summary
+			summary.Worker = w
+			summary.Responded = true
+			mu.Lock()
+			late := collected
+			if !late {
+				summaries[i] = summary
+			}
+			mu.Unlock()
+			if late {
+				mr.recordLateSummary(summary)
+			}
+		}()
+	}
+	replied := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(replied)
+	}()
+	if timeout >= 0 {
+		select {
+		case <-replied:
+		case <-time.After(timeout):
 		}
-		ntask = append(ntask, reply.Ntasks)
+	} else {
+		<-replied
 	}
-	return ntask
+
+	mu.Lock()
+	defer mu.Unlock()
+	collected = true
+	return JobResult{Workers: append([]WorkerSummary(nil), summaries...)}
 }
 
-// Wait blocks until the MapReduce job is complete
-func (mr *Master) Wait() {
+// Wait blocks until the MapReduce job is complete and returns why it
+// failed: the error of the job run by Distributed, or why the master
+// could not run it. Masters started with StartMaster return nil; the
+// errors of their jobs are returned by JobHandle.Wait.
+func (mr *Master) Wait() error {
 	<-mr.shutdown
+	mr.Lock()
+	defer mr.Unlock()
+	return mr.err
+}
+
+// fail records why the master's job failed, for Wait
+func (mr *Master) fail(err error) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.err = err
 }
@@ -0,0 +1,102 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Role is what a control-plane client may do, see WithControlTokens.
+type Role int
+
+const (
+	// RoleObserver may read the state, events and results of jobs and
+	// of the master, e.g. dashboards and `status -follow`
+	RoleObserver Role = iota + 1
+	// RoleOperator may also submit and cancel jobs and reserve slots
+	RoleOperator
+)
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case RoleObserver:
+		return "observer"
+	case RoleOperator:
+		return "operator"
+	}
+	return "none"
+}
+
+// ErrPermissionDenied is returned for control requests whose token does
+// not grant the role they need.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// WithControlTokens makes the master check the token of every
+// control-plane request, over RPC and HTTP, against tokens, which maps
+// each token to the role it grants. Observers may follow jobs and read
+// their status and results but not change anything; operators may also
+// submit and cancel jobs and reserve slots. Without control tokens any
+// client may do anything. Tokens are compared verbatim; the worker
+// token, see WithWorkerToken, is separate.
+func WithControlTokens(tokens map[string]Role) Option {
+	return func(mr *Master) {
+		mr.controlTokens = tokens
+	}
+}
+
+// authorize checks that token grants role
+func (mr *Master) authorize(token string, role Role) error {
+	if len(mr.controlTokens) == 0 {
+		return nil
+	}
+	granted := Role(0)
+	for t, r := range mr.controlTokens {
+		if t != "" && validToken(t, token) {
+			granted = r
+			break
+		}
+	}
+	if granted < role {
+		mr.logger.warnf("Control: rejected a request needing %s as %s", role, granted)
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// checkControl rejects requests from incompatible clients and from
+// clients without role
+func (mr *Master) checkControl(version int, token string, role Role) error {
+	if err := checkProtocolVersion(version); err != nil {
+		return err
+	}
+	return mr.authorize(token, role)
+}
+
+// requireRole wraps an HTTP handler so that it answers only requests
+// whose token, a bearer token in the Authorization header or the token
+// query parameter for browsers' EventSource, grants role
+func (mr *Master) requireRole(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := requestToken(req)
+		if err := mr.authorize(token, role); err != nil {
+			status := http.StatusForbidden
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// requestToken returns the control token an HTTP request carries
+func requestToken(req *http.Request) string {
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return req.URL.Query().Get("token")
+}
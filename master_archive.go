@@ -0,0 +1,43 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// WithArchive bundles the job's logs, manifest and counters into a
+// gzipped tarball in dir once the job has finished. When
+// includeOutputs is set the reduce outputs are archived as well.
+func WithArchive(dir string, includeOutputs bool) Option {
+	return func(mr *Master) {
+		mr.archiveDir = dir
+		mr.archiveOutputs = includeOutputs
+	}
+}
+
+// archiveName constructs the file name of a job archive
+func archiveName(dir string, jobName JobParse, finished time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("%v-%s.tar.gz", jobName, finished.Format("20060102-150405")))
+}
+
+// archiveWorkspace writes the job archive if one was requested
+func (mr *Master) archiveWorkspace() {
+	if mr.archiveDir == "" {
+		return
+	}
+
+	ws := mr.workspace()
+	dirs := []string{ws.LogsDir()}
+	if mr.archiveOutputs {
+		dirs = append(dirs, ws.OutputDir())
+	}
+
+	dest := archiveName(mr.archiveDir, mr.jobName, time.Now())
+	if err := ws.Archive(dest, dirs...); err != nil {
+		mr.logger.errorf("Archive failed: %v", err)
+		return
+	}
+	mr.logger.infof("Archived job %s to %s", mr.jobName, dest)
+}
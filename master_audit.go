@@ -0,0 +1,115 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Kinds of audit log entries
+const (
+	// AuditLateResult is a task attempt that succeeded after another
+	// attempt completed its task, or after its phase ended
+	AuditLateResult = "late_result"
+
+	// AuditLateShutdown is a worker summary that arrived after the
+	// master stopped waiting for the worker's shutdown reply
+	AuditLateShutdown = "late_shutdown"
+)
+
+// What the master did with an audited arrival
+const (
+	AuditDiscarded = "discarded" // Ignored: nothing was counted
+	AuditRecorded  = "recorded"  // Kept, see the kind of the entry
+)
+
+// lateShutdownWait is how long the master keeps listening for shutdown
+// replies after it stopped waiting for them
+const lateShutdownWait = time.Minute
+
+// AuditEntry is a line of the master's audit log, see WithAuditLog.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`        // What arrived, e.g. AuditLateResult
+	Disposition string    `json:"disposition"` // What the master did with it, e.g. AuditDiscarded
+	Worker      string    `json:"worker"`
+	Job         JobParse  `json:"job,omitempty"`
+	Phase       JobParse  `json:"phase,omitempty"`
+	Task        int       `json:"task"` // Task number of late results
+}
+
+// LateResult is the result of a task attempt that succeeded after its
+// task completed or its phase ended. Its metrics and counters are not
+// added to the job's.
+type LateResult struct {
+	Job     JobParse
+	Phase   JobParse
+	Task    int
+	Worker  string
+	Metrics TaskMetrics
+}
+
+// WithAuditLog appends what the master receives too late to act on to
+// path, one JSON encoded AuditEntry per line: results of task attempts
+// that succeeded after their task completed or their phase ended,
+// e.g. attempts the watchdog abandoned, which are discarded, and worker
+// summaries that arrive after the master stopped waiting for the
+// workers' shutdown replies, which Result includes. Without an audit
+// log they are only logged. path is a local file.
+func WithAuditLog(path string) Option {
+	return func(mr *Master) {
+		mr.auditPath = path
+	}
+}
+
+// audit logs an entry and appends it to the audit log
+func (mr *Master) audit(entry AuditEntry) {
+	entry.Time = time.Now()
+	if entry.Kind == AuditLateResult {
+		mr.logger.infof("Audit: %s a late result of %s task %d of job %s from %s",
+			entry.Disposition, entry.Phase, entry.Task, entry.Job, entry.Worker)
+	} else {
+		mr.logger.infof("Audit: %s the late shutdown summary of %s", entry.Disposition, entry.Worker)
+	}
+	mr.Lock()
+	path := mr.auditPath
+	mr.Unlock()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		mr.logger.warnf("Audit: encode entry: %v", err)
+		return
+	}
+	mr.auditMu.Lock()
+	defer mr.auditMu.Unlock()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		mr.logger.warnf("Audit: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		mr.logger.warnf("Audit: write %s: %v", path, err)
+	}
+}
+
+// discardLateResult audits a late task result, for the task schedulers
+func (mr *Master) discardLateResult(late LateResult) {
+	mr.audit(AuditEntry{Kind: AuditLateResult, Disposition: AuditDiscarded,
+		Worker: late.Worker, Job: late.Job, Phase: late.Phase, Task: late.Task})
+}
+
+// recordLateSummary keeps the summary of a worker whose shutdown reply
+// arrived after the master stopped waiting for it, for Result
+func (mr *Master) recordLateSummary(summary WorkerSummary) {
+	mr.Lock()
+	if mr.lateSummaries == nil {
+		mr.lateSummaries = make(map[string]WorkerSummary)
+	}
+	mr.lateSummaries[summary.Worker] = summary
+	mr.Unlock()
+	mr.audit(AuditEntry{Kind: AuditLateShutdown, Disposition: AuditRecorded, Worker: summary.Worker})
+}
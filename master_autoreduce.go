@@ -0,0 +1,44 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// AutoReduce as the number of reduce tasks lets the master choose it
+// from the size of the input, see WithBytesPerReducer.
+const AutoReduce = -1
+
+const (
+	// DefaultBytesPerReducer is the input size handled per reduce task
+	// by AutoReduce jobs unless WithBytesPerReducer says otherwise
+	DefaultBytesPerReducer = 64 << 20
+
+	// maxAutoReduce bounds the reduce tasks AutoReduce picks, keeping
+	// the number of intermediate files manageable on huge inputs
+	maxAutoReduce = 1024
+)
+
+// autoReduceCount picks the number of reduce tasks for files so that
+// every task handles about bytesPerReducer bytes of input. Files that
+// cannot be inspected count as empty.
+func autoReduceCount(files []string, bytesPerReducer int64) int {
+	if bytesPerReducer <= 0 {
+		bytesPerReducer = DefaultBytesPerReducer
+	}
+	var total int64
+	for _, file := range files {
+		info, err := statFile(file)
+		if err != nil {
+			processLog.warnf("AutoReduce: cannot size input %s: %v", file, err)
+			continue
+		}
+		total += info.Size()
+	}
+
+	n := int((total + bytesPerReducer - 1) / bytesPerReducer)
+	if n < 1 {
+		n = 1
+	}
+	if n > maxAutoReduce {
+		n = maxAutoReduce
+	}
+	processLog.infof("AutoReduce: %d bytes of input, using %d reduce tasks", total, n)
+	return n
+}
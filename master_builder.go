@@ -0,0 +1,175 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Job configures and runs a single job in one place, instead of
+// assembling it from Sequential or Distributed, the global Config and
+// socket paths:
+//
+//	err := mapreduce.NewJob("wordcount").
+//		Inputs(files...).
+//		NReduce(5).
+//		Map(mapF).
+//		Reduce(reduceF).
+//		OutputDir("/tmp/wc").
+//		Run()
+//
+// Jobs run sequentially in the calling process unless Distributed is
+// set. Setters record the first invalid setting, which Validate and
+// Run report.
+type Job struct {
+	name      JobParse
+	files     []string
+	nReduce   int
+	mapF      func(string, string) []KeyValue
+	reduceF   func(string, []string) string
+	outputDir string
+	opts      []Option
+	master    string // Address of the master, empty to run sequentially
+	workers   int    // Workers started in this process for the master
+	err       error  // First invalid setting
+}
+
+// NewJob starts the configuration of a job with one reduce task
+func NewJob(name JobParse) *Job {
+	return &Job{name: name, nReduce: 1}
+}
+
+// Inputs adds input files, one map task per file unless the job's
+// options split them
+func (j *Job) Inputs(files ...string) *Job {
+	j.files = append(j.files, files...)
+	return j
+}
+
+// NReduce sets the number of reduce tasks, or AutoReduce
+func (j *Job) NReduce(n int) *Job {
+	if n <= 0 && n != AutoReduce {
+		j.fail(fmt.Errorf("invalid number of reduce tasks: %d", n))
+	}
+	j.nReduce = n
+	return j
+}
+
+// Map sets the map function
+func (j *Job) Map(mapF func(string, string) []KeyValue) *Job {
+	j.mapF = mapF
+	return j
+}
+
+// Reduce sets the reduce function
+func (j *Job) Reduce(reduceF func(string, []string) string) *Job {
+	j.reduceF = reduceF
+	return j
+}
+
+// OutputDir writes the result file to dir instead of the configured
+// result path, see WithResultDir
+func (j *Job) OutputDir(dir string) *Job {
+	j.outputDir = dir
+	return j
+}
+
+// Options adds settings of the job, e.g. WithCompression
+func (j *Job) Options(opts ...Option) *Job {
+	j.opts = append(j.opts, opts...)
+	return j
+}
+
+// Distributed runs the job on a master of its own listening on
+// master, with the given number of workers started in this process.
+// With no local workers, the job waits for workers started elsewhere
+// to register. Local workers listen on sockets in the configured
+// socket directory.
+func (j *Job) Distributed(master string, workers int) *Job {
+	if master == "" {
+		j.fail(fmt.Errorf("master address cannot be empty"))
+	}
+	if workers < 0 {
+		j.fail(fmt.Errorf("invalid number of workers: %d", workers))
+	}
+	j.master = master
+	j.workers = workers
+	return j
+}
+
+// fail records err unless an earlier setting was already invalid
+func (j *Job) fail(err error) {
+	if j.err == nil {
+		j.err = err
+	}
+}
+
+// Validate reports the first problem of the job's settings
+func (j *Job) Validate() error {
+	switch {
+	case j.err != nil:
+		return j.err
+	case j.name == "":
+		return fmt.Errorf("job name cannot be empty")
+	case len(j.files) == 0:
+		return fmt.Errorf("no input files provided")
+	case j.mapF == nil || j.reduceF == nil:
+		return fmt.Errorf("map and reduce functions cannot be nil")
+	}
+	return nil
+}
+
+// Run validates the job, runs it and returns once it has finished
+func (j *Job) Run() error {
+	return j.RunContext(context.Background())
+}
+
+// RunContext runs the job like Run until ctx is done, in which case
+// the job is cancelled and ErrJobCancelled is returned
+func (j *Job) RunContext(ctx context.Context) error {
+	if err := j.Validate(); err != nil {
+		return fmt.Errorf("job %s: %v", j.name, err)
+	}
+	opts := j.opts
+	if j.outputDir != "" {
+		opts = append(opts[:len(opts):len(opts)], WithResultDir(j.outputDir))
+	}
+	if j.master == "" {
+		return SequentialContext(ctx, j.name, j.files, j.nReduce, j.mapF, j.reduceF, opts...)
+	}
+	return j.runDistributed(ctx, opts)
+}
+
+// runDistributed runs the job on a master of its own and shuts the
+// master and its workers down afterwards
+func (j *Job) runDistributed(ctx context.Context, opts []Option) error {
+	mr, err := StartMaster(j.master)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", j.name, err)
+	}
+	defer mr.Shutdown(&ShutdownArgs{Token: mr.authToken}, new(struct{}))
+
+	handle, err := mr.SubmitContext(ctx, JobConfig{Name: j.name, Files: j.files, NReduce: j.nReduce, Options: opts})
+	if err != nil {
+		return fmt.Errorf("job %s: %v", j.name, err)
+	}
+
+	socketDir := Config["socket_base"]
+	if j.workers > 0 {
+		if err := os.MkdirAll(socketDir, 0777); err != nil {
+			handle.Cancel()
+			return fmt.Errorf("job %s: %v", j.name, err)
+		}
+	}
+	for i := 0; i < j.workers; i++ {
+		me := filepath.Join(socketDir, fmt.Sprintf("%s-%d-worker-%d.sock", j.name, os.Getpid(), i))
+		os.Remove(me)
+		if _, err := StartWorker(mr.address, me, j.mapF, j.reduceF, -1); err != nil {
+			handle.Cancel()
+			return fmt.Errorf("job %s: start worker: %v", j.name, err)
+		}
+	}
+	return handle.Wait()
+}
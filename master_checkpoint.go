@@ -0,0 +1,139 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+// Kinds of checkpoint entries
+const (
+	checkpointWorker     = "worker"      // A worker registered
+	checkpointWorkerGone = "worker_gone" // A worker left or was dropped
+	checkpointSubmit     = "submit"      // A job was queued
+	checkpointEnd        = "end"         // A job finished
+)
+
+// checkpointEntry is a line of a master checkpoint, see WithCheckpoint
+type checkpointEntry struct {
+	Kind   string      `json:"kind"`
+	Time   time.Time   `json:"time"`
+	Worker string      `json:"worker,omitempty"`
+	JobID  string      `json:"job_id,omitempty"`
+	Seq    int         `json:"seq,omitempty"` // Submission number of the job
+	Job    *JobRequest `json:"job,omitempty"` // Job of submit entries
+	State  JobState    `json:"state,omitempty"`
+}
+
+// checkpointState is what a checkpoint holds: the registered workers
+// and the jobs not finished yet
+type checkpointState struct {
+	workers map[string]bool
+	jobs    map[string]checkpointEntry // Submit entries of unfinished jobs by ID
+	lastSeq int                        // Highest submission number seen
+}
+
+// WithCheckpoint makes the master append its registered workers and
+// every job it accepts and finishes to the local file at path, as JSON
+// lines, for a standby master to take over from, see StartStandby.
+// Jobs are recorded with the JobOptions their options set, as the
+// control plane submits them; settings that are not part of JobOptions
+// come from the standby's own options.
+func WithCheckpoint(path string) Option {
+	return func(mr *Master) {
+		mr.checkpointPath = path
+	}
+}
+
+// checkpoint appends an entry to the checkpoint, if any. The caller
+// must hold the lock.
+func (mr *Master) checkpoint(entry checkpointEntry) {
+	if mr.checkpointPath == "" {
+		return
+	}
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		mr.logger.warnf("Checkpoint: encode entry: %v", err)
+		return
+	}
+	file, err := os.OpenFile(mr.checkpointPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		mr.logger.warnf("Checkpoint: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		mr.logger.warnf("Checkpoint: write %s: %v", mr.checkpointPath, err)
+	}
+}
+
+// checkpointJob records a job just queued. Its options are applied on
+// top of the master's defaults to a scratch master to learn the
+// JobOptions they set. The caller must hold the lock.
+func (mr *Master) checkpointJob(handle *JobHandle) {
+	if mr.checkpointPath == "" {
+		return
+	}
+	scratch := newMaster(mr.address)
+	scratch.applyOptions(mr.defaults)
+	scratch.applyOptions(handle.config.Options)
+	request := JobRequest{
+		Name:    handle.config.Name,
+		Files:   handle.config.Files,
+		Inputs:  handle.config.Inputs,
+		NReduce: handle.config.NReduce,
+		Options: scratch.options,
+	}
+	mr.checkpoint(checkpointEntry{Kind: checkpointSubmit, JobID: handle.id, Seq: handle.seq, Job: &request})
+}
+
+// newCheckpointState returns the state of an empty checkpoint
+func newCheckpointState() *checkpointState {
+	return &checkpointState{workers: make(map[string]bool), jobs: make(map[string]checkpointEntry)}
+}
+
+// apply adds the complete lines of data to the state and returns the
+// number of bytes read; a line still being written is left for later
+func (s *checkpointState) apply(data []byte) int {
+	read := 0
+	for {
+		end := bytes.IndexByte(data[read:], '\n')
+		if end < 0 {
+			return read
+		}
+		line := data[read : read+end]
+		read += end + 1
+		var entry checkpointEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		switch entry.Kind {
+		case checkpointWorker:
+			s.workers[entry.Worker] = true
+		case checkpointWorkerGone:
+			delete(s.workers, entry.Worker)
+		case checkpointSubmit:
+			if entry.Job != nil {
+				s.jobs[entry.JobID] = entry
+			}
+			s.lastSeq = max(s.lastSeq, entry.Seq)
+		case checkpointEnd:
+			delete(s.jobs, entry.JobID)
+		}
+	}
+}
+
+// pending returns the submit entries of the unfinished jobs in
+// submission order
+func (s *checkpointState) pending() []checkpointEntry {
+	jobs := make([]checkpointEntry, 0, len(s.jobs))
+	for _, entry := range s.jobs {
+		jobs = append(jobs, entry)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Seq < jobs[j].Seq })
+	return jobs
+}
@@ -0,0 +1,185 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SubmitJob queues a job on behalf of a remote client
+func (mr *Master) SubmitJob(args *SubmitJobArgs, reply *SubmitJobReply) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleOperator); err != nil {
+		return err
+	}
+
+	options, err := remoteOptions(args.Job.Options)
+	if err != nil {
+		return err
+	}
+	jobOptions := []Option{func(mr *Master) { mr.options = options }}
+	if args.Job.Reservation != "" {
+		jobOptions = append(jobOptions, WithReservation(args.Job.Reservation))
+	}
+	handle, err := mr.Submit(JobConfig{
+		Name:    args.Job.Name,
+		Files:   args.Job.Files,
+		Inputs:  args.Job.Inputs,
+		NReduce: args.Job.NReduce,
+		Options: jobOptions,
+	})
+	if err != nil {
+		return err
+	}
+	reply.JobID = handle.ID()
+	return nil
+}
+
+// remoteOptions returns the options of a job submitted by a remote
+// client. Its workspaces are confined under the master's output root,
+// so clients cannot have workers write or remove files elsewhere, and
+// the fields the master fills in are cleared.
+func remoteOptions(opts JobOptions) (JobOptions, error) {
+	var err error
+	if opts.Workspace, err = confinedRoot(opts.Workspace); err != nil {
+		return JobOptions{}, err
+	}
+	if opts.Replica, err = confinedRoot(opts.Replica); err != nil {
+		return JobOptions{}, err
+	}
+	opts.GroupFormats = nil
+	opts.DroppedCaches = nil
+	return opts, nil
+}
+
+// confinedRoot resolves dir, a workspace root named by a remote client
+// relative to the master's output root. Empty stays empty, for the
+// default; absolute paths, remote paths and paths leaving the root are
+// rejected.
+func confinedRoot(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	clean := path.Clean(filepath.ToSlash(dir))
+	if path.IsAbs(clean) || filepath.IsAbs(dir) || isRemotePath(dir) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("workspace %q is not a relative path under the master's output root", dir)
+	}
+	return joinPath(Config["output"], clean), nil
+}
+
+// JobStatus reports the state of a submitted job
+func (mr *Master) JobStatus(args *JobArgs, reply *JobStatus) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleObserver); err != nil {
+		return err
+	}
+	handle, err := mr.lookupJob(args.JobID)
+	if err != nil {
+		return err
+	}
+	*reply = handle.Status()
+	return nil
+}
+
+// Reserve reserves task slots on behalf of a remote client
+func (mr *Master) Reserve(args *ReserveArgs, reply *ReserveReply) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleOperator); err != nil {
+		return err
+	}
+	id, err := mr.ReserveSlots(args.Slots, args.Labels)
+	if err != nil {
+		return err
+	}
+	reply.ReservationID = id
+	return nil
+}
+
+// Release returns the slots of a reservation on behalf of a remote
+// client
+func (mr *Master) Release(args *ReleaseArgs, _ *struct{}) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleOperator); err != nil {
+		return err
+	}
+	return mr.ReleaseSlots(args.ReservationID)
+}
+
+// CancelJob cancels a queued or running job
+func (mr *Master) CancelJob(args *JobArgs, _ *struct{}) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleOperator); err != nil {
+		return err
+	}
+	handle, err := mr.lookupJob(args.JobID)
+	if err != nil {
+		return err
+	}
+	handle.Cancel()
+	return nil
+}
+
+// FetchResult returns records of one reduce output of a finished job,
+// starting at args.Offset. Clients fetch the partitions one by one, and
+// each in chunks of at most the message size limit, to keep messages
+// small.
+func (mr *Master) FetchResult(args *FetchResultArgs, reply *FetchResultReply) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleObserver); err != nil {
+		return err
+	}
+	handle, err := mr.lookupJob(args.JobID)
+	if err != nil {
+		return err
+	}
+
+	status := handle.Status()
+	if status.State != JobSucceeded {
+		return fmt.Errorf("job %s has no result, it is %s", args.JobID, status.State)
+	}
+	if args.Part < 0 || args.Part >= status.NReduce {
+		return fmt.Errorf("job %s has no result part %d", args.JobID, args.Part)
+	}
+
+	mr.Lock()
+	limit := mr.messageLimit()
+	mr.Unlock()
+	records, next, done, err := readReduceOutput(handle.workspace.ReduceOutput(args.Part), args.Offset, limit)
+	if err != nil {
+		return err
+	}
+	reply.Records = records
+	reply.Next = next
+	reply.Done = done
+	return nil
+}
+
+// lookupJob finds a submitted job by ID
+func (mr *Master) lookupJob(id string) (*JobHandle, error) {
+	mr.Lock()
+	defer mr.Unlock()
+	handle, ok := mr.handles[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %s", id)
+	}
+	return handle, nil
+}
+
+// readReduceOutput decodes the records of a reduce output file from
+// offset on until they take up limit bytes. It returns the offset of
+// the first record it left out and whether none are left.
+func readReduceOutput(fileName string, offset int64, limit int) ([]KeyValue, int64, bool, error) {
+	file, err := openFile(fileName, offset)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to open result part: %v", err)
+	}
+	defer file.Close()
+
+	var records []KeyValue
+	decoder := json.NewDecoder(file)
+	for decoder.InputOffset() < int64(limit) {
+		var kv KeyValue
+		if err := decoder.Decode(&kv); err != nil {
+			return records, offset + decoder.InputOffset(), true, nil // End of file or error
+		}
+		records = append(records, kv)
+	}
+	return records, offset + decoder.InputOffset(), false, nil
+}
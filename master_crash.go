@@ -0,0 +1,31 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReportCrash records the crash report a dying worker sends as its
+// last RPC, so the reason it vanished is known
+func (mr *Master) ReportCrash(args *CrashReport, _ *struct{}) error {
+	mr.Lock()
+	defer mr.Unlock()
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+	mr.logger.errorf("Master: worker %s crashed while running [%s]: %s",
+		args.Worker, strings.Join(args.Tasks, ", "), args.Reason)
+
+	report := *args
+	report.Token = ""
+	mr.crashes = append(mr.crashes, report)
+	return nil
+}
+
+// Crashes returns the crash reports received from workers
+func (mr *Master) Crashes() []CrashReport {
+	mr.Lock()
+	defer mr.Unlock()
+	return append([]CrashReport{}, mr.crashes...)
+}
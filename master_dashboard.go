@@ -0,0 +1,235 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WithDashboard serves the master's HTTP interface, see Handler, on
+// addr, a TCP host:port, while the master runs: a dashboard of the
+// jobs and workers at /, with a page per job showing its phases on a
+// timeline, the state of every task and links to its result files.
+// Pass it to StartMaster or Distributed.
+func WithDashboard(addr string) Option {
+	return func(mr *Master) {
+		mr.dashboardAddr = addr
+	}
+}
+
+// startDashboard serves Handler on the dashboard address until the
+// master is cleaned up
+func (mr *Master) startDashboard() error {
+	l, err := net.Listen("tcp", mr.dashboardAddr)
+	if err != nil {
+		return fmt.Errorf("dashboard: %v", err)
+	}
+	mr.dashboard = l
+	go func() {
+		if err := http.Serve(l, mr.Handler()); err != nil && !isClosed(mr.shutdown) {
+			mr.logger.errorf("dashboard error: %v", err)
+		}
+	}()
+	mr.logger.infof("Serving dashboard at http://%s/", l.Addr())
+	return nil
+}
+
+// dashboardTemplate renders the dashboard, which reloads itself every
+// few seconds. Links are relative so the page works below any prefix,
+// and carry the token the page was requested with, see linkQuery.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>MapReduce master</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 1em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>MapReduce master <small>{{.Address}}</small></h1>
+<h2>Jobs</h2>
+<table>
+<tr><th>ID</th><th>Name</th><th>State</th><th>Phase</th><th>Tasks</th><th>Result</th><th>Error</th></tr>
+{{range .Jobs}}<tr><td><a href="jobs/{{.ID}}/dashboard{{$.Query}}">{{.ID}}</a></td><td>{{.Name}}</td><td>{{.State}}</td><td>{{.Progress.Phase}}</td><td>{{.Progress.Completed}}/{{.Progress.Total}}</td><td>{{if eq .State "succeeded"}}<a href="jobs/{{.ID}}/preview{{$.Query}}">preview</a>{{end}}</td><td>{{.Error}}</td></tr>
+{{else}}<tr><td colspan="7">No jobs submitted</td></tr>
+{{end}}</table>
+<h2>Workers</h2>
+<table>
+<tr><th>Address</th><th>Host</th><th>Slots</th><th>Last seen</th></tr>
+{{range .Workers}}<tr><td>{{.Address}}</td><td>{{.Host}}</td><td>{{.Slots}}</td><td>{{if not .LastSeen.IsZero}}{{.LastSeen.Format "15:04:05"}}{{end}}</td></tr>
+{{else}}<tr><td colspan="4">No workers registered</td></tr>
+{{end}}</table>
+<p><a href="metrics{{.Query}}">Metrics</a></p>
+</body>
+</html>
+`))
+
+// linkQuery returns the query the dashboard appends to its links, so
+// that a control token passed in the URL of a page, see requestToken,
+// carries over to the pages it links to
+func linkQuery(req *http.Request) string {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		return ""
+	}
+	return "?" + url.Values{"token": {token}}.Encode()
+}
+
+// serveDashboard renders the jobs and workers as an HTML page
+func (mr *Master) serveDashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, struct {
+		Address string
+		Query   string
+		Jobs    []JobStatus
+		Workers []WorkerInfo
+	}{mr.address, linkQuery(req), mr.jobStatuses(), mr.Workers()})
+	if err != nil {
+		mr.logger.warnf("HTTP: render dashboard: %v", err)
+	}
+}
+
+// jobTemplate renders the page of one job. Its links are relative to
+// /jobs/{id}/dashboard and carry its token like the dashboard's.
+var jobTemplate = template.Must(template.New("job").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+{{if not .Finished}}<meta http-equiv="refresh" content="2">{{end}}
+<title>Job {{.Status.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 1em; text-align: left; }
+.timeline { width: 30em; background: #f4f4f4; }
+.bar { height: 1em; background: #4a90d9; min-width: 2px; }
+.grid { display: flex; flex-wrap: wrap; gap: 2px; max-width: 60em; margin-bottom: 2em; }
+.task { width: 1em; height: 1em; }
+.idle { background: #ddd; }
+.running { background: #f0ad4e; }
+.completed { background: #5cb85c; }
+</style>
+</head>
+<body>
+<p><a href="../../{{.Query}}">All jobs</a></p>
+<h1>Job {{.Status.Name}} <small>{{.Status.ID}}</small></h1>
+<p>{{.Status.State}}{{with .Status.Error}}: {{.}}{{end}}</p>
+<h2>Phases</h2>
+<table>
+<tr><th>Phase</th><th>Started</th><th>Duration</th><th>Tasks</th><th>Failed attempts</th><th>Timeline</th></tr>
+{{range .Phases}}<tr><td>{{.Phase}}</td><td>{{.Started.Format "15:04:05"}}</td><td>{{.Duration}}</td><td>{{.Completed}}/{{len .Tasks}}</td><td>{{.Failures}}</td><td class="timeline"><div class="bar" style="{{.Bar}}"></div></td></tr>
+{{else}}<tr><td colspan="6">No phase started</td></tr>
+{{end}}</table>
+{{range .Phases}}<h2>{{.Phase}} tasks</h2>
+<div class="grid">{{$phase := .Phase}}{{range $i, $state := .Tasks}}<div class="task {{$state}}" title="{{$phase}} task {{$i}}: {{$state}}"></div>{{end}}</div>
+{{end}}
+{{if .Outputs}}<h2>Result</h2>
+<table>
+<tr><th>Partition</th><th>Size</th></tr>
+{{range $i, $size := .Outputs}}<tr><td><a href="output/{{$i}}{{$.Query}}">{{$i}}</a></td><td>{{$size}} bytes</td></tr>
+{{end}}</table>
+<p><a href="preview{{.Query}}">Preview</a></p>
+{{end}}
+</body>
+</html>
+`))
+
+// dashboardPhase is a phase as shown on the page of a job
+type dashboardPhase struct {
+	PhaseState
+	Duration time.Duration
+	Bar      template.CSS // Position of the phase on the job's timeline
+}
+
+// serveJobDashboard renders the phases, tasks and result files of a
+// job as an HTML page
+func (mr *Master) serveJobDashboard(w http.ResponseWriter, req *http.Request) {
+	handle, err := mr.lookupJob(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	status := handle.Status()
+
+	// Phases are placed on a timeline from the start of the first to
+	// the end of the last one, or now while it runs
+	var start, end time.Time
+	phases := make([]dashboardPhase, len(status.Phases))
+	for i, phase := range status.Phases {
+		phases[i].PhaseState = phase
+		if phase.Ended.IsZero() {
+			phase.Ended = time.Now()
+		}
+		phases[i].Duration = phase.Ended.Sub(phase.Started).Round(time.Millisecond)
+		if i == 0 {
+			start = phase.Started
+		}
+		end = phase.Ended
+	}
+	if span := end.Sub(start); span > 0 {
+		for i := range phases {
+			offset := float64(phases[i].Started.Sub(start)) / float64(span) * 100
+			width := float64(phases[i].Duration) / float64(span) * 100
+			phases[i].Bar = template.CSS(fmt.Sprintf("margin-left: %.1f%%; width: %.1f%%", offset, width))
+		}
+	}
+
+	var outputs []int64
+	if status.State == JobSucceeded {
+		for _, name := range handle.workspace.ReduceOutputs(status.NReduce) {
+			outputs = append(outputs, fileSize(name))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = jobTemplate.Execute(w, struct {
+		Status   JobStatus
+		Query    string
+		Finished bool
+		Phases   []dashboardPhase
+		Outputs  []int64
+	}{status, linkQuery(req), isClosed(handle.Done()), phases, outputs})
+	if err != nil {
+		mr.logger.warnf("HTTP: render job dashboard: %v", err)
+	}
+}
+
+// serveOutput answers with a reduce output file of a succeeded job
+func (mr *Master) serveOutput(w http.ResponseWriter, req *http.Request) {
+	handle, err := mr.lookupJob(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	status := handle.Status()
+	if status.State != JobSucceeded {
+		http.Error(w, fmt.Sprintf("job %s has no result, it is %s", handle.id, status.State), http.StatusConflict)
+		return
+	}
+	part, err := strconv.Atoi(req.PathValue("part"))
+	if err != nil || part < 0 || part >= status.NReduce {
+		http.Error(w, "invalid partition: "+req.PathValue("part"), http.StatusNotFound)
+		return
+	}
+
+	file, err := openFile(handle.workspace.ReduceOutput(part), 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := io.Copy(w, file); err != nil {
+		mr.logger.warnf("HTTP: send output %d of job %s: %v", part, handle.id, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestDashboardLinks opens the dashboard of a master with control
+// tokens with the token in its URL and follows every link. The pages
+// they lead to are served, as the token carries over to them.
+func TestDashboardLinks(t *testing.T) {
+	const token = "observer secret"
+	mr := startMaster(t, WithControlTokens(map[string]Role{token: RoleObserver}))
+	if _, err := mr.Submit(JobConfig{Name: "dashboard", Files: makeInputs(nMap), NReduce: nReduce}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(mr.Handler())
+	defer srv.Close()
+
+	start, err := url.Parse(srv.URL + "/?" + url.Values{"token": {token}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	href := regexp.MustCompile(`href="([^"]*)"`)
+	seen := map[string]bool{start.String(): true}
+	for pages := []*url.URL{start}; len(pages) > 0; pages = pages[1:] {
+		page := pages[0]
+		resp, err := http.Get(page.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %s", page.RequestURI(), resp.Status)
+			continue
+		}
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			continue
+		}
+		for _, m := range href.FindAllStringSubmatch(string(body), -1) {
+			link, err := page.Parse(html.UnescapeString(m[1]))
+			if err != nil {
+				t.Fatalf("link %q of %s: %v", m[1], page.RequestURI(), err)
+			}
+			if !seen[link.String()] {
+				seen[link.String()] = true
+				pages = append(pages, link)
+			}
+		}
+	}
+	if len(seen) < 3 {
+		t.Errorf("followed %d links, want the job's page and the metrics as well", len(seen))
+	}
+}
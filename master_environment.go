@@ -0,0 +1,153 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// JobEnvironment records what produced a job's result: the framework
+// and code of the master and of every worker that ran the job, its
+// effective settings and the exact input. It is written to the job's
+// logs directory next to the manifest, see JobWorkspace.Environment,
+// when the job starts and again once it has finished, and is part of
+// the job archive.
+type JobEnvironment struct {
+	JobName   JobParse          `json:"job_name"`
+	Framework string            `json:"framework"` // FrameworkVersion of the master
+	Master    BuildInfo         `json:"master"`
+	NReduce   int               `json:"n_reduce"`
+	Options   JobOptions        `json:"options"` // Settings shipped to the workers, seed included
+	Config    map[string]string `json:"config"`  // Paths of the configuration file
+	Inputs    []InputFile       `json:"inputs"`
+	Workers   []WorkerBuild     `json:"workers,omitempty"` // Workers prepared for the job, by address
+	Created   time.Time         `json:"created"`
+	Finished  time.Time         `json:"finished"` // Zero until the job has finished
+}
+
+// InputFile identifies an input file of a job by its content
+type InputFile struct {
+	Path   string `json:"path"`
+	Group  string `json:"group,omitempty"` // Input group of the file, see JobConfig.Inputs
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"` // Empty if the file could not be read
+}
+
+// WorkerBuild describes the code a worker ran a job with
+type WorkerBuild struct {
+	Worker        string    `json:"worker"`
+	Framework     string    `json:"framework"`                // FrameworkVersion of the worker
+	BinaryVersion string    `json:"binary_version,omitempty"` // See WithBinaryVersion
+	Build         BuildInfo `json:"build"`
+}
+
+// Environment returns the path of the job environment
+func (w *JobWorkspace) Environment() string {
+	return joinPath(w.LogsDir(), "environment.json")
+}
+
+// newJobEnvironment snapshots the current job as it starts, hashing
+// its input files
+func (mr *Master) newJobEnvironment() *JobEnvironment {
+	env := &JobEnvironment{
+		JobName:   mr.jobName,
+		Framework: FrameworkVersion,
+		Master:    processBuild(),
+		NReduce:   mr.nReduce,
+		Options:   mr.options,
+		Config:    Config,
+		Created:   time.Now(),
+	}
+	seen := make(map[string]bool)
+	for _, split := range mr.splits {
+		if seen[split.File] {
+			continue
+		}
+		seen[split.File] = true
+		input, err := describeInput(split.File)
+		if err != nil {
+			mr.logger.warnf("Environment: %v", err)
+		}
+		input.Group = split.Group
+		env.Inputs = append(env.Inputs, input)
+	}
+	return env
+}
+
+// finish records the workers prepared for the job once it is over
+func (env *JobEnvironment) finish(builds map[string]WorkerBuild) {
+	env.Workers = env.Workers[:0]
+	for _, build := range builds {
+		env.Workers = append(env.Workers, build)
+	}
+	sort.Slice(env.Workers, func(i, j int) bool { return env.Workers[i].Worker < env.Workers[j].Worker })
+	env.Finished = time.Now()
+}
+
+// describeInput returns the size and hash of an input file
+func describeInput(path string) (InputFile, error) {
+	input := InputFile{Path: path}
+	info, err := statFile(path)
+	if err != nil {
+		return input, fmt.Errorf("stat input %s: %v", path, err)
+	}
+	input.Size = info.Size()
+	file, err := openFile(path, 0)
+	if err != nil {
+		return input, fmt.Errorf("open input %s: %v", path, err)
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return input, fmt.Errorf("hash input %s: %v", path, err)
+	}
+	input.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return input, nil
+}
+
+// Write stores the environment as indented JSON
+func (env *JobEnvironment) Write(path string) error {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode environment: %v", err)
+	}
+	if err := mkdirAll(dirPath(path)); err != nil {
+		return fmt.Errorf("failed to create environment directory: %v", err)
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to write environment: %v", err)
+	}
+	return nil
+}
+
+// ReadJobEnvironment loads the environment recorded for a past job
+func ReadJobEnvironment(path string) (*JobEnvironment, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read environment: %v", err)
+	}
+	var env JobEnvironment
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode environment: %v", err)
+	}
+	return &env, nil
+}
+
+// recordWorkerBuild notes the code a worker prepared for the current
+// job runs. The caller must hold the lock.
+func (mr *Master) recordWorkerBuild(worker string, reply *PrepareReply) {
+	if mr.workerBuilds == nil {
+		mr.workerBuilds = make(map[string]WorkerBuild)
+	}
+	mr.workerBuilds[worker] = WorkerBuild{
+		Worker:        worker,
+		Framework:     reply.Framework,
+		BinaryVersion: reply.BinaryVersion,
+		Build:         reply.Build,
+	}
+}
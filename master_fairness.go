@@ -0,0 +1,91 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "sort"
+
+// WithFairScheduling interleaves the tasks of every phase of the job by
+// size: tasks are sorted into buckets of the same number of tasks, from
+// the largest to the smallest, and handed out one bucket after the
+// other, so neither the small tasks wait behind all the large ones nor
+// the other way round. Map tasks are sized by the bytes of their input
+// split, reduce tasks by the records the map tasks wrote to their
+// partition; reduce tasks are handed out in order when some map task
+// did not report them, e.g. because it ran with WithCombinerCache. With WithTaskPriority, tasks keep their priority
+// order within their bucket. Mixed-size inputs then see more tasks
+// complete early, which lowers the average time a task takes to
+// complete. One bucket or fewer, the default, schedules tasks in order.
+func WithFairScheduling(buckets int) Option {
+	return func(mr *Master) {
+		mr.fairBuckets = buckets
+	}
+}
+
+// taskSizes returns the size of every task of phase for
+// WithFairScheduling, nil if some are unknown
+func (mr *Master) taskSizes(phase JobParse) []int64 {
+	if phase == mapParse {
+		sizes := make([]int64, len(mr.splits))
+		for i, split := range mr.splits {
+			sizes[i] = split.Length
+			if split.Length > 0 {
+				continue
+			}
+			info, err := statFile(split.File)
+			if err != nil {
+				return nil
+			}
+			sizes[i] = info.Size() - split.Offset
+		}
+		return sizes
+	}
+
+	mr.Lock()
+	defer mr.Unlock()
+	if len(mr.partitionRecords) < len(mr.splits) {
+		return nil
+	}
+	sizes := make([]int64, mr.nReduce)
+	for _, records := range mr.partitionRecords {
+		if len(records) != mr.nReduce {
+			return nil
+		}
+		for i, n := range records {
+			sizes[i] += n
+		}
+	}
+	return sizes
+}
+
+// interleaveBySize reorders tasks, already in the order they would be
+// handed out, so that they alternate between buckets of tasks of
+// similar size, starting with the bucket of the largest tasks. Tasks
+// keep their order within their bucket.
+func interleaveBySize(tasks []int, sizes []int64, buckets int) []int {
+	if buckets <= 1 || len(tasks) < 2 {
+		return tasks
+	}
+	buckets = min(buckets, len(tasks))
+	bySize := append([]int(nil), tasks...)
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return sizes[bySize[i]] > sizes[bySize[j]]
+	})
+	bucketOf := make(map[int]int, len(tasks))
+	for rank, task := range bySize {
+		bucketOf[task] = rank * buckets / len(bySize)
+	}
+
+	queues := make([][]int, buckets)
+	for _, task := range tasks {
+		queues[bucketOf[task]] = append(queues[bucketOf[task]], task)
+	}
+	interleaved := make([]int, 0, len(tasks))
+	for len(interleaved) < len(tasks) {
+		for b, queue := range queues {
+			if len(queue) > 0 {
+				interleaved = append(interleaved, queue[0])
+				queues[b] = queue[1:]
+			}
+		}
+	}
+	return interleaved
+}
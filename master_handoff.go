@@ -0,0 +1,145 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+)
+
+// HandoffEnv is set in the environment of a master started by Handoff.
+// The successor finds the inherited listener on file descriptor 3 and
+// the handoff state on file descriptor 4.
+const HandoffEnv = "MAPREDUCE_MASTER_HANDOFF"
+
+// File descriptors passed to the successor master
+const (
+	handoffListenerFD = 3
+	handoffStateFD    = 4
+)
+
+// fileListener is a listener whose socket can be passed to another
+// process, i.e. a unix or TCP listener
+type fileListener interface {
+	net.Listener
+	File() (*os.File, error)
+}
+
+// handoffState is what a master passes on to its successor
+type handoffState struct {
+	Workers []string `json:"workers"` // Registered worker addresses
+}
+
+// Handoff upgrades the master in place. It starts the binary at path
+// with args, passing on the listening socket and the registered
+// workers, and stops accepting connections itself. The socket file is
+// never removed, so workers and clients keep using the same address
+// and reach the new master from then on.
+//
+// Jobs submitted before the handoff are finished by this master;
+// Handoff returns the successor once they are done and this master has
+// shut down. Workers are left running for the successor.
+func (mr *Master) Handoff(path string, args []string) (*os.Process, error) {
+	mr.Lock()
+	stopping := mr.stopping
+	mr.Unlock()
+	if stopping {
+		return nil, fmt.Errorf("master is stopping")
+	}
+
+	listener, ok := mr.listener.(fileListener)
+	if !ok {
+		return nil, fmt.Errorf("listener of %s cannot be handed off", mr.address)
+	}
+	listenerFile, err := listener.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate listener: %v", err)
+	}
+	defer listenerFile.Close()
+
+	stateReader, stateWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create handoff pipe: %v", err)
+	}
+	defer stateReader.Close()
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), HandoffEnv+"=1")
+	cmd.ExtraFiles = []*os.File{listenerFile, stateReader}
+	if err := cmd.Start(); err != nil {
+		stateWriter.Close()
+		return nil, fmt.Errorf("failed to start successor: %v", err)
+	}
+
+	// From here on new connections are accepted by the successor only
+	mr.Lock()
+	mr.handedOff = true
+	state := handoffState{Workers: append([]string(nil), mr.workers...)}
+	handles := make([]*JobHandle, 0, len(mr.handles))
+	for _, handle := range mr.handles {
+		handles = append(handles, handle)
+	}
+	mr.Unlock()
+	if ul, ok := listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	listener.Close()
+
+	err = json.NewEncoder(stateWriter).Encode(state)
+	stateWriter.Close()
+	if err != nil {
+		mr.logger.errorf("Handoff: failed to send state: %v", err)
+	}
+	mr.logger.infof("Handoff: successor %d took over %s, draining %d jobs",
+		cmd.Process.Pid, mr.address, len(handles))
+
+	for _, handle := range handles {
+		<-handle.Done()
+	}
+	mr.cleanup()
+	return cmd.Process, nil
+}
+
+// inheritedListener returns the listener passed on by a predecessor
+// master, or nil if the process was not started by Handoff. The
+// handoff environment is consumed, so children of the successor do
+// not inherit it.
+func inheritedListener() (net.Listener, *handoffState, error) {
+	if os.Getenv(HandoffEnv) == "" {
+		return nil, nil, nil
+	}
+	os.Unsetenv(HandoffEnv)
+
+	listenerFile := os.NewFile(handoffListenerFD, "listener")
+	defer listenerFile.Close()
+	l, err := net.FileListener(listenerFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inherit listener: %v", err)
+	}
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+
+	var state handoffState
+	stateFile := os.NewFile(handoffStateFD, "handoff-state")
+	defer stateFile.Close()
+	if err := json.NewDecoder(stateFile).Decode(&state); err != nil {
+		processLog.warnf("Handoff: no state from predecessor: %v", err)
+	}
+	return l, &state, nil
+}
+
+// adoptHandoffState registers the workers known to the predecessor
+func (mr *Master) adoptHandoffState(state *handoffState) {
+	mr.Lock()
+	defer mr.Unlock()
+	for _, w := range state.Workers {
+		mr.trackWorker(w)
+		mr.addWorker(w)
+	}
+	mr.logger.infof("Handoff: adopted %d workers from predecessor", len(state.Workers))
+}
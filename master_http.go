@@ -0,0 +1,174 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// httpRPCPath is where the HTTP interface of a master answers the
+// net/rpc CONNECT requests of workers and clients
+const httpRPCPath = "/rpc"
+
+// Handler returns the master's HTTP interface, for services that
+// embed the master in an HTTP server they already run:
+//
+//	GET     /            dashboard of the submitted jobs and the workers
+//	GET     /jobs        status of every submitted job
+//	POST    /jobs        submit a JobRequest, answers {"id": ...}
+//	GET     /jobs/{id}   status of a job
+//	DELETE  /jobs/{id}   cancel a job
+//	GET     /jobs/{id}/events        server-sent events of a job, see WatchJob
+//	GET     /jobs/{id}/preview?n=10  records of a succeeded job's result
+//	GET     /jobs/{id}/dashboard     phases, tasks and result files of a job
+//	GET     /jobs/{id}/output/{part} reduce output file of a succeeded job
+//	GET     /workers     registered workers
+//	GET     /status      running job, its task counts and the workers
+//	GET     /metrics     RPC, task and job metrics, see MetricsHandler
+//	CONNECT /rpc         RPCs of workers and clients of http:// masters
+//
+// The interface does not check the worker token. Masters with control
+// tokens, see WithControlTokens, answer GET requests of observers and
+// the others only for operators; the token is sent as a bearer token
+// or in the token query parameter. Without control tokens, wrap the
+// interface in the service's own authentication before exposing job
+// submission.
+func (mr *Master) Handler() http.Handler {
+	mux := http.NewServeMux()
+	observe := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, mr.requireRole(RoleObserver, handler))
+	}
+	observe("GET /{$}", mr.serveDashboard)
+	observe("GET /jobs", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, mr.jobStatuses())
+	})
+	mux.HandleFunc("POST /jobs", mr.requireRole(RoleOperator, mr.serveSubmit))
+	observe("GET /jobs/{id}", func(w http.ResponseWriter, req *http.Request) {
+		handle, err := mr.lookupJob(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, handle.Status())
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", mr.requireRole(RoleOperator, func(w http.ResponseWriter, req *http.Request) {
+		handle, err := mr.lookupJob(req.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		handle.Cancel()
+		writeJSON(w, http.StatusOK, handle.Status())
+	}))
+	observe("GET /jobs/{id}/events", mr.serveEvents)
+	observe("GET /jobs/{id}/preview", mr.servePreview)
+	observe("GET /jobs/{id}/dashboard", mr.serveJobDashboard)
+	observe("GET /jobs/{id}/output/{part}", mr.serveOutput)
+	observe("GET /workers", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, mr.Workers())
+	})
+	observe("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, mr.CurrentStatus())
+	})
+	observe("GET /metrics", MetricsHandler().ServeHTTP)
+	mux.HandleFunc("CONNECT "+httpRPCPath, mr.serveRPC)
+	return mux
+}
+
+// Mount serves the master's HTTP interface, see Handler, below prefix
+// on mux, next to the embedding service's own handlers:
+//
+//	mr, err := mapreduce.StartMaster("http://10.0.0.1:8080/mapreduce")
+//	mr.Mount(mux, "/mapreduce")
+//
+// Masters started on an http:// address have no listener of their
+// own: workers reach them through the mounted RPC endpoint, so they
+// must be mounted at the path of their address.
+func (mr *Master) Mount(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, mr.Handler()))
+}
+
+// serveRPC hands a CONNECT request's connection to the RPC server
+func (mr *Master) serveRPC(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case mr.rpcServer == nil:
+		http.Error(w, "master serves RPCs on "+mr.address, http.StatusNotFound)
+	case mr.rpcClosed.Load():
+		http.Error(w, "master is shut down", http.StatusServiceUnavailable)
+	default:
+		mr.rpcServer.ServeHTTP(w, req)
+	}
+}
+
+// serveSubmit queues the JobRequest in the request body
+func (mr *Master) serveSubmit(w http.ResponseWriter, req *http.Request) {
+	args := SubmitJobArgs{Version: ControlProtocolVersion, Token: requestToken(req)}
+	if err := json.NewDecoder(req.Body).Decode(&args.Job); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var reply SubmitJobReply
+	if err := mr.SubmitJob(&args, &reply); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": reply.JobID})
+}
+
+// defaultPreviewRecords is the number of records of each kind a
+// preview over HTTP returns unless the request asks for another one
+const defaultPreviewRecords = 10
+
+// servePreview answers with a preview of a succeeded job's result, see
+// JobHandle.Preview
+func (mr *Master) servePreview(w http.ResponseWriter, req *http.Request) {
+	args := PreviewResultArgs{Version: ControlProtocolVersion, Token: requestToken(req), JobID: req.PathValue("id"), N: defaultPreviewRecords}
+	if n := req.URL.Query().Get("n"); n != "" {
+		var err error
+		if args.N, err = strconv.Atoi(n); err != nil {
+			http.Error(w, "invalid n: "+n, http.StatusBadRequest)
+			return
+		}
+	}
+	if _, err := mr.lookupJob(args.JobID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	var preview OutputPreview
+	if err := mr.PreviewResult(&args, &preview); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// jobStatuses returns the status of every submitted job in submission
+// order
+func (mr *Master) jobStatuses() []JobStatus {
+	mr.Lock()
+	handles := make([]*JobHandle, 0, len(mr.handles))
+	for _, handle := range mr.handles {
+		handles = append(handles, handle)
+	}
+	mr.Unlock()
+
+	sort.Slice(handles, func(i, j int) bool { return handles[i].seq < handles[j].seq })
+	statuses := make([]JobStatus, len(handles))
+	for i, handle := range handles {
+		statuses[i] = handle.Status()
+	}
+	return statuses
+}
+
+// writeJSON answers a request with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		processLog.warnf("HTTP: encode reply: %v", err)
+	}
+}
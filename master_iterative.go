@@ -0,0 +1,116 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotConverged is returned by RunIterative, along with the result of
+// the last iteration, when the result did not converge within the
+// allowed number of iterations.
+var ErrNotConverged = errors.New("result did not converge")
+
+// ResultSet is the result of a succeeded job: the outputs of its reduce
+// tasks, which hold one JSON encoded KeyValue per line.
+type ResultSet struct {
+	JobID     string
+	Iteration int      // Iteration of RunIterative that produced the result, zero for other jobs
+	Files     []string // Reduce output files by partition, without the partitions left empty
+}
+
+// Each passes every record of the result to fn, partition after
+// partition, and stops at the first error fn returns
+func (r ResultSet) Each(fn func(KeyValue) error) error {
+	for _, file := range r.Files {
+		if err := decodeResultPart(file, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Map returns the records of the result by key. Results holding a key
+// more than once keep its last value.
+func (r ResultSet) Map() (map[string]string, error) {
+	records := make(map[string]string)
+	err := r.Each(func(kv KeyValue) error {
+		records[kv.Key] = kv.Value
+		return nil
+	})
+	return records, err
+}
+
+// Result returns the result of a succeeded job, which stays readable
+// until its workspace is purged
+func (h *JobHandle) Result() (ResultSet, error) {
+	status := h.Status()
+	if status.State != JobSucceeded {
+		return ResultSet{}, fmt.Errorf("job %s has no result, it is %s", h.id, status.State)
+	}
+	result := ResultSet{JobID: h.id}
+	for _, file := range h.workspace.ReduceOutputs(status.NReduce) {
+		if _, err := statFile(file); err == nil {
+			result.Files = append(result.Files, file)
+		}
+	}
+	return result, nil
+}
+
+// ParseResultRecord decodes a line of a reduce output, e.g. the input
+// of the map function of an iteration of RunIterative
+func ParseResultRecord(line string) (KeyValue, error) {
+	var kv KeyValue
+	if err := json.Unmarshal([]byte(line), &kv); err != nil {
+		return KeyValue{}, fmt.Errorf("invalid result record %q: %v", line, err)
+	}
+	return kv, nil
+}
+
+// RunIterative runs job over and over, as algorithms like PageRank do,
+// until converged reports that an iteration's result cur hardly
+// differs from prev, the one before, or maxIters iterations ran. The
+// first iteration reads the job's input; every later one reads the
+// result of the one before with the InputLines format, passing every
+// record to a map call of its own, see ParseResultRecord. Iterations
+// are submitted one after the other as jobs named after job with the
+// iteration appended, e.g. "pagerank-iter3", and run on the workers
+// registered with the master, which stay registered from one iteration
+// to the next. RunIterative returns the result of the last iteration,
+// along with ErrNotConverged if it did not converge.
+func (mr *Master) RunIterative(job JobConfig, maxIters int, converged func(prev, cur ResultSet) bool) (ResultSet, error) {
+	if maxIters < 1 {
+		return ResultSet{}, fmt.Errorf("invalid number of iterations: %d", maxIters)
+	}
+	config := job
+	var prev ResultSet
+	for i := 1; i <= maxIters; i++ {
+		config.Name = JobParse(fmt.Sprintf("%s-iter%d", job.Name, i))
+		handle, err := mr.Submit(config)
+		if err != nil {
+			return prev, fmt.Errorf("iteration %d of %s: %w", i, job.Name, err)
+		}
+		if err := handle.Wait(); err != nil {
+			return prev, fmt.Errorf("iteration %d of %s: %w", i, job.Name, err)
+		}
+		cur, err := handle.Result()
+		if err != nil {
+			return prev, err
+		}
+		cur.Iteration = i
+		if i > 1 && converged(prev, cur) {
+			mr.logger.infof("RunIterative: %s converged after %d iterations", job.Name, i)
+			return cur, nil
+		}
+		if len(cur.Files) == 0 {
+			return cur, fmt.Errorf("iteration %d of %s has an empty result", i, job.Name)
+		}
+
+		prev = cur
+		config.Files = cur.Files
+		config.Inputs = nil
+		config.Options = append(job.Options[:len(job.Options):len(job.Options)], WithInputFormat(InputLines))
+	}
+	return prev, ErrNotConverged
+}
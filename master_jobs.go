@@ -0,0 +1,160 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// runningJob is a job being run by a master of its own, see
+// newJobMaster
+type runningJob struct {
+	handle *JobHandle
+	master *Master
+}
+
+// WithConcurrentJobs lets the master run up to n submitted jobs at
+// once instead of one after the other. Jobs start in submission order
+// as others finish, except that a job waits while another job with the
+// same workspace runs, since their files would collide; jobs behind it
+// may start first. Jobs of the same name do not collide: the default
+// workspace of every job is named after its ID. Running jobs share the
+// workers: every phase being scheduled holds the task slots it was
+// handed until it ends, and takes no more than its share of all slots
+// while other phases are scheduled. It is an option of StartMaster,
+// ignored when given to a single job.
+func WithConcurrentJobs(n int) Option {
+	return func(mr *Master) {
+		mr.concurrentJobs = n
+	}
+}
+
+// maxRunningJobs returns the number of jobs the master runs at once
+func (mr *Master) maxRunningJobs() int {
+	return max(mr.concurrentJobs, 1)
+}
+
+// newJobMaster creates the master running one job. It shares the
+// workers, the job queue and the lock of mr and starts from the
+// master's default options and the job's own. The caller must hold the
+// lock.
+func (mr *Master) newJobMaster(handle *JobHandle) *Master {
+	job := &Master{cluster: mr.cluster, address: mr.address, logger: mr.logger}
+	job.resetJobSettings()
+	job.applyOptions(mr.defaults)
+	job.applyOptions(handle.config.Options)
+	job.inputGroups = handle.config.Inputs
+	if job.options.Workspace == "" {
+		// Jobs of the same name keep their files apart
+		job.options.Workspace = DefaultWorkspace(JobParse(handle.id)).Root
+	}
+	return job
+}
+
+// blocks reports whether job, about to run handle, has to wait for a
+// running job that writes to the same files, i.e. has the same
+// workspace. The caller must hold the lock.
+func (mr *Master) blocks(job *Master, handle *JobHandle) bool {
+	root := job.options.workspace(handle.config.Name).Root
+	for _, r := range mr.running {
+		if r.master.options.workspace(r.handle.config.Name).Root == root {
+			return true
+		}
+	}
+	return false
+}
+
+// startRunning records that job runs handle. The caller must hold the
+// lock.
+func (mr *Master) startRunning(job *Master, handle *JobHandle) {
+	mr.running = append(mr.running, runningJob{handle: handle, master: job})
+	mr.last = job
+}
+
+// stopRunning records that the job of handle has finished, also in
+// the checkpoint
+func (mr *Master) stopRunning(handle *JobHandle) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.checkpoint(checkpointEntry{Kind: checkpointEnd, JobID: handle.id, State: handle.Status().State})
+	for i, r := range mr.running {
+		if r.handle == handle {
+			mr.running = append(mr.running[:i], mr.running[i+1:]...)
+			break
+		}
+	}
+	mr.newCond.Broadcast() // Running phases may take over its slots
+}
+
+// currentJob returns the job started last among the running ones, nil
+// when no job runs. The caller must hold the lock.
+func (mr *Master) currentJob() *JobHandle {
+	if len(mr.running) == 0 {
+		return nil
+	}
+	return mr.running[len(mr.running)-1].handle
+}
+
+// lastJob returns the master of the job started last, which may have
+// finished, or mr itself before the first job and for sequential jobs.
+// The caller must hold the lock.
+func (mr *Master) lastJob() *Master {
+	if mr.last == nil {
+		return mr
+	}
+	return mr.last
+}
+
+// jobMaster returns the master of the running job with the workspace
+// rooted at workspace, or named name if workspace is empty, e.g. for
+// workers of older versions, or that of the job started last if no
+// running job matches, e.g. for workers that do not say which job they
+// ask for. The caller must hold the lock.
+func (mr *Master) jobMaster(name JobParse, workspace string) *Master {
+	for _, r := range mr.running {
+		if workspace != "" && r.master.options.workspace(r.handle.config.Name).Root == workspace ||
+			workspace == "" && r.handle.config.Name == name {
+			return r.master
+		}
+	}
+	return mr.lastJob()
+}
+
+// heldSlots returns the slots of current generations of registered
+// workers in forwarded. The caller must hold the lock.
+func (mr *Master) heldSlots(forwarded map[string]forwarding) int {
+	held := 0
+	for _, w := range mr.workers {
+		if f, ok := forwarded[w]; ok && f.gen == mr.workerGen[w] {
+			held += f.slots
+		}
+	}
+	return held
+}
+
+// leasedSlots returns the slots of worker held by the phases of other
+// running jobs. The caller must hold the lock.
+func (mr *Master) leasedSlots(worker string) int {
+	leased := 0
+	for _, r := range mr.running {
+		if f, ok := r.master.forwarded[worker]; ok && r.master != mr && f.gen == mr.workerGen[worker] {
+			leased += f.slots
+		}
+	}
+	return leased
+}
+
+// slotShare returns the most slots the phase being scheduled may hold:
+// all slots of the registered workers, divided evenly, rounding up,
+// among the phases of the running jobs. The caller must hold the lock.
+func (mr *Master) slotShare() int {
+	total := 0
+	for _, w := range mr.workers {
+		total += mr.workerSlots(w)
+	}
+	phases := 0
+	for _, r := range mr.running {
+		if r.master.forwarded != nil {
+			phases++
+		}
+	}
+	if phases <= 1 {
+		return total
+	}
+	return (total + phases - 1) / phases
+}
@@ -0,0 +1,241 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Kinds of journal entries
+const (
+	journalJob   = "job"   // Opens the journal, describes the job
+	journalTask  = "task"  // A task completed
+	journalPhase = "phase" // All tasks of a phase completed
+	journalDone  = "done"  // The job finished successfully
+)
+
+// journalEntry is a line of a job journal
+type journalEntry struct {
+	Kind string `json:"kind"`
+
+	// Job entries
+	JobName JobParse    `json:"job_name,omitempty"`
+	NReduce int         `json:"n_reduce,omitempty"`
+	Splits  []SplitInfo `json:"splits,omitempty"`
+	Seed    int64       `json:"seed,omitempty"`
+
+	// Task and phase entries
+	Phase   JobParse     `json:"phase,omitempty"`
+	Task    int          `json:"task,omitempty"`
+	Metrics *TaskMetrics `json:"metrics,omitempty"`
+}
+
+// jobJournal appends the progress of the running job to its journal
+type jobJournal struct {
+	mu     sync.Mutex
+	path   string
+	file   io.WriteCloser
+	logger nodeLog
+}
+
+// journalState is what the journal of an earlier run of a job holds
+type journalState struct {
+	job       journalEntry
+	completed map[JobParse]map[int]TaskMetrics // Metrics of the completed tasks by phase and task
+	done      bool
+}
+
+// WithJournal makes the master journal the job's progress: every
+// completed task is appended to logs/journal.jsonl of its workspace,
+// see JobWorkspace.Journal, as it completes. When the master dies
+// mid-job, submitting the same job again after a restart resumes it:
+// tasks the journal lists as completed are not run again as long as
+// their output files are still in the workspace, and their counters
+// and metrics are carried over. A job resumes only if it has the same
+// name, input splits and number of reduce tasks as the journaled one;
+// it also reuses its seed. Jobs that finished start over. Workers
+// should not cache map output, see WithCombinerCache, whose tasks are
+// run again as their output never reached the workspace. Remote
+// workspaces are not journaled.
+func WithJournal() Option {
+	return func(mr *Master) {
+		mr.journaled = true
+	}
+}
+
+// Journal returns the path of the job's journal, see WithJournal
+func (w *JobWorkspace) Journal() string {
+	return joinPath(w.LogsDir(), "journal.jsonl")
+}
+
+// readJournal loads the journal of an earlier run. Lines torn by a
+// crash end it.
+func readJournal(path string) (*journalState, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &journalState{completed: make(map[JobParse]map[int]TaskMetrics)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		switch entry.Kind {
+		case journalJob:
+			state.job = entry
+		case journalTask:
+			if state.completed[entry.Phase] == nil {
+				state.completed[entry.Phase] = make(map[int]TaskMetrics)
+			}
+			var metrics TaskMetrics
+			if entry.Metrics != nil {
+				metrics = *entry.Metrics
+			}
+			state.completed[entry.Phase][entry.Task] = metrics
+		case journalDone:
+			state.done = true
+		}
+	}
+	if state.job.Kind != journalJob {
+		return nil, fmt.Errorf("journal %s does not describe a job", path)
+	}
+	return state, nil
+}
+
+// matches reports whether the journaled job is the one about to run,
+// whose seed is zero unless set with WithSeed
+func (s *journalState) matches(jobName JobParse, splits []SplitInfo, nReduce int, seed int64) bool {
+	return !s.done && s.job.JobName == jobName && s.job.NReduce == nReduce &&
+		(seed == 0 || seed == s.job.Seed) && reflect.DeepEqual(s.job.Splits, splits)
+}
+
+// openJournal starts the journal of the current job, first carrying
+// over the entries of resumed, the tasks of an earlier run whose
+// output is still there, if not nil
+func (mr *Master) openJournal(ws *JobWorkspace, resumed *journalState) (*jobJournal, error) {
+	file, err := createFile(ws.Journal())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal: %v", err)
+	}
+	j := &jobJournal{path: ws.Journal(), file: file, logger: mr.logger}
+	j.record(journalEntry{Kind: journalJob, JobName: mr.jobName, NReduce: mr.nReduce, Splits: mr.splits, Seed: mr.options.Seed})
+	if resumed != nil {
+		for _, phase := range []JobParse{mapParse, reduceParse} {
+			for task, metrics := range resumed.completed[phase] {
+				j.completeTask(phase, task, metrics)
+			}
+		}
+	}
+	return j, nil
+}
+
+// record appends an entry and flushes it to disk
+func (j *jobJournal) record(entry journalEntry) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		j.logger.warnf("Journal: encode entry: %v", err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		j.logger.warnf("Journal: write %s: %v", j.path, err)
+		return
+	}
+	if syncer, ok := j.file.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			j.logger.warnf("Journal: sync %s: %v", j.path, err)
+		}
+	}
+}
+
+// completeTask records a completed task with its metrics
+func (j *jobJournal) completeTask(phase JobParse, task int, metrics TaskMetrics) {
+	j.record(journalEntry{Kind: journalTask, Phase: phase, Task: task, Metrics: &metrics})
+}
+
+// completePhase records that all tasks of a phase completed
+func (j *jobJournal) completePhase(phase JobParse) {
+	j.record(journalEntry{Kind: journalPhase, Phase: phase})
+}
+
+// close ends the journal, marking the job done if it succeeded
+func (j *jobJournal) close(succeeded bool) {
+	if j == nil {
+		return
+	}
+	if succeeded {
+		j.record(journalEntry{Kind: journalDone})
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return
+	}
+	if err := j.file.Close(); err != nil {
+		j.logger.warnf("Journal: close %s: %v", j.path, err)
+	}
+	j.file = nil
+}
+
+// resumeJournal returns the journal of an earlier, unfinished run of
+// the current job, keeping only the tasks whose output is still in the
+// workspace, or nil if there is nothing to resume. It restores the
+// seed of that run.
+func (mr *Master) resumeJournal(ws *JobWorkspace) *journalState {
+	state, err := readJournal(ws.Journal())
+	if err != nil {
+		return nil
+	}
+	if !state.matches(mr.jobName, mr.splits, mr.nReduce, mr.options.Seed) {
+		mr.logger.infof("Journal: %s belongs to another run of the job, starting over", ws.Journal())
+		return nil
+	}
+	replica := mr.options.replica(mr.jobName)
+	for task := range state.completed[mapParse] {
+		for r := 0; r < mr.nReduce; r++ {
+			if _, err := statFile(readableCopy(ws, replica, ws.Intermediate(task, r))); err != nil {
+				delete(state.completed[mapParse], task)
+				break
+			}
+		}
+	}
+	for task := range state.completed[reduceParse] {
+		if _, err := statFile(ws.ReduceOutput(task)); err != nil {
+			delete(state.completed[reduceParse], task)
+		}
+	}
+	mr.options.Seed = state.job.Seed
+	mr.logger.infof("Journal: resuming %s with %d map and %d reduce tasks completed",
+		mr.jobName, len(state.completed[mapParse]), len(state.completed[reduceParse]))
+	return state
+}
+
+// tasks returns the metrics of the tasks of phase an earlier run
+// completed, nil if none
+func (s *journalState) tasks(phase JobParse, total int) map[int]TaskMetrics {
+	if s == nil {
+		return nil
+	}
+	tasks := make(map[int]TaskMetrics)
+	for task, metrics := range s.completed[phase] {
+		if task < total {
+			tasks[task] = metrics
+		}
+	}
+	return tasks
+}
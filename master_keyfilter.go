@@ -0,0 +1,90 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// WithKeyPrefixes keeps only the map output records whose key starts
+// with one of prefixes, e.g. WithKeyPrefixes("com.") for the domains
+// under .com written in reverse. Map tasks drop all other records once
+// they are partitioned, and reduce tasks whose partitions end up empty
+// are not scheduled at all, which saves most of the reduce phase of
+// selective jobs. Under WithSecondarySort the prefixes apply to the
+// group key. Combines with WithKeyFilter.
+func WithKeyPrefixes(prefixes ...string) Option {
+	return func(mr *Master) {
+		mr.options.KeyPrefixes = append([]string(nil), prefixes...)
+	}
+}
+
+// WithKeyFilter keeps only the map output records whose key passes
+// the key filter registered under name, see RegisterKeyFilter and
+// WithKeyPrefixes.
+func WithKeyFilter(name string) Option {
+	return func(mr *Master) {
+		mr.options.KeyFilter = name
+	}
+}
+
+// addPartitionRecords records how many records a map task of the
+// current job wrote to every partition
+func (mr *Master) addPartitionRecords(task int, records []int64) {
+	if records == nil {
+		return
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	mr.partitionRecords[task] = records
+}
+
+// pruneReduceTasks marks the reduce tasks of a job filtering keys
+// whose partitions no map task wrote to, and creates their empty
+// output files so the merge finds every file it expects. Nothing is
+// pruned unless every map task reported its partitions, e.g. because
+// some ran on workers that do not report them.
+func (mr *Master) pruneReduceTasks() {
+	if !mr.options.filtersKeys() {
+		return
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	if len(mr.partitionRecords) < len(mr.splits) {
+		return
+	}
+	total := make([]int64, mr.nReduce)
+	for _, records := range mr.partitionRecords {
+		if len(records) != mr.nReduce {
+			return
+		}
+		for i, n := range records {
+			total[i] += n
+		}
+	}
+
+	pruned := make([]bool, mr.nReduce)
+	n := 0
+	ws := mr.workspace()
+	for i, records := range total {
+		if records > 0 {
+			continue
+		}
+		// A task whose output cannot be created runs after all
+		outFile := ws.ReduceOutput(i)
+		err := mkdirAll(dirPath(outFile))
+		if err == nil {
+			err = writeFile(outFile, nil)
+		}
+		if err != nil {
+			mr.logger.warnf("Master: reduce task %d of job %s: %v", i, mr.jobName, err)
+			continue
+		}
+		pruned[i] = true
+		n++
+	}
+	if n > 0 {
+		mr.pruned = pruned
+		mr.logger.infof("Master: skipping %d of %d reduce tasks of job %s, their partitions are empty", n, mr.nReduce, mr.jobName)
+	}
+}
+
+// isPruned reports whether a reduce task of the current job is skipped
+func (mr *Master) isPruned(task int) bool {
+	return task < len(mr.pruned) && mr.pruned[task]
+}
@@ -0,0 +1,98 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultHeartbeatMisses is how many heartbeats a worker may miss
+// before the master considers it dead
+const defaultHeartbeatMisses = 3
+
+// workerLiveness tracks the heartbeats of one registered worker
+type workerLiveness struct {
+	lastSeen time.Time     // Time of the last heartbeat or registration
+	lost     chan struct{} // Closed once the worker missed too many heartbeats
+}
+
+// trackWorker starts or resumes watching the heartbeats of worker.
+// The caller must hold the lock.
+func (mr *Master) trackWorker(worker string) {
+	if mr.heartbeat <= 0 {
+		return
+	}
+	if mr.liveness == nil {
+		mr.liveness = make(map[string]*workerLiveness)
+	}
+	if l := mr.liveness[worker]; l != nil && !isClosed(l.lost) {
+		l.lastSeen = time.Now()
+		return
+	}
+	mr.liveness[worker] = &workerLiveness{lastSeen: time.Now(), lost: make(chan struct{})}
+}
+
+// lostChan returns a channel closed once worker is declared dead, or
+// nil when heartbeats are disabled. The caller must hold the lock.
+func (mr *Master) lostChan(worker string) chan struct{} {
+	if l := mr.liveness[worker]; l != nil {
+		return l.lost
+	}
+	return nil
+}
+
+// workerLost returns lostChan for the task scheduler
+func (mr *Master) workerLost(worker string) <-chan struct{} {
+	mr.Lock()
+	defer mr.Unlock()
+	return mr.lostChan(worker)
+}
+
+// Heartbeat records that a worker is alive. Workers the master does
+// not know, e.g. after being declared dead, are told to register again.
+func (mr *Master) Heartbeat(args *HeartbeatArgs, reply *HeartbeatReply) error {
+	mr.Lock()
+	defer mr.Unlock()
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+
+	reply.Registered = true
+	if mr.heartbeat <= 0 {
+		return nil
+	}
+	l := mr.liveness[args.Worker]
+	if l == nil || isClosed(l.lost) {
+		reply.Registered = false
+		return nil
+	}
+	l.lastSeen = time.Now()
+	return nil
+}
+
+// monitorHeartbeats declares workers dead once they have missed too
+// many heartbeats, checking every interval. Their running tasks are
+// abandoned and rescheduled on other workers.
+func (mr *Master) monitorHeartbeats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mr.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		mr.Lock()
+		deadline := time.Now().Add(-mr.heartbeat * time.Duration(mr.heartbeatMisses))
+		for worker, l := range mr.liveness {
+			if !isClosed(l.lost) && l.lastSeen.Before(deadline) {
+				mr.logger.warnf("Master: worker %s missed %d heartbeats, removing it",
+					worker, mr.heartbeatMisses)
+				close(l.lost)
+				mr.removeWorker(worker)
+			}
+		}
+		mr.Unlock()
+	}
+}
@@ -0,0 +1,24 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// addMapProducer records that worker wrote the output of a map task of
+// the current job
+func (mr *Master) addMapProducer(task int, worker string) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.producers[task] = worker
+}
+
+// mapProducers returns the worker that wrote the output of every map
+// task of the current job, for the reduce phase to watch, see
+// TaskScheduler.watchProducers. Tasks resumed from the journal have
+// none.
+func (mr *Master) mapProducers() map[int]string {
+	mr.Lock()
+	defer mr.Unlock()
+	producers := make(map[int]string, len(mr.producers))
+	for task, worker := range mr.producers {
+		producers[task] = worker
+	}
+	return producers
+}
@@ -0,0 +1,80 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SplitInfo records which part of which input file a map task
+// processes. Offset and Length are zero for tasks reading a whole
+// file, see WithSplitSize.
+type SplitInfo struct {
+	Task   int    `json:"task"`
+	File   string `json:"file"`
+	Offset int64  `json:"offset,omitempty"` // First byte of the split
+	Length int64  `json:"length,omitempty"` // Bytes in the split, zero to read to the end of the file
+	Group  string `json:"group,omitempty"`  // Input group of the file, see JobConfig.Inputs
+}
+
+// JobManifest describes how a job was laid out into tasks. It is
+// written next to the intermediate files when the job starts, so
+// reruns, resumes and debugging sessions can refer to the same
+// task numbers.
+type JobManifest struct {
+	JobName JobParse    `json:"job_name"`
+	NReduce int         `json:"n_reduce"`
+	Splits  []SplitInfo `json:"splits"`
+	Seed    int64       `json:"seed"` // Seed of the job's random sources, see WithSeed
+	Created time.Time   `json:"created"`
+}
+
+// sortSplits returns the input files in the order map tasks are
+// numbered: lexically sorted, so the same input set always yields the
+// same task numbers regardless of the order the caller listed them in.
+func sortSplits(files []string) []string {
+	sorted := append([]string{}, files...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// newJobManifest builds the manifest of a job whose splits are
+// already in task order
+func newJobManifest(jobName JobParse, splits []SplitInfo, nReduce int) *JobManifest {
+	return &JobManifest{
+		JobName: jobName,
+		NReduce: nReduce,
+		Splits:  splits,
+		Created: time.Now(),
+	}
+}
+
+// Write stores the manifest as indented JSON
+func (m *JobManifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := mkdirAll(dirPath(path)); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %v", err)
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	return nil
+}
+
+// ReadJobManifest loads a manifest written by a previous run
+func ReadJobManifest(path string) (*JobManifest, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var m JobManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %v", err)
+	}
+	return &m, nil
+}
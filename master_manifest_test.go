@@ -0,0 +1,67 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestSortSplits checks that map tasks are numbered in the same order
+// whatever order the input files are listed in, and that the caller's
+// list is left alone.
+func TestSortSplits(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"empty", nil, []string{}},
+		{"single", []string{"a.txt"}, []string{"a.txt"}},
+		{"reversed", []string{"c.txt", "b.txt", "a.txt"}, []string{"a.txt", "b.txt", "c.txt"}},
+		{"directories", []string{"in/b/1.txt", "in/a/2.txt", "in/a/1.txt"}, []string{"in/a/1.txt", "in/a/2.txt", "in/b/1.txt"}},
+		{"numbered", []string{"part-10", "part-9", "part-1"}, []string{"part-1", "part-10", "part-9"}},
+		{"duplicates", []string{"b", "a", "b"}, []string{"a", "b", "b"}},
+	}
+
+	for _, tc := range tests {
+		files := append([]string(nil), tc.files...)
+		if got := sortSplits(files); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if !reflect.DeepEqual(files, tc.files) {
+			t.Errorf("%s: input reordered to %q", tc.name, files)
+		}
+	}
+}
+
+// TestJobManifestRoundTrip writes a manifest and reads it back
+func TestJobManifestRoundTrip(t *testing.T) {
+	splits := []SplitInfo{
+		{Task: 0, File: "in/a.txt"},
+		{Task: 1, File: "in/b.txt", Offset: 0, Length: 1 << 20},
+		{Task: 2, File: "in/b.txt", Offset: 1 << 20, Group: "logs"},
+	}
+	m := newJobManifest("wordcount", splits, 3)
+	m.Seed = 42
+
+	path := filepath.Join(t.TempDir(), "manifest", "manifest.json")
+	if err := m.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadJobManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(m.Created) {
+		t.Errorf("created %v, want %v", got.Created, m.Created)
+	}
+	got.Created = m.Created
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+
+	if _, err := ReadJobManifest(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("reading a missing manifest succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Merger combines the per-partition outputs of the reduce phase.
+// parts contains the output file of every reduce task, indexed by
+// reduce task number.
+type Merger interface {
+	Merge(jobName JobParse, parts []string) error
+}
+
+// MergerFunc adapts an ordinary function to the Merger interface,
+// which is convenient for one-off strategies such as uploading the
+// parts to object storage.
+type MergerFunc func(jobName JobParse, parts []string) error
+
+// Merge calls f(jobName, parts)
+func (f MergerFunc) Merge(jobName JobParse, parts []string) error {
+	return f(jobName, parts)
+}
+
+// accumulatingMerger is the default strategy. It loads every part into
+// memory and writes a single sorted result file through ResultMerger.
+type accumulatingMerger struct {
+	budget  uint64       // Heap budget in bytes, zero means unlimited
+	format  OutputFormat // Format of the result file, nil for TextOutput
+	dir     string       // Directory of the result file, the configured result path if empty
+	inOrder bool         // Keeps the records in partition order instead of sorting them by key
+}
+
+// Merge runs a ResultMerger over the given parts
+func (a accumulatingMerger) Merge(jobName JobParse, parts []string) error {
+	merger := NewResultMerger(jobName, len(parts))
+	merger.parts = parts
+	merger.guard = newMemoryGuard(a.budget)
+	if a.format != nil {
+		merger.format = a.format
+	}
+	if a.dir != "" {
+		merger.resultDir = a.dir
+		merger.resultFile = joinPath(a.dir, resultFileName)
+	}
+	if a.inOrder {
+		if err := merger.prepareResultDirectory(); err != nil {
+			return fmt.Errorf("failed to prepare result directory: %v", err)
+		}
+		return merger.streamResults()
+	}
+	return merger.Execute()
+}
+
+// NoopMerger leaves the per-partition reduce outputs in place.
+// It is useful when downstream consumers read the parts directly.
+type NoopMerger struct{}
+
+// Merge only reports where the parts can be found
+func (NoopMerger) Merge(jobName JobParse, parts []string) error {
+	processLog.infof("Merge: keeping %d result parts of job %s", len(parts), jobName)
+	return nil
+}
+
+// WriterMerger streams every record of every part to W, one
+// "key: value" line per record unless Format is set, without
+// accumulating them in memory. Records are written in part order, not
+// sorted by key.
+type WriterMerger struct {
+	W      io.Writer
+	Format OutputFormat // Writes every record with its single value, may be nil
+}
+
+// Merge copies all records of the parts to the writer
+func (m WriterMerger) Merge(jobName JobParse, parts []string) error {
+	if m.W == nil {
+		return fmt.Errorf("writer merger has no writer")
+	}
+
+	writer := bufio.NewWriter(m.W)
+	for _, part := range parts {
+		if err := streamPart(part, writer, m.Format); err != nil {
+			return fmt.Errorf("failed to stream %s: %v", part, err)
+		}
+	}
+	return writer.Flush()
+}
+
+// streamPart decodes a single reduce output and writes its records,
+// in format if it is not nil
+func streamPart(fileName string, w io.Writer, format OutputFormat) error {
+	file, err := openFile(fileName, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := newRecordDecoder(file, recordCodecJSON)
+	for {
+		var kv KeyValue
+		if err := decoder.Decode(&kv); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if format != nil {
+			if err := format.WriteRecord(w, kv.Key, []string{kv.Value}); err != nil {
+				return err
+			}
+		} else if _, err := fmt.Fprintf(w, "%s: %s\n", kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+}
+
+// ExternalMerger hands the parts off to an external program.
+// The part file paths are appended to Args, so the command is run as
+// "Command Args... part-0 part-1 ...".
+type ExternalMerger struct {
+	Command string
+	Args    []string
+}
+
+// Merge runs the external command and waits for it to finish
+func (m ExternalMerger) Merge(jobName JobParse, parts []string) error {
+	if m.Command == "" {
+		return fmt.Errorf("external merger command cannot be empty")
+	}
+
+	args := append(append([]string{}, m.Args...), parts...)
+	cmd := exec.Command(m.Command, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("external merger %s failed: %v", m.Command, err)
+	}
+	return nil
+}
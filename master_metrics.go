@@ -0,0 +1,29 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// WithMetricsLabels adds labels to the task and job metrics of the
+// job, e.g. the team owning it, so dashboards can break them down by
+// more than the job name, see MetricsSink. Labels named like the ones
+// the master sets, job, phase and state, are ignored.
+func WithMetricsLabels(labels map[string]string) Option {
+	return func(mr *Master) {
+		mr.metricsLabels = make(map[string]string, len(labels))
+		for name, value := range labels {
+			mr.metricsLabels[name] = value
+		}
+	}
+}
+
+// jobMetricsLabels returns the labels of a metric of the current job:
+// the job's own labels, its name and the given name and value
+func (mr *Master) jobMetricsLabels(name string, value string) map[string]string {
+	mr.Lock()
+	defer mr.Unlock()
+	labels := make(map[string]string, len(mr.metricsLabels)+2)
+	for n, v := range mr.metricsLabels {
+		labels[n] = v
+	}
+	labels["job"] = string(mr.jobName)
+	labels[name] = value
+	return labels
+}
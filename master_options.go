@@ -0,0 +1,498 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"time"
+)
+
+// Option configures optional behaviour of a Master.
+// Options are applied before the job starts running, so they can
+// safely replace any of the master's default components.
+type Option func(*Master)
+
+// WithMerger replaces the default result merger used once the
+// reduce phase has finished.
+func WithMerger(merger Merger) Option {
+	return func(mr *Master) {
+		mr.merger = merger
+	}
+}
+
+// WithOutputFormat writes the final result file in format instead of
+// "key: [v1 v2]" lines, e.g. TSVOutput, JSONLinesOutput or a custom
+// OutputFormat. It applies to the default merger only.
+func WithOutputFormat(format OutputFormat) Option {
+	return func(mr *Master) {
+		mr.outputFormat = format
+	}
+}
+
+// WithResultDir makes the default merger write the job's result file
+// to dir instead of the configured result path.
+func WithResultDir(dir string) Option {
+	return func(mr *Master) {
+		mr.resultDir = dir
+	}
+}
+
+// WithSequentialCombiner combines the output of the map tasks run by
+// Sequential like WithCombiner does on workers. Distributed jobs use
+// the combiner their workers were started with.
+func WithSequentialCombiner(combineF func(string, []string) string) Option {
+	return func(mr *Master) {
+		mr.combineF = combineF
+	}
+}
+
+// WithSequentialContext replaces the map and reduce functions of
+// Sequential like WithContextFuncs does on workers.
+func WithSequentialContext(mapF ContextMapFunc, reduceF ContextReduceFunc) Option {
+	return func(mr *Master) {
+		mr.mapCtxF = mapF
+		mr.reduceCtxF = reduceF
+	}
+}
+
+// WithSequentialStreamingMap replaces the map function of Sequential
+// like WithStreamingMap does on workers.
+func WithSequentialStreamingMap(mapF StreamMapFunc) Option {
+	return func(mr *Master) {
+		mr.streamMapF = mapF
+	}
+}
+
+// WithSequentialStreamingReduce replaces the reduce function of
+// Sequential like WithStreamingReduce does on workers. Sequential
+// returns the first error of the function.
+func WithSequentialStreamingReduce(reduceF StreamReduceFunc) Option {
+	return func(mr *Master) {
+		mr.streamReduceF = reduceF
+	}
+}
+
+// WithSeed sets the seed of the job's random sources, see
+// TaskContext.Rand. Without it every job gets a random seed, which is
+// recorded in the job manifest; passing it again reproduces the job.
+func WithSeed(seed int64) Option {
+	return func(mr *Master) {
+		mr.options.Seed = seed
+	}
+}
+
+// WithCompression overrides the automatic codec selection for
+// intermediate map output, e.g. CompressionSnappy or CompressionGzip.
+// Reduce tasks detect the codec of every file they read.
+func WithCompression(c Compression) Option {
+	return func(mr *Master) {
+		mr.options.Compression = c
+	}
+}
+
+// WithMemoryBudget limits the heap that reduce tasks and the result
+// merger may use while grouping records in memory. Reduce tasks fail
+// with a clear error once the budget is exceeded, the merger switches
+// to streaming the results unsorted.
+func WithMemoryBudget(bytes uint64) Option {
+	return func(mr *Master) {
+		mr.options.MemoryBudget = bytes
+	}
+}
+
+// WithWorkspace roots the job's workspace at dir instead of the
+// default directory inside the configured output path. The directory
+// must be reachable under the same path by all workers.
+func WithWorkspace(dir string) Option {
+	return func(mr *Master) {
+		mr.options.Workspace = dir
+	}
+}
+
+// WithIntermediateReplica writes a second copy of every map output
+// file to a workspace rooted at dir, e.g. on another disk or an object
+// store such as "s3://bucket/replicas". Reduce tasks read the copy of
+// a file that went missing after the map phase, e.g. with the disk
+// holding it, instead of failing. Like the workspace, dir must be
+// reachable under the same path by all workers.
+func WithIntermediateReplica(dir string) Option {
+	return func(mr *Master) {
+		mr.options.Replica = dir
+	}
+}
+
+// WithInputEncoding transcodes the job's input files from encoding e
+// to UTF-8 before they reach the map function. EncodingAuto detects
+// the encoding of each file, for corpora mixing encodings, where
+// undecoded input would split one word into differently spelled keys.
+func WithInputEncoding(e InputEncoding) Option {
+	return func(mr *Master) {
+		mr.options.InputEncoding = e
+	}
+}
+
+// WithSplitSize splits input files larger than bytes into several map
+// tasks of about bytes each, so a few huge files do not leave most
+// workers idle. Splits end on record boundaries: lines, unless the job
+// sets WithRecordDelimiter or WithRecordSize.
+func WithSplitSize(bytes int64) Option {
+	return func(mr *Master) {
+		mr.options.SplitSize = bytes
+	}
+}
+
+// WithInputFormat selects the input format dividing the job's input
+// into map tasks and map calls by name: one of the built-in formats
+// InputWholeFile, InputLineSplits, InputLines, InputDelimited and
+// InputFixedLength, or one added with RegisterInputFormat. Without it
+// the job picks a built-in format from its other input options.
+func WithInputFormat(name string) Option {
+	return func(mr *Master) {
+		mr.options.InputFormat = name
+	}
+}
+
+// WithCSVInput reads the job's input files as CSV, passing every
+// record to a map call of its own; wrap the map function in CSVMap to
+// receive the parsed fields. header says whether the first record of
+// every file holds field names to skip or to pass with every record.
+func WithCSVInput(header CSVHeader) Option {
+	return func(mr *Master) {
+		mr.options.InputFormat = InputCSV
+		mr.options.CSVHeader = header
+	}
+}
+
+// WithColumns declares the columns of structured input the map
+// function needs, so readers pass only those and the map function
+// parses less of every record. CSV input is projected on the named
+// columns of its header, or on zero-based column positions, e.g. "0"
+// and "3", when files have no header; a propagated header is projected
+// as well. Fields come in the order of columns.
+func WithColumns(columns ...string) Option {
+	return func(mr *Master) {
+		mr.options.Columns = append([]string(nil), columns...)
+	}
+}
+
+// WithRecordDelimiter makes map tasks call the map function once per
+// record of their input instead of once per file, records being
+// separated by delim, e.g. "\x00" or "\x1e". The delimiter is not
+// part of the value passed to the map function.
+func WithRecordDelimiter(delim string) Option {
+	return func(mr *Master) {
+		mr.options.RecordDelimiter = delim
+	}
+}
+
+// WithRecordSize makes map tasks call the map function once per
+// fixed-width record of size bytes, e.g. for mainframe data sets or
+// binary logs. A short record at the end of a file is passed as it is.
+func WithRecordSize(size int) Option {
+	return func(mr *Master) {
+		mr.options.RecordSize = size
+	}
+}
+
+// WithReadAhead makes map tasks read their input in blocks of
+// blockSize bytes on a background goroutine, one block ahead of the
+// block being consumed. This helps with inputs whose reads are slow,
+// such as files on network storage.
+func WithReadAhead(blockSize int) Option {
+	return func(mr *Master) {
+		mr.options.ReadAhead = blockSize
+	}
+}
+
+// WithDecodeWorkers lets every reduce task decode up to n of its
+// intermediate files in parallel. This shortens reduce tasks with many
+// compressed inputs; reduce functions then receive values in no
+// particular order.
+func WithDecodeWorkers(n int) Option {
+	return func(mr *Master) {
+		mr.options.DecodeWorkers = n
+	}
+}
+
+// WithGCPercent sets the garbage collection target percentage on
+// workers while they run the job's tasks, see debug.SetGCPercent.
+// Shuffle-heavy jobs often trade memory for less GC work with a higher
+// value. A negative value disables the collector.
+func WithGCPercent(percent int) Option {
+	return func(mr *Master) {
+		mr.options.GCPercent = percent
+	}
+}
+
+// WithMemoryLimit sets the soft memory limit of workers while they run
+// the job's tasks, see debug.SetMemoryLimit.
+func WithMemoryLimit(bytes int64) Option {
+	return func(mr *Master) {
+		mr.options.MemoryLimit = bytes
+	}
+}
+
+// WithMaxProcs sets GOMAXPROCS on workers while they run the job's tasks.
+func WithMaxProcs(n int) Option {
+	return func(mr *Master) {
+		mr.options.MaxProcs = n
+	}
+}
+
+// WithIntermediateFormat pins the layout of the job's intermediate
+// files, e.g. IntermediateFormatCodec while a rolling upgrade replaces
+// workers that cannot read newer layouts yet.
+func WithIntermediateFormat(format int) Option {
+	return func(mr *Master) {
+		mr.options.IntermediateFormat = format
+	}
+}
+
+// WithRecordCodec selects how the job's intermediate records are
+// encoded: CodecBinary, the default, or CodecJSON to read intermediate
+// files while debugging a job. Reduce tasks detect the codec of every
+// file they read. Reduce outputs are always JSON lines.
+func WithRecordCodec(codec RecordCodec) Option {
+	return func(mr *Master) {
+		mr.options.RecordCodec = codec
+	}
+}
+
+// WithMaxReduceValues splits keys with more than n values into chunks
+// of at most n values that are reduced independently; their results
+// are then reduced again by a second-level call. This bounds the work
+// of any single reduce call on heavily skewed keys, but requires a
+// reduce function that accepts its own output as a value, e.g. a sum
+// rather than a count of values.
+func WithMaxReduceValues(n int) Option {
+	return func(mr *Master) {
+		mr.options.MaxReduceValues = n
+	}
+}
+
+// WithBytesPerReducer sets how much input each reduce task of an
+// AutoReduce job should handle, DefaultBytesPerReducer by default.
+func WithBytesPerReducer(bytes int64) Option {
+	return func(mr *Master) {
+		mr.bytesPerReducer = bytes
+	}
+}
+
+// WithDeterminismCheck runs a share of the job's tasks, between zero
+// and one, a second time on the same worker and compares the digests
+// of their outputs. Retries and backup tasks are only safe with map and
+// reduce functions producing the same output on every run; tasks
+// failing the check are logged and listed in the job status. Use it in
+// staging runs, each checked task costs its run time again.
+func WithDeterminismCheck(share float64) Option {
+	return func(mr *Master) {
+		mr.verifyShare = share
+	}
+}
+
+// WithMaxInFlight lets at most n tasks of the job run at once, however
+// many workers are registered, e.g. when its tasks query a database or
+// an API that a large cluster would overwhelm. Idle workers wait for a
+// running task to finish. Zero, the default, runs a task on every
+// worker.
+func WithMaxInFlight(n int) Option {
+	return func(mr *Master) {
+		mr.maxInFlight = n
+	}
+}
+
+// WithRetryPolicy decides where the job's failed tasks rejoin the task
+// queue of their phase: behind all waiting tasks with RetryAtEnd, the
+// default, so healthy tasks finish before flaky ones are revisited;
+// ahead of them with RetryImmediately; or ahead of them once delay has
+// passed with RetryAfterDelay, giving a struggling dependency time to
+// recover. Failed tasks go ahead of waiting tasks whatever their
+// priority, see WithTaskPriority, unless the policy is RetryAtEnd.
+func WithRetryPolicy(policy RetryPolicy, delay time.Duration) Option {
+	return func(mr *Master) {
+		mr.retryPolicy = policy
+		mr.retryDelay = delay
+	}
+}
+
+// WithRateLimit adds a rate limit called name that the job's tasks
+// draw from with TaskContext.Acquire, e.g. to stay within the quota of
+// an API. The master hands out perSecond tokens per second to all
+// workers together, up to burst at once.
+func WithRateLimit(name string, perSecond float64, burst int) Option {
+	return func(mr *Master) {
+		if mr.rateLimits == nil {
+			mr.rateLimits = make(map[string]*tokenBucket)
+		}
+		mr.rateLimits[name] = newTokenBucket(perSecond, burst)
+	}
+}
+
+// WithRequiredBinaryVersion restricts the job to workers started with
+// WithBinaryVersion(version). Other workers are rejected when the job
+// starts rather than failing its tasks.
+func WithRequiredBinaryVersion(version string) Option {
+	return func(mr *Master) {
+		mr.binaryVersion = version
+	}
+}
+
+// WithSideInputs lists files the job's map or reduce functions read
+// besides their input, e.g. a lookup table. Workers check that they
+// can read them, and load them with their WithPrepare hook, before
+// receiving any task of the job.
+func WithSideInputs(files ...string) Option {
+	return func(mr *Master) {
+		mr.sideInputs = files
+	}
+}
+
+// WithShippedSideInputs is WithSideInputs for files that only the
+// master can read, e.g. on its local disk. Workers download them from
+// the master when they prepare for the job, in chunks of at most the
+// WithMaxMessageSize limit, and resume interrupted downloads; the
+// WithPrepare hook then sees the local copies. Raise the Other RPC
+// timeout for files that take longer to download than a Prepare call
+// may last.
+func WithShippedSideInputs(files ...string) Option {
+	return func(mr *Master) {
+		mr.sideInputs = files
+		mr.shipInputs = true
+	}
+}
+
+// WithSortedReduce makes reduce tasks group their input by sorting the
+// records by key rather than collecting them in a hash map. Grouping
+// then needs less memory, and every reduce output is sorted by key.
+func WithSortedReduce() Option {
+	return func(mr *Master) {
+		mr.options.SortedReduce = true
+	}
+}
+
+// WithExternalSort makes reduce tasks group their input like
+// WithSortedReduce, but sort at most bufferBytes of records in memory
+// at a time. Partitions too large or too skewed to group in memory are
+// spilled to the job's intermediate directory in sorted runs that are
+// merged while the reduce function runs, so only the values of the key
+// being reduced must fit in memory.
+func WithExternalSort(bufferBytes int64) Option {
+	return func(mr *Master) {
+		mr.options.SortedReduce = true
+		mr.options.SortBuffer = bufferBytes
+	}
+}
+
+// WithSecondarySort sorts the values within every reduce group with
+// the key order registered under name, see KeyOrder and
+// RegisterKeyOrder; KeyOrderComposite sorts keys made by CompositeKey.
+// Map output is partitioned by group key, and reduce functions receive
+// the group key with its values in the order of their composite keys.
+// It implies WithSortedReduce and combines with WithExternalSort.
+func WithSecondarySort(name string) Option {
+	return func(mr *Master) {
+		mr.options.SortedReduce = true
+		mr.options.KeyOrder = name
+	}
+}
+
+// WithRangePartitions partitions the map output by ranges of group keys
+// instead of by hash: reduce task i receives the keys sorting below
+// bounds[i] and not below bounds[i-1], so the job needs one reduce task
+// more than there are bounds, which must be in ascending order. Jobs
+// without bounds have a single reduce task. Since every partition
+// holds the keys after those of the one before, the default merger
+// writes the parts in partition order instead of sorting the result
+// by key; with WithSortedReduce the result is in key order.
+func WithRangePartitions(bounds ...string) Option {
+	return func(mr *Master) {
+		mr.options.RangeBounds = append([]string{}, bounds...)
+	}
+}
+
+// FilePriority returns the scheduling priority of the map task reading
+// file. Tasks of higher priority are handed out first.
+type FilePriority func(file string) int
+
+// PriorityBySize prioritises input files by their size, so the largest
+// files are mapped first. Files that cannot be inspected come last.
+func PriorityBySize(file string) int {
+	info, err := statFile(file)
+	if err != nil {
+		return -1
+	}
+	return int(info.Size())
+}
+
+// WithTaskPriority schedules the map tasks of the job in order of the
+// priority of their input files, highest first, instead of in file
+// order. Starting the largest inputs first, see PriorityBySize, keeps
+// one big file from finishing last and stretching the whole phase.
+// Failed tasks are retried ahead of queued tasks of lower priority.
+func WithTaskPriority(priority FilePriority) Option {
+	return func(mr *Master) {
+		mr.filePriority = priority
+	}
+}
+
+// WithWorkerToken makes the master reject workers that do not present
+// token when they register. The token is also sent with every task, so
+// workers configured with the same WithAuthToken refuse tasks from
+// anyone else. Any shared secret or signed token such as a JWT can be
+// used; it is compared verbatim.
+func WithWorkerToken(token string) Option {
+	return func(mr *Master) {
+		mr.authToken = token
+	}
+}
+
+// WithMaxMessageSize caps the file and result data the master sends in
+// one RPC reply, DefaultMaxMessageSize if zero. Shipped side inputs and
+// the results clients fetch are split into chunks of that size, so a
+// large file never turns into a message that stalls the RPCs of other
+// workers.
+func WithMaxMessageSize(bytes int) Option {
+	return func(mr *Master) {
+		mr.maxMessage = bytes
+	}
+}
+
+// WithRPCTimeouts sets how long the master waits for the RPCs it makes.
+// Set DoTask to NoTimeout for jobs whose tasks may run for very long;
+// workers are then pinged while they work instead.
+func WithRPCTimeouts(t RPCTimeouts) Option {
+	return func(mr *Master) {
+		mr.timeouts = t
+	}
+}
+
+// WithHeartbeat asks workers to send a heartbeat every interval. A
+// worker missing more than misses heartbeats in a row, three if zero,
+// is dropped: its running tasks are rescheduled on other workers and it
+// receives no new ones unless it registers again. Pass it to
+// StartMaster or Distributed.
+func WithHeartbeat(interval time.Duration, misses int) Option {
+	return func(mr *Master) {
+		if misses <= 0 {
+			misses = defaultHeartbeatMisses
+		}
+		mr.heartbeat = interval
+		mr.heartbeatMisses = misses
+	}
+}
+
+// WithLogger sends the master's log messages to logger instead of the
+// process logger, see UseLogger. Messages carry the master's address
+// as the "master" attribute. Pass it to StartMaster or Distributed.
+func WithLogger(logger Logger) Option {
+	return func(mr *Master) {
+		mr.logger.logger = logger
+	}
+}
+
+// applyOptions applies all options to the master in order
+func (mr *Master) applyOptions(opts []Option) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(mr)
+		}
+	}
+}
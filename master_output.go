@@ -0,0 +1,72 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OutputFormat writes the records of the final result file, one key
+// with all of its values at a time. Keys arrive sorted unless the
+// merge had to fall back to streaming, see ResultMerger.Execute, in
+// which case a key may arrive with one value at a time.
+type OutputFormat interface {
+	WriteRecord(w io.Writer, key string, values []string) error
+}
+
+// OutputFormatFunc adapts an ordinary function to the OutputFormat
+// interface
+type OutputFormatFunc func(w io.Writer, key string, values []string) error
+
+// WriteRecord calls f(w, key, values)
+func (f OutputFormatFunc) WriteRecord(w io.Writer, key string, values []string) error {
+	return f(w, key, values)
+}
+
+// Built-in output formats
+var (
+	// TextOutput writes "key: [v1 v2]" lines, the default
+	TextOutput OutputFormat = OutputFormatFunc(writeTextRecord)
+	// TSVOutput writes the key and its values separated by tabs, one
+	// key per line. Tabs, newlines and backslashes in keys and values
+	// are escaped as \t, \n and \\.
+	TSVOutput OutputFormat = OutputFormatFunc(writeTSVRecord)
+	// JSONLinesOutput writes one {"key": ..., "values": [...]} object
+	// per line
+	JSONLinesOutput OutputFormat = OutputFormatFunc(writeJSONRecord)
+)
+
+func writeTextRecord(w io.Writer, key string, values []string) error {
+	_, err := fmt.Fprintf(w, "%s: %v\n", key, values)
+	return err
+}
+
+// tsvEscaper escapes the characters TSV cannot hold in a field
+var tsvEscaper = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func writeTSVRecord(w io.Writer, key string, values []string) error {
+	fields := make([]string, 0, len(values)+1)
+	fields = append(fields, tsvEscaper.Replace(key))
+	for _, v := range values {
+		fields = append(fields, tsvEscaper.Replace(v))
+	}
+	_, err := io.WriteString(w, strings.Join(fields, "\t")+"\n")
+	return err
+}
+
+// jsonRecord is a line of JSONLinesOutput
+type jsonRecord struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+func writeJSONRecord(w io.Writer, key string, values []string) error {
+	line, err := json.Marshal(jsonRecord{Key: key, Values: values})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,31 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestOutputFormats checks the records written by the built-in output
+// formats, including characters that need escaping.
+func TestOutputFormats(t *testing.T) {
+	formats := []struct {
+		name   string
+		format OutputFormat
+		want   string
+	}{
+		{"text", TextOutput, "a\tb: [1 x\ny]\n"},
+		{"tsv", TSVOutput, "a\\tb\t1\tx\\ny\n"},
+		{"json lines", JSONLinesOutput, `{"key":"a\tb","values":["1","x\ny"]}` + "\n"},
+	}
+
+	for _, f := range formats {
+		var buf bytes.Buffer
+		if err := f.format.WriteRecord(&buf, "a\tb", []string{"1", "x\ny"}); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+		if got := buf.String(); got != f.want {
+			t.Errorf("%s: got %q, want %q", f.name, got, f.want)
+		}
+	}
+}
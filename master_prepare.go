@@ -0,0 +1,42 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// prepareWorker readies worker for the current job before it is handed
+// its first task, see Worker.Prepare. It reports whether the worker is
+// ready; workers that fail are not assigned tasks of the job until
+// they register again.
+func (mr *Master) prepareWorker(worker string) bool {
+	mr.Lock()
+	gen := mr.workerGen[worker]
+	if mr.prepared[worker] == gen {
+		mr.Unlock()
+		return true
+	}
+	args := &PrepareArgs{
+		JobName:         mr.jobName,
+		Options:         mr.options,
+		Token:           mr.authToken,
+		Framework:       FrameworkVersion,
+		BinaryVersion:   mr.binaryVersion,
+		SideInputs:      mr.sideInputs,
+		ShipSideInputs:  mr.shipInputs,
+		SideInputHashes: mr.shipHashes,
+	}
+	timeout := mr.timeouts.forMethod(PrepareMethod)
+	mr.Unlock()
+
+	var reply PrepareReply
+	if !callTimeout(worker, PrepareMethod, args, &reply, timeout) {
+		mr.logger.warnf("Master: worker %s cannot run job %s, assigning it no tasks", worker, args.JobName)
+		return false
+	}
+
+	mr.Lock()
+	defer mr.Unlock()
+	if mr.prepared == nil {
+		mr.prepared = make(map[string]int)
+	}
+	mr.prepared[worker] = gen
+	mr.recordWorkerBuild(worker, &reply)
+	return true
+}
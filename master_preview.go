@@ -0,0 +1,54 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// maxPreviewRecords bounds the records of each kind a preview returns
+// over the control plane, which keeps the reply small
+const maxPreviewRecords = 1000
+
+// Preview returns n records from the start, the end and random
+// positions of the output of the master's last successful job, reading
+// only those records and the blocks around them from the reduce
+// output files. Call it after Wait.
+func (r JobResult) Preview(n int) (OutputPreview, error) {
+	if len(r.Outputs) == 0 {
+		return OutputPreview{}, fmt.Errorf("no job finished successfully")
+	}
+	return previewOutputs(r.Outputs, n, rand.Int64N)
+}
+
+// Preview returns n records from the start, the end and random
+// positions of the output of a succeeded job, like JobResult.Preview
+func (h *JobHandle) Preview(n int) (OutputPreview, error) {
+	status := h.Status()
+	if status.State != JobSucceeded {
+		return OutputPreview{}, fmt.Errorf("job %s has no result, it is %s", h.id, status.State)
+	}
+	return previewOutputs(h.workspace.ReduceOutputs(status.NReduce), n, rand.Int64N)
+}
+
+// PreviewResult returns a preview of the output of a finished job, see
+// JobHandle.Preview. At most maxPreviewRecords records of each kind
+// are returned.
+func (mr *Master) PreviewResult(args *PreviewResultArgs, reply *OutputPreview) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleObserver); err != nil {
+		return err
+	}
+	handle, err := mr.lookupJob(args.JobID)
+	if err != nil {
+		return err
+	}
+	*reply, err = handle.Preview(min(args.N, maxPreviewRecords))
+	return err
+}
+
+// setOutputs records the reduce output files of a successful job
+func (mr *Master) setOutputs(outputs []string) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.outputs = outputs
+}
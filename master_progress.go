@@ -0,0 +1,54 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+// ProgressFunc receives the progress of a phase of job every time one
+// of the phase's tasks completes, see WithProgress
+type ProgressFunc func(job JobParse, progress JobProgress)
+
+// WithProgress calls f every time a map or reduce task of the job
+// completes, e.g. to drive a progress bar. Calls are made one at a
+// time in the order the tasks complete, while the scheduler waits, so
+// f should return quickly; to feed a channel, send without blocking.
+// Reduce tasks skipped because their partitions are empty count as
+// completed when the reduce phase starts.
+func WithProgress(f ProgressFunc) Option {
+	return func(mr *Master) {
+		mr.progressF = f
+	}
+}
+
+// Progress returns how many tasks of every phase of the current job
+// have completed, in the order the phases started. Once the job has
+// finished it keeps returning the progress of the job until the next
+// one starts. Of several running jobs it reports the one started last;
+// JobHandle.Status tells each job's progress.
+func (mr *Master) Progress() []JobProgress {
+	mr.Lock()
+	defer mr.Unlock()
+	return append([]JobProgress(nil), mr.lastJob().progress...)
+}
+
+// startProgress records that a phase of the current job with total
+// tasks has started
+func (mr *Master) startProgress(phase JobParse, total int) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.progress = append(mr.progress, JobProgress{Phase: phase, Total: total})
+}
+
+// setProgress records that completed of the total tasks of the phase
+// being scheduled have completed and passes it on to the job's
+// progress function
+func (mr *Master) setProgress(phase JobParse, completed, total int) {
+	progress := JobProgress{Phase: phase, Completed: completed, Total: total}
+	mr.Lock()
+	if n := len(mr.progress); n > 0 && mr.progress[n-1].Phase == phase {
+		mr.progress[n-1] = progress
+	}
+	f, job := mr.progressF, mr.jobName
+	mr.Unlock()
+
+	if f != nil {
+		f(job, progress)
+	}
+}
@@ -0,0 +1,69 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"time"
+)
+
+// tokenBucket is a rate limit kept by the master for all workers of a
+// job. Tokens are handed out ahead of time: a request the bucket cannot
+// cover yet is granted at once together with the time its caller must
+// wait, so waiting happens on the workers rather than in the master.
+type tokenBucket struct {
+	rate   float64 // Tokens added per second
+	burst  float64 // Most tokens the bucket holds
+	tokens float64 // Tokens available at last, negative while owed
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket
+func newTokenBucket(perSecond float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes n tokens and returns how long to wait before using them
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// AcquireTokens reserves tokens of one of the job's rate limits for a
+// task and tells it how long to wait before using them
+func (mr *Master) AcquireTokens(args *AcquireArgs, reply *AcquireReply) error {
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+	mr.Lock()
+	job := mr.jobMaster(args.Job, args.Workspace)
+	mr.Unlock()
+	wait, err := job.acquireTokens(args.Limit, args.N)
+	if err != nil {
+		mr.logger.warnf("Master: worker %s: %v", args.Worker, err)
+		return err
+	}
+	reply.Wait = wait
+	return nil
+}
+
+// acquireTokens reserves n tokens of the rate limit name
+func (mr *Master) acquireTokens(name string, n int) (time.Duration, error) {
+	mr.Lock()
+	defer mr.Unlock()
+	bucket := mr.rateLimits[name]
+	if bucket == nil {
+		return 0, fmt.Errorf("unknown rate limit %q", name)
+	}
+	if n <= 0 || float64(n) > bucket.burst {
+		return 0, fmt.Errorf("rate limit %q cannot grant %d tokens at once, its burst is %v", name, n, bucket.burst)
+	}
+	return bucket.reserve(n, time.Now()), nil
+}
@@ -0,0 +1,343 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PartFormat describes the reduce outputs listed in a DatasetVersion:
+// one JSON object with Key and Value fields per line
+const PartFormat = "mapreduce-kv-json"
+
+// ErrNoDataset is returned when a registry has no version of a dataset
+// matching a lookup
+var ErrNoDataset = errors.New("no such dataset version")
+
+// DatasetVersion is a job output published to a registry. Downstream
+// jobs look it up by dataset name instead of hardcoding its paths.
+type DatasetVersion struct {
+	Dataset   string            `json:"dataset"`
+	Version   int               `json:"version"` // Assigned by the registry, counting from 1
+	Job       JobParse          `json:"job"`
+	Parts     []string          `json:"parts"`            // Reduce outputs in partition order
+	Format    string            `json:"format"`           // Record format of the parts, PartFormat
+	Result    string            `json:"result,omitempty"` // Merged result file of the default merger
+	Schema    map[string]string `json:"schema,omitempty"` // Free-form description of the records
+	Published time.Time         `json:"published"`
+}
+
+// Registry stores the versions of published datasets. FileRegistry and
+// HTTPRegistry are provided; other stores such as etcd plug in by
+// implementing it.
+type Registry interface {
+	// Publish stores v as the next version of its dataset and returns
+	// it with its version number set
+	Publish(v DatasetVersion) (DatasetVersion, error)
+
+	// Resolve returns a version of a dataset, the latest if version
+	// is zero, or ErrNoDataset
+	Resolve(dataset string, version int) (DatasetVersion, error)
+}
+
+// ResolveDataset looks up a reference of the form "dataset@version",
+// where version is a number or "latest"; a bare "dataset" means the
+// latest version
+func ResolveDataset(r Registry, ref string) (DatasetVersion, error) {
+	dataset, version, err := parseDatasetRef(ref)
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+	return r.Resolve(dataset, version)
+}
+
+// parseDatasetRef splits a reference into its dataset and version,
+// zero for the latest
+func parseDatasetRef(ref string) (string, int, error) {
+	dataset, version, ok := strings.Cut(ref, "@")
+	if dataset == "" {
+		return "", 0, fmt.Errorf("invalid dataset reference %q", ref)
+	}
+	if !ok || version == "latest" {
+		return dataset, 0, nil
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil || n <= 0 {
+		return "", 0, fmt.Errorf("invalid version in dataset reference %q", ref)
+	}
+	return dataset, n, nil
+}
+
+// datasetRef formats a reference parsed by parseDatasetRef
+func datasetRef(dataset string, version int) string {
+	if version == 0 {
+		return dataset + "@latest"
+	}
+	return fmt.Sprintf("%s@%d", dataset, version)
+}
+
+// WithPublish publishes the job's output as the next version of
+// dataset in registry once the job has succeeded, together with schema,
+// a free-form description of its records such as field names and
+// types. A job whose output cannot be published fails. The published
+// paths are those of the job's workspace, so give every run a
+// workspace of its own when older versions must stay readable.
+func WithPublish(registry Registry, dataset string, schema map[string]string) Option {
+	return func(mr *Master) {
+		mr.registry = registry
+		mr.dataset = dataset
+		mr.datasetSchema = schema
+	}
+}
+
+// publishResult publishes the output of the finished job if the job
+// asked for it
+func (mr *Master) publishResult() error {
+	if mr.registry == nil {
+		return nil
+	}
+
+	v := DatasetVersion{
+		Dataset:   mr.dataset,
+		Job:       mr.jobName,
+		Format:    PartFormat,
+		Schema:    mr.datasetSchema,
+		Published: time.Now(),
+	}
+	for _, part := range mr.workspace().ReduceOutputs(mr.nReduce) {
+		v.Parts = append(v.Parts, absPath(part))
+	}
+	if mr.merger == nil {
+		v.Result = absPath(mr.resultFile())
+	}
+
+	v, err := mr.registry.Publish(v)
+	if err != nil {
+		return fmt.Errorf("publish %s: %v", mr.dataset, err)
+	}
+	mr.logger.infof("Published job %s as %s@%d", mr.jobName, v.Dataset, v.Version)
+	return nil
+}
+
+// absPath makes path absolute so readers elsewhere can find it
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// FileRegistry keeps all dataset versions in a JSON file, e.g. on a
+// shared file system. Publishing takes a lock file next to it, so
+// several masters can share one registry.
+type FileRegistry struct {
+	Path string
+}
+
+// fileRegistryLock serializes publications within the process
+var fileRegistryLock sync.Mutex
+
+// registryLockTimeout bounds how long Publish waits for the lock file
+const registryLockTimeout = 10 * time.Second
+
+// Publish appends v to the file under the next version of its dataset
+func (r FileRegistry) Publish(v DatasetVersion) (DatasetVersion, error) {
+	fileRegistryLock.Lock()
+	defer fileRegistryLock.Unlock()
+	unlock, err := lockFile(r.Path + ".lock")
+	if err != nil {
+		return v, err
+	}
+	defer unlock()
+
+	versions, err := r.load()
+	if err != nil {
+		return v, err
+	}
+	v.Version = 1
+	for _, old := range versions {
+		if old.Dataset == v.Dataset && old.Version >= v.Version {
+			v.Version = old.Version + 1
+		}
+	}
+	versions = append(versions, v)
+
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return v, err
+	}
+	tmp := r.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return v, err
+	}
+	return v, os.Rename(tmp, r.Path)
+}
+
+// Resolve returns a version of a dataset from the file
+func (r FileRegistry) Resolve(dataset string, version int) (DatasetVersion, error) {
+	versions, err := r.load()
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+	var found DatasetVersion
+	for _, v := range versions {
+		if v.Dataset == dataset && (v.Version == version || version == 0 && v.Version > found.Version) {
+			found = v
+		}
+	}
+	if found.Version == 0 {
+		return found, fmt.Errorf("%w: %s", ErrNoDataset, datasetRef(dataset, version))
+	}
+	return found, nil
+}
+
+// load reads all versions, none if the file does not exist yet
+func (r FileRegistry) load() ([]DatasetVersion, error) {
+	data, err := os.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var versions []DatasetVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("registry %s: %v", r.Path, err)
+	}
+	return versions, nil
+}
+
+// lockFile creates path exclusively, waiting for another holder to
+// remove it, and returns the function removing it
+func lockFile(path string) (func(), error) {
+	deadline := time.Now().Add(registryLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("registry is locked by %s, remove it if no master is publishing", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// HTTPRegistry talks to a registry served over REST, e.g. by
+// RegistryHandler:
+//
+//	POST URL/datasets/{dataset}/versions             publish a version
+//	GET  URL/datasets/{dataset}/versions/{n|latest}  look one up
+type HTTPRegistry struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Publish posts v to the registry
+func (r HTTPRegistry) Publish(v DatasetVersion) (DatasetVersion, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+	resp, err := r.client().Post(r.versionsURL(v.Dataset), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return v, err
+	}
+	return decodeRegistryReply(resp)
+}
+
+// Resolve fetches a version from the registry
+func (r HTTPRegistry) Resolve(dataset string, version int) (DatasetVersion, error) {
+	ref := "latest"
+	if version > 0 {
+		ref = strconv.Itoa(version)
+	}
+	resp, err := r.client().Get(r.versionsURL(dataset) + "/" + ref)
+	if err != nil {
+		return DatasetVersion{}, err
+	}
+	return decodeRegistryReply(resp)
+}
+
+func (r HTTPRegistry) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
+
+func (r HTTPRegistry) versionsURL(dataset string) string {
+	return strings.TrimSuffix(r.URL, "/") + "/datasets/" + url.PathEscape(dataset) + "/versions"
+}
+
+// decodeRegistryReply reads a version or an error from a response
+func decodeRegistryReply(resp *http.Response) (DatasetVersion, error) {
+	defer resp.Body.Close()
+	var v DatasetVersion
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return v, fmt.Errorf("%w: %s", ErrNoDataset, resp.Request.URL)
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(resp.Body)
+		return v, fmt.Errorf("registry: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	err := json.NewDecoder(resp.Body).Decode(&v)
+	return v, err
+}
+
+// RegistryHandler serves a registry, e.g. a FileRegistry, over the
+// REST interface HTTPRegistry uses
+func RegistryHandler(r Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(parts) < 3 || parts[0] != "datasets" || parts[2] != "versions" || len(parts) > 4 {
+			http.NotFound(w, req)
+			return
+		}
+		dataset := parts[1]
+
+		var v DatasetVersion
+		var err error
+		switch {
+		case req.Method == http.MethodPost && len(parts) == 3:
+			if err = json.NewDecoder(req.Body).Decode(&v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			v.Dataset = dataset
+			v, err = r.Publish(v)
+		case req.Method == http.MethodGet && len(parts) == 4:
+			var version int
+			if _, version, err = parseDatasetRef(dataset + "@" + parts[3]); err == nil {
+				v, err = r.Resolve(dataset, version)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if errors.Is(err, ErrNoDataset) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	})
+}
@@ -0,0 +1,135 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "fmt"
+
+// reservation holds task slots of workers for the jobs submitted with
+// it, see ReserveSlots
+type reservation struct {
+	slots map[string]int // Slots reserved per worker
+}
+
+// WithReservation runs the job on the task slots of a reservation made
+// with ReserveSlots, besides the slots nobody reserved. A job whose
+// reservation was released fails when it starts.
+func WithReservation(id string) Option {
+	return func(mr *Master) {
+		mr.reservation = id
+	}
+}
+
+// ReserveSlots reserves n task slots of the registered workers carrying
+// all of labels for an upcoming job and returns the reservation's ID.
+// Jobs submitted with WithReservation(id) use the reserved slots;
+// other jobs leave them idle until ReleaseSlots returns them, so the
+// job can start right away on a shared cluster. Reservations take
+// effect from the next phase scheduled; slots released while a phase
+// runs are handed to it at once. Slots of workers that leave are lost
+// to the reservation until they register again.
+func (mr *Master) ReserveSlots(n int, labels map[string]string) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("invalid number of slots: %d", n)
+	}
+	mr.Lock()
+	defer mr.Unlock()
+
+	r := &reservation{slots: make(map[string]int)}
+	wanted := n
+	for _, w := range mr.workers {
+		if wanted == 0 {
+			break
+		}
+		if !hasLabels(mr.workerRegs[w].Labels, labels) {
+			continue
+		}
+		if free := mr.workerSlots(w) - mr.reservedSlots(w, ""); free > 0 {
+			r.slots[w] = min(free, wanted)
+			wanted -= r.slots[w]
+		}
+	}
+	if wanted > 0 {
+		return "", fmt.Errorf("only %d of %d slots are free on workers labelled %v", n-wanted, n, labels)
+	}
+
+	mr.reservationSeq++
+	id := fmt.Sprintf("reservation-%d", mr.reservationSeq)
+	if mr.reservations == nil {
+		mr.reservations = make(map[string]*reservation)
+	}
+	mr.reservations[id] = r
+	mr.logger.infof("Master: reserved %d slots as %s", n, id)
+	return id, nil
+}
+
+// ReleaseSlots returns the slots of a reservation to all jobs
+func (mr *Master) ReleaseSlots(id string) error {
+	mr.Lock()
+	defer mr.Unlock()
+	if mr.reservations[id] == nil {
+		return fmt.Errorf("unknown reservation %s", id)
+	}
+	delete(mr.reservations, id)
+	mr.newCond.Broadcast() // The running phase may use the slots
+	mr.logger.infof("Master: released %s", id)
+	return nil
+}
+
+// Reservations returns the slots every reservation holds per worker,
+// by reservation ID
+func (mr *Master) Reservations() map[string]map[string]int {
+	mr.Lock()
+	defer mr.Unlock()
+	reservations := make(map[string]map[string]int, len(mr.reservations))
+	for id, r := range mr.reservations {
+		slots := make(map[string]int, len(r.slots))
+		for w, n := range r.slots {
+			slots[w] = n
+		}
+		reservations[id] = slots
+	}
+	return reservations
+}
+
+// checkReservation fails a job whose reservation does not exist. The
+// caller must hold the lock.
+func (mr *Master) checkReservation() error {
+	if mr.reservation != "" && mr.reservations[mr.reservation] == nil {
+		return fmt.Errorf("unknown reservation %s", mr.reservation)
+	}
+	return nil
+}
+
+// workerSlots returns the tasks worker runs at once. The caller must
+// hold the lock.
+func (mr *Master) workerSlots(worker string) int {
+	return max(mr.workerRegs[worker].Slots, 1)
+}
+
+// reservedSlots returns the slots of worker held by reservations other
+// than except. The caller must hold the lock.
+func (mr *Master) reservedSlots(worker string, except string) int {
+	reserved := 0
+	for id, r := range mr.reservations {
+		if id != except {
+			reserved += r.slots[worker]
+		}
+	}
+	return reserved
+}
+
+// jobSlots returns the slots of worker the current job may use: the
+// ones nobody reserved and those of its own reservation. The caller
+// must hold the lock.
+func (mr *Master) jobSlots(worker string) int {
+	return max(mr.workerSlots(worker)-mr.reservedSlots(worker, mr.reservation), 0)
+}
+
+// hasLabels reports whether labels include every label of want
+func hasLabels(labels map[string]string, want map[string]string) bool {
+	for k, v := range want {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
@@ -3,25 +3,22 @@ package mapreduce
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"net/rpc"
-	"os"
-	"path/filepath"
 )
 
 // RPCServer manages the RPC service for the master node
 type RPCServer struct {
-	address  string       // Unix domain socket path
-	listener net.Listener // Network listener
-	server   *rpc.Server  // RPC server instance
+	address   string       // Unix domain socket path or TCP address
+	listener  net.Listener // Network listener
+	transport Transport    // Transport selected by the address
 }
 
 // NewRPCServer creates a new RPC server instance
 func NewRPCServer(address string) *RPCServer {
 	return &RPCServer{
-		address: address,
-		server:  rpc.NewServer(),
+		address:   address,
+		transport: transportFor(address),
 	}
 }
 
@@ -31,15 +28,14 @@ func (s *RPCServer) Start(master *Master) error {
 		return err
 	}
 
-	if err := s.registerMaster(master); err != nil {
-		return err
-	}
-
 	if err := s.setupListener(); err != nil {
 		return err
 	}
 
-	go s.acceptConnections(master.shutdown)
+	if err := s.transport.Serve(s.listener, master); err != nil {
+		s.listener.Close()
+		return fmt.Errorf("failed to register master: %v", err)
+	}
 	return nil
 }
 
@@ -51,23 +47,18 @@ func (s *RPCServer) validateSetup() error {
 	return nil
 }
 
-// registerMaster registers the master's RPC methods
-func (s *RPCServer) registerMaster(master *Master) error {
-	if err := s.server.Register(master); err != nil {
-		return fmt.Errorf("failed to register master: %v", err)
-	}
-	return nil
-}
-
 // setupListener creates and configures the network listener
 func (s *RPCServer) setupListener() error {
-	// Clean up any existing socket file
-	os.Remove(s.address)
+	if s.listener != nil {
+		processLog.infof("Taking over RPC server at: %s", s.address)
+		return nil
+	}
 
-	log.Printf("Starting RPC server at: %s", s.address)
+	processLog.infof("Starting RPC server at: %s", s.address)
 
-	// Create listener
-	l, err := s.createListener()
+	// Create a unix domain socket or TCP listener, depending on the
+	// address, cleaning up any existing socket file
+	l, err := listen(s.address)
 	if err != nil {
 		return err
 	}
@@ -76,48 +67,6 @@ func (s *RPCServer) setupListener() error {
 	return nil
 }
 
-// createListener attempts to create a Unix domain socket listener
-func (s *RPCServer) createListener() (net.Listener, error) {
-	l, err := net.Listen("unix", s.address)
-	if err != nil {
-		// Try to create parent directory if it doesn't exist
-		if dir := filepath.Dir(s.address); dir != "" {
-			if err := os.MkdirAll(dir, 0777); err != nil {
-				return nil, fmt.Errorf("failed to create directory %s: %v", dir, err)
-			}
-			// Retry listener creation
-			l, err = net.Listen("unix", s.address)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create listener: %v", err)
-			}
-		}
-	}
-	return l, nil
-}
-
-// acceptConnections handles incoming RPC connections
-func (s *RPCServer) acceptConnections(shutdown chan struct{}) {
-	for {
-		select {
-		case <-shutdown:
-			return
-		default:
-			conn, err := s.listener.Accept()
-			if err != nil {
-				log.Printf("RPC server accept error: %v", err)
-				return
-			}
-			go s.handleConnection(conn)
-		}
-	}
-}
-
-// handleConnection processes a single RPC connection
-func (s *RPCServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
-	s.server.ServeConn(conn)
-}
-
 // Stop gracefully shuts down the RPC server
 func (s *RPCServer) Stop() error {
 	if s.listener != nil {
@@ -127,26 +76,56 @@ func (s *RPCServer) Stop() error {
 }
 
 // startRPCServer is the entry point for starting the master's RPC service
-func (mr *Master) startRPCServer() {
+func (mr *Master) startRPCServer() error {
+	if isHTTPAddress(mr.address) {
+		// The embedding service's HTTP server answers the RPCs once
+		// the master is mounted on it
+		mr.rpcServer = rpc.NewServer()
+		if err := mr.rpcServer.Register(mr); err != nil {
+			return fmt.Errorf("failed to register master: %v", err)
+		}
+		mr.logger.infof("Serving RPCs at %s once mounted", mr.address)
+		return nil
+	}
+
+	inherited, state, err := inheritedListener()
+	if err != nil {
+		return err
+	}
+
 	server := NewRPCServer(mr.address)
+	server.listener = inherited
 	if err := server.Start(mr); err != nil {
-		log.Fatalf("Failed to start RPC server: %v", err)
+		return err
 	}
 	mr.listener = server.listener
+	if state != nil {
+		mr.adoptHandoffState(state)
+	}
+	return nil
 }
 
 // Shutdown handles the graceful shutdown of the master's RPC server
-func (mr *Master) Shutdown(_, _ *struct{}) error {
-	log.Printf("Shutdown: registration server\n")
+func (mr *Master) Shutdown(args *ShutdownArgs, _ *struct{}) error {
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+	mr.logger.infof("Shutdown: registration server")
+	if mr.listener == nil {
+		mr.rpcClosed.Store(true) // The mounted RPC endpoint refuses further calls
+		return nil
+	}
 	return mr.listener.Close()
 }
 
 // stopRPCServer initiates the shutdown of the RPC server
 func (mr *Master) stopRPCServer() {
 	var reply ShutdownReply
-	ok := call(mr.address, "Master.Shutdown", new(struct{}), &reply)
+	ok := callTimeout(mr.address, "Master.Shutdown", &ShutdownArgs{Token: mr.authToken}, &reply, mr.timeouts.forMethod("Master.Shutdown"))
 	if !ok {
-		log.Fatalf("RPC: Stop failed!!!\n")
+		// cleanup closes the listener without the server's help
+		mr.logger.errorf("RPC: stopping the server through %s failed", mr.address)
+		return
 	}
-	fmt.Println("RPC server shutdown complete")
+	mr.logger.infof("RPC server shutdown complete")
 }
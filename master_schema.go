@@ -0,0 +1,46 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+)
+
+// WithSchema declares the schema of the job's map output. Workers
+// check the records the map function emits against it, and the job
+// fails once its map phase has finished if more than
+// schema.MaxViolations records violate it. Violations are counted in
+// the job status and the first one of every task is logged by its
+// worker.
+func WithSchema(schema Schema) Option {
+	return func(mr *Master) {
+		mr.options.Schema = schema
+	}
+}
+
+// addSchemaViolations counts map output records of the current job
+// violating its schema
+func (mr *Master) addSchemaViolations(n int) {
+	if n == 0 {
+		return
+	}
+	mr.Lock()
+	defer mr.Unlock()
+	mr.schemaViolations += n
+}
+
+// checkSchemaViolations fails the job if its map output violates its
+// schema more often than it tolerates
+func (mr *Master) checkSchemaViolations() error {
+	mr.Lock()
+	defer mr.Unlock()
+	if mr.schemaViolations == 0 {
+		return nil
+	}
+	schema := mr.options.Schema
+	if schema.MaxViolations >= 0 && mr.schemaViolations > schema.MaxViolations {
+		return fmt.Errorf("%d map output records violate the job schema, %d are tolerated; see the worker logs for examples",
+			mr.schemaViolations, schema.MaxViolations)
+	}
+	mr.logger.warnf("Master: %d map output records of job %s violate its schema", mr.schemaViolations, mr.jobName)
+	return nil
+}
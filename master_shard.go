@@ -0,0 +1,212 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ShardFunc names the shard of the merged result a key belongs to.
+// Shard names become part of file names.
+type ShardFunc func(key string) string
+
+// ShardByPrefix shards keys by their first n characters, lower-cased,
+// e.g. 26 shards "a" to "z" for n = 1 on words. Shorter keys form
+// shards of their own.
+func ShardByPrefix(n int) ShardFunc {
+	return func(key string) string {
+		runes := []rune(strings.ToLower(key))
+		if len(runes) > n {
+			runes = runes[:n]
+		}
+		return string(runes)
+	}
+}
+
+// ShardByHash spreads keys evenly over n shards named "0" to "n-1",
+// padded to equal width so the files sort in shard order.
+func ShardByHash(n int) ShardFunc {
+	if n < 1 {
+		n = 1
+	}
+	width := len(fmt.Sprint(n - 1))
+	return func(key string) string {
+		return fmt.Sprintf("%0*d", width, ihash(key)%n)
+	}
+}
+
+// ShardedMerger splits the merged result into one file per shard,
+// "mrt.result-<shard>.txt" in Dir, so very large results stay
+// manageable for downstream consumers. Each file has the format of the
+// default result file and is sorted by key.
+//
+// Records are first streamed into per-shard spill files and then
+// sorted one shard at a time, so memory use is bounded by the largest
+// shard rather than the whole result.
+type ShardedMerger struct {
+	Shard  ShardFunc    // Maps keys to shards, required
+	Dir    string       // Directory receiving the shards, the configured result path if empty
+	Format OutputFormat // Format of the shard files, nil for TextOutput
+}
+
+// Merge writes the records of the parts into their shard files
+func (m ShardedMerger) Merge(jobName JobParse, parts []string) error {
+	if m.Shard == nil {
+		return fmt.Errorf("sharded merger has no shard function")
+	}
+	dir := m.Dir
+	if dir == "" {
+		dir = Config["result"]
+	}
+	if err := mkdirAll(dir); err != nil {
+		return fmt.Errorf("failed to prepare result directory: %v", err)
+	}
+
+	// Spill next to the results unless they go to an object store
+	spillDir := dir
+	if isRemotePath(dir) {
+		spillDir = ""
+	}
+	spills, err := m.spillShards(spillDir, parts)
+	for _, spill := range spills {
+		defer os.Remove(spill.name)
+	}
+	if err != nil {
+		return err
+	}
+
+	shards := make([]string, 0, len(spills))
+	for shard := range spills {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	for _, shard := range shards {
+		if err := writeShard(spills[shard].name, shardFile(dir, shard), m.Format); err != nil {
+			return fmt.Errorf("failed to write shard %q: %v", shard, err)
+		}
+	}
+	processLog.infof("Merge: wrote %d result shards of job %s to %s", len(shards), jobName, dir)
+	return nil
+}
+
+// shardSpill is the unsorted spill file of one shard
+type shardSpill struct {
+	name string
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+// spillShards streams every record of the parts into the spill file of
+// its shard and returns the spill files by shard
+func (m ShardedMerger) spillShards(dir string, parts []string) (map[string]*shardSpill, error) {
+	spills := make(map[string]*shardSpill)
+	closeAll := func() error {
+		var firstErr error
+		for _, s := range spills {
+			err := s.buf.Flush()
+			if cerr := s.file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	for _, part := range parts {
+		err := decodeResultPart(part, func(kv KeyValue) error {
+			shard := m.Shard(kv.Key)
+			s := spills[shard]
+			if s == nil {
+				file, err := os.CreateTemp(dir, ".mrt.shard-*")
+				if err != nil {
+					return err
+				}
+				buf := bufio.NewWriter(file)
+				s = &shardSpill{name: file.Name(), file: file, buf: buf, enc: json.NewEncoder(buf)}
+				spills[shard] = s
+			}
+			return s.enc.Encode(&kv)
+		})
+		if err != nil {
+			closeAll()
+			return spills, fmt.Errorf("failed to shard %s: %v", part, err)
+		}
+	}
+	return spills, closeAll()
+}
+
+// decodeResultPart passes every record of a reduce output to emit.
+// Missing parts are skipped like in the default merge.
+func decodeResultPart(fileName string, emit func(KeyValue) error) error {
+	file, err := openFile(fileName, 0)
+	if err != nil {
+		processLog.warnf("Warning: error processing %s: %v", fileName, err)
+		return nil
+	}
+	defer file.Close()
+
+	decoder := newRecordDecoder(file, recordCodecJSON)
+	for {
+		var kv KeyValue
+		if err := decoder.Decode(&kv); err != nil {
+			return nil // End of file or error
+		}
+		if err := emit(kv); err != nil {
+			return err
+		}
+	}
+}
+
+// writeShard sorts the records of a spill file by key and writes them
+// to the shard's result file in format, TextOutput if nil
+func writeShard(spill string, resultFile string, format OutputFormat) error {
+	if format == nil {
+		format = TextOutput
+	}
+	results := make(map[string][]string)
+	err := decodeResultPart(spill, func(kv KeyValue) error {
+		results[kv.Key] = append(results[kv.Key], kv.Value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(results))
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	file, err := createFile(resultFile)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, key := range keys {
+		if err := format.WriteRecord(writer, key, results[key]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return closeResult(file, writer)
+}
+
+// shardFile returns the result file of a shard. Shard names are
+// escaped so that any key prefix yields a valid file name; the shard
+// of empty keys is named "%", which escaping never produces.
+func shardFile(dir string, shard string) string {
+	name := url.PathEscape(shard)
+	if name == "" {
+		name = "%"
+	}
+	return joinPath(dir, "mrt.result-"+name+".txt")
+}
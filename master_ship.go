@@ -0,0 +1,131 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+)
+
+// DefaultMaxMessageSize is the most file or result data the master
+// sends in one reply unless WithMaxMessageSize says otherwise. It stays
+// below the 4 MiB gRPC limit with room for the rest of the message.
+const DefaultMaxMessageSize = 2 << 20
+
+// messageLimit returns the most file or result bytes per reply
+func (mr *Master) messageLimit() int {
+	if mr.maxMessage <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return mr.maxMessage
+}
+
+// FetchFile returns a chunk of a side input of the job the worker
+// names, the current one if it names none, to a worker downloading it,
+// see WithShippedSideInputs. Only the job's shipped side inputs can be
+// fetched.
+func (mr *Master) FetchFile(args *FetchFileArgs, reply *FetchFileReply) error {
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+	mr.Lock()
+	job := mr.jobMaster(args.Job, args.Workspace)
+	shipped := job.shipInputs && slices.Contains(job.sideInputs, args.File)
+	limit := mr.messageLimit()
+	mr.Unlock()
+	if !shipped {
+		mr.logger.warnf("Master: worker %s asked for %s, which the job does not ship", args.Worker, args.File)
+		return fmt.Errorf("file %s is not shipped with the job", args.File)
+	}
+
+	info, err := statFile(args.File)
+	if err != nil {
+		return err
+	}
+	if args.Offset < 0 || args.Offset > info.Size() {
+		return fmt.Errorf("offset %d is outside of %s, which has %d bytes", args.Offset, args.File, info.Size())
+	}
+	file, err := openFile(args.File, args.Offset)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data := make([]byte, min(int64(limit), info.Size()-args.Offset))
+	if _, err := io.ReadFull(file, data); err != nil {
+		return fmt.Errorf("read %s: %v", args.File, err)
+	}
+	reply.Seq = args.Seq
+	reply.Offset = args.Offset
+	reply.Size = info.Size()
+	reply.Data = data
+	reply.Checksum = crc32.ChecksumIEEE(data)
+	reply.Last = args.Offset+int64(len(data)) == info.Size()
+	return nil
+}
+
+// shippedHash is the content hash of a shipped side input, valid while
+// the file keeps its size and modification time
+type shippedHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// hashSideInputs computes the SHA-256 of the contents of every side
+// input the current job ships, which workers cache the files by.
+// Files unchanged since an earlier job are not read again.
+func (mr *Master) hashSideInputs() error {
+	mr.Lock()
+	files, ship := mr.sideInputs, mr.shipInputs
+	mr.Unlock()
+	if !ship {
+		return nil
+	}
+	sums := make([]string, len(files))
+	for i, file := range files {
+		info, err := statFile(file)
+		if err != nil {
+			return fmt.Errorf("side input: %v", err)
+		}
+		mr.Lock()
+		cached, ok := mr.hashCache[file]
+		mr.Unlock()
+		if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+			sums[i] = cached.sum
+			continue
+		}
+		if sums[i], err = contentHash(file); err != nil {
+			return fmt.Errorf("side input: %v", err)
+		}
+		mr.Lock()
+		if mr.hashCache == nil {
+			mr.hashCache = make(map[string]shippedHash)
+		}
+		mr.hashCache[file] = shippedHash{size: info.Size(), modTime: info.ModTime(), sum: sums[i]}
+		mr.Unlock()
+	}
+	mr.Lock()
+	mr.shipHashes = sums
+	mr.Unlock()
+	return nil
+}
+
+// contentHash returns the hex encoded SHA-256 of a file the master can
+// read, which may be remote, see hashFile
+func contentHash(name string) (string, error) {
+	file, err := openFile(name, 0)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("read %s: %v", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
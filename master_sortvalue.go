@@ -0,0 +1,81 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// sortSampleSize is how many records SortByValue samples from the start
+// of every part of its source to choose the partition bounds
+const sortSampleSize = 1000
+
+// errSampled stops reading a part once enough records were sampled
+var errSampled = errors.New("sampled")
+
+// SortByValue submits a job named name that sorts the records of
+// source, e.g. the result of a word count, by their value: numbers in
+// descending order, ties by key, then values that are no numbers.
+// Records are range partitioned on the value over nReduce reduce tasks,
+// the bounds chosen from a sample of every part of source, so the
+// parts of the result, and the merged result file, hold the records in
+// order; skewed values may leave some parts larger than others. The
+// job runs the built-in BuiltinSortByValue on the workers, whatever
+// their own functions, and counts lines of source that are no result
+// records in SortSkippedCounter. opts are applied after those of the
+// job, e.g. WithResultDir; overriding the input format, the key order
+// or the partitioning breaks the order.
+func (mr *Master) SortByValue(name JobParse, source ResultSet, nReduce int, opts ...Option) (*JobHandle, error) {
+	if nReduce < 1 {
+		return nil, fmt.Errorf("invalid number of reduce tasks: %d", nReduce)
+	}
+	if len(source.Files) == 0 {
+		return nil, fmt.Errorf("result of job %s is empty", source.JobID)
+	}
+	bounds, err := sortBounds(source, nReduce)
+	if err != nil {
+		return nil, err
+	}
+	options := []Option{
+		WithInputFormat(InputLines),
+		WithSecondarySort(KeyOrderByValue),
+		WithRangePartitions(bounds...),
+		func(mr *Master) { mr.options.Builtin = BuiltinSortByValue },
+	}
+	return mr.Submit(JobConfig{
+		Name:    name,
+		Files:   source.Files,
+		NReduce: nReduce,
+		Options: append(options, opts...),
+	})
+}
+
+// sortBounds returns the nReduce-1 keys splitting a sample of the sort
+// keys of source into ranges of about the same size
+func sortBounds(source ResultSet, nReduce int) ([]string, error) {
+	var keys []string
+	for _, file := range source.Files {
+		n := 0
+		err := decodeResultPart(file, func(kv KeyValue) error {
+			keys = append(keys, CompositeKey(valueSortKey(kv.Value), kv.Key))
+			if n++; n == sortSampleSize {
+				return errSampled
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errSampled) {
+			return nil, fmt.Errorf("sample %s: %v", file, err)
+		}
+	}
+	sort.Strings(keys)
+	bounds := make([]string, 0, nReduce-1)
+	for i := 1; i < nReduce; i++ {
+		if len(keys) == 0 {
+			bounds = append(bounds, "")
+			continue
+		}
+		bounds = append(bounds, keys[i*len(keys)/nReduce])
+	}
+	return bounds, nil
+}
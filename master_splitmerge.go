@@ -3,11 +3,9 @@ package mapreduce
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"io"
 	"sort"
 )
 
@@ -17,7 +15,10 @@ type ResultMerger struct {
 	nReduce    int
 	resultDir  string
 	resultFile string
+	parts      []string
 	results    map[string][]string
+	guard      *memoryGuard // Watches the heap while results accumulate
+	format     OutputFormat // Format of the result file
 }
 
 // NewResultMerger creates a new instance for merging results
@@ -26,26 +27,57 @@ func NewResultMerger(jobName JobParse, nReduce int) *ResultMerger {
 		jobName:    jobName,
 		nReduce:    nReduce,
 		resultDir:  Config["result"],
-		resultFile: filepath.Join(Config["result"], "mrt.result.txt"),
+		resultFile: defaultResultFile(),
+		parts:      DefaultWorkspace(jobName).ReduceOutputs(nReduce),
 		results:    make(map[string][]string),
+		format:     TextOutput,
 	}
 }
 
-// Merge combines all reduce task outputs into a single result file
+// resultFileName is the name of the file the default merger writes
+const resultFileName = "mrt.result.txt"
+
+// defaultResultFile returns the file the default merger writes
+func defaultResultFile() string {
+	return joinPath(Config["result"], resultFileName)
+}
+
+// resultFile returns the file the default merger writes for the
+// current job
+func (mr *Master) resultFile() string {
+	if mr.resultDir != "" {
+		return joinPath(mr.resultDir, resultFileName)
+	}
+	return defaultResultFile()
+}
+
+// merge hands all reduce task outputs to the configured merge strategy
 func (mr *Master) merge() {
-	merger := NewResultMerger(mr.jobName, mr.nReduce)
-	if err := merger.Execute(); err != nil {
-		log.Printf("Merge failed: %v", err)
+	merger := mr.merger
+	if merger == nil {
+		// Range partitions are already in the order of the result
+		merger = accumulatingMerger{budget: mr.options.MemoryBudget, format: mr.outputFormat, dir: mr.resultDir, inOrder: mr.options.RangeBounds != nil}
+	}
+	if err := merger.Merge(mr.jobName, mr.workspace().ReduceOutputs(mr.nReduce)); err != nil {
+		mr.logger.errorf("Merge failed: %v", err)
 	}
 }
 
-// Execute performs the merge operation
+// Execute performs the merge operation.
+// If the results outgrow the memory budget while being collected, the
+// merger falls back to streaming the parts into the result file,
+// which keeps the format but gives up sorting by key.
 func (m *ResultMerger) Execute() error {
 	if err := m.prepareResultDirectory(); err != nil {
 		return fmt.Errorf("failed to prepare result directory: %v", err)
 	}
 
 	if err := m.collectReduceOutputs(); err != nil {
+		if errors.Is(err, ErrMemoryBudget) {
+			processLog.warnf("Merge: %v, switching to streaming merge", err)
+			m.results = nil
+			return m.streamResults()
+		}
 		return fmt.Errorf("failed to collect reduce outputs: %v", err)
 	}
 
@@ -59,19 +91,21 @@ func (m *ResultMerger) Execute() error {
 // prepareResultDirectory ensures the result directory exists
 func (m *ResultMerger) prepareResultDirectory() error {
 	// Debug log to print the result directory path
-	log.Printf("Configured result directory path: %s", m.resultDir)
+	processLog.debugf("Configured result directory path: %s", m.resultDir)
 
-	return os.MkdirAll(m.resultDir, 0777)
+	return mkdirAll(m.resultDir)
 }
 
 // collectReduceOutputs reads and combines all reduce task outputs
 func (m *ResultMerger) collectReduceOutputs() error {
-	for i := 0; i < m.nReduce; i++ {
-		fileName := mergeName(m.jobName, i)
-		fmt.Printf("Merge: reading %s\n", fileName)
+	for _, fileName := range m.parts {
+		processLog.debugf("Merge: reading %s", fileName)
 
 		if err := m.processReduceOutput(fileName); err != nil {
-			log.Printf("Warning: error processing %s: %v", fileName, err)
+			if errors.Is(err, ErrMemoryBudget) {
+				return err
+			}
+			processLog.warnf("Warning: error processing %s: %v", fileName, err)
 			continue
 		}
 	}
@@ -80,44 +114,96 @@ func (m *ResultMerger) collectReduceOutputs() error {
 
 // processReduceOutput reads and processes a single reduce output file
 func (m *ResultMerger) processReduceOutput(fileName string) error {
-	file, err := os.Open(fileName)
+	file, err := openFile(fileName, 0)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
+	decoder := newRecordDecoder(file, recordCodecJSON)
 	for {
 		var kv KeyValue
 		if err := decoder.Decode(&kv); err != nil {
 			break // End of file or error
 		}
 		m.results[kv.Key] = append(m.results[kv.Key], kv.Value)
+		if err := m.guard.Check(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // writeResults writes the merged results to the final output file
 func (m *ResultMerger) writeResults() error {
-	file, err := os.Create(m.resultFile)
+	file, err := createFile(m.resultFile)
 	if err != nil {
 		return fmt.Errorf("failed to create result file: %v", err)
 	}
-	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	defer writer.Flush()
 
 	// Get sorted keys for deterministic output
 	keys := m.getSortedKeys()
 
 	// Write each key and its values
 	for _, key := range keys {
-		if _, err := fmt.Fprintf(writer, "%s: %v\n", key, m.results[key]); err != nil {
+		if err := m.format.WriteRecord(writer, key, m.results[key]); err != nil {
+			file.Close()
 			return fmt.Errorf("failed to write result: %v", err)
 		}
 	}
 
+	return closeResult(file, writer)
+}
+
+// streamResults writes the records of every part straight to the
+// result file without holding them in memory. Reduce partitions never
+// share keys, so every key still appears exactly once.
+func (m *ResultMerger) streamResults() error {
+	file, err := createFile(m.resultFile)
+	if err != nil {
+		return fmt.Errorf("failed to create result file: %v", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, fileName := range m.parts {
+		if err := streamReduceOutput(fileName, writer, m.format); err != nil {
+			processLog.warnf("Warning: error streaming %s: %v", fileName, err)
+		}
+	}
+	return closeResult(file, writer)
+}
+
+// closeResult flushes and closes a result file. Files of object stores
+// are only stored once closed, so the error of Close counts too.
+func closeResult(file io.WriteCloser, writer *bufio.Writer) error {
+	err := writer.Flush()
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// streamReduceOutput copies a single reduce output to w in the
+// result file format
+func streamReduceOutput(fileName string, w io.Writer, format OutputFormat) error {
+	file, err := openFile(fileName, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := newRecordDecoder(file, recordCodecJSON)
+	for {
+		var kv KeyValue
+		if err := decoder.Decode(&kv); err != nil {
+			break // End of file or error
+		}
+		if err := format.WriteRecord(w, kv.Key, []string{kv.Value}); err != nil {
+			return fmt.Errorf("failed to write result: %v", err)
+		}
+	}
 	return nil
 }
 
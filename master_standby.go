@@ -0,0 +1,208 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultStandbyProbe is how often a standby checks that the primary is
+// alive when its options ask for no heartbeats
+const defaultStandbyProbe = time.Second
+
+// Standby is a master waiting to take over from a primary master, see
+// StartStandby.
+type Standby struct {
+	primary    string   // Address of the primary
+	address    string   // Address the standby serves once it took over
+	checkpoint string   // Checkpoint of the primary, see WithCheckpoint
+	opts       []Option // Options of the master the standby starts
+	token      string   // Credential the primary expects from workers
+	probe      time.Duration
+	misses     int
+	logger     nodeLog
+
+	state  *checkpointState
+	offset int64 // Bytes of the checkpoint applied to state
+
+	mu       sync.Mutex
+	master   *Master // Master started on takeover, nil before
+	err      error   // Why the takeover failed
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{} // Closed once the standby took over, failed to, or was stopped
+}
+
+// StartStandby starts a hot standby for the primary master at primary,
+// which was started with WithCheckpoint(checkpoint). The standby tails
+// the checkpoint and checks that the primary answers, as often as
+// WithHeartbeat in opts asks workers to report and once a second
+// without it. Once the primary missed as many checks in a row as
+// workers may miss heartbeats, the standby takes over: it starts a
+// master on address with opts, appending to the same checkpoint,
+// adopts the workers registered with the primary and submits the jobs
+// the primary had not finished again, under their IDs. address may be
+// the primary's own unix socket, whose stale file is then removed, so
+// that workers and clients reach the new master without a change;
+// workers given the address with WithStandbyMaster switch to it
+// otherwise.
+//
+// Jobs are submitted again with WithJournal, so a primary that
+// journaled them hands over their completed tasks, and opts should
+// match the primary's defaults. The standby cannot tell a dead primary
+// from one it cannot reach; keep both on the same network.
+func StartStandby(primary, address, checkpoint string, opts ...Option) (*Standby, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
+	if primary == "" || address == "" || checkpoint == "" {
+		return nil, fmt.Errorf("standby needs the primary's address, its own and the checkpoint")
+	}
+	settings := newMaster(address)
+	settings.applyOptions(opts)
+	s := &Standby{
+		primary:    primary,
+		address:    address,
+		checkpoint: checkpoint,
+		opts:       opts,
+		token:      settings.authToken,
+		probe:      settings.heartbeat,
+		misses:     settings.heartbeatMisses,
+		logger:     settings.logger,
+		state:      newCheckpointState(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if s.probe <= 0 {
+		s.probe = defaultStandbyProbe
+	}
+	if s.misses <= 0 {
+		s.misses = defaultHeartbeatMisses
+	}
+	go s.watch()
+	s.logger.infof("Standby: watching primary %s", primary)
+	return s, nil
+}
+
+// Wait blocks until the standby took over and returns the master it
+// started, or the reason it could not take over or was stopped
+func (s *Standby) Wait() (*Master, error) {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.master, s.err
+}
+
+// Stop stops watching the primary. A standby that took over already
+// leaves its master running.
+func (s *Standby) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// watch tails the checkpoint and probes the primary until it misses
+// too many probes, then takes over
+func (s *Standby) watch() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.probe)
+	defer ticker.Stop()
+	missed := 0
+	for missed < s.misses {
+		select {
+		case <-s.stop:
+			s.finish(nil, fmt.Errorf("standby stopped"))
+			return
+		case <-ticker.C:
+		}
+		s.tail()
+		if s.primaryAlive() {
+			missed = 0
+			continue
+		}
+		missed++
+		s.logger.warnf("Standby: primary %s missed %d of %d checks", s.primary, missed, s.misses)
+	}
+	s.tail()
+	s.finish(s.takeOver())
+}
+
+// finish records the outcome of the standby
+func (s *Standby) finish(master *Master, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master, s.err = master, err
+}
+
+// tail applies the lines the primary appended to the checkpoint since
+// the last call
+func (s *Standby) tail() {
+	file, err := os.Open(s.checkpoint)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.warnf("Standby: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() <= s.offset {
+		return
+	}
+	data := make([]byte, info.Size()-s.offset)
+	n, err := file.ReadAt(data, s.offset)
+	if err != nil && n < len(data) {
+		data = data[:n]
+	}
+	s.offset += int64(s.state.apply(data))
+}
+
+// primaryAlive reports whether the primary answers a heartbeat, which
+// it does for workers it does not know too
+func (s *Standby) primaryAlive() bool {
+	args := &HeartbeatArgs{Worker: s.address, Token: s.token}
+	var reply HeartbeatReply
+	return callTimeout(s.primary, HeartbeatMethod, args, &reply, s.probe)
+}
+
+// takeOver starts the master replacing the primary, adopts its workers
+// and submits its unfinished jobs again
+func (s *Standby) takeOver() (*Master, error) {
+	s.logger.warnf("Standby: primary %s is gone, taking over on %s", s.primary, s.address)
+	if s.address == s.primary {
+		if info, err := os.Stat(s.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(s.address)
+		}
+	}
+	opts := append(s.opts[:len(s.opts):len(s.opts)], WithCheckpoint(s.checkpoint))
+	mr, err := StartMaster(s.address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("standby cannot start on %s: %v", s.address, err)
+	}
+
+	mr.Lock()
+	for worker := range s.state.workers {
+		mr.trackWorker(worker)
+		mr.addWorker(worker)
+	}
+	mr.jobSeq = s.state.lastSeq
+	mr.Unlock()
+	jobs := s.state.pending()
+	for _, entry := range jobs {
+		options := entry.Job.Options
+		_, err := mr.submit(context.Background(), JobConfig{
+			Name:    entry.Job.Name,
+			Files:   entry.Job.Files,
+			Inputs:  entry.Job.Inputs,
+			NReduce: entry.Job.NReduce,
+			Options: []Option{func(mr *Master) { mr.options = options }, WithJournal()},
+		}, entry.JobID, entry.Seq)
+		if err != nil {
+			mr.logger.errorf("Standby: cannot submit job %s again: %v", entry.JobID, err)
+		}
+	}
+	mr.logger.infof("Standby: took over from %s with %d workers and %d unfinished jobs",
+		s.primary, len(s.state.workers), len(jobs))
+	return mr, nil
+}
@@ -0,0 +1,81 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "time"
+
+// MasterStatus is a snapshot of what a master is doing, for tools that
+// poll it, see Master.CurrentStatus.
+type MasterStatus struct {
+	JobID   string        `json:"job_id,omitempty"`     // Job being run, the one started last of several, empty when the master is idle
+	Job     JobParse      `json:"job,omitempty"`        // Name of the job
+	Phase   JobParse      `json:"phase,omitempty"`      // Phase being scheduled, empty before the first one
+	Elapsed time.Duration `json:"elapsed_ns,omitempty"` // Time since the job started running
+
+	// Tasks of the phase by state
+	Pending   int `json:"pending"`   // Waiting for a worker, also after a failed attempt
+	Running   int `json:"running"`   // Assigned to a worker
+	Completed int `json:"completed"` // Finished successfully
+	Failed    int `json:"failed"`    // Attempts that failed or were refused so far
+
+	Queued  int          `json:"queued"`         // Jobs submitted behind the running ones
+	Jobs    []string     `json:"jobs,omitempty"` // IDs of the running jobs in the order they started, see WithConcurrentJobs
+	Workers []WorkerInfo `json:"workers"`        // Registered workers
+}
+
+// StatusArgs is the request of the Status RPC.
+type StatusArgs struct {
+	Version int
+	Token   string
+}
+
+// CurrentStatus returns what the master is doing: the running job and
+// the state of the tasks of its current phase, the jobs queued behind
+// it and the registered workers. Of several running jobs it describes
+// the one started last.
+func (mr *Master) CurrentStatus() MasterStatus {
+	mr.Lock()
+	handle := mr.currentJob()
+	status := MasterStatus{Queued: mr.pending}
+	for _, r := range mr.running {
+		status.Jobs = append(status.Jobs, r.handle.id)
+	}
+	mr.Unlock()
+	status.Workers = mr.Workers()
+	if handle == nil {
+		return status
+	}
+
+	handle.mu.Lock()
+	started := handle.started
+	handle.mu.Unlock()
+	status.JobID = handle.id
+	status.Job = handle.config.Name
+	status.Elapsed = time.Since(started)
+	phase, ok := handle.lastPhase()
+	if !ok {
+		return status
+	}
+	status.Phase = phase.Phase
+	status.Failed = phase.Failures
+	for _, state := range phase.Tasks {
+		switch state {
+		case TaskIdle:
+			status.Pending++
+		case TaskRunning:
+			status.Running++
+		case TaskCompleted:
+			status.Completed++
+		}
+	}
+	return status
+}
+
+// Status reports what the master is doing to a remote client, see
+// CurrentStatus
+func (mr *Master) Status(args *StatusArgs, reply *MasterStatus) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleObserver); err != nil {
+		return err
+	}
+	*reply = mr.CurrentStatus()
+	return nil
+}
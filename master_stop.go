@@ -0,0 +1,80 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+)
+
+// StopMode selects how Stop treats jobs that have not finished yet.
+type StopMode int
+
+const (
+	// StopSoft waits for queued and running jobs to finish
+	StopSoft StopMode = iota
+	// StopHard cancels queued and running jobs
+	StopHard
+)
+
+// String returns the name of the mode
+func (m StopMode) String() string {
+	switch m {
+	case StopSoft:
+		return "soft"
+	case StopHard:
+		return "hard"
+	default:
+		return fmt.Sprintf("StopMode(%d)", int(m))
+	}
+}
+
+// Stop shuts the master down. New submissions are rejected right away;
+// jobs already submitted are waited for (StopSoft) or cancelled
+// (StopHard). Afterwards the workers and the RPC server are shut down.
+// A soft stop may be followed by a hard one, e.g. on a second Ctrl-C.
+func (mr *Master) Stop(mode StopMode) error {
+	if mode != StopSoft && mode != StopHard {
+		return fmt.Errorf("invalid stop mode %v", mode)
+	}
+
+	mr.Lock()
+	if mr.handedOff {
+		mr.Unlock()
+		return fmt.Errorf("master was handed off to a successor")
+	}
+	mr.stopping = true
+	handles := make([]*JobHandle, 0, len(mr.handles))
+	for _, handle := range mr.handles {
+		handles = append(handles, handle)
+	}
+	mr.Unlock()
+
+	mr.logger.infof("Stop: %s stop with %d jobs", mode, len(handles))
+	for _, handle := range handles {
+		if mode == StopHard {
+			handle.Cancel()
+		}
+	}
+	for _, handle := range handles {
+		<-handle.Done()
+	}
+
+	mr.shutdownCluster()
+	return nil
+}
+
+// shutdownCluster stops the workers, the RPC server and all
+// background goroutines, at most once
+func (mr *Master) shutdownCluster() {
+	mr.stopOnce.Do(func() {
+		result := mr.killWorkers()
+		mr.Lock()
+		result.Outputs = mr.outputs
+		mr.result = result
+		mr.Unlock()
+		if len(result.Workers) > 0 {
+			mr.logger.infof("Master: worker summary\n%s", result)
+		}
+		mr.stopRPCServer()
+		mr.cleanup()
+	})
+}
@@ -0,0 +1,678 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxQueuedJobs bounds the number of submitted jobs waiting to run
+const maxQueuedJobs = 64
+
+// ErrJobCancelled is returned by JobHandle.Wait for cancelled jobs.
+var ErrJobCancelled = errors.New("job cancelled")
+
+// JobConfig describes a job submitted to a running master.
+type JobConfig struct {
+	Name    JobParse     // Name of the job, used in all of its file names
+	Files   []string     // Input files, one map task per file
+	Inputs  []InputGroup // Named input groups, split after Files, each by its own format
+	NReduce int          // Number of reduce tasks, or AutoReduce
+	Options []Option     // Settings for this job, applied over the master's defaults
+}
+
+// validate checks the configuration before it is queued
+func (c JobConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("job name cannot be empty")
+	}
+	if len(c.allFiles()) == 0 {
+		return fmt.Errorf("no input files provided")
+	}
+	if c.NReduce <= 0 && c.NReduce != AutoReduce {
+		return fmt.Errorf("invalid number of reduce tasks: %d", c.NReduce)
+	}
+	names := make(map[string]bool)
+	for _, group := range c.Inputs {
+		switch {
+		case group.Name == "":
+			return fmt.Errorf("input group without a name")
+		case names[group.Name]:
+			return fmt.Errorf("input group %s declared twice", group.Name)
+		case len(group.Files) == 0:
+			return fmt.Errorf("input group %s has no files", group.Name)
+		}
+		names[group.Name] = true
+	}
+	return nil
+}
+
+// allFiles returns the job's input files, grouped or not
+func (c JobConfig) allFiles() []string {
+	files := c.Files
+	for _, group := range c.Inputs {
+		files = append(files[:len(files):len(files)], group.Files...)
+	}
+	return files
+}
+
+// JobProgress reports how far the current phase of a job has come.
+type JobProgress struct {
+	Phase     JobParse `json:"phase"`     // Phase being scheduled, empty before the job starts
+	Completed int      `json:"completed"` // Tasks of the phase that have finished
+	Total     int      `json:"total"`     // Tasks in the phase
+}
+
+// JobHandle tracks a submitted job.
+type JobHandle struct {
+	id     string
+	seq    int // Position in submission order
+	config JobConfig
+	done   chan struct{}
+	ctx    context.Context    // Cancelled with the job
+	cancel context.CancelFunc // Cancels ctx
+
+	workspace *JobWorkspace // Set once the job starts
+
+	mu       sync.Mutex
+	state    JobState
+	started  time.Time // When the job started running
+	progress JobProgress
+	phases   []PhaseState
+	err      error
+	failure  error // Why the job was failed while it ran, see fail
+
+	counters jobCounters // Counters of the job's completed tasks
+
+	// Event log for watchers, see Master.WatchJob
+	events        []JobEvent
+	eventSeq      int64         // Sequence number of the last event
+	eventsChanged chan struct{} // Closed when an event is recorded, nil until someone waits
+}
+
+// newJobHandle creates the handle of a queued job, which is cancelled
+// along with ctx
+func newJobHandle(ctx context.Context, id string, config JobConfig) *JobHandle {
+	h := &JobHandle{
+		id:     id,
+		config: config,
+		state:  JobQueued,
+		done:   make(chan struct{}),
+	}
+	h.recordEvent(JobEvent{Kind: EventJobState})
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	return h
+}
+
+// ID returns the identifier the master assigned to the job
+func (h *JobHandle) ID() string {
+	return h.id
+}
+
+// Name returns the name of the job
+func (h *JobHandle) Name() JobParse {
+	return h.config.Name
+}
+
+// Status returns a snapshot of the job's state
+func (h *JobHandle) Status() JobStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := JobStatus{
+		ID:       h.id,
+		Name:     h.config.Name,
+		State:    h.state,
+		Progress: h.progress,
+		NReduce:  h.config.NReduce,
+	}
+	for _, phase := range h.phases {
+		status.Phases = append(status.Phases, phase.clone())
+	}
+	if h.err != nil && h.state == JobFailed {
+		status.Error = h.err.Error()
+	}
+	if totals := h.counters.totals(); len(totals) > 0 {
+		status.Counters = totals
+	}
+	return status
+}
+
+// Counters returns the totals of the counters the job's map and reduce
+// functions added to, see TaskContext.Count. While the job runs they
+// cover the tasks completed so far.
+func (h *JobHandle) Counters() map[string]int64 {
+	return h.counters.totals()
+}
+
+// setState records a lifecycle transition
+func (h *JobHandle) setState(state JobState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = state
+	if state == JobRunning {
+		h.started = time.Now()
+	}
+	h.recordEvent(JobEvent{Kind: EventJobState})
+}
+
+// Wait blocks until the job has finished and returns its error, if any
+func (h *JobHandle) Wait() error {
+	<-h.done
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Done returns a channel that is closed once the job has finished
+func (h *JobHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Cancel stops the job. Queued jobs never start; running jobs stop
+// handing out tasks, abort the tasks in flight and skip the remaining
+// phases and the merge.
+func (h *JobHandle) Cancel() {
+	h.cancel()
+}
+
+// Progress returns the progress of the job's current phase
+func (h *JobHandle) Progress() JobProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.progress
+}
+
+// fail stops the running job, which fails with err instead of being
+// cancelled
+func (h *JobHandle) fail(err error) {
+	h.mu.Lock()
+	if h.failure == nil {
+		h.failure = err
+	}
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// setProgress records the progress of a phase
+func (h *JobHandle) setProgress(phase JobParse, completed, total int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.progress = JobProgress{Phase: phase, Completed: completed, Total: total}
+}
+
+// setNReduce records the number of reduce tasks chosen for an
+// AutoReduce job
+func (h *JobHandle) setNReduce(nReduce int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config.NReduce = nReduce
+}
+
+// startPhase records that a phase with total tasks has started
+func (h *JobHandle) startPhase(phase JobParse, total int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	tasks := make([]TaskState, total)
+	for i := range tasks {
+		tasks[i] = TaskIdle
+	}
+	h.phases = append(h.phases, PhaseState{Phase: phase, Tasks: tasks, Started: time.Now()})
+	h.progress = JobProgress{Phase: phase, Total: total}
+	h.recordEvent(JobEvent{Kind: EventPhaseStarted, Phase: phase})
+}
+
+// endPhase records that the phase started last has ended
+func (h *JobHandle) endPhase() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.phases) > 0 {
+		phase := &h.phases[len(h.phases)-1]
+		phase.Ended = time.Now()
+		h.recordEvent(JobEvent{Kind: EventPhaseEnded, Phase: phase.Phase})
+	}
+}
+
+// flagNondeterministic records that two runs of a task of the running
+// phase produced different output
+func (h *JobHandle) flagNondeterministic(task int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.phases) == 0 {
+		return
+	}
+	phase := &h.phases[len(h.phases)-1]
+	phase.Nondeterministic = append(phase.Nondeterministic, task)
+}
+
+// addTaskMetrics adds the schema violations and resource usage of a
+// completed task to the running phase
+func (h *JobHandle) addTaskMetrics(metrics TaskMetrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.phases) == 0 {
+		return
+	}
+	phase := &h.phases[len(h.phases)-1]
+	phase.SchemaViolations += metrics.SchemaViolations
+	phase.WallTime += metrics.WallTime
+	phase.UserCPU += metrics.UserCPU
+	phase.SystemCPU += metrics.SystemCPU
+}
+
+// lastPhase returns the phase started last, if any
+func (h *JobHandle) lastPhase() (PhaseState, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.phases) == 0 {
+		return PhaseState{}, false
+	}
+	return h.phases[len(h.phases)-1].clone(), true
+}
+
+// setTaskState records a task transition in the current phase and
+// reports whether it ended a failed attempt
+func (h *JobHandle) setTaskState(task int, state TaskState) (failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.phases) == 0 {
+		return false
+	}
+	phase := &h.phases[len(h.phases)-1]
+	if task < 0 || task >= len(phase.Tasks) || phase.Tasks[task] == TaskCompleted {
+		return false
+	}
+	failed = phase.Tasks[task] == TaskRunning && state == TaskIdle
+	if failed {
+		phase.Failures++
+	}
+	phase.Tasks[task] = state
+	if state == TaskCompleted {
+		phase.Completed++
+	}
+	h.recordEvent(JobEvent{Kind: EventTask, Phase: phase.Phase, Task: task, TaskState: state})
+	return failed
+}
+
+// setMapTaskState records a transition of a map task run again during
+// the reduce phase because its output was lost
+func (h *JobHandle) setMapTaskState(task int, state TaskState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.phases {
+		phase := &h.phases[i]
+		if phase.Phase != mapParse || task < 0 || task >= len(phase.Tasks) {
+			continue
+		}
+		if phase.Tasks[task] == TaskCompleted {
+			phase.Completed--
+		}
+		if state == TaskCompleted {
+			phase.Completed++
+		}
+		phase.Tasks[task] = state
+		h.recordEvent(JobEvent{Kind: EventTask, Phase: mapParse, Task: task, TaskState: state})
+	}
+}
+
+// finish records the outcome of the job and releases waiters
+func (h *JobHandle) finish(err error) {
+	h.mu.Lock()
+	h.err = err
+	switch {
+	case err == nil:
+		h.state = JobSucceeded
+	case errors.Is(err, ErrJobCancelled):
+		h.state = JobCancelled
+	default:
+		h.state = JobFailed
+	}
+	event := JobEvent{Kind: EventJobState}
+	if h.state == JobFailed {
+		event.Error = err.Error()
+	}
+	h.recordEvent(event)
+	h.mu.Unlock()
+	close(h.done)
+	h.cancel() // Releases the context
+}
+
+// StartMaster starts the master's control plane on address without
+// running any job. Jobs are added with Submit and run one at a time in
+// submission order, or several at once with WithConcurrentJobs;
+// workers stay registered between jobs.
+// The options given here are the defaults for every submitted job.
+func StartMaster(address string, opts ...Option) (*Master, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
+	mr := newMaster(address)
+	mr.defaults = opts
+	mr.applyOptions(opts)
+	mr.jobs = make(chan *JobHandle, maxQueuedJobs)
+	mr.handles = make(map[string]*JobHandle)
+
+	if err := mr.startRPCServer(); err != nil {
+		return nil, err
+	}
+	if mr.dashboardAddr != "" {
+		if err := mr.startDashboard(); err != nil {
+			return nil, err
+		}
+	}
+	if mr.heartbeat > 0 {
+		go mr.monitorHeartbeats(mr.heartbeat)
+	}
+	go mr.processJobs()
+
+	mr.logger.infof("Starting master at %s", address)
+	return mr, nil
+}
+
+// Submit queues a job and returns a handle to follow it
+func (mr *Master) Submit(config JobConfig) (*JobHandle, error) {
+	return mr.SubmitContext(context.Background(), config)
+}
+
+// SubmitContext queues a job like Submit. The job is cancelled once
+// ctx is done, as if JobHandle.Cancel was called.
+func (mr *Master) SubmitContext(ctx context.Context, config JobConfig) (*JobHandle, error) {
+	return mr.submit(ctx, config, "", 0)
+}
+
+// submit queues a job under id with submission number seq, or under
+// the next ones if id is empty
+func (mr *Master) submit(ctx context.Context, config JobConfig, id string, seq int) (*JobHandle, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if mr.jobs == nil {
+		return nil, fmt.Errorf("master does not accept jobs")
+	}
+	if isClosed(mr.shutdown) {
+		return nil, fmt.Errorf("master is shut down")
+	}
+
+	mr.Lock()
+	defer mr.Unlock()
+	if mr.handedOff {
+		return nil, fmt.Errorf("master was handed off to a successor")
+	}
+	if mr.stopping {
+		return nil, fmt.Errorf("master is stopping")
+	}
+	if id == "" {
+		mr.jobSeq++
+		id, seq = fmt.Sprintf("%s-%d", config.Name, mr.jobSeq), mr.jobSeq
+	}
+	handle := newJobHandle(ctx, id, config)
+	handle.seq = seq
+	select {
+	case mr.jobs <- handle:
+		mr.pending++
+		mr.handles[handle.id] = handle
+		mr.checkpointJob(handle)
+		return handle, nil
+	default:
+		return nil, fmt.Errorf("job queue is full")
+	}
+}
+
+// processJobs runs queued jobs until shutdown, as many at once as
+// WithConcurrentJobs allows. Jobs taken from the queue wait while a
+// running job blocks them, see blocks.
+func (mr *Master) processJobs() {
+	finished := make(chan struct{})
+	var waiting []runningJob
+	for {
+		mr.Lock()
+		waiting = mr.startJobs(waiting, finished)
+		queue := mr.jobs
+		if len(mr.running) >= mr.maxRunningJobs() || len(waiting) >= mr.maxRunningJobs() {
+			queue = nil
+		}
+		mr.Unlock()
+
+		select {
+		case handle := <-queue:
+			mr.Lock()
+			waiting = append(waiting, runningJob{handle: handle, master: mr.newJobMaster(handle)})
+			mr.Unlock()
+		case <-finished:
+		case <-mr.shutdown:
+			return
+		}
+	}
+}
+
+// startJobs starts the waiting jobs, in order, that no running job
+// blocks while fewer jobs than allowed run, and returns the others.
+// finished is signalled whenever one of them has finished. The caller
+// must hold the lock.
+func (mr *Master) startJobs(waiting []runningJob, finished chan<- struct{}) []runningJob {
+	var blocked []runningJob
+	for _, w := range waiting {
+		if len(mr.running) >= mr.maxRunningJobs() || mr.blocks(w.master, w.handle) {
+			blocked = append(blocked, w)
+			continue
+		}
+		mr.pending--
+		mr.startRunning(w.master, w.handle)
+		go func() {
+			mr.runJob(w.master, w.handle)
+			mr.stopRunning(w.handle)
+			select {
+			case finished <- struct{}{}:
+			case <-mr.shutdown:
+			}
+		}()
+	}
+	return blocked
+}
+
+// runJob schedules the phases of the job of handle on job, its master
+func (mr *Master) runJob(job *Master, handle *JobHandle) {
+	mr.Lock()
+	handle.workspace = job.options.workspace(handle.config.Name)
+	bytesPerReducer := job.bytesPerReducer
+	reservationErr := job.checkReservation()
+	mr.Unlock()
+
+	if reservationErr != nil {
+		handle.finish(reservationErr)
+		return
+	}
+
+	if handle.config.NReduce == AutoReduce {
+		handle.setNReduce(autoReduceCount(handle.config.allFiles(), bytesPerReducer))
+	}
+
+	if isClosed(handle.ctx.Done()) {
+		handle.finish(ErrJobCancelled)
+		return
+	}
+
+	handle.setState(JobRunning)
+	config := handle.config
+	ctx, span := startSpan(handle.ctx, SpanInternal, "job "+string(config.Name), "job", string(config.Name), "id", handle.id)
+	err := job.run(config.Name, config.Files, config.NReduce, func(phase JobParse) {
+		job.schedulePhase(ctx, phase, handle)
+	}, handle.ctx.Done())
+	if errors.Is(err, ErrJobCancelled) {
+		handle.mu.Lock()
+		if handle.failure != nil {
+			err = handle.failure
+		}
+		handle.mu.Unlock()
+	}
+	span.end(err)
+	handle.finish(err)
+	labels := job.jobMetricsLabels("state", string(handle.Status().State))
+	labels["job"] = string(config.Name) // The job may have failed before it was set up
+	metricsSink().Observe(MetricJobDuration, time.Since(handle.started), labels)
+}
+
+// schedulePhase hands out all tasks of a phase to registered workers.
+// The spans of its tasks are children of the span of ctx.
+func (mr *Master) schedulePhase(ctx context.Context, phase JobParse, handle *JobHandle) {
+	ch := make(chan string)
+	stop := make(chan struct{})
+	go mr.forwardRegistration(ch, stop, mr.startForwarding())
+
+	ts := NewTaskScheduler(mr.jobName, mr.files, mr.nReduce, phase, ch, mr.options)
+	ts.token = mr.authToken
+	ts.logger = mr.logger
+	ts.timeouts = mr.timeouts
+	ts.lost = mr.workerLost
+	ts.gone = mr.workerGone
+	ts.workers = mr.jobWorkers
+	ts.local = mr.hasLocalInput
+	if phase == mapParse {
+		ts.splits = mr.splits
+	}
+	if phase == mapParse && mr.filePriority != nil {
+		ts.priority = make([]int, len(mr.files))
+		for i, file := range mr.files {
+			ts.priority[i] = mr.filePriority(file)
+		}
+	}
+	if mr.fairBuckets > 1 {
+		ts.buckets = mr.fairBuckets
+		ts.sizes = mr.taskSizes(phase)
+	}
+	var span *traceSpan
+	ts.ctx, span = startSpan(ctx, SpanInternal, string(phase)+" phase", "job", string(mr.jobName), "tasks", strconv.Itoa(ts.total))
+	ts.onComplete = func(completed, total int) {
+		handle.setProgress(phase, completed, total)
+		mr.setProgress(phase, completed, total)
+	}
+	labels := mr.jobMetricsLabels("phase", string(phase))
+	ts.onTaskState = func(task int, state TaskState) {
+		if handle.setTaskState(task, state) {
+			metricsSink().Count(MetricTaskFailures, 1, labels)
+		}
+	}
+	ts.verifyShare = mr.verifyShare
+	ts.setMaxInFlight(mr.maxInFlight)
+	ts.retry = mr.retryPolicy
+	ts.retryDelay = mr.retryDelay
+	ts.onMismatch = handle.flagNondeterministic
+	ts.onLate = mr.discardLateResult
+	ts.onMetrics = func(task int, metrics TaskMetrics) {
+		mr.addSchemaViolations(metrics.SchemaViolations)
+		if phase == mapParse {
+			mr.addPartitionRecords(task, metrics.PartitionRecords)
+		}
+		handle.addTaskMetrics(metrics)
+		handle.counters.set(phase, task, metrics.Counters)
+		mr.counters.set(phase, task, metrics.Counters)
+		metricsSink().Observe(MetricTaskDuration, metrics.WallTime, labels)
+	}
+	if phase == mapParse {
+		ts.onProduced = mr.addMapProducer
+		rounds := 0
+		ts.flush = func(cached map[int]cachedOutput) []int {
+			lost := mr.flushWorkerCaches(cached)
+			if rounds++; len(lost) > 0 && rounds >= maxFlushRounds {
+				handle.fail(fmt.Errorf("the output of map tasks %v of job %s was lost with combiner caches %d times", lost, mr.jobName, rounds))
+				return nil
+			}
+			return lost
+		}
+	}
+	if phase == reduceParse {
+		ts.skip = mr.pruned
+		ts.mapSplits = mr.splits
+		ts.onMapRerun = handle.setMapTaskState
+		ts.producers = mr.mapProducers()
+	}
+	mr.Lock()
+	journal, resumed := mr.journal, mr.resumed
+	mr.Unlock()
+	resumedMetrics := resumed.tasks(phase, ts.total)
+	if len(resumedMetrics) > 0 {
+		skip := make([]bool, ts.total)
+		copy(skip, ts.skip) // Pruned reduce tasks, shared with the master
+		for task := range resumedMetrics {
+			skip[task] = true
+		}
+		ts.skip = skip
+	}
+	replay := ts.onMetrics
+	if journal != nil {
+		ts.onMetrics = func(task int, metrics TaskMetrics) {
+			replay(task, metrics)
+			if !ts.isCached(task) { // Cached output is not in the workspace, resumed jobs run the task again
+				journal.completeTask(phase, task, metrics)
+			}
+		}
+	}
+	handle.startPhase(phase, ts.total)
+	for task, metrics := range resumedMetrics {
+		replay(task, metrics) // Carries over the counters and metrics of resumed tasks
+	}
+	stopWatching := mr.watchPhase(phase, ts, handle)
+	ts.Run()
+	stopWatching()
+	handle.endPhase()
+	span.end(handle.ctx.Err())
+	if handle.ctx.Err() != nil {
+		mr.cancelWorkerTasks()
+	}
+	if p, ok := handle.lastPhase(); ok && p.WallTime > 0 {
+		mr.logger.infof("Master: %s phase of job %s took %v of task time, %v user and %v system CPU (%.2f CPUs per task)",
+			phase, mr.jobName, p.WallTime.Round(time.Millisecond), p.UserCPU.Round(time.Millisecond),
+			p.SystemCPU.Round(time.Millisecond), p.CPUShare())
+	}
+
+	mr.stopForwarding(stop)
+}
+
+// resetJobSettings clears everything a previous job configured.
+// The caller must hold the lock.
+func (mr *Master) resetJobSettings() {
+	mr.merger = nil
+	mr.outputFormat = nil
+	mr.resultDir = ""
+	mr.filePriority = nil
+	mr.fairBuckets = 0
+	mr.bytesPerReducer = 0
+	mr.verifyShare = 0
+	mr.maxInFlight = 0
+	mr.retryPolicy = RetryAtEnd
+	mr.retryDelay = 0
+	mr.phaseTimeouts = nil
+	mr.watchdogStall = 0
+	mr.watchdogAction = WatchdogFail
+	mr.rateLimits = nil
+	mr.registry = nil
+	mr.dataset = ""
+	mr.datasetSchema = nil
+	mr.binaryVersion = ""
+	mr.sideInputs = nil
+	mr.inputGroups = nil
+	mr.shipInputs = false
+	mr.shipHashes = nil
+	mr.prepared = nil
+	mr.workerBuilds = nil
+	mr.options = JobOptions{}
+	mr.archiveDir = ""
+	mr.archiveOutputs = false
+	mr.progressF = nil
+	mr.metricsLabels = nil
+	mr.reservation = ""
+	mr.journaled = false
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
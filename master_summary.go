@@ -0,0 +1,74 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobResult summarises the work of a master's workers. It is gathered
+// once, when the master shuts its workers down after its jobs.
+type JobResult struct {
+	// Workers holds one summary per worker registered at shutdown, in
+	// registration order, including workers that did not respond
+	Workers []WorkerSummary
+
+	// Outputs lists the reduce output files of the master's last
+	// successful job, see Preview
+	Outputs []string
+}
+
+// Tasks returns the number of tasks completed by all workers
+func (r JobResult) Tasks() int {
+	n := 0
+	for _, w := range r.Workers {
+		n += w.MapTasks + w.ReduceTasks
+	}
+	return n
+}
+
+// String formats the summary as a table with one line per worker
+func (r JobResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-40s %5s %6s %8s %12s %12s %10s\n",
+		"worker", "map", "reduce", "failures", "read", "written", "uptime")
+	for _, w := range r.Workers {
+		if !w.Responded {
+			fmt.Fprintf(&b, "%-40s did not respond\n", w.Worker)
+			continue
+		}
+		fmt.Fprintf(&b, "%-40s %5d %6d %8d %12d %12d %10v\n",
+			w.Worker, w.MapTasks, w.ReduceTasks, w.Failures, w.InputBytes, w.OutputBytes, w.Uptime.Round(time.Second))
+	}
+	return b.String()
+}
+
+// Result returns the summary of the workers gathered when the master
+// shut down, empty before. Call it after Wait. Workers whose summary
+// arrived after the master stopped waiting for it are included, see
+// WithAuditLog.
+func (mr *Master) Result() JobResult {
+	mr.Lock()
+	defer mr.Unlock()
+	result := mr.result
+	if len(mr.lateSummaries) > 0 {
+		result.Workers = append([]WorkerSummary(nil), result.Workers...)
+		for i, w := range result.Workers {
+			if summary, ok := mr.lateSummaries[w.Worker]; ok && !w.Responded {
+				result.Workers[i] = summary
+			}
+		}
+	}
+	return result
+}
+
+// Counters returns the totals of the counters of the master's current
+// or last job, see TaskContext.Count. Jobs run with Submit have their
+// own, see JobHandle.Counters.
+func (mr *Master) Counters() map[string]int64 {
+	mr.Lock()
+	job := mr.lastJob()
+	mr.Unlock()
+	return job.counters.totals()
+}
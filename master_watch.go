@@ -0,0 +1,147 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxJobEvents bounds the events kept per job. Once it is reached the
+// older half is dropped; watchers that fell that far behind resume with
+// the oldest event kept.
+const maxJobEvents = 10000
+
+// watchJobWait is how long a WatchJob request waits for new events
+// before it returns none, so clients notice a master that went away
+const watchJobWait = 10 * time.Second
+
+// eventStreamKeepAlive is how often an idle event stream over HTTP is
+// sent a comment, which keeps proxies from closing it
+const eventStreamKeepAlive = 15 * time.Second
+
+// recordEvent appends an event to the job's event log and wakes its
+// watchers. The caller must hold h.mu.
+func (h *JobHandle) recordEvent(event JobEvent) {
+	h.eventSeq++
+	event.Seq = h.eventSeq
+	event.Time = time.Now()
+	event.State = h.state
+	event.Progress = h.progress
+	if len(h.events) >= maxJobEvents {
+		h.events = append([]JobEvent(nil), h.events[len(h.events)/2:]...)
+	}
+	h.events = append(h.events, event)
+	if h.eventsChanged != nil {
+		close(h.eventsChanged)
+		h.eventsChanged = nil
+	}
+}
+
+// eventsAfter returns the kept events following sequence number after,
+// a channel closed once more are recorded and whether the job has
+// finished, so none will be
+func (h *JobHandle) eventsAfter(after int64) ([]JobEvent, <-chan struct{}, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.Search(len(h.events), func(i int) bool { return h.events[i].Seq > after })
+	events := append([]JobEvent(nil), h.events[i:]...)
+	if h.eventsChanged == nil {
+		h.eventsChanged = make(chan struct{})
+	}
+	finished := h.state == JobSucceeded || h.state == JobFailed || h.state == JobCancelled
+	return events, h.eventsChanged, finished
+}
+
+// waitEvents returns the events following sequence number after as soon
+// as there are any, or none once wait has passed or stop is closed. It
+// reports whether the job has finished.
+func (h *JobHandle) waitEvents(after int64, wait time.Duration, stop <-chan struct{}) ([]JobEvent, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		events, changed, finished := h.eventsAfter(after)
+		if len(events) > 0 || finished {
+			return events, finished
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, false
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// WatchJob returns the events of a job following args.After to a
+// remote client, waiting a while for new ones if there are none yet.
+// Clients follow a job by calling it again with the sequence number of
+// the last event received until the reply is Done. Observers may watch
+// jobs, see WithControlTokens.
+func (mr *Master) WatchJob(args *WatchJobArgs, reply *WatchJobReply) error {
+	if err := mr.checkControl(args.Version, args.Token, RoleObserver); err != nil {
+		return err
+	}
+	handle, err := mr.lookupJob(args.JobID)
+	if err != nil {
+		return err
+	}
+	reply.Events, reply.Done = handle.waitEvents(args.After, watchJobWait, mr.shutdown)
+	return nil
+}
+
+// serveEvents streams the events of a job as server-sent events, one
+// JSON encoded JobEvent each with its sequence number as the event ID,
+// until the job has finished. Clients resume after the event named by
+// the Last-Event-ID header or the after query parameter.
+func (mr *Master) serveEvents(w http.ResponseWriter, req *http.Request) {
+	handle, err := mr.lookupJob(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	after := req.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = req.URL.Query().Get("after")
+	}
+	var seq int64
+	if after != "" {
+		if seq, err = strconv.ParseInt(after, 10, 64); err != nil {
+			http.Error(w, "invalid event ID: "+after, http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for {
+		events, finished := handle.waitEvents(seq, eventStreamKeepAlive, req.Context().Done())
+		if req.Context().Err() != nil {
+			return
+		}
+		if len(events) == 0 && !finished {
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				processLog.warnf("HTTP: encode event: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Seq, data)
+			seq = event.Seq
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPhaseTimeout is returned by JobHandle.Wait for jobs whose map or
+// reduce phase ran longer than allowed, see WithPhaseTimeouts.
+var ErrPhaseTimeout = errors.New("phase timed out")
+
+// ErrPhaseStalled is returned by JobHandle.Wait for jobs the watchdog
+// failed because no task completed for too long, see WithWatchdog.
+var ErrPhaseStalled = errors.New("phase stalled")
+
+// WatchdogAction is what the watchdog does about a stalled phase.
+type WatchdogAction int
+
+const (
+	// WatchdogFail fails the job
+	WatchdogFail WatchdogAction = iota
+	// WatchdogRequeue abandons the running tasks and hands them to
+	// other workers; the workers that ran them get no further task of
+	// the phase. A phase that stalls again before a task completes
+	// fails the job.
+	WatchdogRequeue
+)
+
+// watchdogChecks is how often the watchdog looks for progress per
+// stall interval
+const watchdogChecks = 10
+
+// WithPhaseTimeouts fails the job once its map phase ran longer than
+// mapMax or its reduce phase longer than reduceMax, logging the state
+// of the scheduler first. Zero leaves a phase without limit.
+func WithPhaseTimeouts(mapMax, reduceMax time.Duration) Option {
+	return func(mr *Master) {
+		mr.phaseTimeouts = map[JobParse]time.Duration{mapParse: mapMax, reduceParse: reduceMax}
+	}
+}
+
+// WithWatchdog watches the phases of the job for progress: once no
+// task completed for stall the watchdog logs the state of the
+// scheduler, which tasks run where and for how long and which wait,
+// and takes action about it. Zero disables the watchdog, the default.
+func WithWatchdog(stall time.Duration, action WatchdogAction) Option {
+	return func(mr *Master) {
+		mr.watchdogStall = stall
+		mr.watchdogAction = action
+	}
+}
+
+// watchPhase enforces the phase timeout and the watchdog of the job on
+// the phase ts schedules, failing handle if needed. The returned
+// function stops watching once the phase is over.
+func (mr *Master) watchPhase(phase JobParse, ts *TaskScheduler, handle *JobHandle) func() {
+	mr.Lock()
+	timeout := mr.phaseTimeouts[phase]
+	stall, action := mr.watchdogStall, mr.watchdogAction
+	mr.Unlock()
+	if timeout <= 0 && stall <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var deadline, tick <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		if stall > 0 {
+			ticker := time.NewTicker(stall / watchdogChecks)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		completed, progressed, requeued := ts.completedTasks(), time.Now(), false
+		for {
+			select {
+			case <-done:
+				return
+			case <-deadline:
+				ts.logState(fmt.Sprintf("%s phase ran longer than %v", phase, timeout))
+				handle.fail(fmt.Errorf("%s phase of job %s ran longer than %v: %w", phase, ts.jobName, timeout, ErrPhaseTimeout))
+				return
+			case <-tick:
+				if n := ts.completedTasks(); n != completed {
+					completed, progressed, requeued = n, time.Now(), false
+					continue
+				}
+				if time.Since(progressed) < stall {
+					continue
+				}
+				ts.logState(fmt.Sprintf("no %s task completed for %v", phase, stall))
+				if action == WatchdogFail || requeued {
+					handle.fail(fmt.Errorf("no %s task of job %s completed for %v: %w", phase, ts.jobName, stall, ErrPhaseStalled))
+					return
+				}
+				mr.logger.warnf("Watchdog: requeueing the running %s tasks of job %s", phase, ts.jobName)
+				ts.abandonRunning()
+				progressed, requeued = time.Now(), true
+			}
+		}
+	}()
+	return func() { close(done) }
+}
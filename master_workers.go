@@ -0,0 +1,134 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// addWorker adds worker to the registered workers unless it is already
+// registered, and reports whether it was added. Every addition starts a
+// new generation of the worker, which schedulers that handed out an
+// earlier generation must forward again. The caller must hold the lock.
+func (mr *Master) addWorker(worker string) bool {
+	if mr.hasWorker(worker) {
+		return false
+	}
+	if mr.workerGen == nil {
+		mr.workerGen = make(map[string]int)
+	}
+	mr.workers = append(mr.workers, worker)
+	mr.workerGen[worker]++
+	mr.checkpoint(checkpointEntry{Kind: checkpointWorker, Worker: worker})
+	mr.newCond.Broadcast()
+	return true
+}
+
+// removeWorker drops worker from the registered workers, so that no
+// further tasks are assigned to it. The caller must hold the lock.
+func (mr *Master) removeWorker(worker string) bool {
+	for i, w := range mr.workers {
+		if w == worker {
+			mr.workers = append(mr.workers[:i], mr.workers[i+1:]...)
+			mr.checkpoint(checkpointEntry{Kind: checkpointWorkerGone, Worker: worker})
+			mr.newCond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// hasWorker reports whether worker is registered. The caller must hold
+// the lock.
+func (mr *Master) hasWorker(worker string) bool {
+	for _, w := range mr.workers {
+		if w == worker {
+			return true
+		}
+	}
+	return false
+}
+
+// workerGone reports whether worker has left or was dropped, for the
+// task schedulers
+func (mr *Master) workerGone(worker string) bool {
+	mr.Lock()
+	defer mr.Unlock()
+	return !mr.hasWorker(worker)
+}
+
+// jobWorkers returns the registered workers with task slots for the
+// running job, for the task schedulers
+func (mr *Master) jobWorkers() []string {
+	mr.Lock()
+	defer mr.Unlock()
+	var workers []string
+	for _, w := range mr.workers {
+		if mr.jobSlots(w) > 0 {
+			workers = append(workers, w)
+		}
+	}
+	return workers
+}
+
+// Workers returns the registered workers in registration order
+func (mr *Master) Workers() []WorkerInfo {
+	mr.Lock()
+	defer mr.Unlock()
+	infos := make([]WorkerInfo, 0, len(mr.workers))
+	for _, w := range mr.workers {
+		reg := mr.workerRegs[w]
+		info := WorkerInfo{
+			Address:    w,
+			Host:       reg.Host,
+			LocalPaths: append([]string(nil), reg.LocalPaths...),
+			Slots:      mr.workerSlots(w),
+			Reserved:   mr.reservedSlots(w, ""),
+		}
+		if labels := reg.Labels; len(labels) > 0 {
+			info.Labels = make(map[string]string, len(labels))
+			for k, v := range labels {
+				info.Labels[k] = v
+			}
+		}
+		if l := mr.liveness[w]; l != nil {
+			info.LastSeen = l.lastSeen
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// hasLocalInput reports whether file lies below one of the local
+// paths worker registered with, for the task schedulers
+func (mr *Master) hasLocalInput(worker string, file string) bool {
+	mr.Lock()
+	defer mr.Unlock()
+	file = filepath.Clean(file)
+	for _, dir := range mr.workerRegs[worker].LocalPaths {
+		dir = filepath.Clean(dir)
+		if file == dir || strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unregister removes a worker that is about to shut down. Tasks it is
+// running are left to finish, but it is assigned no new ones.
+func (mr *Master) Unregister(args *UnregisterArgs, _ *struct{}) error {
+	mr.Lock()
+	defer mr.Unlock()
+	if !validToken(mr.authToken, args.Token) {
+		return fmt.Errorf("invalid worker token")
+	}
+
+	if !mr.removeWorker(args.Worker) {
+		return fmt.Errorf("worker %s is not registered", args.Worker)
+	}
+	delete(mr.liveness, args.Worker)
+	delete(mr.workerRegs, args.Worker)
+	mr.logger.infof("Unregister: worker %s left", args.Worker)
+	return nil
+}
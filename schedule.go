@@ -2,20 +2,49 @@
 package mapreduce
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
 // taskContext contains all information needed for task execution
 type taskContext struct {
-	worker      string   // Worker address
-	taskNum     int      // Task number
-	phase       JobParse // Current phase
-	jobName     JobParse // Job name
-	mapFiles    []string // Input files
-	nOtherTasks int      // Number of tasks in other phase
+	worker      string          // Worker address
+	taskNum     int             // Task number
+	phase       JobParse        // Current phase
+	jobName     JobParse        // Job name
+	mapFiles    []string        // Input files
+	nOtherTasks int             // Number of tasks in other phase
+	options     JobOptions      // Job settings shipped with the task
+	token       string          // Credential shipped with the task
+	timeouts    RPCTimeouts     // Timeouts of the task RPC
+	lost        <-chan struct{} // Closed once the worker is declared dead
+	job         context.Context // Cancelled with the job
+	split       SplitInfo       // Input split of a map task
+	verify      bool            // Whether this is a verification run, see DoTaskArgs
+	uncached    bool            // Whether a map task must write its output, see DoTaskArgs
 }
 
+// RetryPolicy decides where a failed task rejoins the task queue of
+// its phase
+type RetryPolicy int
+
+const (
+	// RetryAtEnd queues failed tasks behind all waiting tasks, so
+	// healthy tasks finish before flaky ones are revisited
+	RetryAtEnd RetryPolicy = iota
+	// RetryImmediately hands failed tasks to the next free worker,
+	// ahead of all waiting tasks
+	RetryImmediately
+	// RetryAfterDelay holds failed tasks back for a delay, then queues
+	// them ahead of all waiting tasks
+	RetryAfterDelay
+)
+
 // TaskScheduler manages the scheduling and execution of MapReduce tasks
 type TaskScheduler struct {
 	jobName      JobParse
@@ -23,9 +52,51 @@ type TaskScheduler struct {
 	nReduce      int
 	phase        JobParse
 	registerChan chan string
+	options      JobOptions
 	taskCount    int
+	total        int                 // Number of tasks in the phase
+	metrics      map[int]TaskMetrics // Metrics reported by completed tasks
 	wg           sync.WaitGroup
 	mu           sync.Mutex
+
+	token       string                              // Credential workers expect with every task
+	timeouts    RPCTimeouts                         // Timeouts of the task RPCs
+	lost        func(worker string) <-chan struct{} // Closed once a worker is declared dead, may be nil
+	gone        func(worker string) bool            // Whether a worker left or was dropped, may be nil
+	workers     func() []string                     // Workers the phase may run tasks on, may be nil
+	local       func(worker, file string) bool      // Whether an input file is local to a worker, may be nil
+	priority    []int                               // Priority of every task, higher first, may be nil
+	sizes       []int64                             // Size of every task, may be nil
+	buckets     int                                 // Size buckets the queue alternates between, see WithFairScheduling
+	retry       RetryPolicy                         // Where failed tasks rejoin the queue
+	retryDelay  time.Duration                       // How long RetryAfterDelay holds failed tasks back
+	splits      []SplitInfo                         // Input split of every map task, may be nil
+	mapSplits   []SplitInfo                         // Input split of every map task run again in the reduce phase, may be nil
+	skip        []bool                              // Tasks known to have nothing to do, not scheduled, may be nil
+	verifyShare float64                             // Share of tasks run twice to check determinism
+	slots       chan struct{}                       // Holds a token per running task, nil for no limit
+	ctx         context.Context                     // Cancelled to stop handing out tasks and abort running ones
+	onComplete  func(completed, total int)          // Called after every completed task
+	onTaskState func(task int, state TaskState)     // Called on every task transition, may be nil
+	onMismatch  func(task int)                      // Called when two runs of a task disagree, may be nil
+	onMetrics   func(task int, metrics TaskMetrics) // Called with the metrics of every completed task, may be nil
+	onMapRerun  func(task int, state TaskState)     // Called on every transition of a map task run again, may be nil
+	onLate      func(late LateResult)               // Called with every result discarded as late, may be nil
+	onProduced  func(task int, worker string)       // Called with the worker of every completed map task, may be nil
+	producers   map[int]string                      // Worker that wrote the output of every map task, reduce phase only, may be empty
+	reruns      map[int]*mapRerun                   // Map tasks being run again
+	attempts    map[*taskAttempt]struct{}           // Task attempts under way
+	stalled     map[string]bool                     // Workers whose tasks the watchdog abandoned, given no other task
+	deferred    map[int]time.Time                   // When tasks were first deferred to wait for another worker, see deferTask
+	failedOn    map[int]map[string]bool             // Workers every task of the phase failed on
+	failures    map[string]int                      // Number of tasks of the phase every worker failed
+	logger      nodeLog                             // Destination of log messages
+	completed   []bool                              // Tasks completed, whose later results are discarded
+	allDone     chan struct{}                       // Closed once every task completed
+	finished    chan struct{}                       // Closed once Run returns
+	flush       cacheFlusher                        // Flushes the combiner caches holding map output at the end of the phase, may be nil
+	cached      map[int]cachedOutput                // Map tasks whose output a combiner cache absorbed
+	uncached    map[int]bool                        // Map tasks run again because a combiner cache lost their output
 }
 
 // NewTaskScheduler creates a new task scheduler instance
@@ -35,6 +106,7 @@ func NewTaskScheduler(
 	nReduce int,
 	phase JobParse,
 	registerChan chan string,
+	options JobOptions,
 ) *TaskScheduler {
 	ts := &TaskScheduler{
 		jobName:      jobName,
@@ -42,6 +114,21 @@ func NewTaskScheduler(
 		nReduce:      nReduce,
 		phase:        phase,
 		registerChan: registerChan,
+		options:      options,
+		metrics:      make(map[int]TaskMetrics),
+		reruns:       make(map[int]*mapRerun),
+		attempts:     make(map[*taskAttempt]struct{}),
+		stalled:      make(map[string]bool),
+		deferred:     make(map[int]time.Time),
+		failedOn:     make(map[int]map[string]bool),
+		failures:     make(map[string]int),
+		producers:    make(map[int]string),
+		ctx:          context.Background(),
+		logger:       processLog,
+		allDone:      make(chan struct{}),
+		finished:     make(chan struct{}),
+		cached:       make(map[int]cachedOutput),
+		uncached:     make(map[int]bool),
 	}
 
 	// Set task count based on phase
@@ -50,28 +137,29 @@ func NewTaskScheduler(
 	} else {
 		ts.taskCount = nReduce
 	}
+	ts.total = ts.taskCount
+	ts.completed = make([]bool, ts.total)
 
 	return ts
 }
 
-// schedule coordinates task distribution and execution
-func schedule(
-	jobName JobParse,
-	mapFiles []string,
-	nReduce int,
-	phase JobParse,
-	registerChan chan string,
-) {
-	scheduler := NewTaskScheduler(jobName, mapFiles, nReduce, phase, registerChan)
-	scheduler.Run()
-}
-
-// Run starts the task scheduling process
+// Run starts the task scheduling process.
+// It returns once every task has completed or the cancel channel is
+// closed; tasks already running when cancelled are not waited for.
 func (ts *TaskScheduler) Run() {
+	defer close(ts.finished)
+
 	// Initialize channels
 	taskChan := ts.createTaskChannel()
 	failedTasks := make(chan int, ts.taskCount)
 	done := make(chan struct{})
+	if ts.taskCount == 0 {
+		close(ts.allDone)
+	}
+
+	if ts.phase == reduceParse {
+		ts.watchProducers()
+	}
 
 	// Start task processor
 	go ts.processTasksAsync(taskChan, failedTasks, done)
@@ -81,16 +169,85 @@ func (ts *TaskScheduler) Run() {
 	<-done
 }
 
-// createTaskChannel initializes and populates the task channel
+// createTaskChannel initializes and populates the task channel.
+// The channel is the scheduler's task queue: with priorities set it is
+// kept ordered by priority, tasks of equal priority by task number.
+// With task sizes and buckets set, the queue then alternates between
+// tasks of different sizes. Skipped tasks count as completed right
+// away.
 func (ts *TaskScheduler) createTaskChannel() chan int {
 	taskChan := make(chan int, ts.taskCount)
-	for i := 0; i < ts.taskCount; i++ {
-		taskChan <- i
+	tasks := make([]int, 0, ts.taskCount)
+	for i := 0; i < ts.total; i++ {
+		if i < len(ts.skip) && ts.skip[i] {
+			ts.setTaskState(i, TaskCompleted)
+			ts.completed[i] = true
+			ts.taskCount--
+			continue
+		}
+		tasks = append(tasks, i)
+	}
+	if ts.taskCount < ts.total && ts.onComplete != nil {
+		ts.onComplete(ts.total-ts.taskCount, ts.total)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return ts.taskPriority(tasks[i]) > ts.taskPriority(tasks[j])
+	})
+	if len(ts.sizes) == ts.total {
+		tasks = interleaveBySize(tasks, ts.sizes, ts.buckets)
+	}
+	for _, task := range tasks {
+		taskChan <- task
 	}
 	return taskChan
 }
 
-// processTasksAsync handles task distribution and retry logic
+// taskPriority returns the priority of a task, zero without priorities
+func (ts *TaskScheduler) taskPriority(taskNum int) int {
+	if taskNum < len(ts.priority) {
+		return ts.priority[taskNum]
+	}
+	return 0
+}
+
+// queueByPriority puts a task back in the queue ahead of all queued
+// tasks of lower priority
+func (ts *TaskScheduler) queueByPriority(taskNum int, taskChan chan int) {
+	insertTask(taskNum, taskChan, func(queued []int) int {
+		return sort.Search(len(queued), func(i int) bool {
+			return ts.taskPriority(queued[i]) < ts.taskPriority(taskNum)
+		})
+	})
+}
+
+// queueFirst puts a task back in the queue ahead of all queued tasks
+func queueFirst(taskNum int, taskChan chan int) {
+	insertTask(taskNum, taskChan, func([]int) int { return 0 })
+}
+
+// insertTask puts a task back in the queue at the position pos picks
+// among the queued tasks. Only the task processor adds tasks to
+// taskChan, so it cannot fill up or close meanwhile.
+func insertTask(taskNum int, taskChan chan int, pos func(queued []int) int) {
+	queued := make([]int, 0, len(taskChan)+1)
+	for n := len(taskChan); n > 0; n-- {
+		t, ok := <-taskChan
+		if !ok {
+			break
+		}
+		queued = append(queued, t)
+	}
+	i := pos(queued)
+	queued = append(queued[:i], append([]int{taskNum}, queued[i:]...)...)
+	for _, t := range queued {
+		taskChan <- t
+	}
+}
+
+// processTasksAsync handles task distribution and retry logic. It is
+// the only goroutine adding tasks to taskChan, which is never closed:
+// attempts may still fail, and queue their task again, after the last
+// task completed.
 func (ts *TaskScheduler) processTasksAsync(
 	taskChan chan int,
 	failedTasks chan int,
@@ -98,18 +255,19 @@ func (ts *TaskScheduler) processTasksAsync(
 ) {
 	for {
 		select {
-		case taskNum, ok := <-taskChan:
-			if !ok {
-				close(done)
-				return
-			}
+		case taskNum := <-taskChan:
 			ts.handleTask(taskNum, taskChan, failedTasks, done)
 
-		case taskNum, ok := <-failedTasks:
-			if !ok {
-				continue
-			}
+		case taskNum := <-failedTasks:
 			ts.requeueFailedTask(taskNum, taskChan, done)
+
+		case <-ts.allDone:
+			close(done)
+			return
+
+		case <-ts.ctx.Done():
+			close(done)
+			return
 		}
 	}
 }
@@ -121,26 +279,334 @@ func (ts *TaskScheduler) handleTask(
 	failedTasks chan int,
 	done chan struct{},
 ) {
-	worker := <-ts.registerChan
+	if ts.isCompleted(taskNum) {
+		return // Queued again by an attempt that failed after another completed it
+	}
+	if !ts.acquireSlot() {
+		return
+	}
+	var worker string
+	select {
+	case worker = <-ts.registerChan:
+	case <-ts.allDone:
+		ts.releaseSlot()
+		return
+	case <-ts.ctx.Done():
+		ts.releaseSlot()
+		return
+	}
+	taskNum = ts.preferUntriedTask(taskNum, worker, taskChan)
+	if ts.deferTask(taskNum, worker) {
+		// The slot is free for other tasks while the task waits
+		ts.releaseSlot()
+		go ts.releaseWorkerAfter(worker, passedOverBackoff)
+		taskChan <- taskNum
+		return
+	}
+	taskNum = ts.preferLocalTask(taskNum, worker, taskChan)
 	ts.wg.Add(1)
 
 	go func() {
 		defer ts.wg.Done()
-		if ts.executeTaskWithRetry(taskNum, worker) {
-			ts.markTaskComplete(taskChan, failedTasks)
-		} else {
+		ts.setTaskState(taskNum, TaskRunning)
+		reply, ok := ts.executeTaskWithRetry(taskNum, worker)
+		succeeded := ok && reply.Refused == "" && len(reply.LostMapTasks) == 0
+		if succeeded && !ts.claimCompletion(taskNum) || !succeeded && ts.isCompleted(taskNum) {
+			// Another attempt completed the task meanwhile, or the
+			// phase is over
+			if succeeded {
+				ts.discardLate(taskNum, worker, reply.Metrics)
+			}
+			ts.releaseSlot()
+			ts.releaseWorker(worker)
+			return
+		}
+		switch {
+		case ok && reply.Refused != "":
+			ts.logger.warnf("Scheduler: %s refused %s task %d of job %s: %s",
+				worker, ts.phase, taskNum, ts.jobName, reply.Refused)
+			ts.setTaskState(taskNum, TaskIdle)
+			ts.handleFailedTask(taskNum, failedTasks, done)
+			ts.releaseSlot()
+			go ts.releaseWorkerAfter(worker, refusedTaskBackoff)
+			return
+		case ok && len(reply.LostMapTasks) > 0:
+			ts.logger.warnf("Scheduler: %s task %d of job %s is missing the output of map tasks %v, running them again",
+				ts.phase, taskNum, ts.jobName, reply.LostMapTasks)
+			ts.setTaskState(taskNum, TaskIdle)
+			for _, mapTask := range reply.LostMapTasks {
+				ts.rerunMapTask(mapTask, worker)
+			}
+			ts.handleFailedTask(taskNum, failedTasks, done)
+		case ok:
+			if ts.onProduced != nil {
+				ts.onProduced(taskNum, worker)
+			}
+			ts.noteCached(taskNum, worker, reply.Cache)
+			ts.recordMetrics(taskNum, reply.Metrics)
+			ts.setTaskState(taskNum, TaskCompleted)
+			if ts.markTaskComplete() {
+				ts.flushCaches(failedTasks, done)
+			}
+			ts.verifyTask(taskNum, worker, reply.Metrics)
+		default:
+			ts.setTaskState(taskNum, TaskIdle)
 			ts.handleFailedTask(taskNum, failedTasks, done)
 		}
-		ts.registerChan <- worker
+		// Free the slot first: handing the worker back waits for the
+		// task processor, which may be waiting for a slot
+		ts.releaseSlot()
+		ts.releaseWorker(worker)
 	}()
 }
 
-// executeTaskWithRetry attempts to execute a task with exponential backoff
-func (ts *TaskScheduler) executeTaskWithRetry(taskNum int, worker string) bool {
+// setMaxInFlight limits the number of tasks running at once to n,
+// zero for no limit
+func (ts *TaskScheduler) setMaxInFlight(n int) {
+	ts.slots = nil
+	if n > 0 {
+		ts.slots = make(chan struct{}, n)
+	}
+}
+
+// acquireSlot waits until fewer tasks than the limit are running. It
+// returns false if the scheduler is cancelled meanwhile.
+func (ts *TaskScheduler) acquireSlot() bool {
+	if ts.slots == nil {
+		return true
+	}
+	select {
+	case ts.slots <- struct{}{}:
+		return true
+	case <-ts.ctx.Done():
+		return false
+	}
+}
+
+// releaseSlot makes room for the next task after acquireSlot
+func (ts *TaskScheduler) releaseSlot() {
+	if ts.slots != nil {
+		<-ts.slots
+	}
+}
+
+// preferLocalTask returns a queued map task whose input file is local
+// to worker, putting taskNum back in its place, or taskNum itself if it
+// is local or no queued task is. Tasks that failed on worker are not
+// picked.
+func (ts *TaskScheduler) preferLocalTask(taskNum int, worker string, taskChan chan int) int {
+	if ts.phase != mapParse || ts.local == nil || ts.local(worker, ts.mapFiles[taskNum]) {
+		return taskNum
+	}
+	return swapQueuedTask(taskNum, taskChan, func(t int) bool {
+		return ts.local(worker, ts.mapFiles[t]) && !ts.avoids(t, worker)
+	})
+}
+
+// preferUntriedTask returns the first queued task that did not fail on
+// worker, putting taskNum back in its place, if taskNum did
+func (ts *TaskScheduler) preferUntriedTask(taskNum int, worker string, taskChan chan int) int {
+	if !ts.avoids(taskNum, worker) {
+		return taskNum
+	}
+	return swapQueuedTask(taskNum, taskChan, func(t int) bool {
+		return !ts.avoids(t, worker)
+	})
+}
+
+// swapQueuedTask returns the first queued task fits accepts, putting
+// taskNum back in its place, or taskNum itself if fits accepts none.
+// Only the task processor adds tasks to taskChan, so it cannot fill up
+// or close while tasks are taken out.
+func swapQueuedTask(taskNum int, taskChan chan int, fits func(t int) bool) int {
+	queued := make([]int, 0, len(taskChan))
+	for len(queued) < cap(queued) {
+		t, ok := <-taskChan
+		if !ok {
+			break
+		}
+		queued = append(queued, t)
+	}
+	chosen := -1
+	for i, t := range queued {
+		if fits(t) {
+			chosen = i
+			break
+		}
+	}
+	if chosen >= 0 {
+		queued[chosen], taskNum = taskNum, queued[chosen]
+	}
+	for _, t := range queued {
+		taskChan <- t
+	}
+	return taskNum
+}
+
+// alternateWorkerWait is how long a task waits for another worker
+// than the one it failed on before running there again
+const alternateWorkerWait = 5 * time.Second
+
+// passedOverBackoff is how long a worker a task was deferred on gets no
+// other task, so another worker may take the task meanwhile
+const passedOverBackoff = 200 * time.Millisecond
+
+// deferTask reports whether taskNum should wait for another worker than
+// worker, which is then handed back after passedOverBackoff while the
+// task is queued again. It does if the task failed on worker, or worker
+// failed blacklistFailures tasks of the phase, while another worker may
+// run it, for alternateWorkerWait at most: the task runs on worker once
+// none turned up in time.
+func (ts *TaskScheduler) deferTask(taskNum int, worker string) bool {
+	if !ts.avoids(taskNum, worker) || !ts.hasAlternative(taskNum, worker) {
+		return false
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	since, ok := ts.deferred[taskNum]
+	if !ok {
+		ts.deferred[taskNum] = time.Now()
+		return true
+	}
+	if time.Since(since) < alternateWorkerWait {
+		return true
+	}
+	delete(ts.deferred, taskNum)
+	return false
+}
+
+// blacklistFailures is the number of failed tasks of a phase after
+// which a worker is passed over for all tasks of the phase while others
+// are available
+const blacklistFailures = 3
+
+// noteFailure remembers that taskNum failed on worker
+func (ts *TaskScheduler) noteFailure(taskNum int, worker string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.failedOn[taskNum][worker] {
+		return
+	}
+	if ts.failedOn[taskNum] == nil {
+		ts.failedOn[taskNum] = make(map[string]bool)
+	}
+	ts.failedOn[taskNum][worker] = true
+	ts.failures[worker]++
+	if ts.failures[worker] == blacklistFailures {
+		ts.logger.warnf("Scheduler: %s failed %d %s tasks of job %s, preferring other workers",
+			worker, blacklistFailures, ts.phase, ts.jobName)
+	}
+}
+
+// avoids reports whether taskNum should rather run on another worker
+// than worker: the task failed there, or the worker is blacklisted
+func (ts *TaskScheduler) avoids(taskNum int, worker string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.failedOn[taskNum][worker] || ts.failures[worker] >= blacklistFailures
+}
+
+// hasAlternative reports whether a worker other than worker that taskNum
+// need not avoid may run the task
+func (ts *TaskScheduler) hasAlternative(taskNum int, worker string) bool {
+	if ts.workers == nil {
+		return false
+	}
+	for _, w := range ts.workers() {
+		if w == worker || ts.avoids(taskNum, w) {
+			continue
+		}
+		ts.mu.Lock()
+		stalled := ts.stalled[w]
+		ts.mu.Unlock()
+		if !stalled {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseWorker hands a worker back for the next task of the phase.
+// Once the phase is over nobody takes workers from the channel, so
+// the worker is dropped; the next phase receives it from the master.
+// Workers that left or were declared dead are dropped as well, like
+// workers whose tasks the watchdog abandoned.
+func (ts *TaskScheduler) releaseWorker(worker string) {
+	if ts.gone != nil && ts.gone(worker) {
+		return
+	}
+	ts.mu.Lock()
+	stalled := ts.stalled[worker]
+	ts.mu.Unlock()
+	if stalled {
+		return
+	}
+	select {
+	case ts.registerChan <- worker:
+	case <-ts.finished:
+	}
+}
+
+// refusedTaskBackoff is how long a worker that refused a task gets no
+// other task of the phase
+const refusedTaskBackoff = 2 * time.Second
+
+// releaseWorkerAfter hands a worker back once delay has passed, or
+// drops it if the phase ends first
+func (ts *TaskScheduler) releaseWorkerAfter(worker string, delay time.Duration) {
+	select {
+	case <-time.After(delay):
+		ts.releaseWorker(worker)
+	case <-ts.finished:
+	}
+}
+
+// executeTaskWithRetry attempts to execute a task with exponential
+// backoff. A task the worker refused is not retried on it, and neither
+// is a failed one while another worker may run it, see deferTask.
+func (ts *TaskScheduler) executeTaskWithRetry(taskNum int, worker string) (DoTaskReply, bool) {
+	return ts.executeWithRetry(ts.taskContext(taskNum, worker))
+}
+
+// executeWithRetry executes the task ctx describes like
+// executeTaskWithRetry, in a span of its own when tracing
+func (ts *TaskScheduler) executeWithRetry(ctx taskContext) (reply DoTaskReply, ok bool) {
+	var span *traceSpan
+	ctx.job, span = startSpan(ctx.job, SpanInternal, string(ctx.phase)+" task",
+		"job", string(ctx.jobName), "task", strconv.Itoa(ctx.taskNum), "worker", ctx.worker)
+	defer func() {
+		switch {
+		case !ok:
+			span.end(fmt.Errorf("%s task %d failed on %s", ctx.phase, ctx.taskNum, ctx.worker))
+		case reply.Refused != "":
+			span.end(fmt.Errorf("refused: %s", reply.Refused))
+		case len(reply.LostMapTasks) > 0:
+			span.end(fmt.Errorf("output of map tasks %v is lost", reply.LostMapTasks))
+		default:
+			span.end(nil)
+		}
+	}()
+	var abandon context.CancelFunc
+	ctx.job, abandon = context.WithCancel(ctx.job)
+	defer ts.trackAttempt(ctx, abandon)()
+
 	const maxRetries = 5
+	worker := ctx.worker
 	for retries := 0; retries < maxRetries; retries++ {
-		if success := ts.executeTask(taskNum, worker); success {
-			return true
+		if reply, success := executeTask(ctx); success {
+			return reply, true
+		}
+		if ts.gone != nil && ts.gone(worker) {
+			break // Retry the task on another worker
+		}
+		if ctx.job.Err() != nil {
+			break // The job was cancelled or the attempt abandoned
+		}
+		if ctx.phase == ts.phase {
+			ts.noteFailure(ctx.taskNum, worker)
+			if ts.hasAlternative(ctx.taskNum, worker) {
+				break // Retry the task on another worker
+			}
 		}
 
 		if retries < maxRetries-1 {
@@ -148,11 +614,279 @@ func (ts *TaskScheduler) executeTaskWithRetry(taskNum int, worker string) bool {
 			time.Sleep(backoff)
 		}
 	}
-	return false
+	return DoTaskReply{}, false
 }
 
-// executeTask attempts to execute a single task
-func (ts *TaskScheduler) executeTask(taskNum int, worker string) bool {
+// mapRerun is a run of a map task in the reduce phase, see
+// rerunMapTask
+type mapRerun struct {
+	done chan struct{} // Closed once the run is over
+	ok   bool          // Whether the run succeeded, set before done is closed
+}
+
+// rerunMapTask runs a map task of the reduce phase's job again on
+// worker because a reduce task could not find its output. Reduce tasks
+// missing the same output wait for the run already under way instead
+// of starting another one, and only run the task themselves if it
+// fails, e.g. because its worker died.
+func (ts *TaskScheduler) rerunMapTask(mapTask int, worker string) {
+	ts.mu.Lock()
+	for ts.reruns[mapTask] != nil {
+		running := ts.reruns[mapTask]
+		ts.mu.Unlock()
+		<-running.done
+		if running.ok {
+			return
+		}
+		ts.mu.Lock()
+	}
+	run := &mapRerun{done: make(chan struct{})}
+	ts.reruns[mapTask] = run
+	producer := ts.producers[mapTask]
+	ts.mu.Unlock()
+	if producer != "" {
+		ts.logger.infof("Scheduler: running map task %d of job %s, whose output %s wrote, again on %s",
+			mapTask, ts.jobName, producer, worker)
+	}
+	defer func() {
+		ts.mu.Lock()
+		delete(ts.reruns, mapTask)
+		ts.mu.Unlock()
+		close(run.done)
+	}()
+
+	ts.setMapTaskState(mapTask, TaskRunning)
+	ctx := ts.taskContext(mapTask, worker)
+	ctx.phase = mapParse
+	ctx.nOtherTasks = ts.nReduce
+	ctx.uncached = true // No cache is flushed any more
+	ctx.split = SplitInfo{}
+	if mapTask < len(ts.mapSplits) {
+		ctx.split = ts.mapSplits[mapTask]
+	}
+	reply, ok := ts.executeWithRetry(ctx)
+	if !ok || reply.Refused != "" {
+		ts.logger.warnf("Scheduler: could not run map task %d of job %s again on %s", mapTask, ts.jobName, worker)
+		ts.setMapTaskState(mapTask, TaskIdle)
+		return
+	}
+	ts.mu.Lock()
+	ts.producers[mapTask] = worker
+	run.ok = true
+	ts.mu.Unlock()
+	ts.setMapTaskState(mapTask, TaskCompleted)
+}
+
+// watchProducers waits, until the phase ends, for the workers that
+// wrote the map output to be declared dead. Map output a dead worker
+// took with it, e.g. on a disk only it could reach, is written again
+// right away instead of once every reduce task reading it failed, see
+// recoverMapOutputs. Without heartbeats only the reduce tasks notice.
+func (ts *TaskScheduler) watchProducers() {
+	if ts.lost == nil {
+		return
+	}
+	ts.mu.Lock()
+	workers := make(map[string]bool)
+	for _, worker := range ts.producers {
+		workers[worker] = true
+	}
+	ts.mu.Unlock()
+	for worker := range workers {
+		lost := ts.lost(worker)
+		if lost == nil {
+			continue
+		}
+		go func(worker string) {
+			select {
+			case <-lost:
+				ts.recoverMapOutputs(worker)
+			case <-ts.allDone:
+			case <-ts.ctx.Done():
+			}
+		}(worker)
+	}
+}
+
+// recoverMapOutputs runs the map tasks whose output the dead worker
+// wrote and a reduce task still to complete can no longer read again,
+// each on another worker
+func (ts *TaskScheduler) recoverMapOutputs(worker string) {
+	lost := ts.unreadableOutputs(worker)
+	if len(lost) == 0 {
+		ts.logger.infof("Scheduler: the map output %s wrote for job %s is still readable", worker, ts.jobName)
+		return
+	}
+	ts.logger.warnf("Scheduler: %s died with the output of map tasks %v of job %s, running them again",
+		worker, lost, ts.jobName)
+	var wg sync.WaitGroup
+	for _, mapTask := range lost {
+		var other string
+		select {
+		case other = <-ts.registerChan:
+		case <-ts.allDone:
+			return
+		case <-ts.ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func(mapTask int) {
+			defer wg.Done()
+			ts.rerunMapTask(mapTask, other)
+			ts.releaseWorker(other)
+		}(mapTask)
+	}
+	wg.Wait()
+}
+
+// unreadableOutputs returns the map tasks worker wrote whose output
+// for a reduce task not completed yet is missing from the workspace
+// and its replica
+func (ts *TaskScheduler) unreadableOutputs(worker string) []int {
+	ws := ts.options.workspace(ts.jobName)
+	replica := ts.options.replica(ts.jobName)
+	ts.mu.Lock()
+	var tasks []int
+	for task, producer := range ts.producers {
+		if producer == worker {
+			tasks = append(tasks, task)
+		}
+	}
+	ts.mu.Unlock()
+	sort.Ints(tasks)
+
+	var lost []int
+	for _, task := range tasks {
+		for r := 0; r < ts.total; r++ {
+			if ts.isCompleted(r) {
+				continue
+			}
+			if _, err := statFile(readableCopy(ws, replica, ws.Intermediate(task, r))); err != nil {
+				lost = append(lost, task)
+				break
+			}
+		}
+	}
+	return lost
+}
+
+// setMapTaskState reports a transition of a map task run again to
+// onMapRerun
+func (ts *TaskScheduler) setMapTaskState(taskNum int, state TaskState) {
+	if ts.onMapRerun != nil {
+		ts.onMapRerun(taskNum, state)
+	}
+}
+
+// taskAttempt is the execution of a task on a worker, see
+// executeWithRetry
+type taskAttempt struct {
+	phase   JobParse
+	taskNum int
+	worker  string
+	started time.Time
+	abandon context.CancelFunc // Aborts the attempt
+}
+
+// trackAttempt records the attempt ctx describes as under way until
+// the returned function is called
+func (ts *TaskScheduler) trackAttempt(ctx taskContext, abandon context.CancelFunc) func() {
+	attempt := &taskAttempt{phase: ctx.phase, taskNum: ctx.taskNum, worker: ctx.worker, started: time.Now(), abandon: abandon}
+	ts.mu.Lock()
+	ts.attempts[attempt] = struct{}{}
+	ts.mu.Unlock()
+	return func() {
+		ts.mu.Lock()
+		delete(ts.attempts, attempt)
+		ts.mu.Unlock()
+		abandon()
+	}
+}
+
+// runningAttempts returns the attempts under way, longest running
+// first
+func (ts *TaskScheduler) runningAttempts() []*taskAttempt {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	attempts := make([]*taskAttempt, 0, len(ts.attempts))
+	for attempt := range ts.attempts {
+		attempts = append(attempts, attempt)
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].started.Before(attempts[j].started)
+	})
+	return attempts
+}
+
+// abandonRunning aborts the attempts under way, so their tasks are
+// queued again, and gives their workers no other task of the phase
+func (ts *TaskScheduler) abandonRunning() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for attempt := range ts.attempts {
+		ts.stalled[attempt.worker] = true
+		attempt.abandon()
+	}
+}
+
+// completedTasks returns the number of tasks of the phase that have
+// completed
+func (ts *TaskScheduler) completedTasks() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.total - ts.taskCount
+}
+
+// logState logs why the scheduler is inspected and its state: how many
+// tasks completed and wait, and which run where for how long
+func (ts *TaskScheduler) logState(reason string) {
+	attempts := ts.runningAttempts()
+	completed := ts.completedTasks()
+	ts.logger.warnf("Watchdog: %s in job %s: %d of %d tasks completed, %d running, %d waiting",
+		reason, ts.jobName, completed, ts.total, len(attempts), max(ts.total-completed-len(attempts), 0))
+	for _, attempt := range attempts {
+		ts.logger.warnf("Watchdog:   %s task %d on %s for %v",
+			attempt.phase, attempt.taskNum, attempt.worker, time.Since(attempt.started).Round(time.Millisecond))
+	}
+}
+
+// verifyTask runs a completed task a second time on the same worker
+// if it is one of the tasks picked for the determinism check, and
+// reports the task when the two runs produced different output. Such
+// tasks would break retries: a rerun after a failure could produce
+// output that does not match what other tasks already consumed.
+// The second run's output is discarded.
+func (ts *TaskScheduler) verifyTask(taskNum int, worker string, metrics TaskMetrics) {
+	if !ts.checksDeterminism(taskNum) {
+		return
+	}
+	ctx := ts.taskContext(taskNum, worker)
+	ctx.verify = true
+	again, ok := executeTask(ctx)
+	if !ok || again.Refused != "" {
+		ts.logger.warnf("Scheduler: could not verify %s task %d of job %s on %s", ts.phase, taskNum, ts.jobName, worker)
+		return
+	}
+	if again.Metrics.OutputDigest == metrics.OutputDigest {
+		return
+	}
+	ts.logger.errorf("Scheduler: %s task %d of job %s is not deterministic, two runs on %s produced different output",
+		ts.phase, taskNum, ts.jobName, worker)
+	if ts.onMismatch != nil {
+		ts.onMismatch(taskNum)
+	}
+}
+
+// checksDeterminism reports whether a task is picked for the
+// determinism check. Picks are spread evenly over the task numbers
+// and include the first task whenever the check is enabled.
+func (ts *TaskScheduler) checksDeterminism(taskNum int) bool {
+	share := ts.verifyShare
+	return share > 0 && math.Ceil(float64(taskNum+1)*share) > math.Ceil(float64(taskNum)*share)
+}
+
+// taskContext describes the execution of a task on worker
+func (ts *TaskScheduler) taskContext(taskNum int, worker string) taskContext {
 	ctx := taskContext{
 		worker:      worker,
 		taskNum:     taskNum,
@@ -160,8 +894,28 @@ func (ts *TaskScheduler) executeTask(taskNum int, worker string) bool {
 		jobName:     ts.jobName,
 		mapFiles:    ts.mapFiles,
 		nOtherTasks: ts.getOtherTaskCount(),
+		options:     ts.options,
+		token:       ts.token,
+		timeouts:    ts.timeouts,
+		lost:        ts.workerLost(worker),
+		job:         ts.ctx,
 	}
-	return executeTask(ctx)
+	if taskNum < len(ts.splits) {
+		ctx.split = ts.splits[taskNum]
+	}
+	ts.mu.Lock()
+	ctx.uncached = ts.phase == mapParse && ts.uncached[taskNum]
+	ts.mu.Unlock()
+	return ctx
+}
+
+// workerLost returns the channel closed once worker is declared dead,
+// nil without liveness tracking
+func (ts *TaskScheduler) workerLost(worker string) <-chan struct{} {
+	if ts.lost == nil {
+		return nil
+	}
+	return ts.lost(worker)
 }
 
 // getOtherTaskCount returns the number of tasks in the other phase
@@ -172,24 +926,167 @@ func (ts *TaskScheduler) getOtherTaskCount() int {
 	return len(ts.mapFiles)
 }
 
-// markTaskComplete updates the task counter and closes channels if needed
-func (ts *TaskScheduler) markTaskComplete(taskChan, failedTasks chan int) {
+// setTaskState reports a task transition to onTaskState
+func (ts *TaskScheduler) setTaskState(taskNum int, state TaskState) {
+	if ts.onTaskState != nil {
+		ts.onTaskState(taskNum, state)
+	}
+}
+
+// recordMetrics stores the metrics reported by a completed task
+func (ts *TaskScheduler) recordMetrics(taskNum int, metrics TaskMetrics) {
+	ts.mu.Lock()
+	ts.metrics[taskNum] = metrics
+	ts.mu.Unlock()
+	if ts.onMetrics != nil {
+		ts.onMetrics(taskNum, metrics)
+	}
+}
+
+// markTaskComplete updates the task counter after claimCompletion and
+// ends the phase once it reaches zero, unless combiner caches hold map
+// output, which it reports: the caller then ends the phase with
+// flushCaches.
+func (ts *TaskScheduler) markTaskComplete() (flush bool) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
 	ts.taskCount--
-	if ts.taskCount == 0 {
-		close(taskChan)
-		close(failedTasks)
+	if ts.onComplete != nil {
+		ts.onComplete(ts.total-ts.taskCount, ts.total)
+	}
+	if ts.taskCount > 0 {
+		return false
+	}
+	if ts.flush != nil && len(ts.cached) > 0 {
+		return true
+	}
+	close(ts.allDone)
+	return false
+}
+
+// cachedOutput is the combiner cache of a worker that absorbed the
+// output of a map task, see DoTaskReply.Cache
+type cachedOutput struct {
+	worker string
+	cache  string
+}
+
+// cacheFlusher flushes the combiner caches that absorbed the output of
+// the map tasks in cached and returns the tasks whose output was lost
+type cacheFlusher func(cached map[int]cachedOutput) []int
+
+// noteCached remembers the combiner cache that absorbed the output of
+// a completed map task, if any
+func (ts *TaskScheduler) noteCached(taskNum int, worker, cache string) {
+	if cache == "" || ts.phase != mapParse {
+		return
 	}
+	ts.mu.Lock()
+	ts.cached[taskNum] = cachedOutput{worker: worker, cache: cache}
+	ts.mu.Unlock()
 }
 
-// handleFailedTask attempts to requeue a failed task
+// isCached reports whether a combiner cache absorbed the output of a
+// completed map task
+func (ts *TaskScheduler) isCached(taskNum int) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	_, ok := ts.cached[taskNum]
+	return ok
+}
+
+// flushCaches has the combiner caches that absorbed map output flushed
+// once every task of the phase completed, see flush. Tasks whose output
+// was lost with a cache, e.g. because its worker died, are queued again
+// to write their output this time, and the caches are flushed once more
+// when they completed. The phase ends once nothing was lost.
+func (ts *TaskScheduler) flushCaches(failedTasks chan int, done chan struct{}) {
+	ts.mu.Lock()
+	cached := make(map[int]cachedOutput, len(ts.cached))
+	for task, out := range ts.cached {
+		cached[task] = out
+	}
+	ts.mu.Unlock()
+	lost := ts.flush(cached)
+
+	ts.mu.Lock()
+	if ts.ctx.Err() != nil {
+		ts.mu.Unlock()
+		return
+	}
+	if len(lost) == 0 {
+		close(ts.allDone)
+		ts.mu.Unlock()
+		return
+	}
+	for _, task := range lost {
+		delete(ts.cached, task)
+		ts.completed[task] = false
+		ts.uncached[task] = true
+	}
+	ts.taskCount += len(lost)
+	if ts.onComplete != nil {
+		ts.onComplete(ts.total-ts.taskCount, ts.total)
+	}
+	ts.mu.Unlock()
+	for _, task := range lost {
+		ts.setTaskState(task, TaskIdle)
+		ts.handleFailedTask(task, failedTasks, done)
+	}
+}
+
+// claimCompletion marks a task completed by the attempt that just
+// succeeded, unless another attempt completed it first or the phase is
+// over: the scheduler returned, or it was cancelled. It reports
+// whether the attempt's result counts.
+func (ts *TaskScheduler) claimCompletion(taskNum int) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.completed[taskNum] || isClosed(ts.finished) || ts.ctx.Err() != nil {
+		return false
+	}
+	ts.completed[taskNum] = true
+	return true
+}
+
+// isCompleted reports whether an attempt completed a task
+func (ts *TaskScheduler) isCompleted(taskNum int) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.completed[taskNum]
+}
+
+// discardLate reports the result of an attempt that succeeded after
+// its task completed or its phase ended to onLate. Its metrics and
+// counters are not added to the job's.
+func (ts *TaskScheduler) discardLate(taskNum int, worker string, metrics TaskMetrics) {
+	if ts.onLate != nil {
+		ts.onLate(LateResult{Job: ts.jobName, Phase: ts.phase, Task: taskNum, Worker: worker, Metrics: metrics})
+	}
+}
+
+// handleFailedTask attempts to requeue a failed task. Under
+// RetryAfterDelay the task is handed to the task processor once the
+// delay has passed.
 func (ts *TaskScheduler) handleFailedTask(
 	taskNum int,
 	failedTasks chan int,
 	done chan struct{},
 ) {
+	if ts.isCompleted(taskNum) {
+		return // Another attempt completed it meanwhile
+	}
+	if ts.retry == RetryAfterDelay && ts.retryDelay > 0 {
+		go func() {
+			select {
+			case <-time.After(ts.retryDelay):
+				failedTasks <- taskNum
+			case <-done:
+			}
+		}()
+		return
+	}
 	select {
 	case failedTasks <- taskNum:
 		// Task queued for retry
@@ -198,12 +1095,21 @@ func (ts *TaskScheduler) handleFailedTask(
 	}
 }
 
-// requeueFailedTask attempts to put a failed task back in the main queue
+// requeueFailedTask attempts to put a failed task back in the main
+// queue where the retry policy asks for
 func (ts *TaskScheduler) requeueFailedTask(
 	taskNum int,
 	taskChan chan int,
 	done chan struct{},
 ) {
+	switch {
+	case ts.retry != RetryAtEnd:
+		queueFirst(taskNum, taskChan)
+		return
+	case ts.priority != nil:
+		ts.queueByPriority(taskNum, taskChan)
+		return
+	}
 	select {
 	case taskChan <- taskNum:
 		// Task requeued
@@ -213,13 +1119,28 @@ func (ts *TaskScheduler) requeueFailedTask(
 }
 
 // executeTask makes an RPC call to execute a task on a worker
-func executeTask(ctx taskContext) bool {
+func executeTask(ctx taskContext) (DoTaskReply, bool) {
+	// Reduce tasks find their input in the workspace, and may outnumber
+	// the input files
+	var file string
+	if ctx.phase == mapParse {
+		file = ctx.mapFiles[ctx.taskNum]
+	}
 	taskArgs := &DoTaskArgs{
 		JobName:         ctx.jobName,
 		Phase:           ctx.phase,
 		TaskNumber:      ctx.taskNum,
-		File:            ctx.mapFiles[ctx.taskNum],
+		File:            file,
 		OtherTaskNumber: ctx.nOtherTasks,
+		Options:         ctx.options,
+		Token:           ctx.token,
+		Offset:          ctx.split.Offset,
+		Length:          ctx.split.Length,
+		Group:           ctx.split.Group,
+		Verify:          ctx.verify,
+		Uncached:        ctx.uncached,
 	}
-	return call(ctx.worker, DoTaskMethod, taskArgs, new(struct{}))
+	var reply DoTaskReply
+	ok := callTask(ctx.job, ctx.worker, taskArgs, &reply, ctx.timeouts, ctx.lost)
+	return reply, ok
 }
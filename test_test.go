@@ -3,11 +3,18 @@ package mapreduce
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -93,20 +100,31 @@ func makeInputs(num int) []string {
 }
 
 // init creates all necessary directories for the test environment.
-// It ensures a clean state by removing and recreating directories.
+// It ensures a clean state by removing the job workspaces and sockets
+// of earlier runs; the fixtures in the output and input directories
+// are kept so that a test run leaves the tree as it found it.
 func init() {
-	// Use paths from the Config variable
+	// Job workspaces are the directories of the output directory
+	entries, _ := os.ReadDir(Config["output"])
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(Config["output"], entry.Name())); err != nil {
+			log.Printf("Failed to remove directory %s: %v", entry.Name(), err)
+		}
+	}
+	if err := os.RemoveAll(Config["socket_base"]); err != nil {
+		log.Printf("Failed to remove directory %s: %v", Config["socket_base"], err)
+	}
+
+	// Ensure all necessary directories exist
 	dirs := []string{
 		Config["output"],
 		Config["input"],
 		Config["socket_base"],
 	}
-
-	// Ensure all necessary directories exist
 	for _, dir := range dirs {
-		if err := os.RemoveAll(dir); err != nil {
-			log.Printf("Failed to remove directory %s: %v", dir, err)
-		}
 		if err := os.MkdirAll(dir, 0777); err != nil {
 			log.Fatalf("Failed to create directory %s: %v", dir, err)
 		}
@@ -150,7 +168,7 @@ func TestBasic(t *testing.T) {
 	timeout := time.After(2 * time.Minute)
 	mr := setup()
 	defer func() {
-		mr.Shutdown(new(struct{}), new(struct{}))
+		mr.Shutdown(&ShutdownArgs{Token: mr.authToken}, new(struct{}))
 		os.RemoveAll("/tmp/824-socket")
 	}()
 
@@ -172,6 +190,50 @@ func TestBasic(t *testing.T) {
 	case <-timeout:
 		t.Fatal("Test timed out")
 	}
+
+	// Both workers report their share of the tasks at shutdown
+	result := mr.Result()
+	if len(result.Workers) != 2 || result.Tasks() < nMap+nReduce {
+		t.Errorf("got %d tasks on %d workers in the summary, want %d on 2",
+			result.Tasks(), len(result.Workers), nMap+nReduce)
+	}
+	for _, w := range result.Workers {
+		if !w.Responded || w.OutputBytes == 0 {
+			t.Errorf("worker %s: summary %+v, want a response with output", w.Worker, w)
+		}
+	}
+}
+
+// grpcAddress returns a gRPC address on a free local port
+func grpcAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return "grpc://" + l.Addr().String()
+}
+
+// TestGRPCTransport runs the job of TestBasic with the master and the
+// workers talking gRPC instead of net/rpc.
+func TestGRPCTransport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	mr := Distributed("test", makeInputs(nMap), nReduce, grpcAddress(t))
+	defer mr.Shutdown(&ShutdownArgs{Token: mr.authToken}, new(struct{}))
+
+	for i := 0; i < 2; i++ {
+		go RunWorker(mr.address, grpcAddress(t), MapFunc, ReduceFunc, -1)
+	}
+	if err := waitJob(t, mr); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+	if result := mr.Result(); len(result.Workers) != 2 {
+		t.Errorf("got summaries of %d workers, want 2", len(result.Workers))
+	}
 }
 
 // checkResults verifies the output of the MapReduce job.
@@ -183,8 +245,14 @@ func TestBasic(t *testing.T) {
 // 3. Verifies that each number from 0 to nNumber-1 appears exactly once
 // 4. Checks that no unexpected numbers are present
 func checkResults(t *testing.T) {
+	checkResultFile(t, "./assets/result/mrt.result.txt")
+}
+
+// checkResultFile verifies a result file like checkResults does, e.g.
+// that of a job given a result directory of its own.
+func checkResultFile(t *testing.T, resultFile string) {
+	t.Helper()
 	// Open and read the result file
-	resultFile := "./assets/result/mrt.result.txt"
 	file, err := os.Open(resultFile)
 	if err != nil {
 		t.Fatalf("Failed to open result file: %v", err)
@@ -249,3 +317,767 @@ func checkResults(t *testing.T) {
 		t.Errorf("Found more numbers than expected: got %d, want %d", len(seen), nNumber)
 	}
 }
+
+// kill stops a worker the way a crash would: it stops serving RPCs and
+// sending heartbeats without telling the master.
+func kill(wk *Worker) {
+	wk.doneOnce.Do(func() { close(wk.done) })
+}
+
+// crashMaster stops a master the way a crash would: it stops answering
+// and writing its checkpoint, and its jobs hand out no more tasks,
+// while its workers keep running.
+func crashMaster(mr *Master) {
+	mr.Lock()
+	mr.checkpointPath = ""
+	var handles []*JobHandle
+	for _, handle := range mr.handles {
+		handles = append(handles, handle)
+	}
+	mr.Unlock()
+	mr.listener.Close()
+	for _, handle := range handles {
+		handle.Cancel()
+	}
+}
+
+// waitJob waits for the master to finish its job and fails the test if
+// it does not within two minutes.
+func waitJob(t *testing.T, mr *Master) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- mr.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Test timed out")
+		return nil
+	}
+}
+
+// startMaster starts a master at the test socket that runs the jobs
+// submitted to it. It is stopped when the test ends.
+func startMaster(t *testing.T, opts ...Option) *Master {
+	t.Helper()
+	socketPath := "/tmp/824-socket/master.sock"
+	os.Remove(socketPath) // Clean up any existing socket file
+	mr, err := StartMaster(socketPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		mr.Stop(StopHard)
+		os.RemoveAll("/tmp/824-socket")
+	})
+	return mr
+}
+
+// waitHandle waits for a submitted job to finish and fails the test if
+// it does not within two minutes.
+func waitHandle(t *testing.T, job *JobHandle) error {
+	t.Helper()
+	select {
+	case <-job.Done():
+		return job.Wait()
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Test timed out")
+		return nil
+	}
+}
+
+// TestCombinerCacheLost kills a worker whose combiner cache holds the
+// output of a map task before the master flushes it; the task is rerun
+// on the other worker and no output is lost.
+func TestCombinerCacheLost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	mr := setup()
+	defer func() {
+		mr.Shutdown(&ShutdownArgs{Token: mr.authToken}, new(struct{}))
+		os.RemoveAll("/tmp/824-socket")
+	}()
+
+	self := make(chan *Worker, 1)
+	var once sync.Once
+	dying := func(file string, value string) []KeyValue {
+		once.Do(func() { go kill(<-self) })
+		return MapFunc(file, value)
+	}
+	wk, err := StartWorker(mr.address, workerFlag(0), dying, ReduceFunc, -1, WithCombinerCache(ReduceFunc, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	self <- wk
+	go RunWorker(mr.address, workerFlag(1), MapFunc, ReduceFunc, -1)
+
+	if err := waitJob(t, mr); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+}
+
+// TestTokenChecks calls the RPC handlers of a worker and a master with
+// a missing and a wrong token and checks that each call is rejected.
+func TestTokenChecks(t *testing.T) {
+	wk := &Worker{authToken: "secret", done: make(chan struct{})}
+	mr := newMaster("test")
+	mr.authToken = "secret"
+	for _, token := range []string{"", "wrong"} {
+		if err := wk.DoTask(&DoTaskArgs{Token: token}, new(DoTaskReply)); err == nil {
+			t.Errorf("a task with token %q was accepted", token)
+		}
+		if err := wk.Ping(&PingArgs{Token: token}, new(struct{})); err == nil {
+			t.Errorf("a ping with token %q was answered", token)
+		}
+		if err := wk.Prepare(&PrepareArgs{Token: token}, new(PrepareReply)); err == nil {
+			t.Errorf("a job preparation with token %q was accepted", token)
+		}
+		if err := wk.CancelTasks(&CancelTasksArgs{JobName: "job", Token: token}, new(struct{})); err == nil {
+			t.Errorf("a task cancellation with token %q was accepted", token)
+		}
+		if err := wk.FlushCache(&FlushCacheArgs{Token: token}, new(FlushCacheReply)); err == nil {
+			t.Errorf("a cache flush with token %q was accepted", token)
+		}
+		if err := wk.Shutdown(&ShutdownArgs{Token: token}, new(ShutdownReply)); err == nil {
+			t.Errorf("a worker shutdown with token %q was accepted", token)
+		}
+		if err := mr.Register(&RegisterArgs{Worker: "w", Token: token}, new(RegisterReply)); err == nil {
+			t.Errorf("a registration with token %q was accepted", token)
+		}
+		if err := mr.Heartbeat(&HeartbeatArgs{Worker: "w", Token: token}, new(HeartbeatReply)); err == nil {
+			t.Errorf("a heartbeat with token %q was accepted", token)
+		}
+		if err := mr.Unregister(&UnregisterArgs{Worker: "w", Token: token}, new(struct{})); err == nil {
+			t.Errorf("a departure with token %q was accepted", token)
+		}
+		if err := mr.ReportCrash(&CrashReport{Worker: "w", Token: token}, new(struct{})); err == nil {
+			t.Errorf("a crash report with token %q was accepted", token)
+		}
+		if err := mr.AcquireTokens(&AcquireArgs{Worker: "w", Token: token, Limit: "db", N: 1}, new(AcquireReply)); err == nil {
+			t.Errorf("a rate limit request with token %q was granted", token)
+		}
+		if err := mr.FetchFile(&FetchFileArgs{Worker: "w", Token: token, File: "in"}, new(FetchFileReply)); err == nil {
+			t.Errorf("a file download with token %q was served", token)
+		}
+		if err := mr.Shutdown(&ShutdownArgs{Token: token}, new(struct{})); err == nil {
+			t.Errorf("a master shutdown with token %q was accepted", token)
+		}
+	}
+	if err := wk.FlushCache(&FlushCacheArgs{Token: "secret"}, new(FlushCacheReply)); err != nil {
+		t.Errorf("a cache flush with the token was refused: %v", err)
+	}
+}
+
+// TestConcurrentJobs runs two jobs of the same name at once on one
+// master. Each job gets a workspace of its own and a correct result.
+func TestConcurrentJobs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	mr := startMaster(t, WithConcurrentJobs(2))
+
+	var jobs []*JobHandle
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce, Options: []Option{WithResultDir(dir)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+		dirs = append(dirs, dir)
+	}
+
+	// Both jobs start before there is a worker to run them
+	deadline := time.Now().Add(10 * time.Second)
+	for len(mr.CurrentStatus().Jobs) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("running jobs %v, want both", mr.CurrentStatus().Jobs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		go RunWorker(mr.address, workerFlag(i), MapFunc, ReduceFunc, -1)
+	}
+	for i, job := range jobs {
+		if err := waitHandle(t, job); err != nil {
+			t.Fatalf("job %d failed: %v", i, err)
+		}
+		checkResultFile(t, filepath.Join(dirs[i], "mrt.result.txt"))
+	}
+	if jobs[0].workspace.Root == jobs[1].workspace.Root {
+		t.Errorf("both jobs ran in workspace %s", jobs[0].workspace.Root)
+	}
+}
+
+// TestJournalResume stops a master in the middle of the map phase of a
+// journaled job and submits the job again to a new master with the same
+// workspace. The map tasks the journal lists are not run again.
+func TestJournalResume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	config := JobConfig{Name: "test", Files: files, NReduce: nReduce, Options: []Option{WithJournal()}}
+
+	// The first run is stopped once two of its map tasks are journaled;
+	// the others stall until then
+	mr := startMaster(t)
+	job, err := mr.Submit(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	ran := 0
+	release := make(chan struct{})
+	stalling := func(file string, value string) []KeyValue {
+		mu.Lock()
+		ran++
+		n := ran
+		mu.Unlock()
+		if n > 2 {
+			<-release
+		}
+		return MapFunc(file, value)
+	}
+	go RunWorker(mr.address, workerFlag(0), stalling, ReduceFunc, -1)
+	journal := DefaultWorkspace(JobParse(job.ID())).Journal()
+	deadline := time.Now().Add(time.Minute)
+	for {
+		state, err := readJournal(journal)
+		if err == nil && len(state.completed[mapParse]) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Test timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mr.Stop(StopHard)
+	close(release)
+	if err := job.Wait(); err == nil {
+		t.Fatal("first run succeeded, want it stopped")
+	}
+	state, err := readJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journaled := make(map[string]bool)
+	for task := range state.completed[mapParse] {
+		journaled[state.job.Splits[task].File] = true
+	}
+	if len(journaled) < 2 || len(journaled) == nMap {
+		t.Fatalf("journal lists %d map tasks, want some of %d", len(journaled), nMap)
+	}
+
+	// The second run runs the other map tasks only
+	mr = startMaster(t)
+	job, err = mr.Submit(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rerun []string
+	recording := func(file string, value string) []KeyValue {
+		mu.Lock()
+		if journaled[file] {
+			rerun = append(rerun, file)
+		}
+		mu.Unlock()
+		return MapFunc(file, value)
+	}
+	go RunWorker(mr.address, workerFlag(1), recording, ReduceFunc, -1)
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	checkResults(t)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rerun) > 0 {
+		t.Errorf("journaled map tasks of %v ran again", rerun)
+	}
+}
+
+// TestStandbyTakeover crashes the primary master during the reduce
+// phase of a job. The standby takes over on the primary's socket and
+// finishes the job with the primary's workers.
+func TestStandbyTakeover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	heartbeat := WithHeartbeat(50*time.Millisecond, 3)
+	primary := startMaster(t, WithCheckpoint(checkpoint), heartbeat)
+	job, err := primary.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := StartStandby(primary.address, primary.address, checkpoint, heartbeat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer standby.Stop()
+
+	// Reduce tasks stall until the primary is gone
+	var once sync.Once
+	reducing := make(chan struct{})
+	release := make(chan struct{})
+	stalling := func(key string, values []string) string {
+		once.Do(func() { close(reducing) })
+		<-release
+		return ReduceFunc(key, values)
+	}
+	for i := 0; i < 2; i++ {
+		go RunWorker(primary.address, workerFlag(i), MapFunc, stalling, -1)
+	}
+	select {
+	case <-reducing:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Test timed out")
+	}
+	crashMaster(primary)
+	close(release)
+	if err := waitHandle(t, job); err == nil {
+		t.Fatal("job succeeded on the crashed primary")
+	}
+
+	mr, err := standby.Wait()
+	if err != nil {
+		t.Fatalf("standby did not take over: %v", err)
+	}
+	t.Cleanup(func() { mr.Stop(StopHard) })
+	mr.Lock()
+	resubmitted := mr.handles[job.ID()]
+	mr.Unlock()
+	if resubmitted == nil {
+		t.Fatalf("standby did not submit job %s again", job.ID())
+	}
+	if err := waitHandle(t, resubmitted); err != nil {
+		t.Fatalf("job failed on the standby: %v", err)
+	}
+	checkResults(t)
+}
+
+// TestLostMapOutput kills a worker once the reduce phase has started
+// and deletes the intermediate files of the map tasks it ran, as if
+// they were on its local disk. Reduce tasks report them missing, the
+// map tasks run again on the other worker and the result is complete.
+func TestLostMapOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	mr := startMaster(t)
+	job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The other worker maps once the doomed one has run a map task,
+	// and reduce tasks stall until it is gone
+	var mu sync.Mutex
+	var mapped []int
+	var once, reduceOnce sync.Once
+	started := make(chan struct{})
+	doomed := func(file string, value string) []KeyValue {
+		mu.Lock()
+		for task, name := range files {
+			if name == file {
+				mapped = append(mapped, task)
+			}
+		}
+		mu.Unlock()
+		once.Do(func() { close(started) })
+		return MapFunc(file, value)
+	}
+	waiting := func(file string, value string) []KeyValue {
+		<-started
+		return MapFunc(file, value)
+	}
+	reducing := make(chan struct{})
+	release := make(chan struct{})
+	stalling := func(key string, values []string) string {
+		reduceOnce.Do(func() { close(reducing) })
+		<-release
+		return ReduceFunc(key, values)
+	}
+	wk, err := StartWorker(mr.address, workerFlag(0), doomed, stalling, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go RunWorker(mr.address, workerFlag(1), waiting, stalling, -1)
+	select {
+	case <-reducing:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Test timed out")
+	}
+
+	kill(wk)
+	mr.Lock()
+	ws := job.workspace
+	mr.Unlock()
+	mu.Lock()
+	for _, task := range mapped {
+		for r := 0; r < nReduce; r++ {
+			if err := os.Remove(ws.Intermediate(task, r)); err != nil {
+				t.Errorf("remove output of map task %d: %v", task, err)
+			}
+		}
+	}
+	mu.Unlock()
+	close(release)
+
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+}
+
+// TestHeartbeatLoss kills a worker in the middle of a map task. The
+// master notices the missing heartbeats, drops the worker and runs its
+// task on the other worker.
+func TestHeartbeatLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	mr := startMaster(t, WithHeartbeat(50*time.Millisecond, 3))
+	job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The doomed worker dies in its first task, which never returns
+	self := make(chan *Worker, 1)
+	var once sync.Once
+	hang := make(chan struct{})
+	defer close(hang)
+	dying := func(file string, value string) []KeyValue {
+		once.Do(func() { kill(<-self) })
+		<-hang
+		return MapFunc(file, value)
+	}
+	wk, err := StartWorker(mr.address, workerFlag(0), dying, ReduceFunc, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self <- wk
+	go RunWorker(mr.address, workerFlag(1), MapFunc, ReduceFunc, -1)
+
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+	for _, w := range mr.Workers() {
+		if w.Address == workerFlag(0) {
+			t.Errorf("worker %s is still registered, want it dropped", w.Address)
+		}
+	}
+}
+
+// TestRetryOtherWorker makes the first attempt of a map task outlast
+// its RPC timeout. The task is retried on the other worker rather than
+// on the one it just failed on.
+func TestRetryOtherWorker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	mr := startMaster(t, WithRPCTimeouts(RPCTimeouts{DoTask: 200 * time.Millisecond}))
+
+	var mu sync.Mutex
+	var attempts []string
+	slowOnce := func(worker string) func(string, string) []KeyValue {
+		return func(file string, value string) []KeyValue {
+			if file == files[0] {
+				mu.Lock()
+				attempts = append(attempts, worker)
+				first := len(attempts) == 1
+				mu.Unlock()
+				if first {
+					time.Sleep(time.Second)
+				}
+			}
+			return MapFunc(file, value)
+		}
+	}
+	// Both workers are registered before the job starts, so the retry
+	// has a choice
+	for i := 0; i < 2; i++ {
+		if _, err := StartWorker(mr.address, workerFlag(i), slowOnce(workerFlag(i)), ReduceFunc, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) < 2 || attempts[1] == attempts[0] {
+		t.Errorf("map task 0 ran on %v, want the retry on another worker", attempts)
+	}
+}
+
+// TestCancelJob cancels the context of a job while a map task waits on
+// the context of its task. The task is aborted, the job ends cancelled
+// and no result is merged.
+func TestCancelJob(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	socketPath := "/tmp/824-socket/master.sock"
+	os.Remove(socketPath)
+	result := filepath.Join(t.TempDir(), "result")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr := DistributedContext(ctx, "test", files, nReduce, socketPath, WithResultDir(result))
+	defer os.RemoveAll("/tmp/824-socket")
+
+	var started, stopped sync.Once
+	running := make(chan struct{})
+	aborted := make(chan struct{})
+	waiting := func(ctx *TaskContext, file string, value string) []KeyValue {
+		started.Do(func() { close(running) })
+		<-ctx.Context().Done()
+		stopped.Do(func() { close(aborted) })
+		return nil
+	}
+	go RunWorker(mr.address, workerFlag(0), MapFunc, ReduceFunc, -1, WithContextFuncs(waiting, nil))
+	select {
+	case <-running:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("Test timed out")
+	}
+
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(10 * time.Second):
+		t.Fatal("running map task was not aborted")
+	}
+	if err := waitJob(t, mr); !errors.Is(err, ErrJobCancelled) {
+		t.Fatalf("job ended with %v, want %v", err, ErrJobCancelled)
+	}
+	if _, err := os.Stat(filepath.Join(result, "mrt.result.txt")); !os.IsNotExist(err) {
+		t.Errorf("cancelled job merged its result: %v", err)
+	}
+}
+
+// TestWatchdogRequeue hangs a map task forever. Once no task completed
+// for the stall interval, the watchdog hands the task to the other
+// worker and the job completes; a job whose watchdog fails stalled
+// phases ends with ErrPhaseStalled instead.
+func TestWatchdogRequeue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	hang := make(chan struct{})
+	defer close(hang)
+	var hangNext atomic.Bool
+	hanging := func(file string, value string) []KeyValue {
+		if hangNext.CompareAndSwap(true, false) {
+			<-hang
+		}
+		return MapFunc(file, value)
+	}
+
+	mr := startMaster(t)
+	if _, err := StartWorker(mr.address, workerFlag(0), hanging, ReduceFunc, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartWorker(mr.address, workerFlag(1), MapFunc, ReduceFunc, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	stall := 300 * time.Millisecond
+	hangNext.Store(true)
+	job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce,
+		Options: []Option{WithWatchdog(stall, WatchdogRequeue)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+
+	hangNext.Store(true)
+	job, err = mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce,
+		Options: []Option{WithWatchdog(stall, WatchdogFail)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitHandle(t, job); !errors.Is(err, ErrPhaseStalled) {
+		t.Fatalf("job ended with %v, want %v", err, ErrPhaseStalled)
+	}
+}
+
+// TestRateLimit has every map task draw a token of a rate limit before
+// it runs. The two workers together get no more tokens than the limit
+// hands out, and a limit the job does not have is refused.
+func TestRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	files := makeInputs(nMap)
+	mr := startMaster(t)
+	const perSecond = 20
+
+	var mu sync.Mutex
+	var granted []time.Time
+	var unknown error
+	limited := func(ctx *TaskContext, file string, value string) []KeyValue {
+		if err := ctx.Acquire("api", 1); err != nil {
+			t.Errorf("acquire: %v", err)
+		}
+		err := ctx.Acquire("other", 1)
+		mu.Lock()
+		granted = append(granted, time.Now())
+		unknown = err
+		mu.Unlock()
+		return MapFunc(file, value)
+	}
+	for i := 0; i < 2; i++ {
+		go RunWorker(mr.address, workerFlag(i), MapFunc, ReduceFunc, -1, WithContextFuncs(limited, nil))
+	}
+	job, err := mr.Submit(JobConfig{Name: "test", Files: files, NReduce: nReduce,
+		Options: []Option{WithRateLimit("api", perSecond, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitHandle(t, job); err != nil {
+		t.Fatalf("job failed: %v", err)
+	}
+	checkResults(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(granted, func(i, j int) bool { return granted[i].Before(granted[j]) })
+	if len(granted) < nMap {
+		t.Fatalf("%d map tasks drew tokens, want %d", len(granted), nMap)
+	}
+	// The burst is one token, the others come one every 1/perSecond
+	want := time.Duration(nMap-1) * time.Second / perSecond
+	if spread := granted[len(granted)-1].Sub(granted[0]); spread < want*9/10 {
+		t.Errorf("%d tokens were granted within %v, want at least %v", len(granted), spread, want)
+	}
+	if unknown == nil {
+		t.Error("a rate limit the job does not have granted tokens")
+	}
+}
+
+// TestSortByValue counts numbers that occur between one and five times,
+// sorts the counts with SortByValue and checks that the merged result
+// lists every number, by descending count and ascending number.
+func TestSortByValue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	const nNumbers = 100
+	in := t.TempDir()
+	var files []string
+	for f := 0; f < 3; f++ {
+		var b strings.Builder
+		for i := f; i < nNumbers; i += 3 {
+			for j := 0; j <= i%5; j++ {
+				fmt.Fprintf(&b, "%03d\n", i)
+			}
+		}
+		file := filepath.Join(in, fmt.Sprintf("input-%d.txt", f))
+		if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	mr := startMaster(t)
+	for i := 0; i < 2; i++ {
+		go RunWorker(mr.address, workerFlag(i), MapFunc, ReduceFunc, -1)
+	}
+	count, err := mr.Submit(JobConfig{Name: "count", Files: files, NReduce: nReduce,
+		Options: []Option{WithResultDir(t.TempDir())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitHandle(t, count); err != nil {
+		t.Fatalf("count failed: %v", err)
+	}
+	result, err := count.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sorted, err := mr.SortByValue("sorted", result, 3, WithResultDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitHandle(t, sorted); err != nil {
+		t.Fatalf("sort failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "mrt.result.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != nNumbers {
+		t.Fatalf("sorted result has %d lines, want %d", len(lines), nNumbers)
+	}
+	prevKey, prevCount := "", 6
+	for _, line := range lines {
+		// Lines read "number: [count]"
+		key, value, ok := strings.Cut(line, ": ")
+		n, err := strconv.Atoi(strings.Trim(value, "[]"))
+		if !ok || err != nil {
+			t.Fatalf("invalid line %q", line)
+		}
+		num, _ := strconv.Atoi(key)
+		if n != num%5+1 {
+			t.Errorf("%s was counted %d times, want %d", key, n, num%5+1)
+		}
+		if n > prevCount || n == prevCount && key < prevKey {
+			t.Errorf("%q follows %s: %d", line, prevKey, prevCount)
+		}
+		prevKey, prevCount = key, n
+	}
+}
+
+// TestRemoteOptions checks that the workspaces of jobs submitted over
+// the control plane stay under the master's output root.
+func TestRemoteOptions(t *testing.T) {
+	root := Config["output"]
+	for _, tc := range []struct {
+		dir  string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"team/wordcount", filepath.Join(root, "team/wordcount"), true},
+		{"team/../wordcount", filepath.Join(root, "wordcount"), true},
+		{"/etc", "", false},
+		{"..", "", false},
+		{"team/../../etc", "", false},
+		{"s3://bucket/ws", "", false},
+	} {
+		opts, err := remoteOptions(JobOptions{Workspace: tc.dir, Replica: tc.dir, DroppedCaches: []string{"c"}})
+		if (err == nil) != tc.ok {
+			t.Errorf("workspace %q: error %v, want ok %v", tc.dir, err, tc.ok)
+			continue
+		}
+		if tc.ok && (opts.Workspace != tc.want || opts.Replica != tc.want || opts.DroppedCaches != nil) {
+			t.Errorf("workspace %q: got %q, replica %q, dropped caches %v; want %q",
+				tc.dir, opts.Workspace, opts.Replica, opts.DroppedCaches, tc.want)
+		}
+	}
+}
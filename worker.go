@@ -3,46 +3,221 @@ package mapreduce
 
 import (
 	"fmt"
-	"log"
 	"net"
-	"net/rpc"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Worker represents a worker node in the MapReduce framework.
 // It executes Map and Reduce tasks assigned by the master.
 type Worker struct {
-	sync.Mutex                                 // Protects concurrent access to worker state
-	name       string                          // Unique identifier for this worker
-	MapF       func(string, string) []KeyValue // User-defined Map function
-	ReduceF    func(string, []string) string   // User-defined Reduce function
-	nTasks     int                             // Number of tasks completed by this worker
-	listener   net.Listener                    // RPC listener for receiving task assignments
-	nRPC       int                             // Number of RPCs remaining before shutdown
+	sync.Mutex                                     // Protects concurrent access to worker state
+	name           string                          // Unique identifier for this worker
+	MapF           func(string, string) []KeyValue // User-defined Map function
+	ReduceF        func(string, []string) string   // User-defined Reduce function
+	nTasks         int                             // Number of tasks completed by this worker
+	stats          taskStats                       // Tasks by outcome, reported at shutdown
+	started        time.Time                       // When the worker started
+	listener       net.Listener                    // RPC listener for receiving task assignments
+	nRPC           int                             // Number of RPCs remaining before shutdown
+	mapCtxF        ContextMapFunc                  // Replaces MapF when set
+	reduceCtxF     ContextReduceFunc               // Replaces ReduceF when set
+	streamMapF     StreamMapFunc                   // Replaces MapF and mapCtxF when set
+	streamReduceF  StreamReduceFunc                // Replaces ReduceF and reduceCtxF when set
+	combineF       func(string, []string) string   // Optional per-task combiner of map output
+	cache          *combinerCache                  // Optional cross-task combiner cache
+	labels         map[string]string               // Labels reported at registration
+	slots          chan struct{}                   // Limits the number of concurrent tasks
+	authToken      string                          // Credential presented to the master
+	localPaths     []string                        // Directories on storage local to the worker
+	disk           *diskScheduler                  // Optional fair scheduler for file I/O
+	tuning         runtimeTuning                   // Runtime settings requested by running jobs
+	timeouts       RPCTimeouts                     // Timeouts of the RPCs the worker makes
+	crash          *crashReporter                  // Optional crash reporting
+	master         string                          // Address of the master the worker registered with
+	binaryVersion  string                          // Version of the map and reduce code, see WithBinaryVersion
+	prepareF       func(PrepareArgs) error         // Optional hook run when a job is prepared
+	minFreeSpace   uint64                          // Free bytes below which spilling tasks are refused
+	shipCacheLimit int64                           // Bytes of shipped side inputs kept across jobs, zero for no limit
+	standby        string                          // Master to switch to once the master is gone, see WithStandbyMaster
+	logger         nodeLog                         // Destination of log messages, see WithWorkerLogger
+
+	registration RegisterReply             // Acknowledgement received from the master
+	leaving      bool                      // Set by Leave, rejects new tasks
+	running      sync.WaitGroup            // Tasks in flight, drained by Leave
+	tasks        map[*runningTask]struct{} // Tasks in flight, aborted by CancelTasks
+	done         chan struct{}             // Closed once the master shuts the worker down
+	doneOnce     sync.Once
+	shipping     sync.Mutex // Serializes downloads of shipped side inputs
 }
 
 // DoTask executes a single Map or Reduce task.
 // It updates the task counter and processes the task according to its phase.
-func (wk *Worker) DoTask(args *DoTaskArgs, _ *struct{}) error {
+func (wk *Worker) DoTask(args *DoTaskArgs, reply *DoTaskReply) (err error) {
+	if !validToken(wk.authToken, args.Token) {
+		wk.logger.warnf("rejected %s task %d with an invalid token", args.Phase, args.TaskNumber)
+		return fmt.Errorf("invalid task token")
+	}
+
+	if wk.slots != nil {
+		wk.slots <- struct{}{}
+		defer func() { <-wk.slots }()
+	}
+
+	if reason := wk.refuseTask(args); reason != "" {
+		wk.logger.infof("refused %s task %d: %s", args.Phase, args.TaskNumber, reason)
+		reply.Refused = reason
+		return nil
+	}
+	if lost := lostMapOutputs(args); len(lost) > 0 {
+		wk.logger.warnf("%s task %d is missing the output of map tasks %v", args.Phase, args.TaskNumber, lost)
+		reply.LostMapTasks = lost
+		return nil
+	}
+
 	wk.Lock()
-	wk.nTasks++
+	if wk.leaving {
+		wk.Unlock()
+		return fmt.Errorf("worker %s is leaving", wk.name)
+	}
+	if !args.Verify {
+		// Verification runs repeat a task, they are not work of their own
+		wk.nTasks++
+	}
+	wk.running.Add(1)
 	wk.Unlock()
+	defer wk.running.Done()
+	defer wk.tuning.apply(args.Options)()
+	defer wk.trackTask(args)()
+	defer wk.recoverTask()
 
+	run, release := wk.startTask(args)
+	defer release()
+	run, span := startSpan(withTraceParent(run, args.TraceParent), SpanServer, "execute "+string(args.Phase)+" task",
+		"job", string(args.JobName), "task", strconv.Itoa(args.TaskNumber), "worker", wk.name)
+	defer func() { span.end(err) }()
+	disk := wk.disk.forTask(fmt.Sprintf("%s-%s-%d", args.JobName, args.Phase, args.TaskNumber))
+	ctx := newTaskContext(args.JobName, args.Phase, args.TaskNumber, args.Options)
+	ctx.Group = args.Group
+	ctx.acquire = func(limit string, n int) (time.Duration, error) {
+		return wk.acquireTokens(args.JobName, args.Options.workspace(args.JobName).Root, limit, n)
+	}
+	ctx.ctx = run
+	opts := args.Options
+	outFile := opts.workspace(args.JobName).ReduceOutput(args.TaskNumber)
+	defer func() { wk.recordTask(args, outFile, err) }()
+	if args.Verify {
+		// A verification run only reports the digest of its output,
+		// which goes to a scratch directory instead of the workspace
+		scratch, err := os.MkdirTemp("", "mrverify-")
+		if err != nil {
+			return fmt.Errorf("verify %s task %d: %v", args.Phase, args.TaskNumber, err)
+		}
+		defer os.RemoveAll(scratch)
+		outFile = filepath.Join(scratch, "output")
+		if args.Phase == mapParse {
+			opts.Workspace = scratch
+		}
+	}
+	builtin, err := opts.builtin()
+	if err != nil {
+		return err
+	}
+	usage := startUsage()
 	switch args.Phase {
 	case mapParse:
-		doMap(args.JobName, args.TaskNumber, args.File, args.OtherTaskNumber, wk.MapF)
+		if builtin != nil {
+			reply.Metrics, err = doMap(run, args.JobName, args.TaskNumber, args.split(), args.OtherTaskNumber, bindMap(builtin.mapF, nil, ctx), nil, opts, disk)
+			break
+		}
+		if wk.streamMapF != nil {
+			reply.Metrics, err = doStreamMap(
+				run,
+				args.JobName,
+				args.TaskNumber,
+				args.split(),
+				args.OtherTaskNumber,
+				wk.streamMapF,
+				opts,
+				disk,
+			)
+			break
+		}
+		mapF := bindMap(wk.mapCtxF, wk.MapF, ctx)
+		if wk.cache != nil && !args.Verify && !args.Uncached {
+			reply.Metrics, err = wk.cache.doMap(run, args, mapF, disk)
+			reply.Cache = wk.cache.id
+			break
+		}
+		reply.Metrics, err = doMap(
+			run,
+			args.JobName,
+			args.TaskNumber,
+			args.split(),
+			args.OtherTaskNumber,
+			mapF,
+			wk.combineF,
+			opts,
+			disk,
+		)
 	case reduceParse:
-		doReduce(
+		if builtin != nil {
+			reply.Metrics, err = doReduce(run, args.JobName, args.TaskNumber, outFile, args.OtherTaskNumber, bindReduce(builtin.reduceF, nil, ctx), opts, disk)
+			break
+		}
+		if wk.streamReduceF != nil {
+			reply.Metrics, err = doStreamReduce(
+				run,
+				args.JobName,
+				args.TaskNumber,
+				outFile,
+				args.OtherTaskNumber,
+				wk.streamReduceF,
+				opts,
+				disk,
+			)
+			break
+		}
+		reply.Metrics, err = doReduce(
+			run,
 			args.JobName,
 			args.TaskNumber,
-			mergeName(args.JobName, args.TaskNumber),
+			outFile,
 			args.OtherTaskNumber,
-			wk.ReduceF,
+			bindReduce(wk.reduceCtxF, wk.ReduceF, ctx),
+			opts,
+			disk,
 		)
 	}
+	if err != nil {
+		if run.Err() != nil {
+			wk.logger.infof("%s task %d cancelled", args.Phase, args.TaskNumber)
+			return fmt.Errorf("%s task %d: %w", args.Phase, args.TaskNumber, ErrJobCancelled)
+		}
+		if lost := failedFetch(args, err); lost >= 0 {
+			wk.logger.warnf("%s task %d could not read the output of map task %d: %v", args.Phase, args.TaskNumber, lost, err)
+			reply.LostMapTasks = []int{lost}
+			return nil
+		}
+		wk.logger.warnf("%s task %d failed: %v", args.Phase, args.TaskNumber, err)
+		return fmt.Errorf("%s task %d: %v", args.Phase, args.TaskNumber, err)
+	}
+	usage.stop(&reply.Metrics)
+	reply.Metrics.Counters = ctx.counterValues()
 
-	fmt.Printf("%s:%v task #%d done\n", wk.name, args.Phase, args.TaskNumber)
+	if args.Verify {
+		wk.logger.debugf("%v task #%d verified (digest %x)", args.Phase, args.TaskNumber, reply.Metrics.OutputDigest)
+		return nil
+	}
+	if args.Phase == mapParse {
+		wk.logger.debugf("%v task #%d done (emit queue peak %d, compression %s, %v)",
+			args.Phase, args.TaskNumber, reply.Metrics.EmitQueuePeak, reply.Metrics.Compression, reply.Metrics.WallTime)
+		return nil
+	}
+	wk.logger.debugf("%v task #%d done (%v)", args.Phase, args.TaskNumber, reply.Metrics.WallTime)
 	return nil
 }
 
@@ -55,67 +230,244 @@ func (wk *Worker) DoTask(args *DoTaskArgs, _ *struct{}) error {
 //   - mapF: User-defined Map function
 //   - reduceF: User-defined Reduce function
 //   - nRPC: Maximum number of RPCs to handle before shutdown
+//   - opts: Optional worker configuration such as a combiner cache
 func RunWorker(
 	masterAddress string,
 	me string,
 	mapF func(string, string) []KeyValue,
 	reduceF func(string, []string) string,
 	nRPC int,
+	opts ...WorkerOption,
 ) error {
+	_, err := StartWorker(masterAddress, me, mapF, reduceF, nRPC, opts...)
+	return err
+}
+
+// StartWorker behaves like RunWorker but returns the running worker,
+// so callers can inspect the master's registration acknowledgement
+// and wait for the worker to be shut down.
+func StartWorker(
+	masterAddress string,
+	me string,
+	mapF func(string, string) []KeyValue,
+	reduceF func(string, []string) string,
+	nRPC int,
+	opts ...WorkerOption,
+) (*Worker, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
 	wk := &Worker{
 		name:    me,
 		MapF:    mapF,
 		ReduceF: reduceF,
 		nRPC:    nRPC,
+		master:  masterAddress,
+		started: time.Now(),
+		done:    make(chan struct{}),
+		logger:  nodeLog{attrs: []any{"worker", me}},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(wk)
+		}
+	}
+	if wk.cache != nil {
+		wk.cache.disk = wk.disk
+	}
+	if wk.crash != nil {
+		wk.enableCrashReports(masterAddress)
 	}
 
-	rpcs := rpc.NewServer()
-	rpcs.Register(wk)
-	os.Remove(me)
-	l, err := net.Listen("unix", me)
+	l, err := listen(me)
 	if err != nil {
-		return fmt.Errorf("RunWorker: worker %s error: %v", me, err)
+		return nil, fmt.Errorf("RunWorker: worker %s error: %v", me, err)
 	}
 	wk.listener = l
 
 	// Register with master before serving
 	if err := wk.register(masterAddress); err != nil {
 		l.Close()
-		return err
+		wk.disableCrashReports()
+		return nil, err
 	}
 
 	// Serve RPC requests
+	if err := transportFor(me).Serve(l, wk); err != nil {
+		l.Close()
+		wk.disableCrashReports()
+		return nil, fmt.Errorf("RunWorker: worker %s error: %v", me, err)
+	}
+
+	if interval := wk.Registration().HeartbeatInterval; interval > 0 {
+		go wk.sendHeartbeats(masterAddress, interval)
+	}
+
+	// Stop accepting connections once the master has shut us down
 	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				break
-			}
-			go rpcs.ServeConn(conn)
-		}
+		<-wk.done
+		wk.disableCrashReports()
+		l.Close()
 	}()
 
-	return nil
+	return wk, nil
 }
 
-// register notifies the master of this worker's existence
+// register notifies the master of this worker's existence and checks
+// the acknowledgement against the worker's own configuration
 func (wk *Worker) register(master string) error {
-	args := &RegisterArgs{Worker: wk.name}
-	ok := call(master, RegisterMethod, args, new(struct{}))
+	host, _ := os.Hostname()
+	args := &RegisterArgs{
+		Worker:     wk.name,
+		Labels:     wk.labels,
+		Token:      wk.authToken,
+		Host:       host,
+		LocalPaths: wk.localPaths,
+		Slots:      cap(wk.slots),
+	}
+	var reply RegisterReply
+	ok := callTimeout(master, RegisterMethod, args, &reply, wk.timeouts.forMethod(RegisterMethod))
 	if !ok {
-		log.Printf("Register: RPC %s master error\n", master)
+		wk.logger.warnf("Register: RPC %s master error", master)
 		return fmt.Errorf("Register: RPC %s master error", master)
 	}
+	wk.Lock()
+	wk.registration = reply
+	wk.Unlock()
+
+	if reply.Workspace != "" {
+		var err error
+		if isRemotePath(reply.Workspace) {
+			// Object stores have no directories to look for
+			_, err = fileSystemFor(reply.Workspace)
+		} else {
+			_, err = os.Stat(reply.Workspace)
+		}
+		if err != nil {
+			wk.logger.warnf("Register: job workspace %s is not visible from this worker: %v",
+				reply.Workspace, err)
+		}
+	}
+	if !reply.JobActive {
+		wk.logger.infof("Register: no active job on %s, master suggests polling in %v",
+			master, reply.PollAfter)
+	}
+	return nil
+}
+
+// Registration returns the acknowledgement the master sent when the
+// worker registered.
+func (wk *Worker) Registration() RegisterReply {
+	wk.Lock()
+	defer wk.Unlock()
+	return wk.registration
+}
+
+// sendHeartbeats tells the master every interval that the worker is
+// alive, until the worker is shut down. When the master has dropped
+// the worker, e.g. after a network partition, it registers again.
+func (wk *Worker) sendHeartbeats(master string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	args := &HeartbeatArgs{Worker: wk.name, Token: wk.authToken}
+	failed := 0
+	for {
+		select {
+		case <-wk.done:
+			return
+		case <-ticker.C:
+		}
+
+		var reply HeartbeatReply
+		if !callTimeout(master, HeartbeatMethod, args, &reply, wk.timeouts.forMethod(HeartbeatMethod)) {
+			wk.logger.warnf("heartbeat to %s failed", master)
+			if failed++; failed >= defaultHeartbeatMisses && wk.standby != "" && master != wk.standby {
+				wk.logger.warnf("master %s is gone, switching to standby %s", master, wk.standby)
+				master = wk.standby
+				wk.Lock()
+				wk.master = master
+				wk.Unlock()
+				if wk.register(master) == nil {
+					failed = 0
+				}
+			}
+			continue
+		}
+		failed = 0
+		if !reply.Registered {
+			wk.Lock()
+			leaving := wk.leaving
+			wk.Unlock()
+			if leaving {
+				continue
+			}
+			wk.logger.warnf("dropped by master %s, registering again", master)
+			wk.register(master)
+		}
+	}
+}
+
+// Wait blocks until the master has shut the worker down
+func (wk *Worker) Wait() {
+	<-wk.done
+}
+
+// Leave takes the worker out of the cluster without waiting for the
+// master to shut it down: the master assigns it no new tasks, the tasks
+// it is running finish, and then the worker stops like after Shutdown.
+func (wk *Worker) Leave() error {
+	wk.Lock()
+	wk.leaving = true
+	wk.Unlock()
+
+	master := wk.masterAddress()
+	args := &UnregisterArgs{Worker: wk.name, Token: wk.authToken}
+	if !callTimeout(master, UnregisterMethod, args, new(struct{}), wk.timeouts.forMethod(UnregisterMethod)) {
+		wk.Lock()
+		wk.leaving = false
+		wk.Unlock()
+		return fmt.Errorf("Leave: RPC %s master error", master)
+	}
+	wk.logger.infof("left master %s, draining running tasks", master)
+	wk.running.Wait()
+
+	wk.doneOnce.Do(func() { close(wk.done) })
 	return nil
 }
 
 // Shutdown handles the worker shutdown request from master.
-// It returns the total number of tasks completed by this worker.
-func (wk *Worker) Shutdown(_ *struct{}, res *ShutdownReply) error {
-	fmt.Printf("Shutdown: worker %s stopping\n", wk.name)
+// It returns the total number of tasks completed by this worker and
+// the summary of its work.
+func (wk *Worker) Shutdown(args *ShutdownArgs, res *ShutdownReply) error {
+	if !validToken(wk.authToken, args.Token) {
+		return fmt.Errorf("invalid task token")
+	}
+	wk.logger.infof("Shutdown: stopping")
 	wk.Lock()
 	defer wk.Unlock()
 	res.Ntasks = wk.nTasks
+	res.Summary = wk.summary()
 	wk.nRPC = 1
+	wk.doneOnce.Do(func() { close(wk.done) })
 	return nil
 }
+
+// acquireTokens reserves tokens of a rate limit the master keeps for
+// job, whose workspace is rooted at workspace
+func (wk *Worker) acquireTokens(job JobParse, workspace, limit string, n int) (time.Duration, error) {
+	args := &AcquireArgs{Worker: wk.name, Token: wk.authToken, Limit: limit, N: n, Job: job, Workspace: workspace}
+	var reply AcquireReply
+	master := wk.masterAddress()
+	if !callTimeout(master, AcquireTokensMethod, args, &reply, wk.timeouts.forMethod(AcquireTokensMethod)) {
+		return 0, fmt.Errorf("rate limit %q: RPC %s master error", limit, master)
+	}
+	return reply.Wait, nil
+}
+
+// masterAddress returns the address of the master the worker reports
+// to, which changes once it switches to a standby
+func (wk *Worker) masterAddress() string {
+	wk.Lock()
+	defer wk.Unlock()
+	return wk.master
+}
@@ -0,0 +1,255 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// combineBatch is the number of buffered values that triggers an
+// early combine of a single key
+const combineBatch = 64
+
+// combinerCache aggregates map output across all map tasks executed by
+// one worker. Records are combined per key in memory and only written
+// to intermediate files when the cache grows past its budget or the
+// master announces the end of the map phase. Tasks report the cache
+// that absorbed their output, so the master runs them again should it
+// be lost before that, see FlushCacheReply.
+//
+// The combine function must produce values that are valid inputs to
+// both itself and the job's reduce function, e.g. partial sums.
+type combinerCache struct {
+	sync.Mutex
+	combineF   func(string, []string) string
+	maxEntries int            // Buffered values allowed before spilling
+	id         string         // Identifier of this cache in spill file names
+	disk       *diskScheduler // Worker's disk scheduler, nil if none
+
+	jobName   JobParse
+	workspace string // Root of the job's workspace, which identifies the job
+	nReduce   int
+	options   JobOptions
+	entries   int
+	values    map[string][]string
+	spills    int             // Number of spills written for the current job
+	spilled   map[string]int  // Spills written for earlier jobs by workspace, which concurrent jobs come back to
+	lost      map[string]bool // Workspaces of earlier jobs whose partial aggregates could not be spilled
+}
+
+// newCombinerCache creates an empty cache for the given worker. Its
+// identifier adds the time it was created to the worker's address, so
+// a worker restarted at the same address does not overwrite the spills
+// of the instance before it.
+func newCombinerCache(worker string, combineF func(string, []string) string, maxEntries int) *combinerCache {
+	return &combinerCache{
+		combineF:   combineF,
+		maxEntries: maxEntries,
+		id:         strconv.Itoa(ihash(worker)) + "." + strconv.FormatInt(time.Now().UnixNano(), 36),
+		values:     make(map[string][]string),
+	}
+}
+
+// doMap runs a map task whose output is absorbed by the cache.
+// The task's own intermediate files are created empty so reducers
+// find every file they expect.
+func (c *combinerCache) doMap(ctx context.Context, args *DoTaskArgs, mapF func(string, string) []KeyValue, disk *taskDisk) (TaskMetrics, error) {
+	// A cancelled task leaves nothing in the cache
+	kva, err := mapInput(ctx, args.split(), mapF, args.Options, disk)
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+
+	ws := args.Options.workspace(args.JobName)
+	if err := ws.Create(); err != nil {
+		return TaskMetrics{}, err
+	}
+
+	names := make([]string, args.OtherTaskNumber)
+	for i := range names {
+		names[i] = ws.Intermediate(args.TaskNumber, i)
+	}
+	metrics, err := writePartitions(ws, names, nil, args.Options, disk)
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+	metrics.OutputDigest = outputDigest(kva)
+	metrics.SchemaViolations = checkSchema(kva, args.Options, args.TaskNumber)
+	// The records reach their partitions in the cache's spills, which
+	// the task reports as its own
+	kva, metrics.PartitionRecords, err = keptRecords(kva, args.OtherTaskNumber, args.Options)
+	if err != nil {
+		return TaskMetrics{}, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.workspace != ws.Root {
+		if c.spilled == nil {
+			c.spilled = make(map[string]int)
+			c.lost = make(map[string]bool)
+		}
+		if err := c.flushLocked(); err != nil {
+			processLog.errorf("Combiner cache: dropped the partial aggregates of job %s: %v", c.jobName, err)
+			c.lost[c.workspace] = true
+		}
+		c.spilled[c.workspace] = c.spills
+		c.values = make(map[string][]string)
+		c.entries = 0
+		c.jobName = args.JobName
+		c.workspace = ws.Root
+		c.nReduce = args.OtherTaskNumber
+		c.options = args.Options
+		c.spills = c.spilled[ws.Root] // Spill names of the job stay unique
+	}
+
+	for _, kv := range kva {
+		values := append(c.values[kv.Key], kv.Value)
+		c.entries++
+		if len(values) >= combineBatch {
+			c.entries -= len(values) - 1
+			values = []string{c.combineF(kv.Key, values)}
+		}
+		c.values[kv.Key] = values
+	}
+
+	if c.maxEntries > 0 && c.entries > c.maxEntries {
+		// The records are the cache's now; a spill that failed is
+		// tried again with the next one
+		if err := c.flushLocked(); err != nil {
+			processLog.warnf("Combiner cache: spill of job %s failed: %v", c.jobName, err)
+		}
+	}
+	return metrics, nil
+}
+
+// Flush writes the buffered partial aggregates of the job with the
+// workspace rooted at workspace to spill files. It fails if they were
+// dropped when another job took over the cache.
+func (c *combinerCache) Flush(jobName JobParse, workspace string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.lost[workspace] {
+		delete(c.lost, workspace)
+		return fmt.Errorf("partial aggregates of job %s were dropped", jobName)
+	}
+	if c.workspace != workspace {
+		return nil // Spilled when another job took over the cache
+	}
+	return c.flushLocked()
+}
+
+// flushLocked combines every buffered key and spills the results.
+// The aggregates stay buffered if the spill fails.
+// The caller must hold the lock.
+func (c *combinerCache) flushLocked() error {
+	if len(c.values) == 0 {
+		return nil
+	}
+
+	kva := make([]KeyValue, 0, len(c.values))
+	for key, values := range c.values {
+		kva = append(kva, KeyValue{key, c.combineF(key, values)})
+	}
+
+	ws := c.options.workspace(c.jobName)
+	names := make([]string, c.nReduce)
+	for i := range names {
+		names[i] = ws.CacheSpill(c.id, c.spills, i)
+	}
+	if _, err := writePartitions(ws, names, kva, c.options, c.disk.forTask("cache-spill")); err != nil {
+		return err
+	}
+	processLog.debugf("Combiner cache: spilled %d keys of job %s", len(kva), c.jobName)
+
+	c.spills++
+	c.entries = 0
+	c.values = make(map[string][]string)
+	return nil
+}
+
+// FlushCache is called by the master once the map phase of a job has
+// finished, so partial aggregates reach disk before any reduce task
+// starts.
+func (wk *Worker) FlushCache(args *FlushCacheArgs, reply *FlushCacheReply) error {
+	if !validToken(wk.authToken, args.Token) {
+		return fmt.Errorf("invalid task token")
+	}
+	if wk.cache == nil {
+		return nil
+	}
+	reply.Cache = wk.cache.id
+	return wk.cache.Flush(args.JobName, args.Workspace)
+}
+
+// Ping answers the master's liveness probes while a task runs
+func (wk *Worker) Ping(args *PingArgs, _ *struct{}) error {
+	if !validToken(wk.authToken, args.Token) {
+		return fmt.Errorf("invalid task token")
+	}
+	return nil
+}
+
+// maxFlushRounds is how many times the map output of a job may be lost
+// with combiner caches before the job fails
+const maxFlushRounds = 3
+
+// flushWorkerCaches asks the workers whose combiner cache absorbed the
+// output of the map tasks in cached to spill it, and returns the tasks
+// whose output was lost: the flush failed, e.g. because the worker
+// died, or the worker no longer has the cache, e.g. because it was
+// restarted. The caches of those tasks are dropped, so that reduce
+// tasks skip what they spilled, see JobOptions.DroppedCaches.
+func (mr *Master) flushWorkerCaches(cached map[int]cachedOutput) []int {
+	mr.Lock()
+	timeout := mr.timeouts.forMethod(FlushCacheMethod)
+	mr.Unlock()
+
+	tasks := make(map[string][]int)
+	for task, out := range cached {
+		tasks[out.worker] = append(tasks[out.worker], task)
+	}
+	var lost []int
+	dropped := make(map[string]bool)
+	for w := range tasks {
+		reply := new(FlushCacheReply)
+		ok := callTimeout(w, FlushCacheMethod, &FlushCacheArgs{JobName: mr.jobName, Workspace: mr.workspace().Root, Token: mr.authToken}, reply, timeout)
+		if !ok {
+			mr.logger.warnf("Master: flush combiner cache of %s failed", w)
+		}
+		for _, task := range tasks[w] {
+			if cache := cached[task].cache; !ok || cache != reply.Cache {
+				lost = append(lost, task)
+				dropped[cache] = true
+			}
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+	sort.Ints(lost)
+	mr.logger.warnf("Master: the combiner caches holding the output of map tasks %v of job %s were lost, running them again",
+		lost, mr.jobName)
+	mr.Lock()
+	for cache := range dropped {
+		mr.options.DroppedCaches = append(mr.options.DroppedCaches, cache)
+	}
+	mr.Unlock()
+	return lost
+}
+
+// removeCacheSpills deletes spill files left behind by an earlier run
+// of the same job, which reducers would otherwise pick up
+func removeCacheSpills(ws *JobWorkspace, nReduce int) {
+	for i := 0; i < nReduce; i++ {
+		spills, _ := globFiles(ws.CacheSpillPattern(i))
+		for _, spill := range spills {
+			removeFile(spill)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// runningTask is a task in flight on a worker that can be aborted
+type runningTask struct {
+	job    JobParse
+	cancel context.CancelFunc
+}
+
+// startTask returns the context of a task the worker is about to run,
+// cancelled by CancelTasks for the task's job. Calling done releases
+// it once the task has finished.
+func (wk *Worker) startTask(args *DoTaskArgs) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &runningTask{job: args.JobName, cancel: cancel}
+
+	wk.Lock()
+	if wk.tasks == nil {
+		wk.tasks = make(map[*runningTask]struct{})
+	}
+	wk.tasks[task] = struct{}{}
+	wk.Unlock()
+
+	return ctx, func() {
+		wk.Lock()
+		delete(wk.tasks, task)
+		wk.Unlock()
+		cancel()
+	}
+}
+
+// CancelTasks aborts the worker's running tasks of a cancelled job.
+// They stop at the next record or reduce group and report the job's
+// cancellation as their error.
+func (wk *Worker) CancelTasks(args *CancelTasksArgs, _ *struct{}) error {
+	if !validToken(wk.authToken, args.Token) {
+		return fmt.Errorf("invalid task token")
+	}
+	wk.Lock()
+	defer wk.Unlock()
+	n := 0
+	for task := range wk.tasks {
+		if task.job == args.JobName {
+			task.cancel()
+			n++
+		}
+	}
+	if n > 0 {
+		wk.logger.infof("cancelled %d tasks of job %s", n, args.JobName)
+	}
+	return nil
+}
+
+// cancelWorkerTasks asks every registered worker to abort its running
+// tasks of the current job, which was cancelled. Workers that do not
+// answer finish their tasks, whose results are then ignored.
+func (mr *Master) cancelWorkerTasks() {
+	mr.Lock()
+	workers := append([]string{}, mr.workers...)
+	args := &CancelTasksArgs{JobName: mr.jobName, Token: mr.authToken}
+	timeout := mr.timeouts.forMethod(CancelTasksMethod)
+	mr.Unlock()
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w string) {
+			defer wg.Done()
+			if !callTimeout(w, CancelTasksMethod, args, new(struct{}), timeout) {
+				mr.logger.warnf("Master: cancel tasks of job %s on %s failed", args.JobName, w)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
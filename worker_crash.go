@@ -0,0 +1,218 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crashLogLines is the number of recent log entries kept for crash reports
+const crashLogLines = 50
+
+// CrashReport explains why a worker process died. Workers with crash
+// reports enabled write one to disk and send it to the master right
+// before exiting on an unrecoverable error.
+type CrashReport struct {
+	Worker  string    // Address of the crashed worker
+	Time    time.Time // When the worker crashed
+	Reason  string    // Fatal error or panic value
+	Stack   string    // Stack of the goroutine that failed
+	Tasks   []string  // Tasks running at the time, e.g. "wordcount Map #3"
+	LogTail []string  // Most recent log entries of the process
+	Token   string    `json:"-"` // Credential presented to the master, not written to report files
+}
+
+// crashReporter records what a worker is doing so that a crash report
+// can be assembled when it dies
+type crashReporter struct {
+	sync.Mutex
+	dir     string              // Directory receiving report files, empty to only send them
+	master  string              // Master receiving the last-gasp report
+	running map[string]struct{} // Descriptions of the tasks running now
+}
+
+// crashWorkers lists the workers of this process with crash reports
+// enabled. A panic takes down the whole process, so every one of them
+// reports.
+var crashWorkers struct {
+	sync.Mutex
+	list []*Worker
+}
+
+// reportCrash sends a crash report for every worker of the process
+func reportCrash(reason string, stack []byte) {
+	crashWorkers.Lock()
+	workers := append([]*Worker{}, crashWorkers.list...)
+	crashWorkers.Unlock()
+
+	for _, wk := range workers {
+		wk.reportCrash(reason, stack)
+	}
+}
+
+// enableCrashReports registers the worker for crash reports and
+// starts recording the log tail
+func (wk *Worker) enableCrashReports(master string) {
+	wk.crash.master = master
+	wk.crash.running = make(map[string]struct{})
+	logTail.install()
+
+	crashWorkers.Lock()
+	crashWorkers.list = append(crashWorkers.list, wk)
+	crashWorkers.Unlock()
+}
+
+// disableCrashReports unregisters a worker that shut down normally
+func (wk *Worker) disableCrashReports() {
+	crashWorkers.Lock()
+	defer crashWorkers.Unlock()
+	for i, w := range crashWorkers.list {
+		if w == wk {
+			crashWorkers.list = append(crashWorkers.list[:i], crashWorkers.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// trackTask records a task as running until the returned function is
+// called. It does nothing without crash reports.
+func (wk *Worker) trackTask(args *DoTaskArgs) (done func()) {
+	if wk.crash == nil {
+		return func() {}
+	}
+	task := fmt.Sprintf("%s %s #%d", args.JobName, args.Phase, args.TaskNumber)
+	wk.crash.Lock()
+	wk.crash.running[task] = struct{}{}
+	wk.crash.Unlock()
+
+	return func() {
+		wk.crash.Lock()
+		delete(wk.crash.running, task)
+		wk.crash.Unlock()
+	}
+}
+
+// recoverTask turns a panicking task into a crash report. The panic
+// is raised again, so the worker still dies.
+func (wk *Worker) recoverTask() {
+	if wk.crash == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		reportCrash(fmt.Sprint(r), debug.Stack())
+		panic(r)
+	}
+}
+
+// reportCrash writes the worker's crash report and sends it to the
+// master. Failures are logged only, the process is going down anyway.
+func (wk *Worker) reportCrash(reason string, stack []byte) {
+	wk.crash.Lock()
+	tasks := make([]string, 0, len(wk.crash.running))
+	for task := range wk.crash.running {
+		tasks = append(tasks, task)
+	}
+	wk.crash.Unlock()
+	sort.Strings(tasks)
+
+	report := &CrashReport{
+		Worker:  wk.name,
+		Time:    time.Now(),
+		Reason:  reason,
+		Stack:   string(stack),
+		Tasks:   tasks,
+		LogTail: logTail.lines(),
+		Token:   wk.authToken,
+	}
+
+	if wk.crash.dir != "" {
+		if err := writeCrashReport(wk.crash.dir, report); err != nil {
+			wk.logger.warnf("write crash report error %v", err)
+		}
+	}
+	if !callTimeout(wk.crash.master, ReportCrashMethod, report, new(struct{}), wk.timeouts.forMethod(ReportCrashMethod)) {
+		wk.logger.warnf("send crash report to %s failed", wk.crash.master)
+	}
+}
+
+// writeCrashReport stores report as JSON in dir, named after the
+// worker and the time of the crash
+func writeCrashReport(dir string, report *CrashReport) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' {
+			return '_'
+		}
+		return r
+	}, strings.TrimPrefix(report.Worker, "/"))
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, fmt.Sprintf("crash-%s-%d.json", name, report.Time.UnixNano()))
+	return os.WriteFile(file, data, 0666)
+}
+
+// logTail keeps the most recent log entries of the process
+var logTail = &tailWriter{}
+
+// tailWriter passes log output through and remembers its last entries
+type tailWriter struct {
+	sync.Mutex
+	once    sync.Once
+	out     io.Writer
+	entries []string
+}
+
+// install routes the standard logger through the tail writer
+func (t *tailWriter) install() {
+	t.once.Do(func() {
+		t.Lock()
+		t.out = log.Writer()
+		t.Unlock()
+		log.SetOutput(t)
+	})
+}
+
+// Write records one log entry and forwards it
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.Lock()
+	t.entries = append(t.entries, strings.TrimSuffix(string(p), "\n"))
+	if len(t.entries) > crashLogLines {
+		t.entries = t.entries[len(t.entries)-crashLogLines:]
+	}
+	t.Unlock()
+	return t.out.Write(p)
+}
+
+// record remembers a log entry written elsewhere, e.g. to a Logger
+func (t *tailWriter) record(entry string) {
+	t.Lock()
+	defer t.Unlock()
+	if t.out == nil {
+		return // No worker of the process reports crashes
+	}
+	t.entries = append(t.entries, entry)
+	if len(t.entries) > crashLogLines {
+		t.entries = t.entries[len(t.entries)-crashLogLines:]
+	}
+}
+
+// lines returns a copy of the recorded entries
+func (t *tailWriter) lines() []string {
+	t.Lock()
+	defer t.Unlock()
+	return append([]string{}, t.entries...)
+}
@@ -0,0 +1,185 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+)
+
+// ioChunkSize is the largest read or write performed under one grant
+// of the disk scheduler
+const ioChunkSize = 1 << 20
+
+// diskScheduler limits how many large reads and writes the tasks of
+// one worker perform at once. File I/O is split into chunks of
+// ioChunkSize and every chunk needs a grant. When tasks compete for
+// grants they are served round-robin, so a task spilling a huge
+// partition cannot starve another task reading its input.
+type diskScheduler struct {
+	sync.Mutex
+	limit   int                        // Chunks allowed in flight
+	active  int                        // Chunks currently in flight
+	waiters map[string][]chan struct{} // Waiting chunks per task
+	order   []string                   // Tasks with waiters, in serving order
+}
+
+// newDiskScheduler creates a scheduler allowing limit concurrent chunks
+func newDiskScheduler(limit int) *diskScheduler {
+	if limit < 1 {
+		limit = 1
+	}
+	return &diskScheduler{
+		limit:   limit,
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+// acquire blocks until task may perform one chunk of I/O
+func (d *diskScheduler) acquire(task string) {
+	d.Lock()
+	if d.active < d.limit && len(d.order) == 0 {
+		d.active++
+		d.Unlock()
+		return
+	}
+
+	grant := make(chan struct{})
+	if len(d.waiters[task]) == 0 {
+		d.order = append(d.order, task)
+	}
+	d.waiters[task] = append(d.waiters[task], grant)
+	d.Unlock()
+	<-grant
+}
+
+// release ends a chunk and hands its grant to the next task in line
+func (d *diskScheduler) release() {
+	d.Lock()
+	defer d.Unlock()
+	if len(d.order) == 0 {
+		d.active--
+		return
+	}
+
+	task := d.order[0]
+	d.order = d.order[1:]
+	queue := d.waiters[task]
+	grant := queue[0]
+	if len(queue) > 1 {
+		d.waiters[task] = queue[1:]
+		d.order = append(d.order, task)
+	} else {
+		delete(d.waiters, task)
+	}
+	close(grant)
+}
+
+// forTask returns the file I/O helper of one task, or nil when the
+// worker has no scheduler
+func (d *diskScheduler) forTask(task string) *taskDisk {
+	if d == nil {
+		return nil
+	}
+	return &taskDisk{sched: d, task: task}
+}
+
+// taskDisk opens and creates the files of one task. A nil *taskDisk
+// performs plain, unscheduled I/O.
+type taskDisk struct {
+	sched *diskScheduler
+	task  string
+}
+
+// open opens a file for reading
+func (t *taskDisk) open(name string) (io.ReadCloser, error) {
+	return t.openAt(name, 0)
+}
+
+// openAt opens a file for reading from offset on. Remote files do not
+// touch the local disk and are never scheduled.
+func (t *taskDisk) openAt(name string, offset int64) (io.ReadCloser, error) {
+	if isRemotePath(name) {
+		return openFile(name, offset)
+	}
+	file, err := os.Open(name)
+	if err == nil && offset > 0 {
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+		}
+	}
+	if err != nil || t == nil {
+		return file, err
+	}
+	r := &scheduledFile{file: file, disk: t}
+	return struct {
+		io.Reader
+		io.Closer
+	}{bufio.NewReaderSize(r, ioChunkSize), file}, nil
+}
+
+// create creates a file for writing. Writes are buffered and reach
+// the disk in chunks; Close flushes the buffer.
+func (t *taskDisk) create(name string) (io.WriteCloser, error) {
+	if isRemotePath(name) {
+		return createFile(name)
+	}
+	file, err := os.Create(name)
+	if err != nil || t == nil {
+		return file, err
+	}
+	w := &scheduledFile{file: file, disk: t}
+	return &bufferedFile{Writer: bufio.NewWriterSize(w, ioChunkSize), file: file}, nil
+}
+
+// scheduledFile performs every chunk of I/O under a scheduler grant
+type scheduledFile struct {
+	file *os.File
+	disk *taskDisk
+}
+
+// Read reads at most one chunk
+func (f *scheduledFile) Read(p []byte) (int, error) {
+	if len(p) > ioChunkSize {
+		p = p[:ioChunkSize]
+	}
+	f.disk.sched.acquire(f.disk.task)
+	defer f.disk.sched.release()
+	return f.file.Read(p)
+}
+
+// Write writes p one chunk at a time
+func (f *scheduledFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > ioChunkSize {
+			chunk = chunk[:ioChunkSize]
+		}
+		f.disk.sched.acquire(f.disk.task)
+		n, err := f.file.Write(chunk)
+		f.disk.sched.release()
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// bufferedFile flushes its buffer before closing the file
+type bufferedFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// Close flushes buffered data and closes the file
+func (f *bufferedFile) Close() error {
+	err := f.Flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
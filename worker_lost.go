@@ -0,0 +1,62 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+)
+
+// lostMapOutputs returns the map tasks whose output a reduce task
+// cannot find in the workspace or its replica, e.g. because the disk
+// holding it failed after the map phase. Every map task writes a file
+// for every partition, empty or not, so a missing file is lost output.
+// The master runs these map tasks again instead of letting the reduce
+// task silently skip their records.
+func lostMapOutputs(args *DoTaskArgs) []int {
+	if args.Phase != reduceParse || args.Verify {
+		return nil
+	}
+	ws := args.Options.workspace(args.JobName)
+	replica := args.Options.replica(args.JobName)
+	var lost []int
+	for i := 0; i < args.OtherTaskNumber; i++ {
+		if _, err := statFile(readableCopy(ws, replica, ws.Intermediate(i, args.TaskNumber))); err != nil {
+			lost = append(lost, i)
+		}
+	}
+	return lost
+}
+
+// fetchError is a failure to read an intermediate file, e.g. because
+// it vanished or its disk failed after the reduce task checked it
+type fetchError struct {
+	file string
+	err  error
+}
+
+func (e *fetchError) Error() string {
+	return fmt.Sprintf("read %s: %v", e.file, e.err)
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+// failedFetch returns the map task whose output a reduce task could
+// not read when it failed with err, -1 if err is no such failure or
+// the file is a combiner cache spill, which no single map task wrote
+func failedFetch(args *DoTaskArgs, err error) int {
+	var fetch *fetchError
+	if args.Phase != reduceParse || args.Verify || !errors.As(err, &fetch) {
+		return -1
+	}
+	ws := args.Options.workspace(args.JobName)
+	replica := args.Options.replica(args.JobName)
+	for i := 0; i < args.OtherTaskNumber; i++ {
+		name := ws.Intermediate(i, args.TaskNumber)
+		if fetch.file == name || replica != nil && fetch.file == replicaPath(ws, replica, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,196 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "time"
+
+// WorkerOption configures optional behaviour of a Worker.
+type WorkerOption func(*Worker)
+
+// WithLabels attaches free-form labels to the worker. They are
+// reported to the master when the worker registers.
+func WithLabels(labels map[string]string) WorkerOption {
+	return func(wk *Worker) {
+		wk.labels = labels
+	}
+}
+
+// WithLocalPaths tells the master which directories the worker reads
+// from local storage, e.g. its own disks on a cluster where every host
+// exports them to the others. Map tasks whose input file lies below one
+// of them are preferably assigned to this worker. Paths are compared
+// with the input file names as the master was given them.
+func WithLocalPaths(paths ...string) WorkerOption {
+	return func(wk *Worker) {
+		wk.localPaths = paths
+	}
+}
+
+// WithSideInputCache limits the side inputs the worker keeps across
+// jobs to maxBytes, see WithShippedSideInputs. Shipped files are cached
+// by the hash of their contents, so a lookup table shipped with every
+// run of a job is downloaded once; once the cache grows past maxBytes,
+// the files used least recently are removed. Zero, the default, keeps
+// every file.
+func WithSideInputCache(maxBytes int64) WorkerOption {
+	return func(wk *Worker) {
+		wk.shipCacheLimit = maxBytes
+	}
+}
+
+// WithStandbyMaster names the master to report to once the worker's
+// master is gone, see StartStandby: after missing as many heartbeats in
+// a row as the master allows, the worker registers with address
+// instead. Heartbeats must be enabled with WithHeartbeat.
+func WithStandbyMaster(address string) WorkerOption {
+	return func(wk *Worker) {
+		wk.standby = address
+	}
+}
+
+// WithBinaryVersion sets the version of the map and reduce code the
+// worker runs. Jobs started with WithRequiredBinaryVersion are only
+// assigned to workers reporting the same version.
+func WithBinaryVersion(version string) WorkerOption {
+	return func(wk *Worker) {
+		wk.binaryVersion = version
+	}
+}
+
+// WithPrepare runs prepare whenever the master readies the worker for
+// a new job, before any of the job's tasks arrive, e.g. to load the
+// job's side inputs into memory. Returning an error keeps the master
+// from assigning the worker tasks of the job, so broken setups surface
+// at job start instead of on the first task.
+func WithPrepare(prepare func(job PrepareArgs) error) WorkerOption {
+	return func(wk *Worker) {
+		wk.prepareF = prepare
+	}
+}
+
+// WithConcurrency limits how many tasks the worker runs at once.
+// Values below one mean a single task at a time. The limit is reported
+// to the master, which hands the worker up to n tasks at once, so
+// bigger machines take a proportionally bigger share of the job.
+func WithConcurrency(n int) WorkerOption {
+	return func(wk *Worker) {
+		if n < 1 {
+			n = 1
+		}
+		wk.slots = make(chan struct{}, n)
+	}
+}
+
+// WithMinFreeSpace makes the worker refuse map tasks and externally
+// sorted reduce tasks, which spill to disk, while the file system of
+// the job workspace has less than bytes free. The master hands refused
+// tasks to other workers, instead of the task failing halfway through
+// a spill and leaving partial files for its retries.
+func WithMinFreeSpace(bytes uint64) WorkerOption {
+	return func(wk *Worker) {
+		wk.minFreeSpace = bytes
+	}
+}
+
+// WithDiskIOLimit schedules the file I/O of the worker's tasks. At most
+// limit reads or writes of up to 1 MB are in flight at once, and tasks
+// waiting for the disk take turns, so one task's large spill cannot
+// starve the input reads of the others. Useful together with
+// WithConcurrency on workers with spinning disks.
+func WithDiskIOLimit(limit int) WorkerOption {
+	return func(wk *Worker) {
+		wk.disk = newDiskScheduler(limit)
+	}
+}
+
+// WithAuthToken sets the credential the worker presents to the master
+// when it registers. The worker then only accepts tasks carrying the
+// same token, see WithWorkerToken.
+func WithAuthToken(token string) WorkerOption {
+	return func(wk *Worker) {
+		wk.authToken = token
+	}
+}
+
+// WithRegisterTimeout sets how long the worker waits for the master to
+// acknowledge its registration, DefaultRPCTimeout by default.
+func WithRegisterTimeout(timeout time.Duration) WorkerOption {
+	return func(wk *Worker) {
+		wk.timeouts.Register = timeout
+	}
+}
+
+// WithCrashReports makes the worker report why it died when a task
+// hits an unrecoverable error or panics. The report, holding the stack,
+// the running tasks and the recent log entries, is written as JSON to
+// dir, unless dir is empty, and sent to the master, which keeps it for
+// Master.Crashes.
+func WithCrashReports(dir string) WorkerOption {
+	return func(wk *Worker) {
+		wk.crash = &crashReporter{dir: dir}
+	}
+}
+
+// WithContextFuncs replaces the worker's map and reduce functions by
+// ones receiving the context of their task, e.g. to sample
+// reproducibly with the job's seed. A nil function keeps the one
+// passed to RunWorker.
+func WithContextFuncs(mapF ContextMapFunc, reduceF ContextReduceFunc) WorkerOption {
+	return func(wk *Worker) {
+		wk.mapCtxF = mapF
+		wk.reduceCtxF = reduceF
+	}
+}
+
+// WithStreamingMap replaces the worker's map function by one called
+// per record of the input and emitting its output as it goes, so map
+// tasks over files of any size run in bounded memory. Combiners are
+// not applied to its output.
+func WithStreamingMap(mapF StreamMapFunc) WorkerOption {
+	return func(wk *Worker) {
+		wk.streamMapF = mapF
+	}
+}
+
+// WithStreamingReduce replaces the worker's reduce function by one
+// iterating over the values of a key, so keys with millions of values
+// need not fit in memory when the job sorts externally, see
+// WithExternalSort. An error returned by the function fails the task,
+// which the master retries.
+func WithStreamingReduce(reduceF StreamReduceFunc) WorkerOption {
+	return func(wk *Worker) {
+		wk.streamReduceF = reduceF
+	}
+}
+
+// WithCombiner combines the output of every map task with combineF
+// before it is written, so the task writes one record per key and
+// partition. The combine function must produce values that are valid
+// inputs to both itself and the job's reduce function, e.g. partial
+// sums. WithCombinerCache takes precedence.
+func WithCombiner(combineF func(string, []string) string) WorkerOption {
+	return func(wk *Worker) {
+		wk.combineF = combineF
+	}
+}
+
+// WithCombinerCache enables a worker-wide partial aggregation cache.
+// Map output of all tasks run by the worker is combined with combineF
+// and spilled once more than maxEntries values are buffered, or when
+// the map phase ends. A maxEntries of zero only spills at phase end.
+// Should the worker die or restart before the master flushes the cache
+// at the end of the map phase, the master runs the tasks whose output
+// the cache held again on other workers, writing their output directly.
+func WithCombinerCache(combineF func(string, []string) string, maxEntries int) WorkerOption {
+	return func(wk *Worker) {
+		wk.cache = newCombinerCache(wk.name, combineF, maxEntries)
+	}
+}
+
+// WithWorkerLogger sends the worker's log messages to logger instead
+// of the process logger, see UseLogger. Messages carry the worker's
+// address as the "worker" attribute.
+func WithWorkerLogger(logger Logger) WorkerOption {
+	return func(wk *Worker) {
+		wk.logger.logger = logger
+	}
+}
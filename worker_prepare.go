@@ -0,0 +1,98 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prepare readies the worker for a job before the master sends it any
+// of the job's tasks. It checks that the worker can run the job, creates
+// the job's workspace directories, checks or downloads the side inputs
+// and runs the worker's prepare hook, e.g. to load them. An error keeps the master
+// from assigning the worker any task of the job.
+func (wk *Worker) Prepare(args *PrepareArgs, reply *PrepareReply) error {
+	if !validToken(wk.authToken, args.Token) {
+		wk.logger.warnf("rejected job %s with an invalid token", args.JobName)
+		return fmt.Errorf("invalid task token")
+	}
+	reply.Framework = FrameworkVersion
+	reply.BinaryVersion = wk.binaryVersion
+	reply.Build = processBuild()
+
+	if err := wk.prepare(args); err != nil {
+		wk.logger.warnf("cannot run job %s: %v", args.JobName, err)
+		return err
+	}
+	wk.logger.infof("ready for job %s", args.JobName)
+	return nil
+}
+
+// prepare checks and sets up everything the job needs on this worker
+func (wk *Worker) prepare(args *PrepareArgs) error {
+	if major(args.Framework) != major(FrameworkVersion) {
+		return fmt.Errorf("master runs framework %s, worker runs %s", args.Framework, FrameworkVersion)
+	}
+	if args.Options.IntermediateFormat > IntermediateFormatCurrent {
+		return fmt.Errorf("intermediate format %d is newer than the supported format %d",
+			args.Options.IntermediateFormat, IntermediateFormatCurrent)
+	}
+	if args.BinaryVersion != "" && args.BinaryVersion != wk.binaryVersion {
+		return fmt.Errorf("job needs binary version %s, worker runs %q", args.BinaryVersion, wk.binaryVersion)
+	}
+	if _, err := args.Options.inputFormat(); err != nil {
+		return err
+	}
+	for group := range args.Options.GroupFormats {
+		if _, err := args.Options.groupInputFormat(group); err != nil {
+			return fmt.Errorf("input group %s: %v", group, err)
+		}
+	}
+	if _, err := resolveRecordCodec(args.Options.RecordCodec, args.Options.IntermediateFormat); err != nil {
+		return err
+	}
+	if err := args.Options.Schema.validate(); err != nil {
+		return err
+	}
+	if _, err := args.Options.keyOrder(); err != nil {
+		return err
+	}
+	if _, err := args.Options.keyFilter(); err != nil {
+		return err
+	}
+	if _, err := args.Options.builtin(); err != nil {
+		return err
+	}
+	if err := args.Options.checkColumns(); err != nil {
+		return err
+	}
+
+	if err := args.Options.workspace(args.JobName).Create(); err != nil {
+		return err
+	}
+	if args.ShipSideInputs {
+		local, err := wk.shipSideInputs(args)
+		if err != nil {
+			return err
+		}
+		args.SideInputs = local
+	}
+	for _, file := range args.SideInputs {
+		if _, err := statFile(file); err != nil {
+			return fmt.Errorf("side input: %v", err)
+		}
+	}
+
+	if wk.prepareF != nil {
+		if err := wk.prepareF(*args); err != nil {
+			return fmt.Errorf("prepare hook: %v", err)
+		}
+	}
+	return nil
+}
+
+// major returns the major component of a version such as "1.4.0"
+func major(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
@@ -0,0 +1,235 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fetchAttempts is how often a chunk of a shipped file is requested
+// before the download fails
+const fetchAttempts = 3
+
+// shipSideInputs downloads the side inputs the master ships with the
+// job and returns the paths of the local copies. Downloads interrupted
+// earlier, e.g. by a Prepare call that timed out, resume where they
+// stopped. Files the master sends a content hash for are kept in the
+// worker's side input cache, see WithSideInputCache, and not downloaded
+// again for later jobs shipping the same contents.
+func (wk *Worker) shipSideInputs(args *PrepareArgs) ([]string, error) {
+	wk.shipping.Lock()
+	defer wk.shipping.Unlock()
+
+	hashed := len(args.SideInputHashes) == len(args.SideInputs)
+	dir := filepath.Join(wk.shipDir(), string(args.JobName))
+	local := make([]string, len(args.SideInputs))
+	for i, file := range args.SideInputs {
+		sum := ""
+		local[i] = filepath.Join(dir, fmt.Sprintf("%d-%s", i, path.Base(file)))
+		if hashed {
+			sum = args.SideInputHashes[i]
+			local[i] = filepath.Join(wk.shipCacheDir(), sum, path.Base(file))
+			if _, err := os.Stat(local[i]); err == nil {
+				now := time.Now()
+				os.Chtimes(filepath.Dir(local[i]), now, now) // Marks the entry used, see pruneShipCache
+				wk.logger.infof("side input %s is cached as %s", file, local[i])
+				continue
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(local[i]), 0755); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		size, err := wk.fetchFile(args.JobName, args.Options.workspace(args.JobName).Root, file, local[i], sum, args.Token)
+		if err != nil {
+			return nil, fmt.Errorf("ship %s: %v", file, err)
+		}
+		wk.logger.infof("downloaded side input %s, %d bytes in %v", file, size,
+			time.Since(start).Round(time.Millisecond))
+	}
+	if hashed {
+		wk.pruneShipCache(args.SideInputHashes)
+	}
+	return local, nil
+}
+
+// shipDir returns the directory receiving shipped side inputs, on the
+// worker's first local path if it has any
+func (wk *Worker) shipDir() string {
+	base := os.TempDir()
+	if len(wk.localPaths) > 0 {
+		base = wk.localPaths[0]
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, wk.name)
+	return filepath.Join(base, "mrship-"+name)
+}
+
+// fetchFile downloads a file job ships from the master chunk by chunk
+// into local, appending to what an earlier attempt left in local.part.
+// The part file is renamed once the last chunk arrived and, if sum is
+// not empty, its SHA-256 matches sum; a part file that does not match
+// is removed.
+func (wk *Worker) fetchFile(job JobParse, workspace, file, local, sum, token string) (int64, error) {
+	part := local + ".part"
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offset, size := info.Size(), int64(-1)
+	for seq := 0; ; seq++ {
+		reply, err := wk.fetchChunk(&FetchFileArgs{
+			Worker:    wk.name,
+			Token:     token,
+			File:      file,
+			Seq:       seq,
+			Offset:    offset,
+			Job:       job,
+			Workspace: workspace,
+		})
+		switch {
+		case err != nil && seq == 0 && offset > 0:
+			// The part file may be left from an older version of the
+			// file, e.g. one that was longer
+			wk.logger.warnf("cannot resume %s at %d, starting over: %v", file, offset, err)
+			offset = 0
+			continue
+		case err != nil:
+			return 0, err
+		case size >= 0 && reply.Size != size:
+			// The file changed on the master during the download
+			offset, size = 0, -1
+			continue
+		case len(reply.Data) == 0 && !reply.Last:
+			return 0, fmt.Errorf("master sent an empty chunk at %d", offset)
+		}
+		size = reply.Size
+		if offset == 0 {
+			if err := out.Truncate(0); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := out.WriteAt(reply.Data, offset); err != nil {
+			return 0, err
+		}
+		offset += int64(len(reply.Data))
+		if reply.Last {
+			break
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if sum != "" && hashFile(part) != sum {
+		os.Remove(part)
+		return 0, fmt.Errorf("contents do not match hash %s, the file changed on the master", sum)
+	}
+	return size, os.Rename(part, local)
+}
+
+// fetchChunk requests one chunk of a shipped file, retrying failed
+// calls and replies that do not match the request
+func (wk *Worker) fetchChunk(args *FetchFileArgs) (*FetchFileReply, error) {
+	var err error
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 100 * time.Millisecond)
+		}
+		var reply FetchFileReply
+		switch {
+		case !callTimeout(wk.masterAddress(), FetchFileMethod, args, &reply, wk.timeouts.forMethod(FetchFileMethod)):
+			err = fmt.Errorf("fetching chunk %d at %d failed", args.Seq, args.Offset)
+		case reply.Seq != args.Seq || reply.Offset != args.Offset:
+			err = fmt.Errorf("got chunk %d at %d, want chunk %d at %d", reply.Seq, reply.Offset, args.Seq, args.Offset)
+		case crc32.ChecksumIEEE(reply.Data) != reply.Checksum:
+			err = fmt.Errorf("chunk %d at %d is corrupt", args.Seq, args.Offset)
+		default:
+			return &reply, nil
+		}
+	}
+	return nil, err
+}
+
+// shipCacheDir returns the directory keeping shipped side inputs across
+// jobs, one directory per content hash
+func (wk *Worker) shipCacheDir() string {
+	return filepath.Join(wk.shipDir(), "cache")
+}
+
+// pruneShipCache removes the least recently used entries of the side
+// input cache until it holds at most the WithSideInputCache limit,
+// keeping the entries of the hashes in use
+func (wk *Worker) pruneShipCache(inUse []string) {
+	if wk.shipCacheLimit <= 0 {
+		return
+	}
+	type cacheEntry struct {
+		dir  string
+		used time.Time
+		size int64
+	}
+	dirs, err := os.ReadDir(wk.shipCacheDir())
+	if err != nil {
+		return
+	}
+	var entries []cacheEntry
+	var total int64
+	for _, d := range dirs {
+		info, err := d.Info()
+		if err != nil || !d.IsDir() || slices.Contains(inUse, d.Name()) {
+			continue
+		}
+		entry := cacheEntry{dir: filepath.Join(wk.shipCacheDir(), d.Name()), used: info.ModTime()}
+		entry.size = dirSize(entry.dir)
+		entries = append(entries, entry)
+		total += entry.size
+	}
+	for _, sum := range inUse {
+		total += dirSize(filepath.Join(wk.shipCacheDir(), sum))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	for _, entry := range entries {
+		if total <= wk.shipCacheLimit {
+			return
+		}
+		if err := os.RemoveAll(entry.dir); err != nil {
+			wk.logger.warnf("side input cache: %v", err)
+			continue
+		}
+		total -= entry.size
+		wk.logger.infof("side input cache: evicted %s, %d bytes", entry.dir, entry.size)
+	}
+}
+
+// dirSize returns the bytes of the files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,54 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// refuseTask returns why the worker cannot take a task right now, or
+// "" if it can. Tasks that spill to disk are refused while the disk
+// they write to is nearly full, see WithMinFreeSpace.
+func (wk *Worker) refuseTask(args *DoTaskArgs) string {
+	if wk.minFreeSpace == 0 || !spillsToDisk(args) {
+		return ""
+	}
+	dir := args.Options.workspace(args.JobName).Root
+	if args.Verify || isRemotePath(dir) {
+		// Verification runs write to a scratch directory, and files of
+		// remote workspaces are staged in the temporary directory
+		dir = os.TempDir()
+	}
+	free, err := freeSpace(existingDir(dir))
+	if err != nil {
+		wk.logger.warnf("free space of %s: %v", dir, err)
+		return ""
+	}
+	if free < wk.minFreeSpace {
+		return fmt.Sprintf("disk of %s is nearly full, %d bytes free, %d reserved", dir, free, wk.minFreeSpace)
+	}
+	return ""
+}
+
+// spillsToDisk reports whether a task writes a share of its input to
+// disk: map tasks write their whole output as intermediate files, and
+// externally sorted reduce tasks spill sorted runs
+func spillsToDisk(args *DoTaskArgs) bool {
+	return args.Phase == mapParse || args.Options.SortedReduce && args.Options.SortBuffer > 0
+}
+
+// existingDir returns dir or its closest ancestor that exists, e.g.
+// before the workspace of a job has been created
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
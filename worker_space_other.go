@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import "errors"
+
+// freeSpace is not supported on this platform
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not reported on this platform")
+}
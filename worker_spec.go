@@ -0,0 +1,159 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WorkerSpec describes a worker in a single JSON document, so
+// orchestration systems can template one spec instead of composing
+// command line flags and configuration files.
+//
+//	{
+//	  "master": "/tmp/824-socket/master.sock",
+//	  "address": "/tmp/824-socket/worker-1.sock",
+//	  "transport": "unix",
+//	  "labels": {"zone": "a"},
+//	  "concurrency": 2,
+//	  "disk_io_limit": 1,
+//	  "min_free_space": 10737418240,
+//	  "auth_token": "secret"
+//	}
+type WorkerSpec struct {
+	Master      string            `json:"master"`        // Address of the master node
+	Address     string            `json:"address"`       // Address this worker listens on
+	Transport   string            `json:"transport"`     // Network transport: "unix" by default, "tcp", "grpc" or "jsonrpc"
+	Labels      map[string]string `json:"labels"`        // Free-form labels reported at registration
+	Concurrency int               `json:"concurrency"`   // Maximum tasks run at once, 1 by default
+	AuthToken   string            `json:"auth_token"`    // Credential presented to the master
+	DiskIOLimit int               `json:"disk_io_limit"` // Concurrent disk chunks, unscheduled if zero
+	TLS         *TLSConfig        `json:"tls"`           // Certificates securing the worker's RPCs
+	LocalPaths  []string          `json:"local_paths"`   // Directories on storage local to the worker
+	S3          *S3Config         `json:"s3"`            // Object store serving s3:// paths
+	HDFS        *HDFSConfig       `json:"hdfs"`          // Cluster serving hdfs:// and webhdfs:// paths
+	GCS         *GCSConfig        `json:"gcs"`           // Cloud Storage serving gs:// paths
+	Statsd      *StatsdConfig     `json:"statsd"`        // Daemon the worker's metrics are sent to
+	OTLP        *OTLPConfig       `json:"otlp"`          // Collector the worker's spans are sent to
+
+	MinFreeSpace uint64 `json:"min_free_space"` // Free bytes below which spilling tasks are refused, zero for none
+}
+
+// ReadWorkerSpec decodes a worker spec from r, e.g. os.Stdin
+func ReadWorkerSpec(r io.Reader) (*WorkerSpec, error) {
+	var spec WorkerSpec
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to decode worker spec: %v", err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Validate checks the spec for missing or unsupported settings
+func (s *WorkerSpec) Validate() error {
+	if s.Master == "" {
+		return fmt.Errorf("worker spec: master address cannot be empty")
+	}
+	if s.Address == "" {
+		return fmt.Errorf("worker spec: worker address cannot be empty")
+	}
+	switch s.Transport {
+	case "", "unix":
+		if err := checkSocketAddress(s.Address); err != nil {
+			return fmt.Errorf("worker spec: %v", err)
+		}
+	case "tcp", "grpc", "jsonrpc":
+	default:
+		return fmt.Errorf("worker spec: unsupported transport %q", s.Transport)
+	}
+	if s.Concurrency < 0 {
+		return fmt.Errorf("worker spec: invalid concurrency %d", s.Concurrency)
+	}
+	if s.DiskIOLimit < 0 {
+		return fmt.Errorf("worker spec: invalid disk I/O limit %d", s.DiskIOLimit)
+	}
+	return nil
+}
+
+// RunWorkerFromSpec starts a worker configured by spec. Additional
+// options are applied after the settings taken from the spec.
+func RunWorkerFromSpec(
+	spec *WorkerSpec,
+	mapF func(string, string) []KeyValue,
+	reduceF func(string, []string) string,
+	opts ...WorkerOption,
+) (*Worker, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if spec.TLS != nil {
+		if err := UseTLS(spec.TLS); err != nil {
+			return nil, err
+		}
+	}
+	if spec.S3 != nil {
+		if err := UseS3(spec.S3); err != nil {
+			return nil, err
+		}
+	}
+	if spec.HDFS != nil {
+		if err := UseHDFS(spec.HDFS); err != nil {
+			return nil, err
+		}
+	}
+	if spec.GCS != nil {
+		if err := UseGCS(spec.GCS); err != nil {
+			return nil, err
+		}
+	}
+	if spec.Statsd != nil {
+		sink, err := StatsdMetrics(spec.Statsd)
+		if err != nil {
+			return nil, err
+		}
+		UseMetrics(sink)
+	}
+	if spec.OTLP != nil {
+		exporter, err := NewOTLPExporter(spec.OTLP)
+		if err != nil {
+			return nil, err
+		}
+		UseTracing(exporter)
+	}
+
+	specOpts := []WorkerOption{
+		WithLabels(spec.Labels),
+		WithConcurrency(spec.Concurrency),
+		WithAuthToken(spec.AuthToken),
+		WithLocalPaths(spec.LocalPaths...),
+		WithMinFreeSpace(spec.MinFreeSpace),
+	}
+	if spec.DiskIOLimit > 0 {
+		specOpts = append(specOpts, WithDiskIOLimit(spec.DiskIOLimit))
+	}
+	return StartWorker(spec.address(spec.Master), spec.address(spec.Address),
+		mapF, reduceF, -1, append(specOpts, opts...)...)
+}
+
+// address applies the spec's transport to addr. Addresses that
+// already carry a scheme are used as they are.
+func (s *WorkerSpec) address(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	switch s.Transport {
+	case "tcp":
+		return tcpScheme + addr
+	case "grpc":
+		return grpcScheme + addr
+	case "jsonrpc":
+		return jsonrpcScheme + addr
+	}
+	return addr
+}
@@ -0,0 +1,103 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"time"
+)
+
+// WorkerSummary is what a worker reports about its work when the
+// master shuts it down. Byte counts are the sizes of the files the
+// worker's completed tasks read and wrote; verification runs are not
+// counted.
+type WorkerSummary struct {
+	Worker      string        // Address of the worker
+	MapTasks    int           // Map tasks completed
+	ReduceTasks int           // Reduce tasks completed
+	Failures    int           // Tasks that returned an error
+	InputBytes  int64         // Bytes of input read by completed tasks
+	OutputBytes int64         // Bytes of output written by completed tasks
+	Uptime      time.Duration // Time from the worker's start to its shutdown
+
+	// Responded is false for workers that did not answer the shutdown
+	// request, e.g. because they had already died; all counts of such
+	// workers are zero
+	Responded bool
+}
+
+// taskStats counts the tasks a worker ran, for its summary
+type taskStats struct {
+	mapTasks    int
+	reduceTasks int
+	failures    int
+	inputBytes  int64
+	outputBytes int64
+}
+
+// recordTask counts a task that has returned in the worker's summary,
+// as a failure if err is set
+func (wk *Worker) recordTask(args *DoTaskArgs, outFile string, err error) {
+	if args.Verify {
+		return
+	}
+	var in, out int64
+	if err == nil {
+		in, out = taskBytes(args, outFile)
+	}
+
+	wk.Lock()
+	defer wk.Unlock()
+	switch {
+	case err != nil:
+		wk.stats.failures++
+	case args.Phase == mapParse:
+		wk.stats.mapTasks++
+	default:
+		wk.stats.reduceTasks++
+	}
+	wk.stats.inputBytes += in
+	wk.stats.outputBytes += out
+}
+
+// summary returns the worker's summary. The caller must hold the lock.
+func (wk *Worker) summary() WorkerSummary {
+	return WorkerSummary{
+		Worker:      wk.name,
+		MapTasks:    wk.stats.mapTasks,
+		ReduceTasks: wk.stats.reduceTasks,
+		Failures:    wk.stats.failures,
+		InputBytes:  wk.stats.inputBytes,
+		OutputBytes: wk.stats.outputBytes,
+		Uptime:      time.Since(wk.started),
+		Responded:   true,
+	}
+}
+
+// taskBytes returns the sizes of the input and the output of a
+// completed task
+func taskBytes(args *DoTaskArgs, outFile string) (in, out int64) {
+	ws := args.Options.workspace(args.JobName)
+	if args.Phase == mapParse {
+		in = args.Length
+		if in == 0 {
+			// The task read the file, or its last split, to the end
+			in = max(fileSize(args.File)-args.Offset, 0)
+		}
+		for r := 0; r < args.OtherTaskNumber; r++ {
+			out += fileSize(ws.Intermediate(args.TaskNumber, r))
+		}
+		return in, out
+	}
+	for _, name := range reduceInputs(ws, args.Options.replica(args.JobName), args.OtherTaskNumber, args.TaskNumber, args.Options.DroppedCaches) {
+		in += fileSize(name)
+	}
+	return in, fileSize(outFile)
+}
+
+// fileSize returns the size of a file, zero if it cannot be inspected
+func fileSize(name string) int64 {
+	info, err := statFile(name)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,69 @@
+// Package mapreduce implements a distributed MapReduce framework
+package mapreduce
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// runtimeTuning applies the runtime settings requested by a job while
+// its tasks run on a worker. The worker's own settings are saved when
+// the first tuned task starts and restored once no tuned task is left.
+// The settings are process-wide: when tasks with different settings
+// overlap, the most recently started one wins.
+type runtimeTuning struct {
+	sync.Mutex
+	active      int   // Tuned tasks currently running
+	gcPercent   int   // Saved GC percent of the worker
+	memoryLimit int64 // Saved soft memory limit of the worker
+	maxProcs    int   // Saved GOMAXPROCS of the worker
+}
+
+// tuned reports whether the job changes any runtime setting
+func (o JobOptions) tuned() bool {
+	return o.GCPercent != 0 || o.MemoryLimit != 0 || o.MaxProcs != 0
+}
+
+// apply switches to the job's settings and returns a function that
+// undoes it once the task has finished
+func (t *runtimeTuning) apply(opts JobOptions) (restore func()) {
+	if !opts.tuned() {
+		return func() {}
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if t.active == 0 {
+		t.gcPercent = debug.SetGCPercent(100)
+		debug.SetGCPercent(t.gcPercent)
+		t.memoryLimit = debug.SetMemoryLimit(-1)
+		t.maxProcs = runtime.GOMAXPROCS(0)
+	}
+	t.active++
+
+	if opts.GCPercent != 0 {
+		debug.SetGCPercent(opts.GCPercent)
+	}
+	if opts.MemoryLimit != 0 {
+		debug.SetMemoryLimit(opts.MemoryLimit)
+	}
+	if opts.MaxProcs != 0 {
+		runtime.GOMAXPROCS(opts.MaxProcs)
+	}
+	return t.release
+}
+
+// release ends a tuned task, restoring the worker's settings after
+// the last one
+func (t *runtimeTuning) release() {
+	t.Lock()
+	defer t.Unlock()
+	t.active--
+	if t.active > 0 {
+		return
+	}
+	debug.SetGCPercent(t.gcPercent)
+	debug.SetMemoryLimit(t.memoryLimit)
+	runtime.GOMAXPROCS(t.maxProcs)
+}